@@ -0,0 +1,7 @@
+// Package docs embeds the OpenAPI document the server publishes.
+package docs
+
+import "embed"
+
+//go:embed openapi.json
+var FS embed.FS
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// About tells where the catalog comes from and who runs the instance, for
+// mirrors to attribute it properly.
+type About struct {
+	Name     string         `json:"name"`
+	Software AboutSoftware  `json:"software"`
+	Operator *AboutOperator `json:"operator,omitempty"`
+	Sources  []AboutSource  `json:"sources"`
+}
+
+// AboutSoftware is the server the instance runs.
+type AboutSoftware struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	License string `json:"license"`
+}
+
+// AboutOperator is who runs the instance.
+type AboutOperator struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// AboutSource is where some of the data comes from.
+type AboutSource struct {
+	Name        string `json:"name"`
+	URL         string `json:"url,omitempty"`
+	Description string `json:"description,omitempty"`
+	// License is an SPDX identifier when there is one, like CC0-1.0
+	License     string `json:"license,omitempty"`
+	LicenseURL  string `json:"license_url,omitempty"`
+	Attribution string `json:"attribution,omitempty"`
+}
+
+// aboutFile is the format of ABOUT_FILE. Its sources replace the built-in
+// ones with the same name and add to the others.
+type aboutFile struct {
+	Operator *AboutOperator `json:"operator"`
+	Sources  []AboutSource  `json:"sources"`
+}
+
+// defaultSources credits the sources of the data the configuration uses.
+func defaultSources(cfg Config) []AboutSource {
+	sources := []AboutSource{}
+	if u, err := url.Parse(cfg.BaseURL); err == nil && u.Host != "" {
+		sources = append(sources, AboutSource{
+			Name:        u.Hostname(),
+			URL:         u.Scheme + "://" + u.Host + "/",
+			Description: "Title IDs, names and systems",
+			Attribution: "Title data from " + u.Hostname(),
+		})
+	}
+	sources = append(sources, AboutSource{
+		Name:        "xboxgamer.pics",
+		URL:         "https://xboxgamer.pics/",
+		Description: "Gamerpics, collected by its contributors",
+		Attribution: "Gamerpics from xboxgamer.pics and its contributors",
+	}, AboutSource{
+		Name:        "Wikidata",
+		URL:         "https://www.wikidata.org/",
+		Description: "Links to Wikidata items, when an admin runs a Wikidata job",
+		License:     "CC0-1.0",
+		LicenseURL:  "https://creativecommons.org/publicdomain/zero/1.0/",
+		Attribution: "Data from Wikidata",
+	})
+	if cfg.RetroAchievementsAPIKey != "" {
+		sources = append(sources, AboutSource{
+			Name:        "RetroAchievements",
+			URL:         "https://retroachievements.org/",
+			Description: "Achievement sets",
+			Attribution: "Achievement data from RetroAchievements.org",
+		})
+	}
+	if cfg.PriceChartingToken != "" {
+		sources = append(sources, AboutSource{
+			Name:        "PriceCharting",
+			URL:         "https://www.pricecharting.com/",
+			Description: "Market values",
+			Attribution: "Prices from PriceCharting",
+		})
+	}
+	if cfg.ReviewScoreProvider == openCriticSource {
+		sources = append(sources, AboutSource{
+			Name:        "OpenCritic",
+			URL:         "https://opencritic.com/",
+			Description: "Review scores",
+			Attribution: "Review scores from OpenCritic",
+		})
+	}
+	if cfg.ArchiveLinks {
+		sources = append(sources, AboutSource{
+			Name:        "Internet Archive",
+			URL:         "https://archive.org/",
+			Description: "Links to archived items",
+			Attribution: "Links to the Internet Archive",
+		})
+	}
+	if cfg.KhinsiderEnricher {
+		sources = append(sources, AboutSource{
+			Name:        "KHInsider",
+			URL:         "https://downloads.khinsider.com/",
+			Description: "Soundtrack links",
+			Attribution: "Soundtrack links from KHInsider",
+		})
+	}
+	return sources
+}
+
+// initAbout builds what /api/v1/about returns from the configuration and
+// ABOUT_FILE, if it exists.
+func (s *Server) initAbout() error {
+	s.about = About{
+		Name: s.config.Branding.Name,
+		Software: AboutSoftware{
+			Name:    "xtitles",
+			Version: buildVersion(),
+			URL:     "https://github.com/birabittoh/xtitles",
+			License: "MIT",
+		},
+		Sources: defaultSources(s.config),
+	}
+
+	data, err := os.ReadFile(s.config.AboutFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var file aboutFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	s.about.Operator = file.Operator
+	for _, source := range file.Sources {
+		if strings.TrimSpace(source.Name) == "" {
+			return fmt.Errorf("source without a name")
+		}
+		replaced := false
+		for i, existing := range s.about.Sources {
+			if strings.EqualFold(existing.Name, source.Name) {
+				s.about.Sources[i] = source
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			s.about.Sources = append(s.about.Sources, source)
+		}
+	}
+	return nil
+}
+
+// getAbout returns the data sources with their licenses and attributions,
+// and who to contact about the instance.
+func (s *Server) getAbout(c *gin.Context) {
+	setCacheHeaders(c, s.config.CacheDetails)
+	c.JSON(http.StatusOK, s.about)
+}
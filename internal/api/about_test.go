@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAbout(t *testing.T) {
+	var about About
+	s := newTestServer(t, testTitles)
+	w := doRequest(s, "GET", "/api/v1/about", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &about); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v; body: %s", w.Code, err, w.Body.String())
+	}
+	if about.Software.License != "MIT" || about.Software.Version == "" || about.Operator != nil || len(about.Sources) != 3 ||
+		about.Sources[0].Name != "127.0.0.1" || about.Sources[1].Name != "xboxgamer.pics" || about.Sources[2].License != "CC0-1.0" {
+		t.Errorf("about = %+v", about)
+	}
+
+	s = newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.ArchiveLinks = true
+		os.WriteFile(cfg.AboutFile, []byte(`{
+			"operator": {"name": "Mirror Ops", "email": "ops@example.com"},
+			"sources": [
+				{"name": "XboxGamer.pics", "url": "https://xboxgamer.pics/", "attribution": "Gamerpics courtesy of xboxgamer.pics"},
+				{"name": "Community uploads", "license": "CC-BY-4.0"}
+			]
+		}`), 0o644)
+	})
+	about = About{}
+	w = doRequest(s, "GET", "/api/v1/about", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &about); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(about.Sources))
+	for i, source := range about.Sources {
+		names[i] = source.Name
+	}
+	if about.Operator == nil || about.Operator.Email != "ops@example.com" ||
+		strings.Join(names, ",") != "127.0.0.1,XboxGamer.pics,Wikidata,Internet Archive,Community uploads" ||
+		about.Sources[1].Attribution != "Gamerpics courtesy of xboxgamer.pics" {
+		t.Errorf("about = %+v", about)
+	}
+
+	for _, content := range []string{`{"sources": [{"url": "https://example.com/"}]}`, `{"operator":`} {
+		cfg := testConfig(t, "http://127.0.0.1:0")
+		os.WriteFile(cfg.AboutFile, []byte(content), 0o644)
+		if _, err := NewServer(cfg); err == nil || !strings.Contains(err.Error(), "ABOUT_FILE") {
+			t.Errorf("ABOUT_FILE with %s: err = %v", content, err)
+		}
+	}
+}
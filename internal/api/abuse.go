@@ -0,0 +1,121 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	abuseActionOff    = "off"
+	abuseActionBlock  = "block"
+	abuseActionTarpit = "tarpit"
+
+	maxRecentBlocks = 100
+)
+
+type BlockEvent struct {
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Rule      string    `json:"rule"`
+	Action    string    `json:"action"`
+}
+
+// blockLog keeps the most recent block events in memory for the admin view.
+type blockLog struct {
+	mu     sync.Mutex
+	events []BlockEvent
+}
+
+func (l *blockLog) Add(e BlockEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+	if len(l.events) > maxRecentBlocks {
+		l.events = l.events[len(l.events)-maxRecentBlocks:]
+	}
+}
+
+// Recent returns the recorded events, newest first.
+func (l *blockLog) Recent() []BlockEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := make([]BlockEvent, len(l.events))
+	for i, e := range l.events {
+		events[len(l.events)-1-i] = e
+	}
+	return events
+}
+
+func (s *Server) initAbuseProtection() {
+	s.duplicateLimiter = newRateLimiter(s.config.AbuseDuplicateLimit, s.config.AbuseDuplicateWindow)
+}
+
+// abuseRule returns the name of the first bot rule the request violates, if any.
+func (s *Server) abuseRule(c *gin.Context) string {
+	if s.config.AbuseBlockEmptyUA && c.GetHeader("User-Agent") == "" {
+		return "missing_user_agent"
+	}
+	if s.config.AbuseMaxPage > 0 {
+		if page, err := strconv.Atoi(c.Query("page")); err == nil && page > s.config.AbuseMaxPage {
+			return "deep_pagination"
+		}
+	}
+	if c.Request.Method == http.MethodGet && c.Request.URL.RawQuery != "" {
+		key := c.ClientIP() + "|" + c.Request.URL.Path + "?" + c.Request.URL.RawQuery
+		if !s.duplicateLimiter.Allow(key) {
+			return "duplicate_queries"
+		}
+	}
+	return ""
+}
+
+func (s *Server) abuseProtection(c *gin.Context) {
+	// Clients with an API key are held to their quotas instead
+	if _, ok := s.requestAPIKey(c); ok || s.config.AbuseAction == abuseActionOff {
+		c.Next()
+		return
+	}
+
+	rule := s.abuseRule(c)
+	if rule == "" {
+		c.Next()
+		return
+	}
+
+	s.recentBlocks.Add(BlockEvent{
+		Time:      time.Now(),
+		IP:        c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.RequestURI(),
+		Rule:      rule,
+		Action:    s.config.AbuseAction,
+	})
+	log.Printf("Abuse rule %s matched for %s (%s)\n", rule, c.ClientIP(), s.config.AbuseAction)
+
+	if s.config.AbuseAction == abuseActionTarpit {
+		// Serve the request, but slowly enough to make scraping unattractive
+		select {
+		case <-time.After(s.config.AbuseTarpitDelay):
+		case <-c.Request.Context().Done():
+			c.Abort()
+			return
+		}
+		c.Next()
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Request blocked"})
+}
+
+func (s *Server) getRecentBlocks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"items": s.recentBlocks.Recent()})
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAbuseRules(t *testing.T) {
+	configure := func(action string) func(*Config) {
+		return func(cfg *Config) {
+			cfg.AbuseAction = action
+			cfg.AbuseBlockEmptyUA = true
+			cfg.AbuseMaxPage = 5
+			cfg.AbuseDuplicateLimit = 2
+			cfg.AbuseDuplicateWindow = time.Minute
+			cfg.AbuseTarpitDelay = 50 * time.Millisecond
+			cfg.APIKeys = []string{"app:app-secret:read"}
+		}
+	}
+	noUA := map[string]string{"User-Agent": ""}
+
+	// Requests run in order against the same server
+	s := newTestServerWithConfig(t, testTitles, configure(abuseActionBlock))
+	tests := []struct {
+		name   string
+		target string
+		header map[string]string
+		status int
+	}{
+		{"regular request", "/api/v1/titles", nil, http.StatusOK},
+		{"missing user agent", "/api/v1/titles", noUA, http.StatusForbidden},
+		{"last page allowed", "/api/v1/titles?page=5", nil, http.StatusOK},
+		{"deep pagination", "/api/v1/titles?page=6", nil, http.StatusForbidden},
+		{"repeated query", "/api/v1/search?q=halo", nil, http.StatusOK},
+		{"repeated query again", "/api/v1/search?q=halo", nil, http.StatusOK},
+		{"repeated query too often", "/api/v1/search?q=halo", nil, http.StatusForbidden},
+		{"other query", "/api/v1/search?q=minecraft", nil, http.StatusOK},
+		{"api key holders are exempt", "/api/v1/titles", map[string]string{"User-Agent": "", "X-API-Key": "app-secret"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := doRequest(s, "GET", tt.target, tt.header); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+
+	var blocks struct {
+		Items []BlockEvent `json:"items"`
+	}
+	w := doRequest(s, "GET", "/api/v1/admin/blocks", map[string]string{"Authorization": "Bearer test-token"})
+	if err := json.Unmarshal(w.Body.Bytes(), &blocks); err != nil {
+		t.Fatalf("blocks: %v; body: %s", err, w.Body.String())
+	}
+	var rules []string
+	for _, block := range blocks.Items {
+		rules = append(rules, block.Rule+" "+block.Path)
+		if block.IP != "192.0.2.1" || block.Action != abuseActionBlock {
+			t.Errorf("block = %+v", block)
+		}
+	}
+	want := []string{
+		"duplicate_queries /api/v1/search?q=halo",
+		"deep_pagination /api/v1/titles?page=6",
+		"missing_user_agent /api/v1/titles",
+	}
+	if !slices.Equal(rules, want) {
+		t.Errorf("blocks = %q, want %q", rules, want)
+	}
+
+	// Tarpitted requests are served, late
+	tarpit := newTestServerWithConfig(t, testTitles, configure(abuseActionTarpit))
+	start := time.Now()
+	if w := doRequest(tarpit, "GET", "/api/v1/titles?page=6", nil); w.Code != http.StatusOK || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("tarpit: status = %d after %s", w.Code, time.Since(start))
+	}
+	off := newTestServerWithConfig(t, testTitles, configure(abuseActionOff))
+	if w := doRequest(off, "GET", "/api/v1/titles", noUA); w.Code != http.StatusOK {
+		t.Errorf("off: status = %d", w.Code)
+	}
+}
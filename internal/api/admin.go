@@ -0,0 +1,49 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin guards admin routes with the bearer token configured in
+// ADMIN_TOKEN, which can do anything, or with an X-API-Key having the scope
+// the route needs. Admin routes are disabled entirely when neither is
+// configured.
+func (s *Server) requireAdmin(c *gin.Context) {
+	if c.GetHeader("X-API-Key") != "" {
+		id, ok := s.requestAPIKey(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if scope := s.adminScope(c); !slices.Contains(id.Scopes, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+			return
+		}
+		c.Next()
+		return
+	}
+
+	if s.config.AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API is disabled"})
+		return
+	}
+
+	if !s.hasAdminToken(c) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	c.Next()
+}
+
+// hasAdminToken tells whether the request carries ADMIN_TOKEN as a bearer
+// token.
+func (s *Server) hasAdminToken(c *gin.Context) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && s.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TitleInput is the body accepted by the admin title endpoints.
+type TitleInput struct {
+	TitleID         string   `json:"title_id"`
+	Name            string   `json:"name"`
+	Systems         []string `json:"systems"`
+	BingID          string   `json:"bing_id"`
+	ServiceConfigID *string  `json:"service_config_id"`
+	PFN             *string  `json:"pfn"`
+}
+
+// title normalizes the input into a title with the given id.
+func (in TitleInput) title(id string) Title {
+	return Title{
+		TitleID:         strings.ToUpper(strings.TrimSpace(id)),
+		Name:            strings.TrimSpace(in.Name),
+		Systems:         parseSystems(strings.Join(in.Systems, ",")),
+		BingID:          strings.TrimSpace(in.BingID),
+		ServiceConfigID: in.ServiceConfigID,
+		PFN:             in.PFN,
+		Curated:         true,
+	}
+}
+
+// titleETag returns a strong ETag for the editable fields of title. Pictures
+// and external ids are left out, they are managed separately from the title
+// itself but bump its updated_at.
+func titleETag(title Title) string {
+	title.Pictures = nil
+	title.ExternalIDs = nil
+	title.Achievements = nil
+	title.MarketValue = nil
+	title.ReviewScore = nil
+	title.Media = nil
+	data, _ := json.Marshal(title)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// checkIfMatch enforces the If-Match precondition of an edit against the
+// current ETag, writing an error response and returning false if it fails.
+func checkIfMatch(c *gin.Context, etag string) bool {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header is required"})
+		return false
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	c.Header("ETag", etag)
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Title was modified since it was fetched"})
+	return false
+}
+
+// validateCuratedTitle applies the ingest rules plus a check of the system list,
+// returning why the title is invalid or "" if it is fine.
+func (s *Server) validateCuratedTitle(t Title) string {
+	if reason := s.validateTitle(t); reason != "" {
+		return reason
+	}
+	if len(t.Systems) == 0 {
+		return "at least one system is required"
+	}
+	for _, system := range t.Systems {
+		if _, ok := systemNames[system]; !ok && !slices.Contains(s.config.Systems, system) {
+			return fmt.Sprintf("unknown system %s", system)
+		}
+	}
+	return ""
+}
+
+func (s *Server) createTitle(c *gin.Context) {
+	var input TitleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	title := input.title(input.TitleID)
+	if reason := s.validateCuratedTitle(title); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid title: " + reason})
+		return
+	}
+
+	if _, err := s.findTitle(title.TitleID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Title already exists"})
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := s.db.Create(&title).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if _, err := s.insertPicturesFor(s.db, []Title{title}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	created, err := s.findTitle(title.TitleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	s.catalogChanged()
+
+	c.Header("Location", "/api/v1/titles/"+strings.ToLower(created.TitleID))
+	c.Header("ETag", titleETag(created))
+	c.JSON(http.StatusCreated, created)
+}
+
+func (s *Server) updateTitle(c *gin.Context) {
+	// Serialize edits so that the If-Match check and the write are atomic
+	s.titleEditMu.Lock()
+	defer s.titleEditMu.Unlock()
+
+	existing, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !checkIfMatch(c, titleETag(existing)) {
+		return
+	}
+
+	var input TitleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if input.TitleID != "" && !strings.EqualFold(strings.TrimSpace(input.TitleID), existing.TitleID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "title_id cannot be changed"})
+		return
+	}
+
+	title := input.title(existing.TitleID)
+	if reason := s.validateCuratedTitle(title); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid title: " + reason})
+		return
+	}
+
+	err = s.db.Model(&Title{TitleID: existing.TitleID}).
+		Select("name", "systems", "bing_id", "service_config_id", "pfn", "curated").
+		Updates(&title).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	s.catalogChanged()
+
+	updated, err := s.findTitle(existing.TitleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("ETag", titleETag(updated))
+	c.JSON(http.StatusOK, updated)
+}
+
+func (s *Server) deleteTitle(c *gin.Context) {
+	s.titleEditMu.Lock()
+	defer s.titleEditMu.Unlock()
+
+	existing, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !checkIfMatch(c, titleETag(existing)) {
+		return
+	}
+
+	// Picture files stay on disk, only their index rows go away
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("title_id = ?", existing.TitleID).Delete(&Picture{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("title_id = ?", existing.TitleID).Delete(&ExternalID{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("title_id = ?", existing.TitleID).Delete(&AchievementSet{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("title_id = ?", existing.TitleID).Delete(&MarketValue{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("title_id = ?", existing.TitleID).Delete(&ReviewScore{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("title_id = ?", existing.TitleID).Delete(&ArchiveItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("title_id = ?", existing.TitleID).Delete(&MediaLink{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("title_id = ?", existing.TitleID).Delete(&TitleView{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Title{TitleID: existing.TitleID}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	s.catalogChanged()
+
+	c.Status(http.StatusNoContent)
+}
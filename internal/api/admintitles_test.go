@@ -0,0 +1,66 @@
+package api
+
+import (
+	"maps"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestAdminTitles(t *testing.T) {
+	r := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+
+	// Steps run in order against the same server. An ifMatch of "current"
+	// sends the ETag the title has right before the step.
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		ifMatch  string
+		status   int
+		contains string
+	}{
+		{"create", "POST", "/api/v1/admin/titles", `{"title_id":"4d5307f1","name":"Halo 3 Beta","systems":["xbox360"]}`, "", http.StatusCreated, `"title_id":"4D5307F1"`},
+		{"created title is searchable", "GET", "/api/v1/search?q=beta", "", "", http.StatusOK, `"total":1`},
+		{"create duplicate", "POST", "/api/v1/admin/titles", `{"title_id":"4D5307F1","name":"Halo 3 Beta","systems":["XBOX360"]}`, "", http.StatusConflict, "already exists"},
+		{"create bad id", "POST", "/api/v1/admin/titles", `{"title_id":"xyz","name":"Bad","systems":["XBOX360"]}`, "", http.StatusBadRequest, "invalid title_id format"},
+		{"create unknown system", "POST", "/api/v1/admin/titles", `{"title_id":"4D5307F2","name":"Bad","systems":["N64"]}`, "", http.StatusBadRequest, "unknown system N64"},
+		{"create without systems", "POST", "/api/v1/admin/titles", `{"title_id":"4D5307F2","name":"Bad"}`, "", http.StatusBadRequest, "at least one system"},
+		{"create body too large", "POST", "/api/v1/admin/titles", `{"name":"` + strings.Repeat("a", 1<<20) + `"}`, "", http.StatusRequestEntityTooLarge, "body is too large"},
+		{"update", "PUT", "/api/v1/admin/titles/4d530802", `{"name":"Halo 3: ODST","systems":["XBOX360","PC"]}`, "current", http.StatusOK, `"systems":["XBOX360","PC"]`},
+		{"update without If-Match", "PUT", "/api/v1/admin/titles/4d530802", `{"name":"Halo 3: ODST","systems":["XBOX360"]}`, "", http.StatusPreconditionRequired, "If-Match header is required"},
+		{"update with stale ETag", "PUT", "/api/v1/admin/titles/4d530802", `{"name":"Halo 3: ODST","systems":["XBOX360"]}`, `"0000000000000000"`, http.StatusPreconditionFailed, "modified since it was fetched"},
+		{"update id mismatch", "PUT", "/api/v1/admin/titles/4d530802", `{"title_id":"4D5307E6","name":"Halo","systems":["XBOX360"]}`, "current", http.StatusBadRequest, "cannot be changed"},
+		{"update missing", "PUT", "/api/v1/admin/titles/00000000", `{"name":"Nothing","systems":["XBOX360"]}`, "*", http.StatusNotFound, "Title not found"},
+		{"sync", "POST", "/api/v1/admin/sync", "", "", http.StatusAccepted, `"kind":"sync"`},
+		{"curated survives sync", "GET", "/api/v1/titles/4d530802", "", "", http.StatusOK, `"systems":["XBOX360","PC"]`},
+		{"delete", "DELETE", "/api/v1/admin/titles/4d5307e6", "", "current", http.StatusNoContent, ""},
+		{"deleted is gone", "GET", "/api/v1/titles/4d5307e6", "", "", http.StatusNotFound, "Title not found"},
+		{"deleted is not searchable", "GET", "/api/v1/search?q=halo", "", "", http.StatusOK, `"total":2`},
+		{"delete missing", "DELETE", "/api/v1/admin/titles/4d5307e6", "", "*", http.StatusNotFound, "Title not found"},
+	}
+
+	for _, step := range steps {
+		header := maps.Clone(admin)
+		switch step.ifMatch {
+		case "":
+		case "current":
+			current := doRequest(r, "GET", "/api/v1/titles/"+path.Base(step.target), nil)
+			header["If-Match"] = current.Header().Get("ETag")
+		default:
+			header["If-Match"] = step.ifMatch
+		}
+
+		w := doRequestBody(r, step.method, step.target, header, step.body)
+		if w.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d; body: %s", step.name, w.Code, step.status, w.Body.String())
+		}
+		r.jobs.Wait()
+		if !strings.Contains(w.Body.String(), step.contains) {
+			t.Errorf("%s: body does not contain %q: %s", step.name, step.contains, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// API key scopes. Admin routes need read to look, write to change the
+// catalog and sync to start the jobs that fetch from elsewhere. Only
+// ADMIN_TOKEN manages the keys themselves, runs SQL queries and profiles the
+// server.
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+	scopeSync  = "sync"
+	scopeAdmin = "admin"
+)
+
+var apiKeyScopes = []string{scopeRead, scopeWrite, scopeSync}
+
+// apiKeyContextKey holds the apiKeyIdentity of a request, once looked up.
+const apiKeyContextKey = "api_key"
+
+// maxAPIKeyMisses is how many unknown keys a client IP may try a minute.
+// Past that its keys aren't looked up, and its requests go as anonymous.
+const maxAPIKeyMisses = 10
+
+// syncRoutes are the admin routes that start sync and enrichment jobs. Each
+// server adds those of its plugin enrichers to a copy, Server.syncRoutes.
+var syncRoutes = map[string]bool{
+	"/api/v1/admin/sync":              true,
+	"/api/v1/admin/pictures/rescan":   true,
+	"/api/v1/admin/pictures/dedupe":   true,
+	"/api/v1/admin/retroachievements": true,
+	"/api/v1/admin/pricecharting":     true,
+	"/api/v1/admin/reviewscores":      true,
+	"/api/v1/admin/wikidata":          true,
+	"/api/v1/admin/archive":           true,
+	"/api/v1/admin/soundtracks":       true,
+}
+
+// readRoutes are the admin routes that are posted to without changing
+// anything.
+var readRoutes = map[string]bool{
+	"/api/v1/admin/exports": true,
+}
+
+var apiKeyNamePattern = regexp.MustCompile(`^[0-9A-Za-z_.-]{1,64}$`)
+
+// APIKey is a key created through the admin API. Only the hash of the key
+// is kept, the key itself is shown once when it is created.
+type APIKey struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"uniqueIndex"`
+	// The first characters of the key, to tell keys apart
+	Prefix string   `json:"prefix"`
+	Hash   string   `json:"-" gorm:"uniqueIndex"`
+	Scopes []string `json:"scopes" gorm:"serializer:json"`
+	// Daily quotas, 0 for the defaults of API_KEY_DAILY_REQUESTS and
+	// API_KEY_DAILY_EXPORT_MB
+	DailyRequests    int64      `json:"daily_requests"`
+	DailyExportBytes int64      `json:"daily_export_bytes"`
+	CreatedAt        time.Time  `json:"created_at"`
+	LastUsedAt       *time.Time `json:"last_used_at"`
+}
+
+type APIKeyInput struct {
+	Name             string   `json:"name"`
+	Scopes           []string `json:"scopes"`
+	DailyRequests    int64    `json:"daily_requests"`
+	DailyExportBytes int64    `json:"daily_export_bytes"`
+}
+
+// CreatedAPIKey is the answer to the creation of a key, the only one that
+// carries the key.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// apiKeyIdentity is what a request authenticated with an API key may do.
+type apiKeyIdentity struct {
+	Name             string
+	Scopes           []string
+	DailyRequests    int64
+	DailyExportBytes int64
+}
+
+// configuredAPIKey is a key of API_KEYS, with the default quotas.
+type configuredAPIKey struct {
+	name   string
+	hash   [sha256.Size]byte
+	scopes []string
+}
+
+// initAPIKeys parses API_KEYS, name:key:scopes entries with scopes joined by
+// +, like ci:s3cr3t:read+sync.
+func (s *Server) initAPIKeys() error {
+	s.apiKeyMisses = newRateLimiter(maxAPIKeyMisses, time.Minute)
+	for _, entry := range s.config.APIKeys {
+		name, rest, _ := strings.Cut(entry, ":")
+		key, scopes, ok := strings.Cut(rest, ":")
+		if !ok || !apiKeyNamePattern.MatchString(name) || key == "" {
+			return fmt.Errorf("expected name:key:scopes, got %q", name)
+		}
+		parsed, err := parseScopes(strings.Split(scopes, "+"))
+		if err != nil {
+			return fmt.Errorf("key %s: %w", name, err)
+		}
+		s.apiKeys = append(s.apiKeys, configuredAPIKey{name: name, hash: sha256.Sum256([]byte(key)), scopes: parsed})
+	}
+	return nil
+}
+
+// parseScopes checks scopes, returning them sorted without duplicates.
+func parseScopes(scopes []string) ([]string, error) {
+	var parsed []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !slices.Contains(apiKeyScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, expected %s", scope, strings.Join(apiKeyScopes, ", "))
+		}
+		if !slices.Contains(parsed, scope) {
+			parsed = append(parsed, scope)
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("no scopes")
+	}
+	slices.Sort(parsed)
+	return parsed, nil
+}
+
+// adminScope returns the scope a request to an admin route needs.
+func (s *Server) adminScope(c *gin.Context) string {
+	route := c.FullPath()
+	switch {
+	case route == "/api/v1/admin/keys" || route == "/api/v1/admin/query" || strings.HasPrefix(route, "/api/v1/admin/keys/") || strings.HasPrefix(route, "/debug/pprof/"):
+		return scopeAdmin
+	case c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || readRoutes[route]:
+		return scopeRead
+	case s.syncRoutes[route]:
+		return scopeSync
+	}
+	return scopeWrite
+}
+
+// lookupAPIKey returns what an X-API-Key may do, looking in API_KEYS first
+// and then in the database.
+func (s *Server) lookupAPIKey(key string) (apiKeyIdentity, bool) {
+	hash := sha256.Sum256([]byte(key))
+	for _, k := range s.apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash[:]) == 1 {
+			return apiKeyIdentity{Name: k.name, Scopes: k.scopes}, true
+		}
+	}
+
+	var stored APIKey
+	if err := s.db.Where("hash = ?", hex.EncodeToString(hash[:])).First(&stored).Error; err != nil {
+		return apiKeyIdentity{}, false
+	}
+	// Recording every use would write on every request
+	if now := time.Now(); stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) > time.Minute {
+		s.db.Model(&stored).UpdateColumn("last_used_at", now)
+	}
+	return apiKeyIdentity{
+		Name:             stored.Name,
+		Scopes:           stored.Scopes,
+		DailyRequests:    stored.DailyRequests,
+		DailyExportBytes: stored.DailyExportBytes,
+	}, true
+}
+
+// requestAPIKey returns the API key the request was made with, looking it up
+// once per request. It returns false when there is none or it is unknown.
+func (s *Server) requestAPIKey(c *gin.Context) (apiKeyIdentity, bool) {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		id := v.(apiKeyIdentity)
+		return id, id.Name != ""
+	}
+	key := c.GetHeader("X-API-Key")
+	if key == "" {
+		return apiKeyIdentity{}, false
+	}
+	// Every unknown key costs a query, so clients get few of them a minute
+	if s.apiKeyMisses.Exceeded(c.ClientIP()) {
+		c.Set(apiKeyContextKey, apiKeyIdentity{})
+		return apiKeyIdentity{}, false
+	}
+	id, ok := s.lookupAPIKey(key)
+	if !ok {
+		s.apiKeyMisses.Allow(c.ClientIP())
+	}
+	c.Set(apiKeyContextKey, id)
+	return id, ok
+}
+
+// requestAPIKeyName returns the name of the API key of the request, if any.
+func requestAPIKeyName(c *gin.Context) string {
+	id, _ := c.Value(apiKeyContextKey).(apiKeyIdentity)
+	return id.Name
+}
+
+func (s *Server) getAPIKeys(c *gin.Context) {
+	keys := []APIKey{}
+	if err := s.db.Order("name ASC").Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func (s *Server) createAPIKey(c *gin.Context) {
+	var input APIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !apiKeyNamePattern.MatchString(input.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid name, expected up to 64 letters, digits, dots, dashes or underscores"})
+		return
+	}
+	scopes, err := parseScopes(input.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scopes: " + err.Error()})
+		return
+	}
+	if input.DailyRequests < 0 || input.DailyExportBytes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quotas can't be negative"})
+		return
+	}
+	var existing int64
+	s.db.Model(&APIKey{}).Where("name = ?", input.Name).Count(&existing)
+	if existing > 0 || slices.ContainsFunc(s.apiKeys, func(k configuredAPIKey) bool { return k.name == input.Name }) {
+		c.JSON(http.StatusConflict, gin.H{"error": "API key already exists"})
+		return
+	}
+
+	secret := make([]byte, 24)
+	rand.Read(secret)
+	key := "xt_" + hex.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(key))
+	created := CreatedAPIKey{
+		APIKey: APIKey{
+			Name:             input.Name,
+			Prefix:           key[:10],
+			Hash:             hex.EncodeToString(hash[:]),
+			Scopes:           scopes,
+			DailyRequests:    input.DailyRequests,
+			DailyExportBytes: input.DailyExportBytes,
+		},
+		Key: key,
+	}
+	if err := s.db.Create(&created.APIKey).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/api/v1/admin/keys/%d", created.ID))
+	c.JSON(http.StatusCreated, created)
+}
+
+func (s *Server) deleteAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+	var key APIKey
+	if err := s.db.First(&key, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// A key created later under the same name starts afresh
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("api_key = ?", key.Name).Delete(&APIKeyUsage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&key).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	s.quotas.forget(key.Name)
+	c.Status(http.StatusNoContent)
+}
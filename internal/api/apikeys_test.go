@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestAPIKeys(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.APIKeys = []string{"ci:ci-secret:read+sync"} })
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+	ci := map[string]string{"X-API-Key": "ci-secret"}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header map[string]string
+		status int
+	}{
+		{"read scope", "GET", "/api/v1/admin/sync", ci, http.StatusOK},
+		{"missing write scope", "POST", "/api/v1/admin/titles", ci, http.StatusForbidden},
+		{"keys need the admin token", "GET", "/api/v1/admin/keys", ci, http.StatusForbidden},
+		{"queries need the admin token", "POST", "/api/v1/admin/query", ci, http.StatusForbidden},
+		{"unknown key", "GET", "/api/v1/admin/sync", map[string]string{"X-API-Key": "nope"}, http.StatusUnauthorized},
+		{"admin token", "GET", "/api/v1/admin/keys", admin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := doRequest(s, tt.method, tt.target, tt.header); w.Code != tt.status {
+				t.Errorf("status = %d, want %d; body: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+
+	w := doRequestBody(s, "POST", "/api/v1/admin/keys", admin, `{"name":"editor","scopes":["write","read","write"]}`)
+	var created CreatedAPIKey
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("create key: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(created.Key, created.Prefix) || !slices.Equal(created.Scopes, []string{"read", "write"}) {
+		t.Errorf("created key = %+v", created)
+	}
+	editor := map[string]string{"X-API-Key": created.Key}
+	if w := doRequestBody(s, "POST", "/api/v1/admin/titles", editor, `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("write with editor key: status = %d, want 400 past authentication; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "POST", "/api/v1/admin/sync", editor); w.Code != http.StatusForbidden {
+		t.Errorf("sync with editor key: status = %d, want 403", w.Code)
+	}
+	if w := doRequestBody(s, "POST", "/api/v1/admin/keys", admin, `{"name":"ci","scopes":["read"]}`); w.Code != http.StatusConflict {
+		t.Errorf("key named like a configured one: status = %d, want 409", w.Code)
+	}
+	if w := doRequestBody(s, "POST", "/api/v1/admin/keys", admin, `{"name":"bad","scopes":["admin"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("admin scope: status = %d, want 400", w.Code)
+	}
+
+	w = doRequest(s, "GET", "/api/v1/admin/keys", admin)
+	if strings.Contains(w.Body.String(), created.Key) || !strings.Contains(w.Body.String(), `"name":"editor"`) ||
+		!strings.Contains(w.Body.String(), `"last_used_at":"`) {
+		t.Errorf("key listing: %s", w.Body.String())
+	}
+	if w := doRequest(s, "DELETE", fmt.Sprintf("/api/v1/admin/keys/%d", created.ID), admin); w.Code != http.StatusNoContent {
+		t.Errorf("delete key: status = %d", w.Code)
+	}
+	if w := doRequest(s, "GET", "/api/v1/admin/sync", editor); w.Code != http.StatusUnauthorized {
+		t.Errorf("deleted key: status = %d, want 401", w.Code)
+	}
+}
+
+func TestAPIKeyMisses(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.APIKeys = []string{"ci:ci-secret:read"} })
+	lookups := 0
+	s.db.Callback().Query().After("gorm:query").Register("test:api_key_lookups", func(db *gorm.DB) {
+		if db.Statement.Table == "api_keys" {
+			lookups++
+		}
+	})
+
+	// Unknown keys are looked up a few times a minute per client, then not at all
+	for i := range maxAPIKeyMisses + 5 {
+		w := doRequest(s, "GET", "/api/v1/admin/sync", map[string]string{"X-API-Key": fmt.Sprintf("guess-%d", i)})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("guess %d: status = %d, want 401", i, w.Code)
+		}
+	}
+	if lookups != maxAPIKeyMisses {
+		t.Errorf("unknown keys looked up %d times, want %d", lookups, maxAPIKeyMisses)
+	}
+	if w := doRequest(s, "GET", "/api/v1/admin/sync", map[string]string{"X-API-Key": "ci-secret"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("valid key after too many misses: status = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	archiveJobKind = "archive"
+
+	archiveKindManual = "manual"
+	archiveKindScan   = "scan"
+
+	// archiveMaxResults caps the search results considered per title.
+	archiveMaxResults = 50
+)
+
+// archiveDoc is a result of the advanced search API.
+type archiveDoc struct {
+	Identifier string `json:"identifier"`
+	Title      string `json:"title"`
+	MediaType  string `json:"mediatype"`
+}
+
+// archiveKind classifies a search result, returning "" for items that are
+// neither manuals nor scans (ISOs, videos, reviews...).
+func archiveKind(doc archiveDoc) string {
+	title := strings.ToLower(doc.Title)
+	switch {
+	case doc.MediaType == "texts" && strings.Contains(title, "manual"):
+		return archiveKindManual
+	case doc.MediaType == "image",
+		strings.Contains(title, "scan"), strings.Contains(title, "cover"), strings.Contains(title, "disc"):
+		return archiveKindScan
+	}
+	return ""
+}
+
+// archiveQuery searches for texts and images whose title contains the name
+// of t and mentions one of its systems.
+func archiveQuery(t Title) string {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	var systems []string
+	for _, system := range t.Systems {
+		name := quote(systemName(system))
+		systems = append(systems, "title:("+name+")", "subject:("+name+")")
+	}
+	query := "title:(" + quote(t.Name) + ") AND mediatype:(texts OR image)"
+	if len(systems) > 0 {
+		query += " AND (" + strings.Join(systems, " OR ") + ")"
+	}
+	return query
+}
+
+// searchArchive finds the manuals and scans the Internet Archive has for t.
+func (s *Server) searchArchive(ctx context.Context, t Title) ([]ArchiveItem, error) {
+	query := url.Values{
+		"q":      {archiveQuery(t)},
+		"fl[]":   {"identifier", "title", "mediatype"},
+		"rows":   {strconv.Itoa(archiveMaxResults)},
+		"output": {"json"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.ArchiveURL+"advancedsearch.php?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	client := &http.Client{Timeout: s.config.UpstreamTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Response struct {
+			Docs []archiveDoc `json:"docs"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode failed: %w", err)
+	}
+
+	// The search matches words, not names: "Halo 3" finds "Halo 3: ODST" too
+	var items []ArchiveItem
+	name := nameWords(t.Name)
+	for _, doc := range result.Response.Docs {
+		kind := archiveKind(doc)
+		if kind == "" || doc.Identifier == "" || !containsName(nameWords(doc.Title), name) {
+			continue
+		}
+		items = append(items, ArchiveItem{TitleID: t.TitleID, Identifier: doc.Identifier, Name: doc.Title, Kind: kind})
+	}
+	return items, nil
+}
+
+// archiveDescriptionWords may follow the name of a game in the title of an
+// item without being part of the name.
+var archiveDescriptionWords = map[string]bool{
+	"manual": true, "instructions": true, "booklet": true, "scan": true, "scans": true,
+	"cover": true, "covers": true, "disc": true, "box": true, "xbox": true, "pc": true,
+	"ntsc": true, "pal": true, "game": true,
+}
+
+// nameWords splits a name into its normalized words.
+func nameWords(name string) []string {
+	return strings.FieldsFunc(normalizeName(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsName tells whether the words of name appear in title and are not
+// followed by more words of a longer name: "Halo 3" is in "Halo 3 Manual"
+// but not in "Halo 3: ODST Manual".
+func containsName(title, name []string) bool {
+	for i := 0; i+len(name) <= len(title); i++ {
+		end := i + len(name)
+		if slices.Equal(title[i:end], name) && (end == len(title) || archiveDescriptionWords[title[end]]) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncArchiveItems looks up the Internet Archive items of titles that have
+// none yet, or of every title when force is set, replacing what was found
+// before.
+func (s *Server) syncArchiveItems(ctx context.Context, job *Job, force bool) error {
+	query := s.db.WithContext(ctx).Order("title_id ASC")
+	if !force {
+		query = query.Where("NOT EXISTS (SELECT 1 FROM archive_items WHERE archive_items.title_id = titles.title_id)")
+	}
+	var titles []Title
+	if err := query.Find(&titles).Error; err != nil {
+		return err
+	}
+
+	found, linked := 0, 0
+	for i, t := range titles {
+		job.SetProgress(i, len(titles))
+
+		// Stay well within the API rate limits
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.config.ArchiveDelay):
+			}
+		}
+
+		items, err := s.searchArchive(ctx, t)
+		if err != nil {
+			return fmt.Errorf("searching %s failed: %w", t.TitleID, err)
+		}
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("title_id = ?", t.TitleID).Delete(&ArchiveItem{}).Error; err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				return nil
+			}
+			return tx.Create(&items).Error
+		})
+		if err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			linked++
+			found += len(items)
+		}
+	}
+
+	job.SetProgress(len(titles), len(titles))
+	job.SetResult(fmt.Sprintf("%d items found for %d of %d titles", found, linked, len(titles)))
+	return nil
+}
+
+// ArchiveItemsResponse lists the Internet Archive items of a title.
+type ArchiveItemsResponse struct {
+	TitleID string        `json:"title_id"`
+	Items   []ArchiveItem `json:"items"`
+}
+
+func (s *Server) getTitleArchiveItems(c *gin.Context) {
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.titleNotFound(c, c.Param("id"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	items := []ArchiveItem{}
+	if err := s.db.Where("title_id = ?", title.TitleID).Order("kind ASC, identifier ASC").Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, ArchiveItemsResponse{TitleID: title.TitleID, Items: items})
+}
+
+func (s *Server) createArchiveJob(c *gin.Context) {
+	if !s.config.ArchiveLinks {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Internet Archive links are disabled"})
+		return
+	}
+
+	force, _ := strconv.ParseBool(c.Query("force"))
+	job := s.jobs.Start(archiveJobKind, func(ctx context.Context, job *Job) error {
+		return s.syncArchiveItems(ctx, job, force)
+	})
+	c.Header("Location", "/api/v1/admin/archive/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+func (s *Server) getArchiveJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != archiveJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enricherJobResponse(job))
+}
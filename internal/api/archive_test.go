@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestArchiveItems(t *testing.T) {
+	var queries []string
+	ia := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		queries = append(queries, q)
+		if !strings.HasPrefix(q, `title:("Halo 3")`) {
+			io.WriteString(w, `{"response":{"docs":[]}}`)
+			return
+		}
+		io.WriteString(w, `{"response":{"docs":[
+			{"identifier":"halo-3-manual","title":"Halo 3 Manual (Xbox 360)","mediatype":"texts"},
+			{"identifier":"halo-3-odst-manual","title":"Halo 3: ODST Manual","mediatype":"texts"},
+			{"identifier":"halo-3-disc","title":"Halo 3","mediatype":"image"},
+			{"identifier":"halo-3-review","title":"Halo 3 review","mediatype":"texts"}
+		]}}`)
+	}))
+	t.Cleanup(ia.Close)
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.ArchiveURL = ia.URL + "/"
+		cfg.ArchiveDelay = 0
+	})
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+	if w := doRequest(s, "POST", "/api/v1/admin/archive", admin); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("archive links should be disabled by default: status = %d", w.Code)
+	}
+	s.config.ArchiveLinks = true
+
+	w := doRequest(s, "POST", "/api/v1/admin/archive", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting the search: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), "2 items found for 1 of 4 titles") {
+		t.Fatalf("search did not finish as expected: %s", w.Body.String())
+	}
+	if !slices.Contains(queries, `title:("Halo 3") AND mediatype:(texts OR image) AND (title:("Xbox 360") OR subject:("Xbox 360"))`) {
+		t.Errorf("unexpected queries: %q", queries)
+	}
+
+	w = doRequest(s, "GET", "/api/v1/titles/4d5307e6/archive", nil)
+	var resp ArchiveItemsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Items) != 2 || resp.Items[0].Identifier != "halo-3-manual" || resp.Items[1].Kind != "scan" ||
+		!strings.Contains(w.Body.String(), `"url":"https://archive.org/details/halo-3-manual"`) {
+		t.Errorf("unexpected items: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d530802/archive", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"items":[]`) {
+		t.Errorf("title without items: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/ffffffff/archive", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown title: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400", nil); w.Code != http.StatusOK {
+		t.Errorf("pictures should still be served: status = %d", w.Code)
+	}
+
+	// Only titles without items are searched again
+	queries = nil
+	doRequest(s, "POST", "/api/v1/admin/archive", admin)
+	s.jobs.Wait()
+	if len(queries) != 3 {
+		t.Errorf("second search made %d queries, want 3", len(queries))
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/birabittoh/xtitles/docs"
+	"github.com/birabittoh/xtitles/templates"
+)
+
+// embeddedAssets are the files the server needs at runtime, bundled so that
+// the binary runs from any working directory. They are laid out like the
+// repository, for ASSETS_DIR to override.
+var embeddedAssets = mountFS{"templates": templates.FS, "docs": docs.FS}
+
+// mountFS serves each file system under a directory of its own.
+type mountFS map[string]fs.FS
+
+// resolve returns the file system and the path within it of name.
+func (m mountFS) resolve(op, name string) (fs.FS, string, error) {
+	if !fs.ValidPath(name) {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, rest, _ := strings.Cut(name, "/")
+	sub, ok := m[dir]
+	if !ok {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if rest == "" {
+		rest = "."
+	}
+	return sub, rest, nil
+}
+
+func (m mountFS) Open(name string) (fs.File, error) {
+	sub, rest, err := m.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return sub.Open(rest)
+}
+
+func (m mountFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	sub, rest, err := m.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadDir(sub, rest)
+}
+
+// overlayFS serves files from upper when they exist there, from lower
+// otherwise, merging directory listings.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.lower.Open(name)
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(o.upper, name)
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	if upperErr != nil && lowerErr != nil {
+		return nil, lowerErr
+	}
+
+	entries := upper
+	for _, e := range lower {
+		if !slices.ContainsFunc(upper, func(u fs.DirEntry) bool { return u.Name() == e.Name() }) {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+// assets returns the embedded assets, overridden file by file by those found
+// in ASSETS_DIR when it is set, to customize templates without rebuilding.
+func (s *Server) assets() fs.FS {
+	if s.config.AssetsDir == "" {
+		return embeddedAssets
+	}
+	return overlayFS{upper: os.DirFS(s.config.AssetsDir), lower: embeddedAssets}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedAssets(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	s := newTestServer(t, testTitles)
+	if w := doRequest(s, "GET", "/", nil); w.Code != http.StatusOK {
+		t.Errorf("frontend from another directory: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	w := doRequest(s, "GET", "/api/openapi.json", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"openapi"`) {
+		t.Errorf("OpenAPI spec from another directory: status = %d", w.Code)
+	}
+
+	override := t.TempDir()
+	os.MkdirAll(filepath.Join(override, "templates"), 0755)
+	os.WriteFile(filepath.Join(override, "templates", "index.html"), []byte(`custom {{.title}}`), 0644)
+	s = newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.AssetsDir = override })
+	if w := doRequest(s, "GET", "/", nil); !strings.Contains(w.Body.String(), "custom XTitles") {
+		t.Errorf("template was not overridden: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/compare", nil); w.Code != http.StatusOK {
+		t.Errorf("templates missing from the override directory should be embedded: status = %d", w.Code)
+	}
+}
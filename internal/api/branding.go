@@ -0,0 +1,76 @@
+package api
+
+import (
+	"html/template"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// defaultBackground is the background of the pages unless BRAND_BACKGROUND_COLOR is set.
+const defaultBackground = "linear-gradient(135deg, #0a1a0a 0%, #1a3d1a 50%, #2d5a2d 100%)"
+
+var brandColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Branding is how an instance presents itself in its pages, so that mirrors
+// can tell themselves apart.
+type Branding struct {
+	Name        string
+	Tagline     string
+	LogoURL     string
+	AccentColor template.CSS
+	Background  template.CSS
+	FooterLinks []BrandingLink
+}
+
+type BrandingLink struct {
+	Label string
+	URL   string
+}
+
+func loadBranding() Branding {
+	return Branding{
+		Name:        getEnv("BRAND_NAME", "XTitles"),
+		Tagline:     getEnv("BRAND_TAGLINE", "Browse and search Xbox 360 game titles and their gamerpics"),
+		LogoURL:     brandURL("BRAND_LOGO_URL", getEnv("BRAND_LOGO_URL", "")),
+		AccentColor: template.CSS(brandColor("BRAND_ACCENT_COLOR", getEnv("BRAND_ACCENT_COLOR", ""), "#90ee90")),
+		Background:  template.CSS(brandColor("BRAND_BACKGROUND_COLOR", getEnv("BRAND_BACKGROUND_COLOR", ""), defaultBackground)),
+		FooterLinks: parseFooterLinks(getEnv("BRAND_FOOTER_LINKS", "Source Code=https://github.com/birabittoh/xtitles,API=/api/openapi.json")),
+	}
+}
+
+// brandColor validates a hex color, since it ends up in style sheets.
+func brandColor(name, value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	if !brandColorPattern.MatchString(value) {
+		log.Printf("Warning: ignoring %s=%q, expected a color like #90ee90\n", name, value)
+		return fallback
+	}
+	return value
+}
+
+// brandURL accepts absolute http(s) links and paths on this instance.
+func brandURL(name, value string) string {
+	if value == "" || strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://") ||
+		(strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "//")) {
+		return value
+	}
+	log.Printf("Warning: ignoring %s=%q, expected an http(s) URL or a path\n", name, value)
+	return ""
+}
+
+// parseFooterLinks parses a Label=URL list. Labels can't contain commas.
+func parseFooterLinks(value string) []BrandingLink {
+	var links []BrandingLink
+	for _, pair := range parseList(value) {
+		label, link, ok := strings.Cut(pair, "=")
+		label, link = strings.TrimSpace(label), brandURL("BRAND_FOOTER_LINKS", strings.TrimSpace(link))
+		if !ok || label == "" || link == "" {
+			continue
+		}
+		links = append(links, BrandingLink{Label: label, URL: link})
+	}
+	return links
+}
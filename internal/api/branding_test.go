@@ -0,0 +1,40 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBranding(t *testing.T) {
+	t.Setenv("BRAND_NAME", "Gamerpic Mirror")
+	t.Setenv("BRAND_LOGO_URL", "/static/logo.png")
+	t.Setenv("BRAND_ACCENT_COLOR", "#ff8800")
+	t.Setenv("BRAND_BACKGROUND_COLOR", "red;}body{display:none")
+	t.Setenv("BRAND_FOOTER_LINKS", "Status=https://status.example.com,Bad=javascript:alert(1)")
+	s := newTestServer(t, testTitles)
+
+	w := doRequest(s, "GET", "/", nil)
+	for _, want := range []string{
+		"<title>Gamerpic Mirror</title>", `--accent: #ff8800`, `src="/static/logo.png"`,
+		`href="https://status.example.com"`, `content="Gamerpic Mirror"`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("index does not contain %q", want)
+		}
+	}
+	for _, unwanted := range []string{"display:none", "javascript", "Source Code"} {
+		if strings.Contains(w.Body.String(), unwanted) {
+			t.Errorf("index contains %q", unwanted)
+		}
+	}
+
+	w = doRequest(s, "GET", "/titles/4d5307e6", nil)
+	for _, want := range []string{
+		"- Gamerpic Mirror</title>", `property="og:title"`, `property="og:url" content="http://example.com/titles/4d5307e6"`,
+		`property="og:image"`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("title page does not contain %q", want)
+		}
+	}
+}
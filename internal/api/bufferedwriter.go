@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bufferedWriter holds back a response for a middleware to rewrite once the
+// handler is done, unless the handler flushes: streamed responses go out as
+// they are written.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	// onWrite, if set, is called before each buffered write, and may start
+	// streaming. onStream is called before the headers go out when the
+	// response starts streaming
+	onWrite  func()
+	onStream func()
+
+	body        bytes.Buffer
+	wroteHeader bool
+	streaming   bool
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if !w.streaming && w.onWrite != nil {
+		w.onWrite()
+	}
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	if w.streaming {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.wroteHeader = true
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.wroteHeader || w.body.Len() > 0 || w.ResponseWriter.Written()
+}
+
+func (w *bufferedWriter) Size() int {
+	if w.streaming {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Flush() {
+	w.stream()
+	w.ResponseWriter.Flush()
+}
+
+// stream sends what was buffered and lets the rest of the response through.
+func (w *bufferedWriter) stream() {
+	if w.streaming {
+		return
+	}
+	w.streaming = true
+	if w.onStream != nil {
+		w.onStream()
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	w.ResponseWriter.Write(w.body.Bytes())
+	w.body.Reset()
+}
+
+// release restores the writer it wraps on c and sends it body, the buffered
+// one or its rewrite. Streamed responses are already out.
+func (w *bufferedWriter) release(c *gin.Context, body []byte) {
+	c.Writer = w.ResponseWriter
+	if w.streaming {
+		return
+	}
+	c.Writer.Header().Del("Content-Length")
+	if w.wroteHeader {
+		c.Writer.WriteHeaderNow()
+	}
+	if len(body) > 0 {
+		c.Writer.Write(body)
+	}
+}
+
+// isJSON tells whether the response on c is JSON.
+func isJSON(c *gin.Context) bool {
+	return strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "application/json")
+}
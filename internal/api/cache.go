@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CachePolicy describes the Cache-Control header sent for a class of routes.
+// Ages are in seconds; a policy with both ages at zero disables caching.
+type CachePolicy struct {
+	MaxAge    int
+	SMaxAge   int
+	Immutable bool
+}
+
+func loadCachePolicy(class string, defaults CachePolicy) CachePolicy {
+	prefix := "CACHE_" + strings.ToUpper(class) + "_"
+	return CachePolicy{
+		MaxAge:    getEnvInt(prefix+"MAX_AGE", defaults.MaxAge),
+		SMaxAge:   getEnvInt(prefix+"S_MAXAGE", defaults.SMaxAge),
+		Immutable: getEnvBool(prefix+"IMMUTABLE", defaults.Immutable),
+	}
+}
+
+func (p CachePolicy) Header() string {
+	if p.MaxAge <= 0 && p.SMaxAge <= 0 {
+		return "no-cache"
+	}
+
+	directives := []string{"public", fmt.Sprintf("max-age=%d", max(p.MaxAge, 0))}
+	if p.SMaxAge > 0 {
+		directives = append(directives, fmt.Sprintf("s-maxage=%d", p.SMaxAge))
+	}
+	if p.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// setCacheHeaders applies policy to a successful response.
+func setCacheHeaders(c *gin.Context, policy CachePolicy) {
+	c.Header("Cache-Control", policy.Header())
+	if policy.MaxAge > 0 {
+		c.Header("Expires", time.Now().Add(time.Duration(policy.MaxAge)*time.Second).Format(http.TimeFormat))
+	}
+}
+
+// catalogVersion identifies the state of the catalog for conditional requests
+// on listings. It starts over, with a new epoch, whenever the server starts.
+type catalogVersion struct {
+	mu       sync.RWMutex
+	epoch    int64
+	version  uint64
+	modified time.Time
+}
+
+// catalogChanged must be called after titles or pictures are written.
+func (s *Server) catalogChanged() {
+	s.catalog.mu.Lock()
+	s.catalog.version++
+	s.catalog.modified = time.Now()
+	s.catalog.mu.Unlock()
+
+	s.refreshSearchIndex()
+}
+
+// catalogValidators returns the ETag and Last-Modified time of listings.
+func (s *Server) catalogValidators() (string, time.Time) {
+	s.catalog.mu.RLock()
+	defer s.catalog.mu.RUnlock()
+	return fmt.Sprintf(`W/"%x-%d"`, s.catalog.epoch, s.catalog.version), s.catalog.modified
+}
+
+// catalogGeneration returns the generation of the catalog an ETag of
+// catalogValidators stands for, like 18c1f0e2a4b5c6d7-42. Listings read in a
+// snapshot report the generation read before it, so that pages of the same
+// generation are known to be consistent with each other.
+func catalogGeneration(etag string) string {
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the
+// weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag and Last-Modified headers of a response and
+// answers 304 with the cache headers of policy if the client already has it,
+// in which case it returns true.
+func notModified(c *gin.Context, policy CachePolicy, etag string, modified time.Time) bool {
+	c.Header("ETag", etag)
+	if !modified.IsZero() {
+		c.Header("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is only looked at without If-None-Match
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		if !etagMatches(ifNoneMatch, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	setCacheHeaders(c, policy)
+	c.Status(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	targets := []string{"/api/v1/titles", "/api/v1/titles/4d530802", "/api/v1/search?q=halo", "/api/v1/systems"}
+	validators := make(map[string]*httptest.ResponseRecorder)
+	for _, target := range targets {
+		w := doRequest(s, "GET", target, nil)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" {
+			t.Fatalf("%s: status = %d, ETag = %q, Last-Modified = %q", target, w.Code, w.Header().Get("ETag"), w.Header().Get("Last-Modified"))
+		}
+		validators[target] = w
+
+		for _, header := range []string{"If-None-Match", "If-Modified-Since"} {
+			value := w.Header().Get("ETag")
+			if header == "If-Modified-Since" {
+				value = w.Header().Get("Last-Modified")
+			}
+			cached := doRequest(s, "GET", target, map[string]string{header: value})
+			if cached.Code != http.StatusNotModified {
+				t.Errorf("%s with %s: status = %d, want %d", target, header, cached.Code, http.StatusNotModified)
+			}
+			if cached.Header().Get("Cache-Control") == "" {
+				t.Errorf("%s with %s: 304 is missing Cache-Control", target, header)
+			}
+		}
+	}
+
+	// Adding a picture changes the title and every listing
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	body, contentType := multipartPicture(t, "20402.png", pngData.Bytes())
+	header := map[string]string{"Authorization": "Bearer test-token", "Content-Type": contentType}
+	if w := doRequestBody(s, "POST", "/api/v1/admin/titles/4d530802/pictures", header, body); w.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	for _, target := range targets {
+		w := doRequest(s, "GET", target, map[string]string{"If-None-Match": validators[target].Header().Get("ETag")})
+		if w.Code != http.StatusOK {
+			t.Errorf("%s after a change: status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestListingGeneration(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	generation := func(target string) string {
+		t.Helper()
+		w := doRequest(s, "GET", target, nil)
+		var page struct {
+			Generation string `json:"generation"`
+			G          string `json:"g"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &page) != nil {
+			t.Fatalf("%s: status = %d; body: %s", target, w.Code, w.Body.String())
+		}
+		if page.Generation != "" && w.Header().Get("ETag") != `W/"`+page.Generation+`"` {
+			t.Errorf("%s: generation %q doesn't match ETag %s", target, page.Generation, w.Header().Get("ETag"))
+		}
+		return page.Generation + page.G
+	}
+
+	first := generation("/api/v1/titles?limit=2")
+	if first == "" {
+		t.Fatal("no generation in listing")
+	}
+	for _, target := range []string{"/api/v1/titles?limit=2&page=2", "/api/v1/search?q=halo", "/api/v1/manifest", "/api/v1/titles?profile=slim"} {
+		if got := generation(target); got != first {
+			t.Errorf("%s: generation = %q, want %q", target, got, first)
+		}
+	}
+
+	header := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+	w := doRequestBody(s, "POST", "/api/v1/admin/titles", header, `{"title_id":"4D5307F1","name":"Halo 3 Beta","systems":["XBOX360"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if got := generation("/api/v1/titles?limit=2&page=2"); got == first {
+		t.Errorf("generation unchanged after a write: %q", got)
+	}
+}
+
+func TestCachePolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy CachePolicy
+		want   string
+	}{
+		{CachePolicy{}, "no-cache"},
+		{CachePolicy{MaxAge: 60}, "public, max-age=60"},
+		{CachePolicy{SMaxAge: 600}, "public, max-age=0, s-maxage=600"},
+		{CachePolicy{MaxAge: 31536000, Immutable: true}, "public, max-age=31536000, immutable"},
+		{CachePolicy{MaxAge: -1, SMaxAge: 0}, "no-cache"},
+	} {
+		if got := tt.policy.Header(); got != tt.want {
+			t.Errorf("%+v: Header() = %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+
+	// Each route class takes its CACHE_<CLASS>_* variables, or its defaults
+	t.Setenv("CACHE_LISTS_MAX_AGE", "10")
+	t.Setenv("CACHE_LISTS_S_MAXAGE", "600")
+	t.Setenv("CACHE_PICTURES_IMMUTABLE", "false")
+	for _, tt := range []struct {
+		class    string
+		defaults CachePolicy
+		want     CachePolicy
+	}{
+		{"lists", CachePolicy{MaxAge: 60}, CachePolicy{MaxAge: 10, SMaxAge: 600}},
+		{"pictures", CachePolicy{MaxAge: 31536000, Immutable: true}, CachePolicy{MaxAge: 31536000}},
+		{"export", CachePolicy{MaxAge: 3600}, CachePolicy{MaxAge: 3600}},
+	} {
+		if got := loadCachePolicy(tt.class, tt.defaults); got != tt.want {
+			t.Errorf("%s: policy = %+v, want %+v", tt.class, got, tt.want)
+		}
+	}
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.CacheLists = CachePolicy{MaxAge: 10, SMaxAge: 600}
+		cfg.CacheDetails = CachePolicy{}
+		cfg.CachePictures = CachePolicy{MaxAge: 86400, Immutable: true}
+		cfg.CacheExport = CachePolicy{SMaxAge: 120}
+	})
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+	w := doRequestBody(s, "POST", "/api/v1/admin/exports", admin, `{}`)
+	var status ExportJobResponse
+	json.Unmarshal(w.Body.Bytes(), &status)
+	job, ok := s.jobs.Get(status.ID)
+	if !ok {
+		t.Fatalf("export: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	for job.Status().Status != jobDone && job.Status().Status != jobFailed {
+		time.Sleep(10 * time.Millisecond)
+	}
+	json.Unmarshal(doRequest(s, "GET", "/api/v1/admin/exports/"+status.ID, admin).Body.Bytes(), &status)
+	download, _ := url.Parse(status.DownloadURL)
+
+	list := doRequest(s, "GET", "/api/v1/titles", nil)
+	tests := []struct {
+		name   string
+		target string
+		header map[string]string
+		status int
+		want   string
+	}{
+		{"lists", "/api/v1/titles", nil, http.StatusOK, "public, max-age=10, s-maxage=600"},
+		{"revalidated list", "/api/v1/titles", map[string]string{"If-None-Match": list.Header().Get("ETag")}, http.StatusNotModified, "public, max-age=10, s-maxage=600"},
+		{"details", "/api/v1/titles/4d5307e6", nil, http.StatusOK, "no-cache"},
+		{"pictures", "/api/v1/titles/4d5307e6/20400.png", nil, http.StatusOK, "public, max-age=86400, immutable"},
+		{"export", download.RequestURI(), nil, http.StatusOK, "public, max-age=0, s-maxage=120"},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, tt.header)
+		if w.Code != tt.status || w.Header().Get("Cache-Control") != tt.want {
+			t.Errorf("%s: status = %d, Cache-Control = %q; want %d, %q", tt.name, w.Code, w.Header().Get("Cache-Control"), tt.status, tt.want)
+		}
+	}
+}
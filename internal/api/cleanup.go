@@ -0,0 +1,373 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	cleanupActionPictures = "cleanup.pictures"
+	cleanupActionTitles   = "cleanup.titles"
+
+	cleanupSampleSize = 10
+)
+
+// AuditEntry records a destructive admin operation and what it affected.
+type AuditEntry struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	Action   string `json:"action" gorm:"index"`
+	Filter   string `json:"filter"`
+	Affected int64  `json:"affected"`
+	ClientIP string `json:"client_ip"`
+	// The API key the operation was made with, empty for ADMIN_TOKEN
+	APIKey    string    `json:"api_key,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PictureCleanupRequest selects the pictures to delete. Picture names are
+// kinds of artwork, e.g. 8000 for the title icon or the 28000 range for
+// gamerpics, so a name prefix removes a whole range at once.
+type PictureCleanupRequest struct {
+	Name         string `json:"name,omitempty"`
+	NamePrefix   string `json:"name_prefix,omitempty"`
+	TitleID      string `json:"title_id,omitempty"`
+	DeleteFiles  bool   `json:"delete_files,omitempty"`
+	ConfirmToken string `json:"confirm_token,omitempty"`
+}
+
+// TitleCleanupRequest selects the titles to delete.
+type TitleCleanupRequest struct {
+	System          string `json:"system,omitempty"`
+	NameContains    string `json:"name_contains,omitempty"`
+	WithoutPictures bool   `json:"without_pictures,omitempty"`
+	ConfirmToken    string `json:"confirm_token,omitempty"`
+}
+
+// CleanupPreview is the answer to a cleanup request without a confirmation
+// token: what would be deleted, and the token to send to go ahead.
+type CleanupPreview struct {
+	Action       string     `json:"action"`
+	Matched      int64      `json:"matched"`
+	Sample       []string   `json:"sample"`
+	ConfirmToken string     `json:"confirm_token,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// CleanupResult is the answer to a confirmed cleanup request.
+type CleanupResult struct {
+	Action  string `json:"action"`
+	Deleted int64  `json:"deleted"`
+	Updated int64  `json:"updated"`
+	AuditID uint   `json:"audit_id"`
+}
+
+// cleanupSignature binds a confirmation token to the action, its filter and
+// the number of matches, so that it can't be used for a different cleanup or
+// once the matches have changed.
+func (s *Server) cleanupSignature(action, filter string, matched, expires int64) string {
+	mac := hmac.New(sha256.New, s.cleanupKey)
+	fmt.Fprintf(mac, "%s|%s|%d|%d", action, filter, matched, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// confirmCleanup answers a cleanup request without a token with a preview of
+// its matches, or checks the token of a confirmed one. It returns true if the
+// cleanup should go ahead, having written the response otherwise.
+func (s *Server) confirmCleanup(c *gin.Context, action, filter, token string, matched int64, sample []string) bool {
+	if token == "" {
+		preview := CleanupPreview{Action: action, Matched: matched, Sample: sample}
+		if matched > 0 {
+			expiresAt := time.Now().Add(s.config.CleanupConfirmTTL).Truncate(time.Second)
+			expires := expiresAt.Unix()
+			preview.ConfirmToken = fmt.Sprintf("%d.%s", expires, s.cleanupSignature(action, filter, matched, expires))
+			preview.ExpiresAt = &expiresAt
+		}
+		c.JSON(http.StatusOK, preview)
+		return false
+	}
+
+	rawExpires, signature, ok := strings.Cut(token, ".")
+	expires, err := strconv.ParseInt(rawExpires, 10, 64)
+	if !ok || err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid confirm_token"})
+		return false
+	}
+	if time.Now().Unix() > expires {
+		c.JSON(http.StatusConflict, gin.H{"error": "confirm_token has expired, request a new preview"})
+		return false
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.cleanupSignature(action, filter, matched, expires))) {
+		c.JSON(http.StatusConflict, gin.H{"error": "confirm_token does not match this cleanup, request a new preview"})
+		return false
+	}
+	return true
+}
+
+// audit records a destructive admin operation.
+func (s *Server) audit(tx *gorm.DB, c *gin.Context, action, filter string, affected int64) (AuditEntry, error) {
+	entry := AuditEntry{
+		Action:   action,
+		Filter:   filter,
+		Affected: affected,
+		ClientIP: c.ClientIP(),
+		APIKey:   requestAPIKeyName(c),
+	}
+	return entry, tx.Create(&entry).Error
+}
+
+func (req PictureCleanupRequest) scope(db *gorm.DB) *gorm.DB {
+	if req.Name != "" {
+		db = db.Where("name = ?", req.Name)
+	}
+	if req.NamePrefix != "" {
+		db = db.Where("substr(name, 1, ?) = ?", len(req.NamePrefix), req.NamePrefix)
+	}
+	if req.TitleID != "" {
+		db = db.Where("title_id = ?", req.TitleID)
+	}
+	return db
+}
+
+func (s *Server) cleanupPictures(c *gin.Context) {
+	var req PictureCleanupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+	req.NamePrefix = strings.ToLower(strings.TrimSpace(req.NamePrefix))
+	req.TitleID = strings.ToUpper(strings.TrimSpace(req.TitleID))
+	if req.Name == "" && req.NamePrefix == "" && req.TitleID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one of name, name_prefix or title_id is required"})
+		return
+	}
+	if (req.Name != "" && !pictureNamePattern.MatchString(req.Name)) ||
+		(req.NamePrefix != "" && !pictureNamePattern.MatchString(req.NamePrefix)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid picture name"})
+		return
+	}
+
+	token := req.ConfirmToken
+	req.ConfirmToken = ""
+	filter, _ := json.Marshal(req)
+
+	s.titleEditMu.Lock()
+	defer s.titleEditMu.Unlock()
+
+	var pictures []Picture
+	if err := s.db.Scopes(req.scope).Order("title_id ASC").Order("name ASC").Find(&pictures).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	sample := []string{}
+	for _, p := range pictures[:min(len(pictures), cleanupSampleSize)] {
+		sample = append(sample, strings.ToLower(p.TitleID)+"/"+p.Name+s.config.PicturesSuffix)
+	}
+
+	if !s.confirmCleanup(c, cleanupActionPictures, string(filter), token, int64(len(pictures)), sample) {
+		return
+	}
+
+	var deleted int64
+	var entry AuditEntry
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Scopes(req.scope).Delete(&Picture{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+
+		titleIDs := make([]string, 0, len(pictures))
+		for _, p := range pictures {
+			titleIDs = append(titleIDs, p.TitleID)
+		}
+		now := time.Now()
+		for ids := range slices.Chunk(slices.Compact(titleIDs), 500) {
+			if err := tx.Model(&Title{}).Where("title_id IN ?", ids).UpdateColumn("updated_at", now).Error; err != nil {
+				return err
+			}
+		}
+
+		var err error
+		entry, err = s.audit(tx, c, cleanupActionPictures, string(filter), deleted)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if req.DeleteFiles {
+		for _, p := range pictures {
+			if err := s.pictures.Delete(s.ctx, p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Warning: removing picture %s failed: %v\n", pictureKey(p), err)
+			}
+			s.removeDerivedPictures(strings.ToLower(p.TitleID), p.Name)
+		}
+	}
+
+	s.catalogChanged()
+
+	c.JSON(http.StatusOK, CleanupResult{Action: cleanupActionPictures, Deleted: deleted, AuditID: entry.ID})
+}
+
+func (req TitleCleanupRequest) scope(db *gorm.DB) *gorm.DB {
+	db = store.FilterBySystem(db, req.System)
+	if req.NameContains != "" {
+		db = db.Where(`lower(titles.name) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(req.NameContains))+"%")
+	}
+	if req.WithoutPictures {
+		db = db.Where("NOT EXISTS (SELECT 1 FROM pictures WHERE pictures.title_id = titles.title_id)")
+	}
+	return db
+}
+
+// cleanupTitles deletes the titles matching a filter. When purging a system,
+// titles that upstream also lists under other systems only lose that one.
+func (s *Server) cleanupTitles(c *gin.Context) {
+	var req TitleCleanupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	req.System = strings.ToUpper(strings.TrimSpace(req.System))
+	req.NameContains = strings.TrimSpace(req.NameContains)
+	if req.System == "" && req.NameContains == "" && !req.WithoutPictures {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one of system, name_contains or without_pictures is required"})
+		return
+	}
+
+	token := req.ConfirmToken
+	req.ConfirmToken = ""
+	filter, _ := json.Marshal(req)
+
+	s.titleEditMu.Lock()
+	defer s.titleEditMu.Unlock()
+
+	var titles []Title
+	if err := s.db.Model(&Title{}).Scopes(req.scope).Select("title_id", "name", "systems").Order("title_id ASC").Find(&titles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	sample := []string{}
+	for _, t := range titles[:min(len(titles), cleanupSampleSize)] {
+		sample = append(sample, t.TitleID+" "+t.Name)
+	}
+
+	if !s.confirmCleanup(c, cleanupActionTitles, string(filter), token, int64(len(titles)), sample) {
+		return
+	}
+
+	var deleted, updated int64
+	var entry AuditEntry
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var deleteIDs []string
+		for _, t := range titles {
+			if req.System == "" || len(t.Systems) <= 1 {
+				deleteIDs = append(deleteIDs, t.TitleID)
+				continue
+			}
+			systems := slices.DeleteFunc(slices.Clone(t.Systems), func(system string) bool { return system == req.System })
+			if err := tx.Model(&Title{TitleID: t.TitleID}).Select("systems").Updates(&Title{Systems: systems}).Error; err != nil {
+				return err
+			}
+			updated++
+		}
+
+		// Picture files stay on disk, only their index rows go away
+		for ids := range slices.Chunk(deleteIDs, 500) {
+			if err := tx.Where("title_id IN ?", ids).Delete(&Picture{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("title_id IN ?", ids).Delete(&ExternalID{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("title_id IN ?", ids).Delete(&AchievementSet{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("title_id IN ?", ids).Delete(&MarketValue{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("title_id IN ?", ids).Delete(&ReviewScore{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("title_id IN ?", ids).Delete(&ArchiveItem{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("title_id IN ?", ids).Delete(&MediaLink{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("title_id IN ?", ids).Delete(&TitleView{}).Error; err != nil {
+				return err
+			}
+			result := tx.Where("title_id IN ?", ids).Delete(&Title{})
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted += result.RowsAffected
+		}
+
+		var err error
+		entry, err = s.audit(tx, c, cleanupActionTitles, string(filter), deleted+updated)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	s.catalogChanged()
+
+	c.JSON(http.StatusOK, CleanupResult{Action: cleanupActionTitles, Deleted: deleted, Updated: updated, AuditID: entry.ID})
+}
+
+func (s *Server) getAuditLog(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	offset := (page - 1) * limit
+
+	var entries []AuditEntry
+	var total int64
+	err := store.ReadSnapshot(c.Request.Context(), s.db, func(tx *gorm.DB) error {
+		if err := tx.Model(&AuditEntry{}).Count(&total).Error; err != nil {
+			return err
+		}
+		return tx.Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Items:  entries,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		Page:   page,
+		Pages:  int((total + int64(limit) - 1) / int64(limit)),
+	})
+}
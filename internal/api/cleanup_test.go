@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanup(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+
+	steps := []struct {
+		name     string
+		target   string
+		body     string
+		confirm  bool
+		status   int
+		contains string
+	}{
+		{"filter required", "/api/v1/admin/cleanup/pictures", `{}`, false, http.StatusBadRequest, "required"},
+		{"pictures preview", "/api/v1/admin/cleanup/pictures", `{"name_prefix":"204"}`, false, http.StatusOK, `"matched":3`},
+		{"token bound to filter", "/api/v1/admin/cleanup/pictures", `{"name":"20401"}`, true, http.StatusConflict, "does not match"},
+		{"pictures confirmed", "/api/v1/admin/cleanup/pictures", `{"name_prefix":"204"}`, true, http.StatusOK, `"deleted":3`},
+		{"token bound to matches", "/api/v1/admin/cleanup/pictures", `{"name_prefix":"204"}`, true, http.StatusConflict, "does not match"},
+		{"system preview", "/api/v1/admin/cleanup/titles", `{"system":"pc"}`, false, http.StatusOK, `"matched":1`},
+		{"system confirmed", "/api/v1/admin/cleanup/titles", `{"system":"pc"}`, true, http.StatusOK, `"deleted":0,"updated":1`},
+		{"filter preview", "/api/v1/admin/cleanup/titles", `{"name_contains":"HALO"}`, false, http.StatusOK, `"matched":2`},
+		{"filter confirmed", "/api/v1/admin/cleanup/titles", `{"name_contains":"HALO"}`, true, http.StatusOK, `"deleted":2`},
+	}
+
+	token := ""
+	for _, step := range steps {
+		body := step.body
+		if step.confirm {
+			var withToken map[string]any
+			json.Unmarshal([]byte(body), &withToken)
+			withToken["confirm_token"] = token
+			data, _ := json.Marshal(withToken)
+			body = string(data)
+		}
+		w := doRequestBody(s, "POST", step.target, admin, body)
+		if w.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d; body: %s", step.name, w.Code, step.status, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), step.contains) {
+			t.Errorf("%s: body does not contain %q: %s", step.name, step.contains, w.Body.String())
+		}
+		var preview CleanupPreview
+		if json.Unmarshal(w.Body.Bytes(), &preview) == nil && preview.ConfirmToken != "" {
+			token = preview.ConfirmToken
+		}
+	}
+
+	w := doRequest(s, "GET", "/api/v1/titles/584109eb", nil)
+	if !strings.Contains(w.Body.String(), `"systems":["XBOX360"]`) {
+		t.Errorf("purged system was not removed from a multi-system title: %s", w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(s.config.PicturesFolder, "4d5307e6", "20400.png")); err != nil {
+		t.Errorf("picture files should be kept without delete_files: %v", err)
+	}
+
+	w = doRequest(s, "GET", "/api/v1/admin/audit", admin)
+	if !strings.Contains(w.Body.String(), `"total":3`) || !strings.Contains(w.Body.String(), `"action":"cleanup.titles"`) {
+		t.Errorf("audit log does not list the cleanups: %s", w.Body.String())
+	}
+}
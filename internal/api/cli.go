@@ -0,0 +1,409 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// command is a subcommand of the binary.
+type command struct {
+	name string
+	args string
+	help string
+	run  func(cfg Config, args []string) error
+}
+
+// commands are listed in this order by usage. Without a command the binary
+// serves, as it always did.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "serve", help: "Serve the catalog over HTTP (default)", run: serve},
+		{name: "sync", help: "Sync the catalog with upstream and write the JSON exports", run: syncCommand},
+		{name: "import", args: "<file.json>", help: "Merge titles from a JSON file, as a sync would", run: importCommand},
+		{name: "export", args: "[-o archive.zip] [-format f] [-system s]", help: "Write the JSON exports, or an artwork archive with -o", run: exportCommand},
+		{name: "rescan-pictures", args: "[-full]", help: "Index new picture files and drop the rows of deleted ones", run: rescanCommand},
+		{name: "dedupe-pictures", args: "[-link]", help: "List pictures with the same content, or hard-link them with -link", run: dedupeCommand},
+		{name: "merge", args: "[-prefer manual|newer] [-from driver] [-dry-run] <titles.full.json|dsn>", help: "Merge the titles of another instance, from an export or its database", run: mergeCommand},
+		{name: "migrate-db", args: "-to driver -to-dsn dsn [-from driver] [-from-dsn dsn]", help: "Copy every table into another, empty database", run: migrateDBCommand},
+		{name: "version", help: "Print the version of the binary", run: versionCommand},
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [command] [arguments]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", c.name, c.help)
+		if c.args != "" {
+			fmt.Fprintf(w, "  %-16s   %s %s\n", "", c.name, c.args)
+		}
+	}
+}
+
+// Run runs the command named by args[0], serving without one.
+func Run(args []string) error {
+	return runCommand(loadConfig(), args)
+}
+
+// runCommand runs the command named by args[0] with the rest of args.
+func runCommand(cfg Config, args []string) error {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || (len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help")) {
+		usage(os.Stdout)
+		return nil
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(cfg, args)
+		}
+	}
+	usage(os.Stderr)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func serve(cfg Config, args []string) error {
+	s, err := newServer(cfg)
+	if err != nil {
+		return fmt.Errorf("starting server: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Listen right away so that probes can tell a starting server from a dead one
+	log.Printf("xtitles %s starting on %s\n", buildVersion(), s.config.Address)
+	go func() {
+		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Server failed to start: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	started := make(chan error, 1)
+	s.background.Go(func() { started <- s.Start() })
+
+	select {
+	case err := <-started:
+		if err != nil {
+			return fmt.Errorf("starting server: %w", err)
+		}
+
+		s.exportToJSON()
+		s.background.Go(func() { s.runJanitor(s.config.JanitorInterval) })
+		s.background.Go(func() { s.runReportScheduler(s.config.ReportSchedulerInterval) })
+		s.background.Go(s.runSyncScheduler)
+
+		for _, addr := range listenAddresses(s.config.Address) {
+			if addr.Network == "unix" {
+				log.Printf("Serving on unix socket %s\n", addr.Address)
+				continue
+			}
+			host, port, _ := net.SplitHostPort(addr.Address)
+			if host == "" {
+				host = "localhost"
+			}
+			log.Printf("Frontend available at: http://%s\n", net.JoinHostPort(host, port))
+			log.Printf("API available at: http://%s/api/v1\n", net.JoinHostPort(host, port))
+		}
+		<-ctx.Done()
+		// A second signal skips the drain
+		stop()
+		s.drain(context.Background())
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: unclean shutdown: %v\n", err)
+	}
+	return nil
+}
+
+// withDatabase runs fn on a server whose database is open, but that neither
+// loads the catalog nor listens. An interrupt cancels fn through the server
+// context.
+func withDatabase(cfg Config, fn func(s *Server) error) error {
+	s, err := newServer(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, s.cancel)
+
+	if err := s.initDB(); err != nil {
+		return fmt.Errorf("initializing database: %w", err)
+	}
+	return fn(s)
+}
+
+func syncCommand(cfg Config, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("sync takes no arguments")
+	}
+	return withDatabase(cfg, func(s *Server) error {
+		if _, err := s.syncTitles(); err != nil {
+			return err
+		}
+		s.exportToJSON()
+		return nil
+	})
+}
+
+// readTitlesFile reads a file holding either a list of titles, like the JSON
+// exports, or an upstream response.
+func readTitlesFile(path string) ([]Title, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var titles []Title
+	if err := json.Unmarshal(data, &titles); err != nil {
+		var page struct {
+			Items []Title `json:"items"`
+		}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("%s is neither a list of titles nor an upstream response: %w", path, err)
+		}
+		titles = page.Items
+	}
+	return titles, nil
+}
+
+// importCommand merges titles from a file holding either a list of titles or
+// an upstream response. Like upstream data, imported titles don't override
+// curated ones.
+func importCommand(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: import <file.json>")
+	}
+	titles, err := readTitlesFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	return withDatabase(cfg, func(s *Server) error {
+		titles, rejects := s.validateTitles(titles)
+		for _, r := range rejects {
+			log.Printf("Skipping %s: %s\n", r.TitleID, r.Reason)
+		}
+
+		run := SyncRun{StartedAt: time.Now()}
+		if err := s.mergeTitlesIntoCatalog(&run, mergeTitles(titles)); err != nil {
+			return err
+		}
+		log.Printf("Import finished: %d added, %d updated, %d unchanged, %d skipped, %d pictures\n",
+			run.Added, run.Updated, run.Unchanged, len(rejects), run.Pictures)
+		return nil
+	})
+}
+
+func exportCommand(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "write an artwork archive to this path instead of the JSON exports")
+	var req ExportRequest
+	fs.StringVar(&req.Format, "format", exportFormatJSON, "archive format: json, gamelist or launchbox")
+	fs.StringVar(&req.System, "system", "", "only export titles of this system")
+	fs.StringVar(&req.ROMPath, "rom-path", defaultExportROMPath, "ROM path template of gamelist archives")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := req.normalize(); err != nil {
+		return err
+	}
+
+	return withDatabase(cfg, func(s *Server) error {
+		if *output == "" {
+			s.exportToJSON()
+			return nil
+		}
+
+		job := s.jobs.Start(exportJobKind, func(ctx context.Context, job *Job) error {
+			return s.runArtworkExport(ctx, job, req)
+		})
+		s.jobs.Wait()
+		if status := job.Status(); status.Status != jobDone {
+			return fmt.Errorf("export failed: %s", status.Error)
+		}
+		defer os.Remove(job.Result())
+		if err := copyFile(job.Result(), *output); err != nil {
+			return err
+		}
+		log.Printf("Exported %d pictures to %s\n", job.Status().Total, *output)
+		return nil
+	})
+}
+
+// rescanCommand rescans the pictures, and with -full reads the files of
+// unchanged folders too.
+func rescanCommand(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("rescan-pictures", flag.ContinueOnError)
+	full := fs.Bool("full", false, "read every file, even in unchanged folders")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: rescan-pictures [-full]")
+	}
+	return withDatabase(cfg, func(s *Server) error {
+		rescan, err := s.rescanPictures(*full, nil)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rescan finished: %s\n", rescan)
+		return nil
+	})
+}
+
+// dedupeCommand reports the duplicate pictures, and with -link replaces them
+// with hard links to their original.
+func dedupeCommand(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("dedupe-pictures", flag.ContinueOnError)
+	link := fs.Bool("link", false, "hard-link duplicates to their original")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: dedupe-pictures [-link]")
+	}
+	return withDatabase(cfg, func(s *Server) error {
+		if *link {
+			result, err := s.dedupePictures()
+			if err != nil {
+				return err
+			}
+			log.Printf("Dedupe finished: %s\n", result)
+			return nil
+		}
+
+		report, err := s.duplicateReport()
+		if err != nil {
+			return err
+		}
+		for _, group := range report.Groups {
+			fmt.Printf("%s  %s\n", group.SHA256[:12], strings.Join(group.Pictures, " "))
+		}
+		log.Printf("%d duplicate pictures in %d groups, %d bytes to save with -link\n", report.Duplicates, len(report.Groups), report.WastedBytes)
+		return nil
+	})
+}
+
+// mergeCommand merges the titles of another instance into this catalog, from
+// a JSON export like titles.full.json or from its database, to consolidate
+// forks. Titles both have differently go to the side -prefer picks.
+func mergeCommand(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	prefer := fs.String("prefer", mergePreferManual, "on conflicts keep the curated title, then the newer one (manual), or the newer one (newer)")
+	from := fs.String("from", dbDriverSQLite, "driver of the database to merge, unless merging a .json export")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: merge [-prefer manual|newer] [-from driver] [-dry-run] <titles.full.json|dsn>")
+	}
+	if *prefer != mergePreferManual && *prefer != mergePreferNewer {
+		return fmt.Errorf("invalid -prefer %q, expected manual or newer", *prefer)
+	}
+	source := fs.Arg(0)
+
+	return withDatabase(cfg, func(s *Server) error {
+		var titles []Title
+		if strings.HasSuffix(strings.ToLower(source), ".json") {
+			var err error
+			if titles, err = readTitlesFile(source); err != nil {
+				return err
+			}
+		} else {
+			if *from == s.config.DBDriver && source == s.dbDSN() {
+				return fmt.Errorf("cannot merge a database into itself")
+			}
+			other, err := openDatabase(*from, source)
+			if err != nil {
+				return err
+			}
+			defer closeDB(other)
+			if err := other.WithContext(s.ctx).Find(&titles).Error; err != nil {
+				return fmt.Errorf("reading the titles of %s: %w", source, err)
+			}
+		}
+
+		merge, err := s.mergeCatalog(titles, *prefer, *dryRun, func(c mergeConflict) {
+			fmt.Println(c)
+		})
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			log.Printf("Dry run, nothing written and pictures not indexed: %s\n", merge)
+			return nil
+		}
+		log.Printf("Merge finished: %s\n", merge)
+		return nil
+	})
+}
+
+// migrateDBCommand copies the database, by default the configured one, into
+// another one, e.g. to move a deployment from SQLite to Postgres.
+func migrateDBCommand(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("migrate-db", flag.ContinueOnError)
+	from := fs.String("from", cfg.DBDriver, "driver of the database to copy")
+	fromDSN := fs.String("from-dsn", "", "database to copy (default: DB_DSN, or DB_FILE in the data directory)")
+	to := fs.String("to", "", "driver of the database to copy into")
+	toDSN := fs.String("to-dsn", "", "database to copy into, which must be empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" || *toDSN == "" || fs.NArg() > 0 {
+		return fmt.Errorf("usage: migrate-db -to driver -to-dsn dsn [-from driver] [-from-dsn dsn]")
+	}
+
+	cfg.DBDriver = *from
+	if *fromDSN != "" {
+		cfg.DBDSN = *fromDSN
+	}
+	return withDatabase(cfg, func(s *Server) error {
+		if *to == s.config.DBDriver && *toDSN == s.dbDSN() {
+			return fmt.Errorf("cannot migrate a database into itself")
+		}
+		dst, err := openDatabase(*to, *toDSN)
+		if err != nil {
+			return err
+		}
+		defer closeDB(dst)
+
+		log.Printf("Migrating the %s database to %s...\n", s.config.DBDriver, *to)
+		copies, err := migrateDatabase(s.ctx, s.db, dst, logTableProgress)
+		if err != nil {
+			return err
+		}
+		var rows int64
+		for _, c := range copies {
+			rows += c.Copied
+		}
+		log.Printf("Migration finished: %d rows in %d tables, counts verified\n", rows, len(copies))
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	cfg := testConfig(t, fakeUpstream(t, testTitles).URL)
+	writePictureTree(t, cfg.PicturesFolder, testPictures)
+	// The JSON exports are written to the working directory
+	t.Chdir(t.TempDir())
+
+	if err := runCommand(cfg, []string{"frobnicate"}); err == nil {
+		t.Error("expected an error for an unknown command")
+	}
+	if err := runCommand(cfg, []string{"version"}); err != nil {
+		t.Errorf("version: %v", err)
+	}
+	if err := runCommand(cfg, []string{"sync"}); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if _, err := os.Stat("titles.json"); err != nil {
+		t.Errorf("sync did not write the exports: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "import.json")
+	os.WriteFile(file, []byte(`{"items":[{"title_id":"5841125A","name":"Terraria","systems":["XBOX360"]}]}`), 0644)
+	if err := runCommand(cfg, []string{"import", file}); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	writePictureTree(t, cfg.PicturesFolder, map[string][]string{"5841125a": {"20400"}})
+	os.Remove(filepath.Join(cfg.PicturesFolder, "4d5307e6", "20401.png"))
+	if err := runCommand(cfg, []string{"rescan-pictures"}); err != nil {
+		t.Fatalf("rescan-pictures: %v", err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "artwork.zip")
+	if err := runCommand(cfg, []string{"export", "-o", archive, "-system", "xbox360"}); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if !slices.Contains(names, "titles/5841125a/20400.png") || slices.Contains(names, "titles/4d5307e6/20401.png") {
+		t.Errorf("archive does not reflect the import and rescan: %v", names)
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CompareResponse struct {
+	Items       []Title  `json:"items"`
+	Differences []string `json:"differences"`
+}
+
+func (s *Server) compareTitles(c *gin.Context) {
+	ids := strings.Split(c.Query("ids"), ",")
+	if len(ids) != 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'ids' must contain exactly two title IDs"})
+		return
+	}
+
+	titles := make([]Title, len(ids))
+	for i, id := range ids {
+		var err error
+		if titles[i], err = s.findTitle(id); err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Title %s not found", id)})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	setCacheHeaders(c, s.config.CacheLists)
+	c.JSON(http.StatusOK, CompareResponse{
+		Items:       titles,
+		Differences: diffTitles(titles[0], titles[1]),
+	})
+}
+
+// diffTitles returns the JSON names of the fields that differ between a and b.
+func diffTitles(a, b Title) []string {
+	diff := []string{}
+	if a.TitleID != b.TitleID {
+		diff = append(diff, "title_id")
+	}
+	if a.Name != b.Name {
+		diff = append(diff, "name")
+	}
+	if !slices.Equal(a.Systems, b.Systems) {
+		diff = append(diff, "systems")
+	}
+	if a.BingID != b.BingID {
+		diff = append(diff, "bing_id")
+	}
+	if !equalStringPtr(a.ServiceConfigID, b.ServiceConfigID) {
+		diff = append(diff, "service_config_id")
+	}
+	if !equalStringPtr(a.PFN, b.PFN) {
+		diff = append(diff, "pfn")
+	}
+	if !slices.Equal(pictureNames(a.Pictures), pictureNames(b.Pictures)) {
+		diff = append(diff, "pictures")
+	}
+	return diff
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func pictureNames(pictures []Picture) []string {
+	names := make([]string, len(pictures))
+	for i, pic := range pictures {
+		names[i] = pic.Name
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffTitles(t *testing.T) {
+	cfg := "66acd000"
+	otherCfg := "77fe1000"
+	base := func() Title {
+		return Title{
+			TitleID:         "4D5307E6",
+			Name:            "Halo 3",
+			Systems:         []string{"XBOX360"},
+			BingID:          "bing",
+			ServiceConfigID: &cfg,
+			Pictures:        []Picture{{Name: "20400"}, {Name: "20401"}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Title)
+		want   []string
+	}{
+		{"identical", func(*Title) {}, []string{}},
+		{"picture order", func(t *Title) { t.Pictures = []Picture{{Name: "20401"}, {Name: "20400"}} }, []string{}},
+		{"same service config", func(t *Title) { same := cfg; t.ServiceConfigID = &same }, []string{}},
+		{"regional variant", func(t *Title) { t.TitleID, t.BingID = "4D5307E7", "" }, []string{"title_id", "bing_id"}},
+		{"name", func(t *Title) { t.Name = "Halo 3: ODST" }, []string{"name"}},
+		{"systems", func(t *Title) { t.Systems = []string{"XBOX360", "PC"} }, []string{"systems"}},
+		{"missing service config", func(t *Title) { t.ServiceConfigID = nil }, []string{"service_config_id"}},
+		{"other service config", func(t *Title) { t.ServiceConfigID = &otherCfg }, []string{"service_config_id"}},
+		{"pfn", func(t *Title) { pfn := "Halo"; t.PFN = &pfn }, []string{"pfn"}},
+		{"pictures", func(t *Title) { t.Pictures = t.Pictures[:1] }, []string{"pictures"}},
+	}
+	for _, tt := range tests {
+		b := base()
+		tt.change(&b)
+		if got := diffTitles(base(), b); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: differences = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := diffTitles(b, base()); !slices.Equal(got, tt.want) {
+			t.Errorf("%s (swapped): differences = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompareTitles(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/api/v1/compare?ids=4d5307e6,4D5307E6", http.StatusOK, `"differences":[]`},
+		{"/api/v1/compare?ids=4d5307e6,584109eb", http.StatusOK, `"differences":["title_id","name","systems","bing_id","pictures"]`},
+		{"/api/v1/compare?ids=4d5307e6,nope", http.StatusNotFound, "Title nope not found"},
+		{"/api/v1/compare", http.StatusBadRequest, "exactly two"},
+		{"/api/v1/compare?ids=4d5307e6,4d530802,415607f7", http.StatusBadRequest, "exactly two"},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, nil)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: status = %d, want %d containing %s; body: %s", tt.target, w.Code, tt.status, tt.want, w.Body.String())
+		}
+	}
+}
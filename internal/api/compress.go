@@ -0,0 +1,221 @@
+package api
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultCompressionTypes are the media types worth compressing. Entries
+// ending in /* match every subtype.
+const defaultCompressionTypes = "text/*, application/json, application/problem+json, " +
+	"application/x-ndjson, application/xml, application/rss+xml, application/atom+xml, application/javascript, image/svg+xml"
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	// deflate is the zlib format over HTTP, not raw deflate
+	zlibWriters = sync.Pool{New: func() any { return zlib.NewWriter(io.Discard) }}
+)
+
+// acceptedEncoding returns the encoding to compress a response with for an
+// Accept-Encoding header, preferring gzip, or "" for none.
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[name] = q > 0
+	}
+	gz, listed := accepted["gzip"]
+	switch {
+	case gz || !listed && accepted["*"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressible reports whether COMPRESSION_TYPES allows contentType.
+func (s *Server) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, allowed := range s.config.CompressionTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(allowed, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// it is worth compressing: once it reaches the minimum size, is flushed or
+// ends.
+type compressWriter struct {
+	gin.ResponseWriter
+	s        *Server
+	encoding string
+
+	buf         []byte
+	decided     bool
+	wroteHeader bool
+	encoder     io.WriteCloser
+	// Bytes written by the handlers, before compression
+	size int
+}
+
+// decide sets the headers of the response and writes out what was held back.
+// Small responses are only compressed when flushed, since a stream is
+// expected to go on.
+func (w *compressWriter) decide(flushing bool) error {
+	w.decided = true
+	h := w.Header()
+	status := w.Status()
+	if w.s.compressible(h.Get("Content-Type")) && h.Get("Content-Encoding") == "" &&
+		status >= 200 && status != http.StatusNoContent && status != http.StatusPartialContent && status != http.StatusNotModified {
+		h.Add("Vary", "Accept-Encoding")
+		if w.encoding != "" && (flushing || len(w.buf) >= w.s.config.CompressionMinSize) {
+			h.Set("Content-Encoding", w.encoding)
+			h.Del("Content-Length")
+			// Ranges would be of the compressed body
+			h.Del("Accept-Ranges")
+			// The compressed body is another representation of the resource
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+			if w.encoding == "gzip" {
+				gz := gzipWriters.Get().(*gzip.Writer)
+				gz.Reset(w.ResponseWriter)
+				w.encoder = gz
+			} else {
+				zw := zlibWriters.Get().(*zlib.Writer)
+				zw.Reset(w.ResponseWriter)
+				w.encoder = zw
+			}
+		}
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.write(buf)
+	return err
+}
+
+func (w *compressWriter) write(b []byte) (int, error) {
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	w.size += len(b)
+	if w.decided {
+		return w.write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.s.config.CompressionMinSize {
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow waits for the body, since the headers still depend on it.
+func (w *compressWriter) WriteHeaderNow() {
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.wroteHeader = true
+}
+
+func (w *compressWriter) Written() bool {
+	return w.wroteHeader || len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+// Size returns the size of the body before compression, so that what is
+// counted of a response doesn't depend on whether the client compresses.
+func (w *compressWriter) Size() int {
+	if w.size == 0 {
+		return w.ResponseWriter.Size()
+	}
+	return w.size
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close writes out what is left of the response.
+func (w *compressWriter) close() {
+	if !w.decided && (len(w.buf) > 0 || w.wroteHeader) {
+		w.decide(false)
+	}
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		encoder.Close()
+		gzipWriters.Put(encoder)
+	case *zlib.Writer:
+		encoder.Close()
+		zlibWriters.Put(encoder)
+	}
+	w.encoder = nil
+}
+
+// compress compresses the responses of the types in COMPRESSION_TYPES that
+// are at least COMPRESSION_MIN_SIZE bytes, with gzip or deflate depending on
+// what the client accepts. Clients that accept neither still get the Vary
+// header, so that caches in between keep the representations apart.
+func (s *Server) compress(c *gin.Context) {
+	if c.Request.Method == http.MethodHead {
+		c.Next()
+		return
+	}
+
+	original := c.Writer
+	w := &compressWriter{ResponseWriter: original, s: s, encoding: acceptedEncoding(c.GetHeader("Accept-Encoding"))}
+	c.Writer = w
+	// Not deferred, so that a panic doesn't send what was held back with the
+	// status of a success before recovery answers
+	c.Next()
+	w.close()
+	// Middleware registered before this one sees what was sent
+	c.Writer = original
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.CompressionMinSize = 256 })
+	plain := doRequest(s, "GET", "/api/v1/titles", nil)
+	if plain.Header().Get("Content-Encoding") != "" || !strings.Contains(plain.Header().Get("Vary"), "Accept-Encoding") {
+		t.Fatalf("without Accept-Encoding: headers = %v", plain.Header())
+	}
+
+	tests := []struct {
+		target   string
+		accept   string
+		encoding string
+	}{
+		{"/api/v1/titles", "gzip, deflate, br", "gzip"},
+		{"/api/v1/titles", "deflate", "deflate"},
+		{"/api/v1/titles", "gzip;q=0, *", ""},
+		{"/api/v1/titles", "br", ""},
+		{"/api/v1/export", "*", "gzip"},
+		{"/api/v1/export?format=csv", "gzip", "gzip"},
+		// Too small to be worth it
+		{"/healthz", "gzip", ""},
+		{"/api/v1/titles/4d5307e6/20400", "gzip", ""},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, map[string]string{"Accept-Encoding": tt.accept})
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d", tt.target, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s with %q: encoding = %q, want %q", tt.target, tt.accept, got, tt.encoding)
+			continue
+		}
+
+		var body io.Reader = w.Body
+		switch tt.encoding {
+		case "gzip":
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.target, err)
+			}
+			body = gz
+		case "deflate":
+			zr, err := zlib.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.target, err)
+			}
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Errorf("%s with %q: reading body: %v", tt.target, tt.accept, err)
+		}
+		if tt.target == "/api/v1/titles" && string(data) != plain.Body.String() {
+			t.Errorf("%s with %q: body differs from the uncompressed one", tt.target, tt.accept)
+		}
+	}
+
+	w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"Accept-Encoding": "gzip"})
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(etag, "W/") || w.Header().Get("Content-Length") != "" {
+		t.Errorf("compressed: ETag = %q, Content-Length = %q", etag, w.Header().Get("Content-Length"))
+	}
+	w = doRequest(s, "GET", "/api/v1/titles", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": etag})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("revalidation: status = %d, encoding = %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+
+	s.config.CompressionTypes = []string{"text/*"}
+	w = doRequest(s, "GET", "/api/v1/titles", map[string]string{"Accept-Encoding": "gzip"})
+	if w.Header().Get("Content-Encoding") != "" || strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+		t.Errorf("JSON not allowed: headers = %v", w.Header())
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCORSAllowedHeaders = "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key, X-API-Key"
+
+// corsExposedHeaders are the response headers scripts of other origins may
+// read, beyond the few browsers always expose.
+const corsExposedHeaders = "ETag, Location, Retry-After, Link, Deprecation, Sunset"
+
+// corsAllowed reports whether CORS_ALLOWED_ORIGINS lets origin call the API.
+// Entries are origins, * for any, or a wildcard for subdomains like
+// https://*.example.com.
+func (s *Server) corsAllowed(origin string) bool {
+	for _, allowed := range s.config.CORSAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			host, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(host, "."+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cors lets the pages of the allowed origins call the API from browsers, and
+// answers their preflight requests. Credentials are sent as headers rather
+// than cookies, so they aren't allowed.
+func (s *Server) cors(c *gin.Context) {
+	path := c.Request.URL.Path
+	if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/thegamesdb/") {
+		c.Next()
+		return
+	}
+
+	origin := c.GetHeader("Origin")
+	h := c.Writer.Header()
+	h.Add("Vary", "Origin")
+	if origin == "" || !s.corsAllowed(origin) {
+		c.Next()
+		return
+	}
+	if slices.Contains(s.config.CORSAllowedOrigins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", strings.Join(s.config.CORSAllowedMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(s.config.CORSAllowedHeaders, ", "))
+		if s.config.CORSMaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(s.config.CORSMaxAge.Seconds())))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+	c.Next()
+}
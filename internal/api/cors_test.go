@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.CORSAllowedOrigins = []string{"https://covers.example.org", "https://*.example.net"}
+	})
+
+	preflight := map[string]string{
+		"Origin":                         "https://covers.example.org",
+		"Access-Control-Request-Method":  "GET",
+		"Access-Control-Request-Headers": "x-api-key",
+	}
+	w := doRequest(s, "OPTIONS", "/api/v1/titles", preflight)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://covers.example.org" ||
+		!strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "X-API-Key") ||
+		w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("preflight: status = %d; headers: %v", w.Code, w.Header())
+	}
+
+	tests := []struct {
+		origin string
+		target string
+		want   string
+	}{
+		{"https://covers.example.org", "/api/v1/titles/4d5307e6", "https://covers.example.org"},
+		{"https://tools.example.net", "/api/v1/titles/4d5307e6", "https://tools.example.net"},
+		{"https://example.net", "/api/v1/titles/4d5307e6", ""},
+		{"http://tools.example.net", "/api/v1/titles/4d5307e6", ""},
+		{"https://evil.example.com", "/api/v1/titles/4d5307e6", ""},
+		{"https://covers.example.org", "/", ""},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, map[string]string{"Origin": tt.origin})
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("%s from %s: allowed origin = %q, want %q", tt.target, tt.origin, got, tt.want)
+		}
+		if tt.want != "" && !strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "ETag") {
+			t.Errorf("%s from %s: ETag not exposed", tt.target, tt.origin)
+		}
+	}
+
+	s.config.CORSAllowedOrigins = []string{"*"}
+	w = doRequest(s, "GET", "/api/v1/titles", map[string]string{"Origin": "https://anywhere.example"})
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("any origin: allowed origin = %q", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
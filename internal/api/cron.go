@@ -0,0 +1,211 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a standard five-field cron expression: minute, hour, day of
+// month, month and day of week, each a set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Like cron, when both days are restricted either one matching is enough
+	anyDOM, anyDOW bool
+}
+
+// cronMacros are the shorthands cron accepts for common schedules.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseCron parses a cron expression such as "*/15 * * * *" or "@daily".
+// Fields take values, ranges, steps and lists, and months and days of the week
+// can be named (jan, mon...).
+func parseCron(expr string) (cronSchedule, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return s, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return s, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return s, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return s, fmt.Errorf("month: %w", err)
+	}
+	// Both 0 and 7 are Sunday
+	if s.dow, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return s, fmt.Errorf("day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.anyDOM = strings.HasPrefix(fields[2], "*") || fields[2] == "?"
+	s.anyDOW = strings.HasPrefix(fields[4], "*") || fields[4] == "?"
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+// into a bit set. names, when set, are the names of the values from min up.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		span, stepValue, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepValue)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepValue)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if span != "*" && span != "?" {
+			first, last, isRange := strings.Cut(span, "-")
+			var err error
+			if lo, err = value(first); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(last); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" means from 5 on, every 15
+				hi = max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q", span)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay tells whether t falls on a day of the schedule.
+func (s cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.anyDOM && s.anyDOW:
+		return true
+	case s.anyDOM:
+		return dow
+	case s.anyDOW:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next returns the first time of the schedule after t, or the zero time when
+// there is none within five years (e.g. February 31st).
+func (s cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// initSyncSchedule parses SYNC_SCHEDULE, so that a typo stops the server
+// instead of silently never syncing.
+func (s *Server) initSyncSchedule() error {
+	if s.config.SyncSchedule == "" {
+		return nil
+	}
+	schedule, err := parseCron(s.config.SyncSchedule)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("%q never runs", s.config.SyncSchedule)
+	}
+	s.syncSchedule = &schedule
+	return nil
+}
+
+// runSyncScheduler syncs with upstream on the configured schedule. A run that
+// comes due while another sync is still going is skipped.
+func (s *Server) runSyncScheduler() {
+	if s.syncSchedule == nil {
+		return
+	}
+	for {
+		next := s.syncSchedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		run, err := s.syncTitles()
+		switch {
+		case errors.Is(err, errSyncInProgress):
+			log.Println("Scheduled sync skipped: another sync is still running")
+		case err != nil:
+			log.Printf("Scheduled sync failed: %v\n", err)
+		default:
+			log.Printf("Scheduled sync done: %d added, %d updated\n", run.Added, run.Updated)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, time.January, 14, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.January, 14, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.January, 14, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.January, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, time.January, 15, 0, 0, 0, 0, time.UTC)},
+		{"30 4 * * mon-fri", time.Date(2026, time.January, 15, 4, 30, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2026, time.January, 18, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2026, time.January, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2026, time.January, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 feb *", time.Date(2028, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{"5/20 10 * * *", time.Date(2026, time.January, 14, 10, 25, 0, 0, time.UTC)},
+		{"0 0 31 feb *", time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) did not fail", expr)
+		}
+	}
+
+	cfg := testConfig(t, "http://127.0.0.1:0/")
+	cfg.SyncSchedule = "0 0 31 feb *"
+	if _, err := newServer(cfg); err == nil || !strings.Contains(err.Error(), "SYNC_SCHEDULE") {
+		t.Errorf("newServer with a schedule that never runs: %v", err)
+	}
+}
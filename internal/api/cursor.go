@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// pageCursor is where a page of a listing starts: right after the title
+// TitleID, which search results ranked Rank. Clients get it as an opaque
+// next_cursor and pass it back as ?cursor=, which unlike an offset doesn't
+// skip or repeat titles when the catalog changes in between.
+type pageCursor struct {
+	TitleID string  `json:"id"`
+	Rank    float64 `json:"r,omitempty"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func (c pageCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parsePageCursor(value string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(b, &cursor); err != nil || cursor.TitleID == "" {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// nextCursor returns the cursor of the page after titles, or "" when there is
+// none.
+func nextCursor(titles []Title, more bool) string {
+	if !more || len(titles) == 0 {
+		return ""
+	}
+	return pageCursor{TitleID: titles[len(titles)-1].TitleID}.String()
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestCursorPagination(t *testing.T) {
+	type page struct {
+		Items []struct {
+			TitleID string `json:"title_id"`
+		} `json:"items"`
+		Total      int64  `json:"total"`
+		Page       int    `json:"page"`
+		NextCursor string `json:"next_cursor"`
+	}
+	get := func(t *testing.T, s *Server, target string) page {
+		t.Helper()
+		w := doRequest(s, "GET", target, nil)
+		var p page
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &p) != nil {
+			t.Fatalf("%s: status = %d; body: %s", target, w.Code, w.Body.String())
+		}
+		return p
+	}
+	// walk follows the cursors from target and returns every title listed
+	walk := func(t *testing.T, s *Server, target string) []string {
+		t.Helper()
+		var ids []string
+		p := get(t, s, target)
+		for {
+			for _, item := range p.Items {
+				ids = append(ids, item.TitleID)
+			}
+			if p.NextCursor == "" {
+				return ids
+			}
+			p = get(t, s, target+"&cursor="+p.NextCursor)
+			if p.Page != 0 || p.Total == 0 {
+				t.Errorf("%s: cursor page %+v", target, p)
+			}
+		}
+	}
+
+	s := newTestServer(t, testTitles)
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/api/v1/titles?limit=1", []string{"415607F7", "4D5307E6", "4D530802", "584109EB"}},
+		{"/api/v1/titles?limit=3&reverse=true", []string{"584109EB", "4D530802", "4D5307E6", "415607F7"}},
+		{"/api/v1/titles?limit=1&system=pc", []string{"584109EB"}},
+		{"/api/v1/search?q=halo&limit=1", []string{"4D5307E6", "4D530802"}},
+	}
+	for _, tt := range tests {
+		if got := walk(t, s, tt.target); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: walked %v, want %v", tt.target, got, tt.want)
+		}
+	}
+	fuzzy := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.SearchBackend = searchBackendFuzzy })
+	if got := walk(t, fuzzy, "/api/v1/search?q=halo&limit=1"); !slices.Equal(got, []string{"4D5307E6", "4D530802"}) {
+		t.Errorf("fuzzy search: walked %v", got)
+	}
+
+	// A title added in between shifts offsets, not cursors
+	first := get(t, s, "/api/v1/titles?limit=2")
+	header := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+	if w := doRequestBody(s, "POST", "/api/v1/admin/titles", header, `{"title_id":"40000001","name":"Early","systems":["XBOX360"]}`); w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	next := get(t, s, "/api/v1/titles?limit=2&cursor="+first.NextCursor)
+	if len(next.Items) != 2 || next.Items[0].TitleID != "4D530802" || next.Total != 5 {
+		t.Errorf("page after the cursor = %+v", next)
+	}
+
+	for _, target := range []string{"/api/v1/titles?cursor=garbage", "/api/v1/titles?sort=score&cursor=" + first.NextCursor, "/api/v1/search?q=halo&cursor=e30"} {
+		if w := doRequest(s, "GET", target, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	dbDriverSQLite   = store.DriverSQLite
+	dbDriverPostgres = store.DriverPostgres
+)
+
+// dbDialectors open a database by DB_DRIVER. Postgres takes DB_DSN in the
+// libpq format, e.g. "host=localhost user=xtitles dbname=xtitles sslmode=disable".
+var dbDialectors = map[string]func(dsn string) gorm.Dialector{
+	dbDriverSQLite:   sqlite.Open,
+	dbDriverPostgres: postgres.Open,
+}
+
+// dbModels are the tables of the database, parents before the tables that
+// refer to them.
+var dbModels = []any{
+	&Title{}, &Picture{}, &TitleView{}, &IngestReject{}, &SyncRun{}, &IdempotencyRecord{},
+	&AuditEntry{}, &Report{}, &ReportRun{}, &ExternalID{}, &AchievementSet{}, &MarketValue{},
+	&ReviewScore{}, &ArchiveItem{}, &MediaLink{}, &UsageDay{}, &SearchHit{}, &APIKey{}, &APIKeyUsage{},
+	&UsedNonce{}, &PictureFolderScan{},
+}
+
+// dbDSN returns DB_DSN, or the SQLite file in the data directory by default.
+func (s *Server) dbDSN() string {
+	if s.config.DBDSN != "" {
+		return s.config.DBDSN
+	}
+	return filepath.Join(s.config.DataDir, s.config.DBFile)
+}
+
+func (s *Server) openDB() (*gorm.DB, error) {
+	return openDatabase(s.config.DBDriver, s.dbDSN())
+}
+
+// openDatabase opens a database with one of the registered drivers.
+func openDatabase(driver, dsn string) (*gorm.DB, error) {
+	open, ok := dbDialectors[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %q (available: %s)",
+			driver, strings.Join(slices.Sorted(maps.Keys(dbDialectors)), ", "))
+	}
+	return gorm.Open(open(dsn), &gorm.Config{})
+}
+
+// isSQLite tells whether db runs on SQLite, which the full-text index,
+// integrity checks and backups depend on.
+func isSQLite(db *gorm.DB) bool {
+	return db.Dialector.Name() == dbDriverSQLite
+}
+
+// databaseSize returns how many bytes the database takes on disk.
+func databaseSize(db *gorm.DB) (int64, error) {
+	var size int64
+	query := "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+	if db.Dialector.Name() == dbDriverPostgres {
+		query = "SELECT pg_database_size(current_database())"
+	}
+	err := db.Raw(query).Scan(&size).Error
+	return size, err
+}
+
+// escapeLike escapes the LIKE wildcards in s, for patterns using ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
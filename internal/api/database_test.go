@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDatabaseDriver(t *testing.T) {
+	cfg := testConfig(t, "http://127.0.0.1:0")
+	cfg.DBDriver = "oracle"
+	if _, err := NewServer(cfg); err == nil || !strings.Contains(err.Error(), "unsupported database driver") {
+		t.Fatalf("expected an unsupported driver error, got %v", err)
+	}
+
+	dsn := filepath.Join(t.TempDir(), "custom.db")
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.DBDSN = dsn })
+	if _, err := os.Stat(dsn); err != nil {
+		t.Fatalf("database not created at DB_DSN: %v", err)
+	}
+
+	var raw string
+	s.db.Raw("SELECT systems FROM titles WHERE title_id = ?", testTitles[0].TitleID).Scan(&raw)
+	var systems []string
+	if err := json.Unmarshal([]byte(raw), &systems); err != nil || len(systems) == 0 {
+		t.Fatalf("systems not stored as a JSON array: %q", raw)
+	}
+
+	if err := s.db.Create(&Title{TitleID: "0000FFFF", Name: "No Systems"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var title Title
+	s.db.First(&title, "title_id = ?", "0000FFFF")
+	if title.Systems != nil {
+		t.Errorf("expected no systems, got %v", title.Systems)
+	}
+}
+
+// postgresTestDSN returns XTITLES_TEST_POSTGRES_DSN pointed at a schema of
+// its own, dropped after the test, or skips the test when it is unset.
+func postgresTestDSN(t *testing.T) string {
+	t.Helper()
+	dsn := os.Getenv("XTITLES_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("XTITLES_TEST_POSTGRES_DSN is not set")
+	}
+	db, err := openDatabase(dbDriverPostgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("xtitles_test_%d", time.Now().UnixNano())
+	if err := db.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		closeDB(db)
+	})
+	return dsn + " search_path=" + schema
+}
+
+func TestPostgres(t *testing.T) {
+	dsn := postgresTestDSN(t)
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.DBDriver = dbDriverPostgres
+		cfg.DBDSN = dsn
+	})
+	if name := s.db.Dialector.Name(); name != dbDriverPostgres {
+		t.Fatalf("dialector = %s", name)
+	}
+
+	// Systems are jsonb, listed with jsonb_array_elements_text
+	w := doRequest(s, "GET", "/api/v1/systems", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"system":"XBOX360","name":"Xbox 360","count":4`) ||
+		!strings.Contains(w.Body.String(), `"system":"PC","name":"PC","count":1`) {
+		t.Errorf("systems: %d %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles?system=PC", nil); !strings.Contains(w.Body.String(), `"total":1`) {
+		t.Errorf("system filter: %s", w.Body.String())
+	}
+
+	// Stats read a repeatable read snapshot and the size of the database
+	w = doRequest(s, "GET", "/api/v1/stats", nil)
+	var stats CatalogStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("stats: %d %s", w.Code, w.Body.String())
+	}
+	if stats.Titles != int64(len(testTitles)) || stats.TitlesWithPictures != 2 || stats.DatabaseSize <= 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if w := doRequest(s, "GET", "/api/v1/search?q=halo", nil); !strings.Contains(w.Body.String(), `"total":2`) {
+		t.Errorf("search: %s", w.Body.String())
+	}
+
+	// A SQLite deployment moves over with migrate-db
+	src := newTestServer(t, testTitles)
+	src.Close()
+	to := postgresTestDSN(t)
+	if err := runCommand(src.config, []string{"migrate-db", "-to", dbDriverPostgres, "-to-dsn", to}); err != nil {
+		t.Fatalf("migrate-db: %v", err)
+	}
+	cfg := src.config
+	cfg.DBDriver = dbDriverPostgres
+	cfg.DBDSN = to
+	copied, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer on the copy: %v", err)
+	}
+	t.Cleanup(copied.Close)
+	if w := doRequest(copied, "GET", "/api/v1/titles/584109eb", nil); !strings.Contains(w.Body.String(), `"systems":["XBOX360","PC"]`) {
+		t.Errorf("copied title: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	datasetFormatNDJSON = "ndjson"
+	datasetFormatCSV    = "csv"
+
+	// datasetBatchSize is how many titles are read from the database at a
+	// time while streaming the dataset.
+	datasetBatchSize = 500
+)
+
+// DatasetTitle is a line of the dataset export: a title with the names of
+// its pictures.
+type DatasetTitle struct {
+	TitleID         string     `json:"title_id"`
+	Name            string     `json:"name"`
+	Systems         SystemList `json:"systems"`
+	BingID          string     `json:"bing_id"`
+	ServiceConfigID *string    `json:"service_config_id"`
+	PFN             *string    `json:"pfn"`
+	Pictures        []string   `json:"pictures"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	Curated         bool       `json:"curated"`
+}
+
+func datasetTitle(t Title) DatasetTitle {
+	pictures := make([]string, len(t.Pictures))
+	for i, p := range t.Pictures {
+		pictures[i] = p.Name
+	}
+	return DatasetTitle{
+		TitleID:         t.TitleID,
+		Name:            t.Name,
+		Systems:         t.Systems,
+		BingID:          t.BingID,
+		ServiceConfigID: t.ServiceConfigID,
+		PFN:             t.PFN,
+		Pictures:        pictures,
+		CreatedAt:       t.CreatedAt,
+		UpdatedAt:       t.UpdatedAt,
+		Curated:         t.Curated,
+	}
+}
+
+// datasetColumn is a column of the CSV dataset export.
+type datasetColumn struct {
+	name  string
+	value func(t DatasetTitle) string
+}
+
+// datasetColumns are the columns the CSV export can have, in their default
+// order. Lists are joined with semicolons.
+var datasetColumns = []datasetColumn{
+	{"title_id", func(t DatasetTitle) string { return t.TitleID }},
+	{"name", func(t DatasetTitle) string { return t.Name }},
+	{"systems", func(t DatasetTitle) string { return strings.Join(t.Systems, ";") }},
+	{"bing_id", func(t DatasetTitle) string { return t.BingID }},
+	{"pfn", func(t DatasetTitle) string {
+		if t.PFN == nil {
+			return ""
+		}
+		return *t.PFN
+	}},
+	{"picture_count", func(t DatasetTitle) string { return strconv.Itoa(len(t.Pictures)) }},
+	{"pictures", func(t DatasetTitle) string { return strings.Join(t.Pictures, ";") }},
+	{"updated_at", func(t DatasetTitle) string { return t.UpdatedAt.UTC().Format(time.RFC3339) }},
+}
+
+// defaultDatasetColumns are the CSV columns when none are requested.
+var defaultDatasetColumns = []string{"title_id", "name", "systems", "picture_count"}
+
+// parseDatasetColumns resolves a comma-separated list of column names.
+func parseDatasetColumns(value string) ([]datasetColumn, error) {
+	names := parseList(value)
+	if len(names) == 0 {
+		names = defaultDatasetColumns
+	}
+
+	columns := make([]datasetColumn, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(datasetColumns, func(c datasetColumn) bool { return c.name == strings.ToLower(name) })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns = append(columns, datasetColumns[i])
+	}
+	return columns, nil
+}
+
+// exportDataset streams the whole catalog, or the titles of some systems, in
+// title id order, as NDJSON or as CSV. Titles are read in batches so that memory use doesn't grow with the
+// catalog.
+func (s *Server) exportDataset(c *gin.Context) {
+	format := c.DefaultQuery("format", datasetFormatNDJSON)
+	var columns []datasetColumn
+	switch format {
+	case datasetFormatNDJSON:
+	case datasetFormatCSV:
+		var err error
+		if columns, err = parseDatasetColumns(c.Query("columns")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid columns: " + err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format, expected ndjson or csv"})
+		return
+	}
+
+	etag, modified := s.catalogValidators()
+	if notModified(c, s.config.CacheLists, etag, modified) {
+		return
+	}
+
+	setCacheHeaders(c, s.config.CacheLists)
+	var write func(t DatasetTitle) error
+	var flush func() error
+	if format == datasetFormatCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="titles.csv"`)
+
+		w := csv.NewWriter(c.Writer)
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = column.name
+		}
+		w.Write(record)
+		write = func(t DatasetTitle) error {
+			for i, column := range columns {
+				record[i] = column.value(t)
+			}
+			return w.Write(record)
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="titles.ndjson"`)
+
+		encoder := json.NewEncoder(c.Writer)
+		write = func(t DatasetTitle) error { return encoder.Encode(t) }
+		flush = func() error { return nil }
+	}
+	c.Status(http.StatusOK)
+
+	ctx := c.Request.Context()
+	system := c.Query("system")
+	last := ""
+	for {
+		var titles []Title
+		err := store.FilterBySystem(s.db.WithContext(ctx), system).Preload("Pictures").
+			Where("title_id > ?", last).Order("title_id ASC").Limit(datasetBatchSize).Find(&titles).Error
+		if err != nil {
+			// The status is already out, cutting the stream short is all that's left
+			log.Printf("Error streaming the dataset: %v\n", err)
+			return
+		}
+
+		for _, t := range titles {
+			if err := write(datasetTitle(t)); err != nil {
+				return
+			}
+		}
+		if err := flush(); err != nil {
+			return
+		}
+		c.Writer.Flush()
+
+		if len(titles) < datasetBatchSize {
+			return
+		}
+		last = titles[len(titles)-1].TitleID
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDatasetExport(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	if w := doRequest(s, "GET", "/api/v1/export?format=xml", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w := doRequest(s, "GET", "/api/v1/export?format=ndjson", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("status = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) != len(testTitles) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(testTitles), w.Body.String())
+	}
+	var first DatasetTitle
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.TitleID != "415607F7" || len(first.Pictures) != 0 {
+		t.Errorf("first line = %+v", first)
+	}
+	if !strings.Contains(w.Body.String(), `"title_id":"4D5307E6","name":"Halo 3","systems":["XBOX360"]`) ||
+		!strings.Contains(w.Body.String(), `"pictures":["20400","20401"]`) {
+		t.Errorf("titles are missing fields or pictures:\n%s", w.Body.String())
+	}
+
+	if w := doRequest(s, "GET", "/api/v1/export", map[string]string{"If-None-Match": w.Header().Get("ETag")}); w.Code != http.StatusNotModified {
+		t.Errorf("revalidation: status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	w = doRequest(s, "GET", "/api/v1/export?format=csv&columns=title_id&system=pc", nil)
+	if w.Body.String() != "title_id\n584109EB\n" {
+		t.Errorf("export of a system:\n%s", w.Body.String())
+	}
+}
+
+func TestDatasetCSVExport(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	if w := doRequest(s, "GET", "/api/v1/export?format=csv&columns=title_id,price", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown column: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w := doRequest(s, "GET", "/api/v1/export?format=csv", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("status = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	want := "title_id,name,systems,picture_count\n415607F7,Call of Duty 4,XBOX360,0\n4D5307E6,Halo 3,XBOX360,2\n"
+	if !strings.HasPrefix(w.Body.String(), want) || !strings.Contains(w.Body.String(), "584109EB,Minecraft,XBOX360;PC,1\n") {
+		t.Errorf("unexpected CSV:\n%s", w.Body.String())
+	}
+
+	w = doRequest(s, "GET", "/api/v1/export?format=csv&columns=NAME,pictures", nil)
+	if !strings.HasPrefix(w.Body.String(), "name,pictures\nCall of Duty 4,\nHalo 3,20400;20401\n") {
+		t.Errorf("unexpected CSV with custom columns:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// dbMigrateBatchSize is how many rows are copied at a time.
+const dbMigrateBatchSize = 500
+
+// TableCopy is the outcome of copying one table.
+type TableCopy struct {
+	Table  string
+	Source int64
+	Copied int64
+}
+
+// migrateDatabase copies every table of src into dst, which must be empty,
+// in a single transaction. Counts are checked once the rows are in, so that
+// nothing is committed unless every table made it whole.
+func migrateDatabase(ctx context.Context, src, dst *gorm.DB, progress func(table string, copied, total int64)) ([]TableCopy, error) {
+	src, dst = src.WithContext(ctx), dst.WithContext(ctx)
+
+	if err := dst.AutoMigrate(dbModels...); err != nil {
+		return nil, fmt.Errorf("creating the schema: %w", err)
+	}
+	for _, model := range dbModels {
+		var n int64
+		if err := dst.Model(model).Count(&n).Error; err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, fmt.Errorf("the destination database is not empty: %s has %d rows", tableName(dst, model), n)
+		}
+	}
+
+	var copies []TableCopy
+	err := dst.Transaction(func(tx *gorm.DB) error {
+		for _, model := range dbModels {
+			c, err := copyTable(src, tx, model, progress)
+			if err != nil {
+				return fmt.Errorf("copying %s: %w", c.Table, err)
+			}
+			copies = append(copies, c)
+		}
+
+		for i, model := range dbModels {
+			if err := tx.Model(model).Count(&copies[i].Copied).Error; err != nil {
+				return err
+			}
+			if copies[i].Copied != copies[i].Source {
+				return fmt.Errorf("%s has %d rows after copying %d", copies[i].Table, copies[i].Copied, copies[i].Source)
+			}
+		}
+		return nil
+	})
+	return copies, err
+}
+
+// copyTable copies the rows of model's table by batches, in primary key order.
+func copyTable(src, dst *gorm.DB, model any, progress func(table string, copied, total int64)) (TableCopy, error) {
+	stmt := &gorm.Statement{DB: src}
+	if err := stmt.Parse(model); err != nil {
+		return TableCopy{}, err
+	}
+	c := TableCopy{Table: stmt.Schema.Table}
+	if err := src.Model(model).Count(&c.Source).Error; err != nil {
+		return c, err
+	}
+
+	order := make([]string, len(stmt.Schema.PrimaryFields))
+	for i, f := range stmt.Schema.PrimaryFields {
+		order[i] = f.DBName
+	}
+
+	var copied int64
+	for offset := 0; copied < c.Source; offset += dbMigrateBatchSize {
+		rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem()))
+		err := src.Model(model).Order(strings.Join(order, ", ")).
+			Offset(offset).Limit(dbMigrateBatchSize).Find(rows.Interface()).Error
+		if err != nil {
+			return c, err
+		}
+		n := rows.Elem().Len()
+		if n == 0 {
+			break
+		}
+		// Rows keep their ids, and associations are tables of their own
+		if err := dst.Omit(clause.Associations).Create(rows.Interface()).Error; err != nil {
+			return c, err
+		}
+		copied += int64(n)
+		if progress != nil {
+			progress(c.Table, copied, c.Source)
+		}
+	}
+
+	// Rows were inserted with their ids, which leaves Postgres sequences behind
+	if id := stmt.Schema.PrioritizedPrimaryField; id != nil && id.AutoIncrement && dst.Dialector.Name() == dbDriverPostgres {
+		err := dst.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', '%s'), COALESCE(MAX(%s), 0) + 1, false) FROM %s",
+			c.Table, id.DBName, id.DBName, c.Table)).Error
+		if err != nil {
+			return c, fmt.Errorf("resetting the id sequence: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func tableName(db *gorm.DB, model any) string {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return fmt.Sprintf("%T", model)
+	}
+	return stmt.Schema.Table
+}
+
+// logTableProgress logs the progress of a table every tenth of the way.
+func logTableProgress(table string, copied, total int64) {
+	if copied == total || copied*10/total != (copied-dbMigrateBatchSize)*10/total {
+		log.Printf("Copying %s: %d/%d rows\n", table, copied, total)
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateDB(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	doRequest(s, "POST", "/api/v1/titles/4d5307e6/view", nil)
+	s.Close()
+
+	if err := runCommand(s.config, []string{"migrate-db", "-to", "sqlite"}); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("missing destination: err = %v", err)
+	}
+	if err := runCommand(s.config, []string{"migrate-db", "-to", "mysql", "-to-dsn", "x"}); err == nil || !strings.Contains(err.Error(), "unsupported database driver") {
+		t.Errorf("unknown driver: err = %v", err)
+	}
+	// Postgres is built in, as the README documents
+	unreachable := "host=127.0.0.1 port=1 connect_timeout=1 sslmode=disable"
+	if err := runCommand(s.config, []string{"migrate-db", "-to", "postgres", "-to-dsn", unreachable}); err == nil || strings.Contains(err.Error(), "unsupported database driver") {
+		t.Errorf("postgres destination: err = %v", err)
+	}
+
+	dsn := filepath.Join(t.TempDir(), "copy.db")
+	if err := runCommand(s.config, []string{"migrate-db", "--from", "sqlite", "--to", "sqlite", "--to-dsn", dsn}); err != nil {
+		t.Fatalf("migrate-db: %v", err)
+	}
+	if err := runCommand(s.config, []string{"migrate-db", "-to", "sqlite", "-to-dsn", dsn}); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("migrating twice: err = %v", err)
+	}
+
+	cfg := s.config
+	cfg.DBDSN = dsn
+	copied, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer on the copy: %v", err)
+	}
+	t.Cleanup(copied.Close)
+	if w := doRequest(copied, "GET", "/api/v1/titles/4d5307e6", nil); !strings.Contains(w.Body.String(), `"alt":"Halo 3 gamerpic 20400"`) {
+		t.Errorf("copied title: %s", w.Body.String())
+	}
+	// Ids carry on from the copied rows
+	if w := doRequest(copied, "POST", "/api/v1/titles/584109eb/view", nil); !strings.Contains(w.Body.String(), `"views":1`) {
+		t.Errorf("recording a view on the copy: %s", w.Body.String())
+	}
+	var views []TitleView
+	copied.db.Order("id").Find(&views)
+	if len(views) != 2 || views[0].TitleID != "4D5307E6" || views[1].ID != 2 {
+		t.Errorf("unexpected views: %+v", views)
+	}
+}
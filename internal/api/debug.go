@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const debugStartKey = "debug:start"
+
+type debugContextKey struct{}
+
+// requestDebug collects what a request with debug=true spent its time on.
+type requestDebug struct {
+	mu      sync.Mutex
+	start   time.Time
+	queries []DebugQuery
+	db      time.Duration
+	search  time.Duration
+	filters any
+	// lastWork is when the last query or search ended, and firstWrite when
+	// the response started coming out: encoding it is what lies in between.
+	lastWork   time.Time
+	firstWrite time.Time
+}
+
+func debugFromContext(ctx context.Context) *requestDebug {
+	if ctx == nil {
+		return nil
+	}
+	d, _ := ctx.Value(debugContextKey{}).(*requestDebug)
+	return d
+}
+
+// debugSearch records the time spent searching.
+func debugSearch(ctx context.Context, start time.Time) {
+	if d := debugFromContext(ctx); d != nil {
+		d.mu.Lock()
+		d.search += time.Since(start)
+		d.lastWork = time.Now()
+		d.mu.Unlock()
+	}
+}
+
+// debugFilters records the filters a listing applied.
+func debugFilters(ctx context.Context, filters any) {
+	if d := debugFromContext(ctx); d != nil {
+		d.mu.Lock()
+		d.filters = filters
+		d.mu.Unlock()
+	}
+}
+
+// DebugInfo is the breakdown added to responses of requests with debug=true.
+type DebugInfo struct {
+	TotalMs float64 `json:"total_ms"`
+	// DBMs adds up the time of the queries, which can overlap and add up to
+	// more than TotalMs
+	DBMs            float64      `json:"db_ms"`
+	SearchMs        float64      `json:"search_ms"`
+	SerializationMs float64      `json:"serialization_ms"`
+	Queries         []DebugQuery `json:"queries"`
+	Cache           DebugCache   `json:"cache"`
+	Filters         any          `json:"filters,omitempty"`
+}
+
+// DebugQuery is a statement run for a request.
+type DebugQuery struct {
+	SQL  string  `json:"sql"`
+	Rows int64   `json:"rows"`
+	Ms   float64 `json:"ms"`
+}
+
+// DebugCache tells how HTTP caching applied to a response.
+type DebugCache struct {
+	ETag         string `json:"etag,omitempty"`
+	NotModified  bool   `json:"not_modified"`
+	CacheControl string `json:"cache_control,omitempty"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func (d *requestDebug) info(c *gin.Context, end time.Time) DebugInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info := DebugInfo{
+		TotalMs:  milliseconds(end.Sub(d.start)),
+		DBMs:     milliseconds(d.db),
+		SearchMs: milliseconds(d.search),
+		Queries:  d.queries,
+		Filters:  d.filters,
+		Cache: DebugCache{
+			ETag:         c.Writer.Header().Get("ETag"),
+			NotModified:  c.Writer.Status() == http.StatusNotModified,
+			CacheControl: c.Writer.Header().Get("Cache-Control"),
+		},
+	}
+	if info.Queries == nil {
+		info.Queries = []DebugQuery{}
+	}
+	if !d.firstWrite.IsZero() {
+		since := d.start
+		if d.lastWork.After(since) {
+			since = d.lastWork
+		}
+		info.SerializationMs = milliseconds(d.firstWrite.Sub(since))
+	}
+	return info
+}
+
+// debugRequests adds a DebugInfo to the JSON responses of requests with
+// debug=true, under a debug key, and a Server-Timing header to the others.
+// Streamed responses go out before it is known, so they get neither. It
+// reveals the queries run, so it takes ADMIN_TOKEN.
+func (s *Server) debugRequests(c *gin.Context) {
+	if c.Query("debug") != "true" {
+		c.Next()
+		return
+	}
+	if !s.hasAdminToken(c) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "debug=true takes the admin token"})
+		return
+	}
+
+	d := &requestDebug{start: time.Now()}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), debugContextKey{}, d))
+	w := &bufferedWriter{
+		ResponseWriter: c.Writer,
+		onWrite: func() {
+			d.mu.Lock()
+			if d.firstWrite.IsZero() {
+				d.firstWrite = time.Now()
+			}
+			d.mu.Unlock()
+		},
+		onStream: func() { c.Writer.Header().Set("Cache-Control", "no-store") },
+	}
+	c.Writer = w
+
+	c.Next()
+
+	if w.streaming {
+		w.release(c, nil)
+		return
+	}
+	info := d.info(c, time.Now())
+	c.Header("Cache-Control", "no-store")
+	c.Header("Server-Timing", fmt.Sprintf("db;dur=%.3f, search;dur=%.3f, serialization;dur=%.3f, total;dur=%.3f",
+		info.DBMs, info.SearchMs, info.SerializationMs, info.TotalMs))
+
+	body := w.body.Bytes()
+	if trimmed := bytes.TrimSpace(body); isJSON(c) && bytes.HasPrefix(trimmed, []byte("{")) && bytes.HasSuffix(trimmed, []byte("}")) {
+		encoded, err := json.Marshal(info)
+		if err == nil {
+			var spliced bytes.Buffer
+			spliced.Write(trimmed[:len(trimmed)-1])
+			if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+				spliced.WriteByte(',')
+			}
+			spliced.WriteString(`"debug":`)
+			spliced.Write(encoded)
+			spliced.WriteString("}\n")
+			body = spliced.Bytes()
+		}
+	}
+	w.release(c, body)
+}
+
+// registerQueryDebug records the statements run with the context of a
+// request with debug=true.
+func (s *Server) registerQueryDebug(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		if debugFromContext(tx.Statement.Context) != nil {
+			tx.InstanceSet(debugStartKey, time.Now())
+		}
+	}
+	after := func(tx *gorm.DB) {
+		start, ok := tx.InstanceGet(debugStartKey)
+		if !ok {
+			return
+		}
+		d := debugFromContext(tx.Statement.Context)
+		elapsed := time.Since(start.(time.Time))
+		query := DebugQuery{
+			SQL:  tx.Dialector.Explain(tx.Statement.SQL.String(), tx.Statement.Vars...),
+			Rows: tx.Statement.RowsAffected,
+			Ms:   milliseconds(elapsed),
+		}
+		d.mu.Lock()
+		d.queries = append(d.queries, query)
+		d.db += elapsed
+		d.lastWork = time.Now()
+		d.mu.Unlock()
+	}
+
+	callbacks := db.Callback()
+	for _, p := range []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	} {
+		if err := p.before("debug:before_"+p.operation, before); err != nil {
+			return err
+		}
+		if err := p.after("debug:after_"+p.operation, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
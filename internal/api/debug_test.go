@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDebugMode(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	if w := doRequest(s, "GET", "/api/v1/titles?debug=true", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("without the admin token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", admin); strings.Contains(w.Body.String(), `"debug"`) {
+		t.Errorf("debug info without debug=true: %s", w.Body.String())
+	}
+
+	w := doRequest(s, "GET", "/api/v1/titles?debug=true&system=pc", admin)
+	var resp struct {
+		Items []Title   `json:"items"`
+		Debug DebugInfo `json:"debug"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v; body: %s", w.Code, err, w.Body.String())
+	}
+	if len(resp.Items) != 1 || len(resp.Debug.Queries) == 0 || !strings.Contains(resp.Debug.Queries[0].SQL, `"PC"`) ||
+		resp.Debug.DBMs <= 0 || resp.Debug.Cache.ETag == "" {
+		t.Errorf("debug info = %+v", resp.Debug)
+	}
+	if filters, _ := resp.Debug.Filters.(map[string]any); filters["System"] != "pc" {
+		t.Errorf("filters = %v", resp.Debug.Filters)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" || !strings.HasPrefix(w.Header().Get("Server-Timing"), "db;dur=") {
+		t.Errorf("headers = %v", w.Header())
+	}
+
+	w = doRequest(s, "GET", "/api/v1/search?q=halo&debug=true", admin)
+	if !strings.Contains(w.Body.String(), `"search_ms":`) || !strings.Contains(w.Body.String(), `"filters":{"backend":`) {
+		t.Errorf("search debug info: %s", w.Body.String())
+	}
+
+	// Revalidations only get the header, and streamed responses nothing but
+	// not being cached
+	etag := w.Header().Get("ETag")
+	w = doRequest(s, "GET", "/api/v1/search?q=halo&debug=true", map[string]string{"Authorization": "Bearer test-token", "If-None-Match": etag})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Server-Timing") == "" {
+		t.Errorf("revalidation: status = %d, headers = %v; body: %s", w.Code, w.Header(), w.Body.String())
+	}
+	w = doRequest(s, "GET", "/api/v1/export?debug=true", admin)
+	if lines := strings.Count(w.Body.String(), "\n"); lines != len(testTitles) || strings.Contains(w.Body.String(), `"debug"`) ||
+		w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("export: %d lines, headers = %v", lines, w.Header())
+	}
+}
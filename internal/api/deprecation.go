@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeDeprecation marks a route clients should move away from.
+type routeDeprecation struct {
+	// Since is when the route was deprecated, Sunset when it stops being
+	// served, if that is planned
+	Since  time.Time
+	Sunset time.Time
+	// Successor is the URL of the route replacing it, if any
+	Successor string
+}
+
+// deprecatedRoutes is the registry of the routes on their way out, by method
+// and route pattern, like "GET /api/v1/titles/:id". DEPRECATED_ROUTES adds to
+// it.
+var deprecatedRoutes = map[string]routeDeprecation{}
+
+// initDeprecations merges DEPRECATED_ROUTES into the registry.
+func (s *Server) initDeprecations() error {
+	s.deprecations = maps.Clone(deprecatedRoutes)
+	for _, entry := range s.config.DeprecatedRoutes {
+		route, d, err := parseRouteDeprecation(entry)
+		if err != nil {
+			return err
+		}
+		s.deprecations[route] = d
+	}
+	return nil
+}
+
+// parseRouteDeprecation parses a DEPRECATED_ROUTES entry: a method, a route,
+// the date it was deprecated, then optionally the date of its sunset and the
+// URL of its successor, separated by spaces.
+func parseRouteDeprecation(entry string) (string, routeDeprecation, error) {
+	var d routeDeprecation
+	fields := strings.Fields(entry)
+	if len(fields) < 3 || len(fields) > 5 || !strings.HasPrefix(fields[1], "/") {
+		return "", d, fmt.Errorf("expected \"METHOD /route since [sunset] [successor]\", got %q", entry)
+	}
+	route := strings.ToUpper(fields[0]) + " " + fields[1]
+
+	var err error
+	if d.Since, err = parseDateOrTime(fields[2]); err != nil {
+		return "", d, fmt.Errorf("%s: invalid date %q", route, fields[2])
+	}
+	rest := fields[3:]
+	if len(rest) > 0 {
+		if sunset, err := parseDateOrTime(rest[0]); err == nil {
+			d.Sunset = sunset
+			rest = rest[1:]
+		}
+	}
+	if len(rest) > 1 {
+		return "", d, fmt.Errorf("%s: invalid sunset %q", route, rest[0])
+	}
+	if len(rest) == 1 {
+		d.Successor = rest[0]
+	}
+	if !d.Sunset.IsZero() && d.Sunset.Before(d.Since) {
+		return "", d, fmt.Errorf("%s: sunset before the deprecation", route)
+	}
+	return route, d, nil
+}
+
+// checkDeprecations makes sure the deprecated routes exist, which catches
+// typos in DEPRECATED_ROUTES.
+func (s *Server) checkDeprecations(r *gin.Engine) error {
+	routes := make(map[string]bool)
+	for _, route := range r.Routes() {
+		routes[route.Method+" "+route.Path] = true
+	}
+	for route := range s.deprecations {
+		if !routes[route] {
+			return fmt.Errorf("deprecated route %s doesn't exist", route)
+		}
+	}
+	return nil
+}
+
+// warnDeprecated adds the Deprecation (RFC 9745) and Sunset (RFC 8594)
+// headers to the responses of deprecated routes, and a link to their
+// successor. Past their sunset, they answer 410 Gone.
+func (s *Server) warnDeprecated(c *gin.Context) {
+	d, ok := s.deprecations[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		c.Next()
+		return
+	}
+
+	c.Header("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+	if d.Successor != "" {
+		c.Writer.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", d.Successor))
+	}
+	if !d.Sunset.IsZero() {
+		c.Header("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		if !time.Now().Before(d.Sunset) {
+			c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": "This endpoint was removed"})
+			return
+		}
+	}
+	c.Next()
+}
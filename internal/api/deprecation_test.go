@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDeprecatedRoutes(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.DeprecatedRoutes = []string{
+			"GET /api/v1/manifest 2026-01-01 2099-01-01 /api/v2/manifest",
+			"get /api/v1/titles/:id/archive 2025-01-01 2026-01-01",
+			"GET /api/v1/compare 2026-02-01T12:00:00Z",
+		}
+	})
+
+	w := doRequest(s, "GET", "/api/v1/manifest", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "@1767225600" ||
+		w.Header().Get("Sunset") != "Thu, 01 Jan 2099 00:00:00 GMT" ||
+		w.Header().Get("Link") != `</api/v2/manifest>; rel="successor-version"` {
+		t.Errorf("deprecated route: status = %d, headers = %v", w.Code, w.Header())
+	}
+	w = doRequest(s, "GET", "/api/v1/compare?ids=4D5307E6,4D530802", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "@1769947200" || w.Header().Get("Sunset") != "" {
+		t.Errorf("route without a sunset: status = %d, headers = %v", w.Code, w.Header())
+	}
+	w = doRequest(s, "GET", "/api/v1/titles/4D5307E6/archive", nil)
+	if w.Code != http.StatusGone || w.Header().Get("Sunset") == "" {
+		t.Errorf("route past its sunset: status = %d, headers = %v", w.Code, w.Header())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", nil); w.Header().Get("Deprecation") != "" {
+		t.Errorf("current route is deprecated: %v", w.Header())
+	}
+
+	for _, entry := range []string{
+		"GET /api/v1/manifest",
+		"GET api/v1/manifest 2026-01-01",
+		"GET /api/v1/manifest soon",
+		"GET /api/v1/manifest 2026-01-01 later /api/v2/manifest",
+		"GET /api/v1/manifest 2026-01-01 2025-01-01",
+		"GET /api/v1/nothing 2026-01-01",
+		"POST /api/v1/manifest 2026-01-01",
+	} {
+		cfg := testConfig(t, "http://127.0.0.1:0")
+		cfg.DeprecatedRoutes = []string{entry}
+		if _, err := NewServer(cfg); err == nil {
+			t.Errorf("DEPRECATED_ROUTES=%s was accepted", entry)
+		}
+	}
+}
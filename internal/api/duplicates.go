@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const pictureDedupeJobKind = "picture_dedupe"
+
+// DuplicatePictures is a set of pictures with the same content, e.g. a cover
+// shared by the regional releases of a game. The first one is the original,
+// the one the others are linked to when deduplicating.
+type DuplicatePictures struct {
+	SHA256   string   `json:"sha256"`
+	Size     int64    `json:"size"`
+	Pictures []string `json:"pictures"`
+}
+
+type DuplicateReport struct {
+	Groups     []DuplicatePictures `json:"groups"`
+	Duplicates int                 `json:"duplicates"`
+	// Bytes the duplicates take, leaving out those already hard-linked
+	WastedBytes int64 `json:"wasted_bytes"`
+}
+
+// DedupeResult is the outcome of hard-linking duplicates to their original.
+type DedupeResult struct {
+	Linked     int   `json:"linked"`
+	SavedBytes int64 `json:"saved_bytes"`
+	// Files whose content no longer matches their hash, until a rescan
+	Skipped int `json:"skipped"`
+}
+
+func (r DedupeResult) String() string {
+	return fmt.Sprintf("%d pictures linked, %d bytes saved, %d skipped", r.Linked, r.SavedBytes, r.Skipped)
+}
+
+// pictureKey names a picture in reports, the way it is requested.
+func pictureKey(p Picture) string {
+	return strings.ToLower(p.TitleID) + "/" + p.Name
+}
+
+// duplicatePictures returns the pictures sharing their hash with another,
+// grouped by hash, originals first.
+func (s *Server) duplicatePictures() ([][]Picture, error) {
+	var pictures []Picture
+	err := s.db.Where("sha256 IN (?)", s.db.Model(&Picture{}).
+		Select("sha256").Where("sha256 <> ''").Group("sha256").Having("COUNT(*) > 1")).
+		Order("sha256, title_id, name").Find(&pictures).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var groups [][]Picture
+	for i, p := range pictures {
+		if i == 0 || p.SHA256 != pictures[i-1].SHA256 {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], p)
+	}
+	return groups, nil
+}
+
+// duplicateReport lists the duplicate pictures and the space they take.
+func (s *Server) duplicateReport() (DuplicateReport, error) {
+	report := DuplicateReport{Groups: []DuplicatePictures{}}
+	groups, err := s.duplicatePictures()
+	if err != nil {
+		return report, err
+	}
+
+	disk, local := s.pictures.(diskPictures)
+	for _, group := range groups {
+		d := DuplicatePictures{SHA256: group[0].SHA256, Size: group[0].Size}
+		var original os.FileInfo
+		if local {
+			original, _ = os.Stat(disk.path(group[0]))
+		}
+		for i, p := range group {
+			d.Pictures = append(d.Pictures, pictureKey(p))
+			if i == 0 {
+				continue
+			}
+			report.Duplicates++
+			if !local {
+				report.WastedBytes += p.Size
+			} else if info, err := os.Stat(disk.path(p)); err == nil && (original == nil || !os.SameFile(original, info)) {
+				report.WastedBytes += info.Size()
+			}
+		}
+		report.Groups = append(report.Groups, d)
+	}
+	return report, nil
+}
+
+// dedupePictures replaces duplicate files with hard links to their original.
+// Each file is hashed again first, so that one replaced on disk since it was
+// indexed is left alone.
+func (s *Server) dedupePictures() (DedupeResult, error) {
+	var result DedupeResult
+	disk, ok := s.pictures.(diskPictures)
+	if !ok {
+		return result, errors.New("linking duplicates needs local picture storage")
+	}
+	groups, err := s.duplicatePictures()
+	if err != nil {
+		return result, err
+	}
+
+	for _, group := range groups {
+		if err := s.ctx.Err(); err != nil {
+			return result, err
+		}
+		originalPath := disk.path(group[0])
+		original, err := os.Stat(originalPath)
+		if err != nil || !fileHasHash(originalPath, group[0].SHA256) {
+			result.Skipped += len(group) - 1
+			continue
+		}
+		for _, p := range group[1:] {
+			path := disk.path(p)
+			info, err := os.Stat(path)
+			if err != nil || os.SameFile(original, info) {
+				continue
+			}
+			if !fileHasHash(path, p.SHA256) {
+				result.Skipped++
+				continue
+			}
+			if err := linkFileAtomic(originalPath, path); err != nil {
+				return result, fmt.Errorf("linking %s: %w", pictureKey(p), err)
+			}
+			result.Linked++
+			result.SavedBytes += info.Size()
+		}
+	}
+	return result, nil
+}
+
+func fileHasHash(path, sum string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == sum
+}
+
+// linkFileAtomic replaces path with a hard link to target, so that readers
+// see either file but never a missing one. Pictures are always replaced by
+// renames rather than written in place, which keeps linked copies apart.
+func linkFileAtomic(target, path string) error {
+	tmp := path + ".link.tmp"
+	os.Remove(tmp)
+	if err := os.Link(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// flagDuplicatePictures sets the duplicate_of of the pictures of title that
+// are copies of another picture.
+func (s *Server) flagDuplicatePictures(title *Title) error {
+	var sums []string
+	for _, p := range title.Pictures {
+		if p.SHA256 != "" {
+			sums = append(sums, p.SHA256)
+		}
+	}
+	if len(sums) == 0 {
+		return nil
+	}
+
+	var copies []Picture
+	err := s.db.Select("title_id, name, sha256").Where("sha256 IN ?", sums).
+		Order("sha256, title_id, name").Find(&copies).Error
+	if err != nil {
+		return err
+	}
+	originals := make(map[string]string)
+	for _, p := range copies {
+		if _, ok := originals[p.SHA256]; !ok {
+			originals[p.SHA256] = pictureKey(p)
+		}
+	}
+	for i, p := range title.Pictures {
+		if original := originals[p.SHA256]; original != "" && original != pictureKey(p) {
+			title.Pictures[i].DuplicateOf = original
+		}
+	}
+	return nil
+}
+
+func (s *Server) getDuplicatePictures(c *gin.Context) {
+	report, err := s.duplicateReport()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// triggerPictureDedupe hard-links duplicate pictures in the background,
+// holding the sync lock so that a rescan doesn't hash files meanwhile.
+func (s *Server) triggerPictureDedupe(c *gin.Context) {
+	if _, ok := s.pictures.(diskPictures); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Linking duplicates needs local picture storage"})
+		return
+	}
+	if !s.syncMu.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Sync already in progress"})
+		return
+	}
+
+	job := s.jobs.Start(pictureDedupeJobKind, func(_ context.Context, job *Job) error {
+		defer s.syncMu.Unlock()
+		result, err := s.dedupePictures()
+		if err != nil {
+			return err
+		}
+		job.SetResult(result.String())
+		return nil
+	})
+	c.Header("Location", "/api/v1/admin/pictures/dedupe/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+func (s *Server) getPictureDedupeJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != pictureDedupeJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enricherJobResponse(job))
+}
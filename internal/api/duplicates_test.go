@@ -0,0 +1,54 @@
+package api
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDuplicatePictures(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	// Every test picture is the same PNG
+	w := doRequest(s, "GET", "/api/v1/admin/pictures/duplicates", admin)
+	if !strings.Contains(w.Body.String(), `"size":75,"pictures":["4d5307e6/20400","4d5307e6/20401","584109eb/20400"]}],"duplicates":2,"wasted_bytes":150`) {
+		t.Errorf("report: %s", w.Body.String())
+	}
+	w = doRequest(s, "GET", "/api/v1/titles/4d5307e6", nil)
+	if strings.Count(w.Body.String(), `"duplicate_of":"4d5307e6/20400"`) != 1 {
+		t.Errorf("only 20401 should be flagged: %s", w.Body.String())
+	}
+
+	// A file replaced since it was indexed is left alone
+	replaced := filepath.Join(s.config.PicturesFolder, "584109eb", "20400.png")
+	f, _ := os.Create(replaced)
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	f.Close()
+
+	w = doRequest(s, "POST", "/api/v1/admin/pictures/dedupe", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting a dedupe: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), `"result":"1 pictures linked, 75 bytes saved, 1 skipped"`) {
+		t.Errorf("dedupe job: %s", w.Body.String())
+	}
+
+	original, _ := os.Stat(filepath.Join(s.config.PicturesFolder, "4d5307e6", "20400.png"))
+	linked, _ := os.Stat(filepath.Join(s.config.PicturesFolder, "4d5307e6", "20401.png"))
+	other, _ := os.Stat(replaced)
+	if !os.SameFile(original, linked) || os.SameFile(original, other) {
+		t.Error("only the unchanged duplicate should be linked to its original")
+	}
+	w = doRequest(s, "GET", "/api/v1/admin/pictures/duplicates", admin)
+	if !strings.Contains(w.Body.String(), `"wasted_bytes":`+strconv.FormatInt(other.Size(), 10)) {
+		t.Errorf("report after linking: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	exportFormatJSON      = "json"
+	exportFormatGamelist  = "gamelist"
+	exportFormatLaunchBox = "launchbox"
+
+	defaultExportROMPath = "./{name}.iso"
+)
+
+// ExportRequest is the optional body of POST /admin/exports.
+type ExportRequest struct {
+	Format  string `json:"format"`
+	System  string `json:"system"`
+	ROMPath string `json:"rom_path"`
+}
+
+// exportFormat describes how an export archive lays out its catalog file and
+// artwork. Pictures are stored under PictureDir/<title id>/ and referenced
+// from the catalog file by their path relative to it.
+type exportFormat struct {
+	CatalogFile string
+	PictureDir  string
+	Write       func(s *Server, w io.Writer, format exportFormat, titles []Title, req ExportRequest) error
+}
+
+var exportFormats = map[string]exportFormat{
+	exportFormatJSON:      {CatalogFile: "titles.json", PictureDir: "titles", Write: writeJSONCatalog},
+	exportFormatGamelist:  {CatalogFile: "gamelist.xml", PictureDir: "images", Write: writeGamelist},
+	exportFormatLaunchBox: {CatalogFile: "Metadata.xml", PictureDir: "Images", Write: writeLaunchBoxMetadata},
+}
+
+// launchBoxPlatforms maps systems to the platform names LaunchBox uses.
+var launchBoxPlatforms = map[string]string{
+	"XBOX":    "Microsoft Xbox",
+	"XBOX360": "Microsoft Xbox 360",
+	"XBOXONE": "Microsoft Xbox One",
+	"PC":      "Windows",
+}
+
+func (req *ExportRequest) normalize() error {
+	req.Format = strings.ToLower(strings.TrimSpace(req.Format))
+	if req.Format == "" {
+		req.Format = exportFormatJSON
+	}
+	if _, ok := exportFormats[req.Format]; !ok {
+		return fmt.Errorf("unknown format %q", req.Format)
+	}
+	req.System = strings.ToUpper(strings.TrimSpace(req.System))
+	if req.ROMPath == "" {
+		req.ROMPath = defaultExportROMPath
+	}
+	return nil
+}
+
+// exportPicturePath returns where a picture is stored in an export archive.
+func (s *Server) exportPicturePath(format exportFormat, title Title, picture Picture) string {
+	return fmt.Sprintf("%s/%s/%s%s", format.PictureDir, strings.ToLower(title.TitleID), picture.Name, s.config.PicturesSuffix)
+}
+
+// exportFileName replaces the characters file systems reject in a title name.
+func exportFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// titleDatabaseID reads a title id as a number, for formats that want numeric ids.
+func titleDatabaseID(titleID string) int64 {
+	n, _ := strconv.ParseUint(titleID, 16, 32)
+	return int64(n)
+}
+
+func writeJSONCatalog(s *Server, w io.Writer, format exportFormat, titles []Title, req ExportRequest) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(exportedTitles(titles))
+}
+
+type gameList struct {
+	XMLName xml.Name       `xml:"gameList"`
+	Games   []gameListGame `xml:"game"`
+}
+
+type gameListGame struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Path   string `xml:"path"`
+	Name   string `xml:"name"`
+	Image  string `xml:"image,omitempty"`
+}
+
+// writeGamelist writes an EmulationStation gamelist.xml. ROM paths come from
+// the rom_path template since the catalog doesn't know the local file names.
+func writeGamelist(s *Server, w io.Writer, format exportFormat, titles []Title, req ExportRequest) error {
+	list := gameList{Games: make([]gameListGame, 0, len(titles))}
+	for _, t := range titles {
+		game := gameListGame{
+			ID:     t.TitleID,
+			Source: "xtitles",
+			Path: strings.NewReplacer(
+				"{title_id}", t.TitleID,
+				"{name}", exportFileName(t.Name),
+			).Replace(req.ROMPath),
+			Name: t.Name,
+		}
+		if len(t.Pictures) > 0 {
+			game.Image = "./" + s.exportPicturePath(format, t, t.Pictures[0])
+		}
+		list.Games = append(list.Games, game)
+	}
+	return writeXML(w, list)
+}
+
+type launchBoxMetadata struct {
+	XMLName xml.Name         `xml:"LaunchBox"`
+	Games   []launchBoxGame  `xml:"Game"`
+	Images  []launchBoxImage `xml:"GameImage"`
+}
+
+type launchBoxGame struct {
+	Name       string `xml:"Name"`
+	DatabaseID int64  `xml:"DatabaseID"`
+	Platform   string `xml:"Platform"`
+}
+
+type launchBoxImage struct {
+	DatabaseID int64  `xml:"DatabaseID"`
+	FileName   string `xml:"FileName"`
+	Type       string `xml:"Type"`
+}
+
+// writeLaunchBoxMetadata writes the catalog in the format of LaunchBox's
+// Metadata.xml, with image file names pointing into the archive. The first
+// picture of a title is its front box art.
+func writeLaunchBoxMetadata(s *Server, w io.Writer, format exportFormat, titles []Title, req ExportRequest) error {
+	metadata := launchBoxMetadata{Games: make([]launchBoxGame, 0, len(titles))}
+	for _, t := range titles {
+		system := req.System
+		if system == "" && len(t.Systems) > 0 {
+			system = t.Systems[0]
+		}
+		platform, ok := launchBoxPlatforms[system]
+		if !ok {
+			platform = systemName(system)
+		}
+
+		id := titleDatabaseID(t.TitleID)
+		metadata.Games = append(metadata.Games, launchBoxGame{Name: t.Name, DatabaseID: id, Platform: platform})
+		if len(t.Pictures) > 0 {
+			metadata.Images = append(metadata.Images, launchBoxImage{
+				DatabaseID: id,
+				FileName:   s.exportPicturePath(format, t, t.Pictures[0]),
+				Type:       "Box - Front",
+			})
+		}
+	}
+	return writeXML(w, metadata)
+}
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
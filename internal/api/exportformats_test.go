@@ -0,0 +1,69 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportFormats(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+
+	if w := doRequestBody(s, "POST", "/api/v1/admin/exports", admin, `{"format":"csv"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	cases := []struct {
+		body    string
+		catalog string
+		picture string
+		want    []string
+	}{
+		{`{"format":"gamelist","system":"xbox360"}`, "gamelist.xml", "images/4d5307e6/20400.png", []string{
+			`<game id="4D5307E6" source="xtitles">`, `<path>./Halo 3_ ODST.iso</path>`, `<image>./images/4d5307e6/20400.png</image>`,
+		}},
+		{`{"format":"launchbox"}`, "Metadata.xml", "Images/584109eb/20400.png", []string{
+			`<DatabaseID>1297287142</DatabaseID>`, `<Platform>Microsoft Xbox 360</Platform>`, `<FileName>Images/4d5307e6/20400.png</FileName>`,
+		}},
+	}
+	for _, tc := range cases {
+		w := doRequestBody(s, "POST", "/api/v1/admin/exports", admin, tc.body)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d; body: %s", tc.body, w.Code, w.Body.String())
+		}
+		var status JobStatus
+		json.Unmarshal(w.Body.Bytes(), &status)
+		job, _ := s.jobs.Get(status.ID)
+		for job.Status().Status != jobDone && job.Status().Status != jobFailed {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		zr, err := zip.OpenReader(job.Result())
+		if err != nil {
+			t.Fatalf("%s: %v (job %+v)", tc.body, err, job.Status())
+		}
+		defer zr.Close()
+		files := map[string]string{}
+		for _, f := range zr.File {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			files[f.Name] = string(data)
+		}
+		if _, ok := files[tc.picture]; !ok {
+			t.Errorf("%s: archive does not contain %s: %v", tc.body, tc.picture, slices.Collect(maps.Keys(files)))
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(files[tc.catalog], want) {
+				t.Errorf("%s: %s does not contain %q:\n%s", tc.body, tc.catalog, want, files[tc.catalog])
+			}
+		}
+	}
+}
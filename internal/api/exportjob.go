@@ -0,0 +1,204 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+const exportJobKind = "export"
+
+type ExportJobResponse struct {
+	JobStatus
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func (s *Server) initExportJobs() error {
+	if err := os.MkdirAll(s.config.ExportDir, 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	s.exportSigningKey = []byte(s.config.ExportSigningKey)
+	if len(s.exportSigningKey) == 0 {
+		// Download links won't survive a restart, but neither do the jobs
+		s.exportSigningKey = []byte(newJobID())
+	}
+	return nil
+}
+
+func (s *Server) exportSignature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, s.exportSigningKey)
+	fmt.Fprintf(mac, "%s|%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) exportDownloadURL(c *gin.Context, id string) string {
+	expires := time.Now().Add(s.config.ExportURLTTL).Unix()
+	return fmt.Sprintf("%s/api/v1/exports/%s/download?expires=%d&signature=%s",
+		s.requestBaseURL(c), id, expires, s.exportSignature(id, expires))
+}
+
+// runArtworkExport writes the titles and pictures selected by req into a zip
+// archive in the export directory, along with a catalog file in req.Format.
+func (s *Server) runArtworkExport(ctx context.Context, job *Job, req ExportRequest) error {
+	format := exportFormats[req.Format]
+
+	var titles []Title
+	query := store.FilterBySystem(s.db.Model(&Title{}), req.System)
+	if err := query.Order("title_id ASC").Preload("Pictures").Find(&titles).Error; err != nil {
+		return fmt.Errorf("loading titles failed: %w", err)
+	}
+
+	total := 0
+	for _, title := range titles {
+		total += len(title.Pictures)
+	}
+	job.SetProgress(0, total)
+
+	path := filepath.Join(s.config.ExportDir, job.ID()+".zip")
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+
+	w, err := zw.Create(format.CatalogFile)
+	if err != nil {
+		return err
+	}
+	if err := format.Write(s, w, format, titles, req); err != nil {
+		return fmt.Errorf("error writing %s: %w", format.CatalogFile, err)
+	}
+
+	done := 0
+	for _, title := range titles {
+		for _, pic := range title.Pictures {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if err := s.addPictureToZip(ctx, zw, pic, s.exportPicturePath(format, title, pic)); err != nil {
+				return err
+			}
+
+			done++
+			job.SetProgress(done, total)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	job.SetResult(path)
+	return nil
+}
+
+func (s *Server) addPictureToZip(ctx context.Context, zw *zip.Writer, p Picture, name string) error {
+	f, err := s.pictures.Open(ctx, p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	// Pictures are already compressed, so just store them
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (s *Server) exportJobResponse(c *gin.Context, job *Job) ExportJobResponse {
+	resp := ExportJobResponse{JobStatus: job.Status()}
+	if resp.Status == jobDone {
+		resp.DownloadURL = s.exportDownloadURL(c, job.ID())
+		// The janitor keeps archives with links that may still be used
+		touchFile(job.Result())
+	}
+	return resp
+}
+
+func (s *Server) createExportJob(c *gin.Context) {
+	// The body is optional, an empty one exports everything as JSON
+	var req ExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if err := req.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export: " + err.Error()})
+		return
+	}
+
+	job := s.jobs.Start(exportJobKind, func(ctx context.Context, job *Job) error {
+		return s.runArtworkExport(ctx, job, req)
+	})
+	c.Header("Location", "/api/v1/admin/exports/"+job.ID())
+	c.JSON(http.StatusAccepted, s.exportJobResponse(c, job))
+}
+
+func (s *Server) getExportJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != exportJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s.exportJobResponse(c, job))
+}
+
+func (s *Server) downloadExport(c *gin.Context) {
+	id := c.Param("id")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(c.Query("signature")), []byte(s.exportSignature(id, expires))) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid signature"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		c.JSON(http.StatusGone, gin.H{"error": "Download link expired"})
+		return
+	}
+
+	job, ok := s.jobs.Get(id)
+	if !ok || job.Status().Status != jobDone {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if _, err := os.Stat(job.Result()); err != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Export is no longer available"})
+		return
+	}
+	touchFile(job.Result())
+
+	setCacheHeaders(c, s.config.CacheExport)
+	c.FileAttachment(job.Result(), "xtitles-export.zip")
+}
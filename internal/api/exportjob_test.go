@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExportDownload(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+	w := doRequestBody(s, "POST", "/api/v1/admin/exports", admin, `{}`)
+	var status ExportJobResponse
+	json.Unmarshal(w.Body.Bytes(), &status)
+	job, ok := s.jobs.Get(status.ID)
+	if !ok {
+		t.Fatalf("export: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	for job.Status().Status != jobDone && job.Status().Status != jobFailed {
+		time.Sleep(10 * time.Millisecond)
+	}
+	json.Unmarshal(doRequest(s, "GET", "/api/v1/admin/exports/"+status.ID, admin).Body.Bytes(), &status)
+	download, err := url.Parse(status.DownloadURL)
+	if err != nil || status.DownloadURL == "" {
+		t.Fatalf("download url = %q (job %+v)", status.DownloadURL, job.Status())
+	}
+
+	signed := func(id string, expires int64) string {
+		return fmt.Sprintf("/api/v1/exports/%s/download?expires=%d&signature=%s", id, expires, s.exportSignature(id, expires))
+	}
+	past := time.Now().Add(-time.Minute).Unix()
+	future := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"valid link", download.RequestURI(), http.StatusOK},
+		{"tampered signature", fmt.Sprintf("/api/v1/exports/%s/download?expires=%d&signature=00", status.ID, future), http.StatusForbidden},
+		{"extended expiry", fmt.Sprintf("/api/v1/exports/%s/download?expires=%d&signature=%s", status.ID, future+1, download.Query().Get("signature")), http.StatusForbidden},
+		{"missing expiry", "/api/v1/exports/" + status.ID + "/download", http.StatusForbidden},
+		{"expired link", signed(status.ID, past), http.StatusGone},
+		{"unknown export", signed("nope", future), http.StatusNotFound},
+		{"unknown job", "/api/v1/admin/exports/nope", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := doRequest(s, "GET", tt.target, admin); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d; body: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+	}
+
+	// Links outlive archives removed by the janitor
+	os.Remove(job.Result())
+	if w := doRequest(s, "GET", download.RequestURI(), nil); w.Code != http.StatusGone {
+		t.Errorf("removed archive: status = %d, want %d", w.Code, http.StatusGone)
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// externalIDOriginAdmin marks ids set by a maintainer, which enrichers never
+// overwrite.
+const externalIDOriginAdmin = "admin"
+
+// externalSources are the databases titles can be mapped to.
+var externalSources = map[string]string{
+	"giantbomb":         "GiantBomb",
+	"igdb":              "IGDB",
+	"opencritic":        "OpenCritic",
+	"pricecharting":     "PriceCharting",
+	"retroachievements": "RetroAchievements",
+	"thegamesdb":        "TheGamesDB",
+	"wikidata":          "Wikidata",
+}
+
+type ExternalIDInput struct {
+	ID string `json:"id"`
+}
+
+var (
+	errUnknownExternalSource = errors.New("unknown external source")
+	errInvalidExternalID     = errors.New("invalid external id")
+	errExternalIDTaken       = errors.New("external id is mapped to another title")
+	errExternalIDCurated     = errors.New("external id was set by a maintainer")
+)
+
+// normalizeExternalID validates a source and id pair, returning them in their
+// stored form.
+func normalizeExternalID(source, id string) (string, string, error) {
+	source = strings.ToLower(strings.TrimSpace(source))
+	if _, ok := externalSources[source]; !ok {
+		return "", "", errUnknownExternalSource
+	}
+	id = strings.TrimSpace(id)
+	if id == "" || len(id) > 64 || strings.ContainsAny(id, "/ \t\r\n") {
+		return "", "", errInvalidExternalID
+	}
+	if source == wikidataSource {
+		id = strings.ToUpper(id)
+		if !wikidataQIDPattern.MatchString(id) {
+			return "", "", errInvalidExternalID
+		}
+	}
+	return source, id, nil
+}
+
+// setExternalID maps titleID to id in source on behalf of origin, which is
+// either a maintainer or the name of the enricher that found the id. Enrichers
+// get errExternalIDCurated instead of replacing what a maintainer set.
+func (s *Server) setExternalID(titleID, source, id, origin string) (ExternalID, error) {
+	source, id, err := normalizeExternalID(source, id)
+	if err != nil {
+		return ExternalID{}, err
+	}
+
+	mapping := ExternalID{TitleID: titleID, Source: source, ExternalID: id, Origin: origin}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var taken ExternalID
+		err := tx.Where("source = ? AND external_id = ? AND title_id <> ?", source, id, titleID).First(&taken).Error
+		if err == nil {
+			return errExternalIDTaken
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var current ExternalID
+		err = tx.Where("title_id = ? AND source = ?", titleID, source).First(&current).Error
+		if err == nil && current.Origin == externalIDOriginAdmin && origin != externalIDOriginAdmin {
+			return errExternalIDCurated
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "title_id"}, {Name: "source"}},
+			DoUpdates: clause.AssignmentColumns([]string{"external_id", "origin", "updated_at"}),
+		}).Create(&mapping).Error
+		if err != nil {
+			return err
+		}
+		// Title responses embed their external ids
+		return tx.Model(&Title{TitleID: titleID}).UpdateColumn("updated_at", time.Now()).Error
+	})
+	return mapping, err
+}
+
+func (s *Server) putExternalID(c *gin.Context) {
+	s.titleEditMu.Lock()
+	defer s.titleEditMu.Unlock()
+
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var input ExternalIDInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	mapping, err := s.setExternalID(title.TitleID, c.Param("source"), input.ID, externalIDOriginAdmin)
+	switch {
+	case errors.Is(err, errUnknownExternalSource), errors.Is(err, errInvalidExternalID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid external id: " + err.Error()})
+	case errors.Is(err, errExternalIDTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "External id is already mapped to another title"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	default:
+		s.catalogChanged()
+		c.JSON(http.StatusOK, mapping)
+	}
+}
+
+func (s *Server) deleteExternalID(c *gin.Context) {
+	s.titleEditMu.Lock()
+	defer s.titleEditMu.Unlock()
+
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	source := strings.ToLower(c.Param("source"))
+	result := s.db.Where("title_id = ? AND source = ?", title.TitleID, source).Delete(&ExternalID{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "External id not found"})
+		return
+	}
+	switch source {
+	case retroAchievementsSource:
+		s.db.Where("title_id = ?", title.TitleID).Delete(&AchievementSet{})
+	case priceChartingSource:
+		s.db.Where("title_id = ?", title.TitleID).Delete(&MarketValue{})
+	case openCriticSource:
+		s.db.Where("title_id = ? AND source = ?", title.TitleID, source).Delete(&ReviewScore{})
+	}
+	s.db.Model(&Title{TitleID: title.TitleID}).UpdateColumn("updated_at", time.Now())
+	s.catalogChanged()
+
+	c.Status(http.StatusNoContent)
+}
+
+// getTitleByExternalID serves the title mapped to an id in another database.
+func (s *Server) getTitleByExternalID(c *gin.Context) {
+	source, id, err := normalizeExternalID(c.Param("source"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid external id: " + err.Error()})
+		return
+	}
+
+	var mapping ExternalID
+	if err := s.db.Where("source = ? AND external_id = ?", source, id).First(&mapping).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No title has this external id"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	title, err := s.findTitle(mapping.TitleID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("Content-Location", "/api/v1/titles/"+strings.ToLower(title.TitleID))
+	s.renderTitle(c, title)
+}
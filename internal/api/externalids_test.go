@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExternalIDs(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+	etag := doRequest(s, "GET", "/api/v1/titles", nil).Header().Get("ETag")
+
+	w := doRequestBody(s, "PUT", "/api/v1/admin/titles/4d5307e6/external/igdb", admin, `{"id":"1234"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"origin":"admin"`) {
+		t.Fatalf("setting an external id: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("listing not revalidated after an external id changed: status = %d", w.Code)
+	}
+	w = doRequestBody(s, "PUT", "/api/v1/admin/titles/584109eb/external/igdb", admin, `{"id":"1234"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("mapping an id to a second title: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	w = doRequestBody(s, "PUT", "/api/v1/admin/titles/4d5307e6/external/mobygames", admin, `{"id":"1"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown source: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = doRequest(s, "GET", "/api/v1/external/IGDB/1234", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title_id":"4D5307E6"`) {
+		t.Fatalf("lookup by external id: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"external_ids":[{"source":"igdb","id":"1234"`) {
+		t.Errorf("title does not list its external ids: %s", w.Body.String())
+	}
+
+	if _, err := s.setExternalID("4D5307E6", "igdb", "999", "enricher"); !errors.Is(err, errExternalIDCurated) {
+		t.Errorf("enricher overwrote a maintainer id: err = %v", err)
+	}
+	if _, err := s.setExternalID("4D5307E6", "giantbomb", "3030-1", "enricher"); err != nil {
+		t.Errorf("enricher could not add an id: %v", err)
+	}
+
+	w = doRequest(s, "DELETE", "/api/v1/admin/titles/4d5307e6/external/igdb", admin)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("deleting an external id: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := doRequest(s, "GET", "/api/v1/external/igdb/1234", nil); w.Code != http.StatusNotFound {
+		t.Errorf("deleted external id still resolves: status = %d", w.Code)
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// feedEntries is how many titles /feed.xml lists unless told otherwise.
+const feedEntries = 50
+
+// atomFeed is an Atom feed (RFC 4287) of the titles changed last.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary"`
+}
+
+// feedEntry describes a title for feed readers, linking to its page and, as
+// an enclosure, to its first picture.
+func (s *Server) feedEntry(baseURL string, title Title) atomEntry {
+	page := fmt.Sprintf("%s/titles/%s", baseURL, strings.ToLower(title.TitleID))
+	published, updated := title.CreatedAt, title.UpdatedAt
+	if title.FirstSeenAt != nil {
+		published = *title.FirstSeenAt
+	}
+	if synced := title.LastSynced(); synced != nil {
+		updated = *synced
+	}
+	entry := atomEntry{
+		ID:        page,
+		Title:     title.Name,
+		Published: published.UTC().Format(time.RFC3339),
+		Updated:   updated.UTC().Format(time.RFC3339),
+		Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: page}},
+	}
+
+	systems := make([]string, len(title.Systems))
+	for i, system := range title.Systems {
+		systems[i] = systemName(system)
+		entry.Categories = append(entry.Categories, atomCategory{Term: system, Label: systems[i]})
+	}
+	entry.Summary = fmt.Sprintf("%s (%s)", title.TitleID, strings.Join(systems, ", "))
+	switch len(title.Pictures) {
+	case 0:
+		entry.Summary += ", no pictures"
+	case 1:
+		entry.Summary += ", 1 picture"
+	default:
+		entry.Summary += fmt.Sprintf(", %d pictures", len(title.Pictures))
+	}
+
+	if len(title.Pictures) > 0 {
+		first := title.Pictures[0]
+		for _, picture := range title.Pictures[1:] {
+			if picture.Name < first.Name {
+				first = picture
+			}
+		}
+		entry.Links = append(entry.Links, atomLink{
+			Rel:    "enclosure",
+			Type:   mime.TypeByExtension(path.Ext(s.config.PicturesSuffix)),
+			Href:   s.pictureURL(baseURL, title.TitleID, first.Name),
+			Length: first.Size,
+		})
+	}
+	return entry
+}
+
+// getFeed returns an Atom feed of the titles syncs added or updated last, for
+// collectors to notice new entries upstream. Edits and enrichers don't move
+// titles up. It takes the system filter of listings and a limit of up to 100
+// entries.
+func (s *Server) getFeed(c *gin.Context) {
+	limit := feedEntries
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			c.String(http.StatusBadRequest, "Invalid limit, expected 1 to 100")
+			return
+		}
+		limit = n
+	}
+
+	etag, modified := s.catalogValidators()
+	if notModified(c, s.config.CacheLists, etag, modified) {
+		return
+	}
+
+	titles, _, err := s.titles.Titles(c.Request.Context(), store.TitleQuery{
+		System:       c.Query("system"),
+		SortBySynced: true,
+		Limit:        limit,
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	baseURL := s.requestBaseURL(c)
+	self := baseURL + "/feed.xml"
+	if c.Request.URL.RawQuery != "" {
+		self += "?" + c.Request.URL.RawQuery
+	}
+	feed := atomFeed{
+		ID:    baseURL + "/feed.xml",
+		Title: s.config.Branding.Name + ": recently added and updated titles",
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: self},
+			{Rel: "alternate", Type: "text/html", Href: baseURL + "/"},
+		},
+		Author: atomAuthor{Name: s.config.Branding.Name},
+	}
+	var updated time.Time
+	for _, title := range titles {
+		feed.Entries = append(feed.Entries, s.feedEntry(baseURL, title))
+		if synced := title.LastSynced(); synced != nil && synced.After(updated) {
+			updated = *synced
+		}
+	}
+	// A feed without entries was last updated when the catalog was
+	if updated.IsZero() {
+		updated = modified
+	}
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Encoding error")
+		return
+	}
+	setCacheHeaders(c, s.config.CacheLists)
+	c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), data...))
+}
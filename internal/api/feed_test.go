@@ -0,0 +1,75 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFeed(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	w := doRequest(s, "GET", "/feed.xml?limit=2", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("status = %d, headers = %v", w.Code, w.Header())
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 2 || feed.Updated == "" || feed.Links[0].Href != "http://example.com/feed.xml?limit=2" {
+		t.Fatalf("feed = %+v", feed)
+	}
+
+	w = doRequest(s, "GET", "/feed.xml?system=PC", nil)
+	feed = atomFeed{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 1 {
+		t.Fatalf("system filter: %s", w.Body.String())
+	}
+	entry := feed.Entries[0]
+	if entry.Title != "Minecraft" || entry.ID != "http://example.com/titles/584109eb" ||
+		entry.Summary != "584109EB (Xbox 360, PC), 1 picture" || len(entry.Categories) != 2 {
+		t.Errorf("entry = %+v", entry)
+	}
+	if len(entry.Links) != 2 || entry.Links[1].Rel != "enclosure" || entry.Links[1].Type != "image/png" ||
+		entry.Links[1].Href != "http://example.com/api/v1/titles/584109eb/20400.png" {
+		t.Errorf("links = %+v", entry.Links)
+	}
+
+	if w := doRequest(s, "GET", "/feed.xml?limit=1000", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("limit=1000: status = %d", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if w := doRequest(s, "GET", "/feed.xml", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status = %d", w.Code)
+	}
+
+	// Only syncs bring titles up, edits and enrichers don't
+	synced := time.Now().Add(-time.Hour)
+	s.db.Model(&Title{}).Where("1 = 1").UpdateColumn("synced_at", synced.Add(-time.Hour))
+	s.db.Model(&Title{}).Where("title_id = ?", "4D5307E6").UpdateColumn("synced_at", synced)
+	if _, err := s.setExternalID("415607F7", "igdb", "1", externalIDOriginAdmin); err != nil {
+		t.Fatal(err)
+	}
+	s.catalogChanged()
+	w = doRequest(s, "GET", "/feed.xml", map[string]string{"Accept-Encoding": "gzip"})
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("feed not compressed: %v", w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed = atomFeed{}
+	if err := xml.NewDecoder(zr).Decode(&feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != len(testTitles) || feed.Entries[0].Title != "Halo 3" || feed.Updated != synced.UTC().Format(time.RFC3339) {
+		t.Errorf("feed after an edit = %+v", feed)
+	}
+}
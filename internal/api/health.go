@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const readinessPingTimeout = 2 * time.Second
+
+// startupState tracks how far a server got through Start. Requests other than
+// health checks are refused until it is done, instead of failing on a missing
+// database or serving an empty catalog.
+type startupState struct {
+	dbOpen     atomic.Bool
+	dataLoaded atomic.Bool
+	// draining is set once the server is going away, for readyz to fail
+	// while requests are still served
+	draining atomic.Bool
+	// sync is the job of the sync run while starting, if any
+	sync atomic.Pointer[Job]
+
+	mu  sync.Mutex
+	err error
+}
+
+func (st *startupState) fail(err error) {
+	st.mu.Lock()
+	st.err = err
+	st.mu.Unlock()
+}
+
+func (st *startupState) failure() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.err
+}
+
+// ReadinessStatus is the body of /readyz.
+type ReadinessStatus struct {
+	Status     string `json:"status"`
+	Database   bool   `json:"database"`
+	DataLoaded bool   `json:"data_loaded"`
+	Error      string `json:"error,omitempty"`
+	// How far the sync run while starting got, until the server is ready
+	Sync               *SyncProgress `json:"sync,omitempty"`
+	LastSync           *SyncRun      `json:"last_sync"`
+	LastSuccessfulSync *SyncRun      `json:"last_successful_sync"`
+}
+
+// syncProgress returns how far the sync run while starting got, or nil if
+// there is none or the server is done starting.
+func (st *startupState) syncProgress() *SyncProgress {
+	job := st.sync.Load()
+	if job == nil || st.dataLoaded.Load() {
+		return nil
+	}
+	progress, _ := job.Detail().(SyncProgress)
+	return &progress
+}
+
+// requireReady answers 503 until the server has finished starting. Browsers
+// get a page following the progress of the startup sync instead of an error,
+// which reloads once the catalog can be served.
+func (s *Server) requireReady(c *gin.Context) {
+	if s.startup.dataLoaded.Load() {
+		c.Next()
+		return
+	}
+	c.Header("Retry-After", "5")
+	if c.Request.Method == http.MethodGet && !strings.HasPrefix(c.Request.URL.Path, "/api/") &&
+		c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		c.Header("Cache-Control", "no-store")
+		if s.config.SecureHeaders {
+			c.Header("Content-Security-Policy", s.frontendPolicy())
+		}
+		c.HTML(http.StatusServiceUnavailable, "starting.html", gin.H{
+			"title":    s.config.Branding.Name,
+			"progress": s.startup.syncProgress(),
+		})
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server is starting"})
+}
+
+// healthz only tells that the process is up and serving requests.
+func (s *Server) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz tells whether the server can serve the catalog, along with the state
+// of its last syncs. A failed sync doesn't make the server unready, it keeps
+// serving the data it has.
+func (s *Server) readyz(c *gin.Context) {
+	status := ReadinessStatus{
+		Status:     "starting",
+		Database:   s.startup.dbOpen.Load(),
+		DataLoaded: s.startup.dataLoaded.Load(),
+		Sync:       s.startup.syncProgress(),
+	}
+	if err := s.startup.failure(); err != nil {
+		status.Status = "failed"
+		status.Error = err.Error()
+	}
+
+	if status.Database {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessPingTimeout)
+		defer cancel()
+		if sqlDB, err := s.db.DB(); err != nil || sqlDB.PingContext(ctx) != nil {
+			status.Database = false
+			if status.Error == "" {
+				status.Error = "database is unreachable"
+			}
+		}
+	}
+	if status.Database {
+		var runs []SyncRun
+		if s.db.Order("id DESC").Limit(1).Find(&runs).Error == nil && len(runs) > 0 {
+			status.LastSync = &runs[0]
+		}
+		if run, err := s.lastSuccessfulSync(); err == nil {
+			status.LastSuccessfulSync = &run
+		}
+	}
+
+	code := http.StatusServiceUnavailable
+	switch {
+	case s.startup.draining.Load():
+		status.Status = "draining"
+	case status.Database && status.DataLoaded && status.Error == "":
+		status.Status = "ready"
+		code = http.StatusOK
+	}
+	c.JSON(code, status)
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	upstream "github.com/birabittoh/xtitles/internal/sync"
+)
+
+func TestHealthProbes(t *testing.T) {
+	upstream := fakeUpstream(t, testTitles)
+	cfg := testConfig(t, upstream.URL)
+	writePictureTree(t, cfg.PicturesFolder, testPictures)
+
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	if w := doRequest(s, "GET", "/healthz", nil); w.Code != http.StatusOK {
+		t.Errorf("healthz while starting: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	w := doRequest(s, "GET", "/readyz", nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"status":"starting"`) {
+		t.Errorf("readyz while starting: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	w = doRequest(s, "GET", "/api/v1/titles", nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("API while starting: status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	w = doRequest(s, "GET", "/readyz", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"ready"`) {
+		t.Fatalf("readyz once started: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	var status ReadinessStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if status.LastSuccessfulSync == nil || status.LastSuccessfulSync.Added != len(testTitles) {
+		t.Errorf("readyz does not report the initial sync: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", nil); w.Code != http.StatusOK {
+		t.Errorf("API once started: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// Draining servers keep serving, but tell load balancers to go elsewhere
+	s.config.DrainTimeout = time.Millisecond
+	s.drain(context.Background())
+	w = doRequest(s, "GET", "/readyz", nil)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"status":"draining"`) {
+		t.Errorf("readyz while draining: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", nil); w.Code != http.StatusOK {
+		t.Errorf("API while draining: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestStartupProgress(t *testing.T) {
+	// Upstream holds the pages past the first until released
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var matching []Title
+		for _, title := range testTitles {
+			if slices.Contains(title.Systems, r.URL.Query().Get("system")) {
+				matching = append(matching, title)
+			}
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if offset > 0 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		end := min(offset+2, len(matching))
+		offset = min(offset, end)
+		json.NewEncoder(w).Encode(upstream.Response{Items: matching[offset:end], Count: len(matching)})
+	}))
+	t.Cleanup(srv.Close)
+
+	s, err := newServer(testConfig(t, srv.URL))
+	if err != nil {
+		t.Fatalf("newServer: %v", err)
+	}
+	t.Cleanup(s.Close)
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+
+	var status ReadinessStatus
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		w := doRequest(s, "GET", "/readyz", nil)
+		status = ReadinessStatus{}
+		json.Unmarshal(w.Body.Bytes(), &status)
+		if status.Sync != nil && status.Sync.Fetched > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readyz never reported the startup sync: %s", w.Body.String())
+		}
+	}
+	if status.Status != "starting" || status.Sync.Phase != syncPhaseFetching || status.Sync.Fetched != 2 || status.Sync.Total != 4 {
+		t.Errorf("readyz while importing: %+v, sync %+v", status, status.Sync)
+	}
+
+	browser := map[string]string{"Accept": "text/html,application/xhtml+xml,*/*;q=0.8"}
+	w := doRequest(s, "GET", "/titles/4d5307e6", browser)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "2 / 4 titles") || w.Header().Get("Retry-After") == "" {
+		t.Errorf("page while importing: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	// Scripts and API clients still get an error they can handle
+	if w := doRequest(s, "GET", "/api/v1/titles", browser); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "Server is starting") {
+		t.Errorf("API while importing: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/", nil); !strings.Contains(w.Body.String(), "Server is starting") {
+		t.Errorf("without Accept: %s", w.Body.String())
+	}
+
+	close(release)
+	if err := <-started; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	w = doRequest(s, "GET", "/readyz", nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"sync"`) {
+		t.Errorf("readyz once started: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/", browser); w.Code != http.StatusOK {
+		t.Errorf("page once started: status = %d", w.Code)
+	}
+}
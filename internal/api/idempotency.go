@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyRecord is the stored response of a POST request made with an
+// Idempotency-Key header, replayed when the client retries the request.
+type IdempotencyRecord struct {
+	Key         string    `gorm:"primaryKey"`
+	RequestHash string    `gorm:"size:64"`
+	Status      int       `gorm:"not null"`
+	ContentType string    `gorm:"not null"`
+	Location    string    `gorm:"not null"`
+	Body        []byte    `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"index"`
+}
+
+// recordingWriter keeps a copy of the response body while writing it out.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// requestHash fingerprints the parts of a request that must match on retry.
+func requestHash(c *gin.Context, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, c.Request.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotency replays the stored response of POST requests retried with the
+// same Idempotency-Key instead of running them again.
+func (s *Server) idempotency(c *gin.Context) {
+	key := c.GetHeader("Idempotency-Key")
+	if c.Request.Method != http.MethodPost || key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		abortWithProblem(c, http.StatusBadRequest, "Idempotency-Key header is too long")
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		abortWithProblem(c, http.StatusRequestEntityTooLarge, "The request body is too large")
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	// Keys are scoped to the endpoint they were first used with
+	scoped := c.Request.Method + " " + c.Request.URL.Path + " " + key
+	hash := requestHash(c, body)
+
+	s.idempotencyMu.Lock()
+	if s.idempotencyInFlight[scoped] {
+		s.idempotencyMu.Unlock()
+		abortWithProblem(c, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+		return
+	}
+
+	var record IdempotencyRecord
+	err = s.db.First(&record, "key = ? AND created_at > ?", scoped, time.Now().Add(-s.config.IdempotencyTTL)).Error
+	if err == nil {
+		s.idempotencyMu.Unlock()
+		if record.RequestHash != hash {
+			abortWithProblem(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			return
+		}
+		if record.Location != "" {
+			c.Header("Location", record.Location)
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(record.Status, record.ContentType, record.Body)
+		c.Abort()
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.idempotencyMu.Unlock()
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	s.idempotencyInFlight[scoped] = true
+	s.idempotencyMu.Unlock()
+
+	defer func() {
+		s.idempotencyMu.Lock()
+		delete(s.idempotencyInFlight, scoped)
+		s.idempotencyMu.Unlock()
+	}()
+
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+
+	// Only successes are stored, so failed requests (e.g. unauthorized or
+	// hitting a server error) get another chance when retried
+	status := w.Status()
+	if status < 200 || status >= 300 {
+		return
+	}
+
+	record = IdempotencyRecord{
+		Key:         scoped,
+		RequestHash: hash,
+		Status:      status,
+		ContentType: w.Header().Get("Content-Type"),
+		Location:    w.Header().Get("Location"),
+		Body:        w.body.Bytes(),
+		CreatedAt:   time.Now(),
+	}
+	// Replace any expired record left behind under the same key
+	if err := s.db.Save(&record).Error; err != nil {
+		log.Printf("Warning: storing idempotent response failed: %v\n", err)
+	}
+}
+
+// purgeIdempotencyRecords deletes stored responses older than the TTL.
+func (s *Server) purgeIdempotencyRecords() {
+	result := s.db.Where("created_at <= ?", time.Now().Add(-s.config.IdempotencyTTL)).Delete(&IdempotencyRecord{})
+	if result.Error != nil {
+		log.Printf("Janitor: error purging idempotency records: %v\n", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("Janitor: purged %d expired idempotency records\n", result.RowsAffected)
+	}
+}
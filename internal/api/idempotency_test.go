@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	r := newTestServer(t, testTitles)
+	header := func(key string) map[string]string {
+		return map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json", "Idempotency-Key": key}
+	}
+	beta := `{"title_id":"4D5307F1","name":"Halo 3 Beta","systems":["XBOX360"]}`
+
+	steps := []struct {
+		name     string
+		key      string
+		body     string
+		status   int
+		replayed string
+		contains string
+	}{
+		{"first request", "create-beta", beta, http.StatusCreated, "", `"title_id":"4D5307F1"`},
+		{"retry is replayed", "create-beta", beta, http.StatusCreated, "true", `"title_id":"4D5307F1"`},
+		{"key reused with another body", "create-beta", `{"title_id":"4D5307F2","name":"Other","systems":["XBOX360"]}`, http.StatusUnprocessableEntity, "", "different request"},
+		{"new key runs again", "create-beta-2", beta, http.StatusConflict, "", "already exists"},
+		{"failures are not stored", "create-beta-2", beta, http.StatusConflict, "", "already exists"},
+	}
+
+	for _, step := range steps {
+		w := doRequestBody(r, "POST", "/api/v1/admin/titles", header(step.key), step.body)
+		if w.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d; body: %s", step.name, w.Code, step.status, w.Body.String())
+		}
+		if got := w.Header().Get("Idempotent-Replayed"); got != step.replayed {
+			t.Errorf("%s: Idempotent-Replayed = %q, want %q", step.name, got, step.replayed)
+		}
+		if !strings.Contains(w.Body.String(), step.contains) {
+			t.Errorf("%s: body does not contain %q: %s", step.name, step.contains, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errImagePoolBusy is returned when every worker is busy and the queue full.
+var errImagePoolBusy = errors.New("image processing is saturated")
+
+// imagePool bounds the image operations running at once, thumbnails and
+// format conversions alike, so that a burst of them can't starve the CPU.
+// Operations beyond the workers wait in a queue of bounded depth, and are
+// refused once it is full.
+type imagePool struct {
+	workers chan struct{}
+	queue   chan struct{} // workers and waiting slots together
+
+	running atomic.Int64
+	waiting atomic.Int64
+}
+
+func newImagePool(workers, queue int) *imagePool {
+	workers = max(workers, 1)
+	return &imagePool{
+		workers: make(chan struct{}, workers),
+		queue:   make(chan struct{}, workers+max(queue, 0)),
+	}
+}
+
+// do runs fn on a worker, waiting for one if needed. It returns
+// errImagePoolBusy right away when the queue is full, or ctx's error if ctx
+// is done first.
+func (p *imagePool) do(ctx context.Context, fn func() error) (time.Duration, error) {
+	select {
+	case p.queue <- struct{}{}:
+	default:
+		return 0, errImagePoolBusy
+	}
+	defer func() { <-p.queue }()
+
+	start := time.Now()
+	p.waiting.Add(1)
+	select {
+	case p.workers <- struct{}{}:
+		p.waiting.Add(-1)
+	case <-ctx.Done():
+		p.waiting.Add(-1)
+		return time.Since(start), ctx.Err()
+	}
+	wait := time.Since(start)
+
+	p.running.Add(1)
+	defer func() {
+		p.running.Add(-1)
+		<-p.workers
+	}()
+	return wait, fn()
+}
+
+// imagesBusy answers 503 when err means the image pool turned the request
+// away, telling the client to try again shortly.
+func (s *Server) imagesBusy(c *gin.Context, err error) bool {
+	if !errors.Is(err, errImagePoolBusy) {
+		return false
+	}
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Image processing is busy, try again shortly"})
+	return true
+}
+
+// processImage runs fn on the image pool, recording how long it queued.
+func (s *Server) processImage(ctx context.Context, operation string, fn func() error) error {
+	wait, err := s.images.do(ctx, fn)
+	if errors.Is(err, errImagePoolBusy) {
+		s.metrics.record(func() { s.metrics.imageRejected.add(1, operation) })
+		return err
+	}
+	s.metrics.record(func() { s.metrics.imageQueueWait.observe(wait.Seconds(), operation) })
+	return err
+}
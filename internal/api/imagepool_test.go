@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImagePool(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.ImageWorkers = 1
+		cfg.ImageQueue = 1
+	})
+
+	// Hold the only worker, then fill the queue
+	release := make(chan struct{})
+	running := make(chan struct{})
+	go s.processImage(context.Background(), "test", func() error {
+		close(running)
+		<-release
+		return nil
+	})
+	<-running
+	queued := make(chan *httptest.ResponseRecorder)
+	go func() { queued <- doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400.png?w=1&h=1", nil) }()
+	for s.images.waiting.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20401.png?w=1", nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("saturated: status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Originals and cached thumbnails don't need a worker
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20401.png", nil); w.Code != http.StatusOK {
+		t.Errorf("original while saturated: status = %d", w.Code)
+	}
+
+	metrics := doRequest(s, "GET", "/metrics", nil).Body.String()
+	for _, want := range []string{
+		"xtitles_image_operations_running 1",
+		"xtitles_image_operations_waiting 1",
+		`xtitles_image_operations_rejected_total{operation="thumbnail"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+
+	close(release)
+	if w := <-queued; w.Code != http.StatusOK {
+		t.Errorf("queued: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20401.png?w=1", nil); w.Code != http.StatusOK {
+		t.Errorf("after the burst: status = %d", w.Code)
+	}
+}
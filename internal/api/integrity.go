@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	integrityCheckOff   = "off"
+	integrityCheckQuick = "quick"
+	integrityCheckFull  = "full"
+)
+
+// backupPattern matches the names of the backups backupDB writes.
+const backupPattern = "titles-*.db"
+
+// corruptionError is returned by checkIntegrity when the pragma ran and found
+// problems, as opposed to failing to run.
+type corruptionError struct {
+	pragma   string
+	problems []string
+}
+
+func (e *corruptionError) Error() string {
+	problems := e.problems
+	if len(problems) > 5 {
+		problems = append(problems[:5:5], "...")
+	}
+	return fmt.Sprintf("%s reported problems: %s", e.pragma, strings.Join(problems, "; "))
+}
+
+// checkIntegrity runs the configured SQLite integrity pragma against gdb. A
+// *corruptionError means the database is corrupt, any other error that it
+// couldn't be checked.
+func (s *Server) checkIntegrity(gdb *gorm.DB) error {
+	pragma := "quick_check"
+	if s.config.DBIntegrityCheck == integrityCheckFull {
+		pragma = "integrity_check"
+	}
+
+	var results []string
+	if err := gdb.Raw("PRAGMA " + pragma).Scan(&results).Error; err != nil {
+		return fmt.Errorf("%s failed: %w", pragma, err)
+	}
+	if len(results) == 1 && results[0] == "ok" {
+		return nil
+	}
+	return &corruptionError{pragma: pragma, problems: results}
+}
+
+func closeDB(gdb *gorm.DB) {
+	if sqlDB, err := gdb.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// latestBackup returns the most recently modified backup backupDB wrote, if
+// any. Other databases in the directory are none of its business.
+func (s *Server) latestBackup() (string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.config.BackupDir, backupPattern))
+	if err != nil || len(matches) == 0 {
+		return "", err
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, _ := os.Stat(matches[i])
+		b, _ := os.Stat(matches[j])
+		return a.ModTime().After(b.ModTime())
+	})
+	return matches[0], nil
+}
+
+// restoreLatestBackup moves the corrupt database at dbPath aside and replaces it
+// with the latest backup.
+func (s *Server) restoreLatestBackup(dbPath string) error {
+	backup, err := s.latestBackup()
+	if err != nil {
+		return fmt.Errorf("listing backups failed: %w", err)
+	}
+	if backup == "" {
+		return fmt.Errorf("no backup available in %s", s.config.BackupDir)
+	}
+
+	corruptPath := fmt.Sprintf("%s.corrupt-%s", dbPath, time.Now().Format("20060102150405"))
+	if err := os.Rename(dbPath, corruptPath); err != nil {
+		return fmt.Errorf("failed to move corrupt database aside: %w", err)
+	}
+	// Stale journals belong to the corrupt database
+	os.Remove(dbPath + "-wal")
+	os.Remove(dbPath + "-shm")
+
+	if err := copyFile(backup, dbPath); err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	log.Printf("Restored database from backup %s (corrupt copy kept at %s)\n", backup, corruptPath)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// backupDB writes a consistent copy of the database into the backup directory
+// and removes the oldest backups beyond the configured count. It runs when
+// the server starts, not for every command. Only SQLite databases are backed
+// up, others are left to their own tooling.
+func (s *Server) backupDB(gdb *gorm.DB) error {
+	if s.config.DBBackupKeep <= 0 || !isSQLite(gdb) {
+		return nil
+	}
+	if err := os.MkdirAll(s.config.BackupDir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	path := filepath.Join(s.config.BackupDir, fmt.Sprintf("titles-%s.db", time.Now().Format("20060102150405")))
+	if err := gdb.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(s.config.BackupDir, backupPattern))
+	if err != nil {
+		return err
+	}
+	sort.Strings(matches)
+	for len(matches) > s.config.DBBackupKeep {
+		os.Remove(matches[0])
+		matches = matches[1:]
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntegrityCheck(t *testing.T) {
+	cfg := testConfig(t, fakeUpstream(t, testTitles).URL)
+	cfg.DBIntegrityCheck = integrityCheckFull
+	writePictureTree(t, cfg.PicturesFolder, testPictures)
+	dbPath := filepath.Join(cfg.DataDir, cfg.DBFile)
+
+	start := func() (*Server, error) {
+		t.Helper()
+		s, err := NewServer(cfg)
+		if err == nil {
+			s.Close()
+		}
+		return s, err
+	}
+	corruptCopies := func() []string {
+		t.Helper()
+		matches, _ := filepath.Glob(dbPath + ".corrupt-*")
+		return matches
+	}
+
+	// The first start creates the database and backs it up
+	if _, err := start(); err != nil {
+		t.Fatalf("first start: %v", err)
+	}
+	if backup, err := (&Server{config: cfg}).latestBackup(); err != nil || backup == "" {
+		t.Fatalf("no backup after starting: %q, %v", backup, err)
+	}
+	if _, err := start(); err != nil || len(corruptCopies()) != 0 {
+		t.Fatalf("clean check: err = %v, corrupt copies = %v", err, corruptCopies())
+	}
+
+	// A database that can't be checked is left alone
+	db, err := openDatabase(cfg.DBDriver, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "BEGIN EXCLUSIVE"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = start()
+	if err == nil || !strings.Contains(err.Error(), "integrity check failed") {
+		t.Errorf("locked database: err = %v", err)
+	}
+	if len(corruptCopies()) != 0 {
+		t.Errorf("a locked database was restored: %v", corruptCopies())
+	}
+	conn.ExecContext(context.Background(), "ROLLBACK")
+	conn.Close()
+
+	// An index that no longer matches its table is corruption
+	if err := db.Create(&[]TitleView{{TitleID: "4D5307E6"}, {TitleID: "584109EB"}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDB.Exec("PRAGMA writable_schema = ON"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlDB.Exec("UPDATE sqlite_master SET sql = replace(sql, '(`title_id`)', '(`created_at`)') WHERE name = 'idx_title_views_title_id'"); err != nil {
+		t.Fatal(err)
+	}
+	closeDB(db)
+
+	// Unrelated databases next to the backups are never restored
+	unrelated := filepath.Join(cfg.BackupDir, "unrelated.db")
+	os.WriteFile(unrelated, []byte("not a database"), 0644)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(unrelated, future, future)
+
+	if _, err := start(); err != nil {
+		t.Fatalf("corrupt database: %v", err)
+	}
+	if len(corruptCopies()) != 1 {
+		t.Errorf("corrupt database was not moved aside: %v", corruptCopies())
+	}
+}
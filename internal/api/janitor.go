@@ -0,0 +1,217 @@
+package api
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// managedDir is a directory of disposable files (caches, temporary exports)
+// whose total size the janitor keeps under MaxBytes by evicting the least
+// recently used files. Files are considered used when their mtime is bumped.
+// Match limits the directory to some of its files, and the janitor never
+// evicts those Pinned says are still needed.
+type managedDir struct {
+	Name     string
+	Path     string
+	MaxBytes int64
+	Match    func(path string) bool
+	Pinned   func(f managedFile) bool
+}
+
+type DiskUsage struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Bytes     int64     `json:"bytes"`
+	Files     int       `json:"files"`
+	MaxBytes  int64     `json:"max_bytes"`
+	Evicted   int       `json:"evicted"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type managedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// initManagedDirs registers the directories the janitor keeps in check.
+func (s *Server) initManagedDirs() {
+	s.registerManagedDir(managedDir{
+		Name:     "exports",
+		Path:     s.config.ExportDir,
+		MaxBytes: s.config.ExportMaxSize,
+		// Issuing a download link touches the archive, links last ExportURLTTL
+		Pinned: func(f managedFile) bool { return time.Since(f.modTime) < s.config.ExportURLTTL },
+	})
+	s.registerManagedDir(managedDir{Name: "thumbnails", Path: s.config.ThumbnailDir, MaxBytes: s.config.ThumbnailCacheMaxSize})
+	if s.config.PictureStorage == pictureStorageLocal || s.config.PictureStorage == "" {
+		// Converted copies sit next to the originals, which are not disposable
+		s.registerManagedDir(managedDir{
+			Name:     "converted pictures",
+			Path:     s.config.PicturesFolder,
+			MaxBytes: s.config.PictureCacheMaxSize,
+			Match:    s.isConvertedPicture,
+		})
+	}
+	s.registerManagedDir(managedDir{
+		Name:     "quarantine",
+		Path:     s.config.DataDir,
+		MaxBytes: s.config.QuarantineMaxSize,
+		Match:    func(path string) bool { return strings.Contains(filepath.Base(path), ".corrupt-") },
+	})
+}
+
+// isConvertedPicture tells whether path is a picture converted to another
+// format, rather than an original.
+func (s *Server) isConvertedPicture(path string) bool {
+	ext := filepath.Ext(path)
+	_, known := knownPictureFormats[strings.TrimPrefix(ext, ".")]
+	return known && !strings.EqualFold(ext, s.config.PicturesSuffix)
+}
+
+func (s *Server) registerManagedDir(d managedDir) {
+	for i := range s.managedDirs {
+		if s.managedDirs[i].Name == d.Name {
+			s.managedDirs[i] = d
+			return
+		}
+	}
+	s.managedDirs = append(s.managedDirs, d)
+}
+
+// touchFile marks a managed file as recently used.
+func touchFile(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+func listManagedFiles(root string, match func(path string) bool) ([]managedFile, error) {
+	var files []managedFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || (match != nil && !match(path)) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, managedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// cleanManagedDir removes stale temporary files and evicts the least recently
+// used files until the directory fits within its cap.
+func (s *Server) cleanManagedDir(d managedDir) (DiskUsage, error) {
+	usage := DiskUsage{Name: d.Name, Path: d.Path, MaxBytes: d.MaxBytes, CheckedAt: time.Now()}
+
+	files, err := listManagedFiles(d.Path, d.Match)
+	if err != nil {
+		return usage, err
+	}
+
+	// Oldest first
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	kept := files[:0]
+	for _, f := range files {
+		if strings.HasSuffix(f.path, ".tmp") && time.Since(f.modTime) > s.config.JanitorTmpMaxAge {
+			if os.Remove(f.path) == nil {
+				usage.Evicted++
+				continue
+			}
+		}
+		kept = append(kept, f)
+		usage.Bytes += f.size
+	}
+
+	for _, f := range kept {
+		if d.MaxBytes <= 0 || usage.Bytes <= d.MaxBytes {
+			break
+		}
+		if strings.HasSuffix(f.path, ".tmp") {
+			// Probably still being written
+			continue
+		}
+		if d.Pinned != nil && d.Pinned(f) {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			log.Printf("Janitor: failed to remove %s: %v\n", f.path, err)
+			continue
+		}
+		usage.Bytes -= f.size
+		usage.Evicted++
+	}
+	usage.Files = len(files) - usage.Evicted
+
+	return usage, nil
+}
+
+func (s *Server) runJanitorOnce() {
+	s.purgeIdempotencyRecords()
+	s.purgeUsage()
+	s.purgeQuotas()
+	s.purgeNonces()
+	s.purgeTitleViews()
+
+	for _, d := range s.managedDirs {
+		usage, err := s.cleanManagedDir(d)
+		if err != nil {
+			log.Printf("Janitor: error cleaning %s: %v\n", d.Path, err)
+			continue
+		}
+		if usage.Evicted > 0 {
+			log.Printf("Janitor: evicted %d files from %s (%d bytes in use)\n", usage.Evicted, d.Name, usage.Bytes)
+		}
+
+		s.diskUsageMu.Lock()
+		s.lastDiskUsage[d.Name] = usage
+		s.diskUsageMu.Unlock()
+	}
+}
+
+func (s *Server) runJanitor(interval time.Duration) {
+	s.runJanitorOnce()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.runJanitorOnce()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) getDiskUsage(c *gin.Context) {
+	s.diskUsageMu.RLock()
+	defer s.diskUsageMu.RUnlock()
+
+	items := make([]DiskUsage, 0, len(s.managedDirs))
+	for _, d := range s.managedDirs {
+		if usage, ok := s.lastDiskUsage[d.Name]; ok {
+			items = append(items, usage)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
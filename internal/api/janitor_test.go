@@ -0,0 +1,87 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.ThumbnailCacheMaxSize = 250
+		cfg.ExportMaxSize = 100
+		cfg.ExportURLTTL = 24 * time.Hour
+		cfg.PictureCacheMaxSize = 1
+		cfg.QuarantineMaxSize = 1
+	})
+
+	// writeFile writes 100 bytes to path, last used age ago
+	writeFile := func(path string, age time.Duration) {
+		t.Helper()
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := time.Now().Add(-age)
+		os.Chtimes(path, used, used)
+	}
+	thumbnail := func(name string) string { return filepath.Join(s.config.ThumbnailDir, "4d5307e6", name) }
+	export := func(name string) string { return filepath.Join(s.config.ExportDir, name) }
+	picture := func(name string) string { return filepath.Join(s.config.PicturesFolder, "4d5307e6", name) }
+	quarantined := filepath.Join(s.config.DataDir, s.config.DBFile+".corrupt-20260101000000")
+
+	writeFile(thumbnail("oldest.png"), 3*time.Hour)
+	writeFile(thumbnail("older.png"), 2*time.Hour)
+	writeFile(thumbnail("recent.png"), time.Hour)
+	writeFile(export("expired.zip"), 48*time.Hour)
+	writeFile(export("linked.zip"), 2*time.Hour)
+	writeFile(export("just-linked.zip"), time.Minute)
+	writeFile(picture("20400.webp"), time.Hour)
+	writeFile(quarantined, time.Hour)
+
+	s.runJanitorOnce()
+
+	tests := []struct {
+		path string
+		kept bool
+	}{
+		// Least recently used first, until the directory fits
+		{thumbnail("oldest.png"), false},
+		{thumbnail("older.png"), true},
+		{thumbnail("recent.png"), true},
+		// Exports whose links are still valid stay, over the limit or not
+		{export("expired.zip"), false},
+		{export("linked.zip"), true},
+		{export("just-linked.zip"), true},
+		// Converted copies go, never the originals next to them
+		{picture("20400.webp"), false},
+		{picture("20400.png"), true},
+		{quarantined, false},
+		{filepath.Join(s.config.DataDir, s.config.DBFile), true},
+	}
+	for _, tt := range tests {
+		if _, err := os.Stat(tt.path); (err == nil) != tt.kept {
+			t.Errorf("%s: kept = %t, want %t", tt.path, err == nil, tt.kept)
+		}
+	}
+
+	s.diskUsageMu.RLock()
+	thumbnails := s.lastDiskUsage["thumbnails"]
+	s.diskUsageMu.RUnlock()
+	if thumbnails.Bytes != 200 || thumbnails.Files != 2 || thumbnails.Evicted != 1 {
+		t.Errorf("thumbnails usage = %+v", thumbnails)
+	}
+
+	w := doRequest(s, "GET", "/metrics", nil)
+	for _, want := range []string{
+		`xtitles_disk_usage_bytes{dir="thumbnails"} 200`,
+		`xtitles_disk_usage_bytes{dir="exports"} 200`,
+		`xtitles_disk_limit_bytes{dir="thumbnails"} 250`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+}
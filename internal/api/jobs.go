@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// Job is a unit of background work tracked in memory.
+type Job struct {
+	mu sync.RWMutex
+
+	id        string
+	kind      string
+	status    string
+	done      int
+	total     int
+	result    string
+	detail    any
+	err       string
+	createdAt time.Time
+	updatedAt time.Time
+
+	// changed is closed and replaced whenever the job is updated
+	changed chan struct{}
+}
+
+type JobStatus struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Status    string    `json:"status"`
+	Done      int       `json:"done"`
+	Total     int       `json:"total"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (j *Job) ID() string {
+	return j.id
+}
+
+// SetProgress records how many of total steps have been completed.
+func (j *Job) SetProgress(done, total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done = done
+	j.total = total
+	j.updatedAt = time.Now()
+	j.notifyLocked()
+}
+
+// SetResult stores an opaque result (e.g. a file path) for the job's consumer.
+func (j *Job) SetResult(result string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.result = result
+	j.notifyLocked()
+}
+
+// SetDetail stores what the job is doing beyond its progress, for jobs whose
+// consumers show more than a progress bar.
+func (j *Job) SetDetail(detail any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.detail = detail
+	j.updatedAt = time.Now()
+	j.notifyLocked()
+}
+
+func (j *Job) Detail() any {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.detail
+}
+
+// Changed returns a channel closed on the next update of the job.
+func (j *Job) Changed() <-chan struct{} {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.changed
+}
+
+func (j *Job) notifyLocked() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *Job) Result() string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.result
+}
+
+func (j *Job) setStatus(status string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	if err != nil {
+		j.err = err.Error()
+	}
+	j.updatedAt = time.Now()
+	j.notifyLocked()
+}
+
+// Wait blocks until the job has returned, and returns its error.
+func (j *Job) Wait() error {
+	for {
+		// Taken before the status so that no update is missed in between
+		changed := j.Changed()
+		switch status := j.Status(); status.Status {
+		case jobDone:
+			return nil
+		case jobFailed:
+			return errors.New(status.Error)
+		}
+		<-changed
+	}
+}
+
+func (j *Job) Status() JobStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return JobStatus{
+		ID:        j.id,
+		Kind:      j.kind,
+		Status:    j.status,
+		Done:      j.done,
+		Total:     j.total,
+		Error:     j.err,
+		CreatedAt: j.createdAt,
+		UpdatedAt: j.updatedAt,
+	}
+}
+
+// EnricherJobResponse is the status of a job enriching titles from another
+// database, with a summary of what it did once it is done.
+type EnricherJobResponse struct {
+	JobStatus
+	Result string `json:"result,omitempty"`
+}
+
+func enricherJobResponse(job *Job) EnricherJobResponse {
+	return EnricherJobResponse{JobStatus: job.Status(), Result: job.Result()}
+}
+
+type jobRegistry struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+
+	// ctx is cancelled when the server shuts down
+	ctx     context.Context
+	running sync.WaitGroup
+}
+
+func newJobRegistry(ctx context.Context) *jobRegistry {
+	return &jobRegistry{jobs: make(map[string]*Job), ctx: ctx}
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start runs fn in the background and returns its job immediately.
+func (r *jobRegistry) Start(kind string, fn func(ctx context.Context, job *Job) error) *Job {
+	now := time.Now()
+	job := &Job{
+		id:        newJobID(),
+		kind:      kind,
+		status:    jobPending,
+		createdAt: now,
+		updatedAt: now,
+		changed:   make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	r.jobs[job.id] = job
+	r.mu.Unlock()
+
+	r.running.Go(func() {
+		job.setStatus(jobRunning, nil)
+		if err := fn(r.ctx, job); err != nil {
+			log.Printf("Job %s (%s) failed: %v\n", job.id, kind, err)
+			job.setStatus(jobFailed, err)
+			return
+		}
+		job.setStatus(jobDone, nil)
+	})
+
+	return job
+}
+
+// Wait blocks until every job has returned.
+func (r *jobRegistry) Wait() {
+	r.running.Wait()
+}
+
+func (r *jobRegistry) Get(id string) (*Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	job, ok := r.jobs[id]
+	return job, ok
+}
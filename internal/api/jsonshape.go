@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// freeFormJSONKeys hold maps of caller data, like the params of a report,
+// rather than struct fields. Their values are copied as they are.
+var freeFormJSONKeys = map[string]bool{"params": true, "filters": true}
+
+// jsonShape is how shapeJSON rewrites a response.
+type jsonShape struct {
+	// compact drops the fields that are null, "", [] or {}
+	compact bool
+	// camel turns snake_case keys into camelCase
+	camel bool
+}
+
+// shapeJSON rewrites the JSON responses of requests with compact=true, left
+// without their null and empty fields, or case=camel, with camelCase keys,
+// for frontends that expect either. Fields keep their order and numbers
+// their digits, and freeFormJSONKeys their contents. Responses other than
+// JSON are neither buffered nor reshaped.
+func (s *Server) shapeJSON(c *gin.Context) {
+	var shape jsonShape
+	shape.compact = c.Query("compact") == "true"
+	switch c.Query("case") {
+	case "", "snake":
+	case "camel":
+		shape.camel = true
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid case, expected snake or camel"})
+		return
+	}
+	if !shape.compact && !shape.camel {
+		c.Next()
+		return
+	}
+
+	w := &bufferedWriter{ResponseWriter: c.Writer}
+	// Anything but JSON, like CSV exports, goes out as it is written
+	w.onWrite = func() {
+		if !isJSON(c) {
+			w.stream()
+		}
+	}
+	c.Writer = w
+	c.Next()
+
+	body := w.body.Bytes()
+	if !w.streaming && isJSON(c) && len(body) > 0 {
+		if shaped, err := shape.rewrite(body); err == nil {
+			body = shaped
+		}
+	}
+	w.release(c, body)
+}
+
+// rewrite returns the JSON document in body reshaped.
+func (shape jsonShape) rewrite(body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var out bytes.Buffer
+	if _, err := shape.value(dec, &out); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("more than one JSON value")
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// value copies the next value of dec to out, reshaped, and tells whether it
+// is empty. Array elements are kept, empty or not, for their positions.
+func (shape jsonShape) value(dec *json.Decoder, out *bytes.Buffer) (bool, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '[' {
+			out.WriteByte('[')
+			n := 0
+			for ; dec.More(); n++ {
+				if n > 0 {
+					out.WriteByte(',')
+				}
+				if _, err := shape.value(dec, out); err != nil {
+					return false, err
+				}
+			}
+			_, err := dec.Token()
+			out.WriteByte(']')
+			return n == 0, err
+		}
+
+		out.WriteByte('{')
+		n := 0
+		var field bytes.Buffer
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return false, err
+			}
+			key, _ := token.(string)
+			field.Reset()
+			fieldShape := shape
+			if freeFormJSONKeys[key] {
+				fieldShape = jsonShape{}
+			}
+			empty, err := fieldShape.value(dec, &field)
+			if err != nil {
+				return false, err
+			}
+			if shape.compact && empty {
+				continue
+			}
+			if n > 0 {
+				out.WriteByte(',')
+			}
+			if shape.camel {
+				key = camelCase(key)
+			}
+			writeJSONString(out, key)
+			out.WriteByte(':')
+			out.Write(field.Bytes())
+			n++
+		}
+		_, err := dec.Token()
+		out.WriteByte('}')
+		return n == 0, err
+	case nil:
+		out.WriteString("null")
+		return true, nil
+	case string:
+		writeJSONString(out, t)
+		return t == "", nil
+	case json.Number:
+		out.WriteString(t.String())
+	case bool:
+		out.WriteString(strconv.FormatBool(t))
+	}
+	return false, nil
+}
+
+func writeJSONString(out *bytes.Buffer, s string) {
+	encoded, _ := json.Marshal(s)
+	out.Write(encoded)
+}
+
+// camelCase turns a snake_case key into camelCase, like title_id into
+// titleId. Keys without underscores are left alone.
+func camelCase(key string) string {
+	parts := strings.Split(key, "_")
+	if len(parts) == 1 {
+		return key
+	}
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, part := range parts[1:] {
+		r, size := utf8.DecodeRuneInString(part)
+		if size == 0 {
+			continue
+		}
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(part[size:])
+	}
+	return b.String()
+}
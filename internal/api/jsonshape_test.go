@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJSONShape(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	tests := []struct {
+		target   string
+		contains []string
+		excludes []string
+	}{
+		{"/api/v1/titles/415607f7", []string{`"pfn":null`, `"pictures":[]`, `"title_id":"415607F7"`}, nil},
+		{"/api/v1/titles/415607f7?compact=true", []string{`{"title_id":"415607F7","name":"Call of Duty 4","systems":["XBOX360"],"created_at":`, `"curated":false`},
+			[]string{"pfn", "service_config_id", "bing_id", "pictures"}},
+		{"/api/v1/titles/4d5307e6?compact=true", []string{`"pictures":[{"id":`, `"bing_id":"66acd000-77fe-1000-9115-d8024d5307e6"`}, []string{"pfn"}},
+		{"/api/v1/titles/4d5307e6?case=camel", []string{`"titleId":"4D5307E6"`, `"bingId":`, `"serviceConfigId":null`}, []string{"title_id"}},
+		{"/api/v1/titles?case=camel&compact=true&limit=1", []string{`{"items":[{"titleId":"415607F7"`, `"total":4,"limit":1,"offset":0,"page":1,"pages":4`, `"nextCursor":`},
+			[]string{"pfn", "next_cursor"}},
+		{"/api/v1/search?q=zzzz&compact=true", []string{`"total":0`}, []string{`"items"`}},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d", tt.target, w.Code)
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: body does not contain %s: %s", tt.target, want, w.Body.String())
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(w.Body.String(), unwanted) {
+				t.Errorf("%s: body contains %s: %s", tt.target, unwanted, w.Body.String())
+			}
+		}
+	}
+
+	if w := doRequest(s, "GET", "/api/v1/titles?case=kebab", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("case=kebab: status = %d", w.Code)
+	}
+	// Errors are reshaped too, and streams are left alone
+	if w := doRequest(s, "GET", "/api/v1/titles/00000000?case=camel", nil); !strings.Contains(w.Body.String(), `"titleId":"00000000"`) {
+		t.Errorf("not found: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/export?case=camel", nil); !strings.Contains(w.Body.String(), `"title_id"`) {
+		t.Errorf("export: %s", w.Body.String())
+	}
+
+	// Free-form maps keep their keys
+	shaped, err := jsonShape{camel: true, compact: true}.rewrite([]byte(`{"report_id":1,"params":{"only_with_pictures":"","system":"PC"}}`))
+	if want := `{"reportId":1,"params":{"only_with_pictures":"","system":"PC"}}` + "\n"; err != nil || string(shaped) != want {
+		t.Errorf("rewrite = %s, %v; want %s", shaped, err, want)
+	}
+
+	// Other responses go out as they are written, not once the handler returns
+	r := gin.New()
+	r.GET("/csv", s.shapeJSON, func(c *gin.Context) {
+		c.Header("Content-Type", "text/csv")
+		c.Writer.WriteString("title_id,name\n")
+		if c.Writer.(*bufferedWriter).body.Len() != 0 {
+			t.Error("csv response was buffered")
+		}
+	})
+	if w := doRequest(r, "GET", "/csv?case=camel&compact=true", nil); w.Body.String() != "title_id,name\n" {
+		t.Errorf("csv: %q", w.Body.String())
+	}
+}
+
+func TestCamelCase(t *testing.T) {
+	for key, want := range map[string]string{
+		"title_id": "titleId", "name": "name", "only_with_pictures": "onlyWithPictures", "a__b": "aB", "AddedSince": "AddedSince",
+	} {
+		if got := camelCase(key); got != want {
+			t.Errorf("camelCase(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// LetterCount is the number of titles whose name starts with a letter.
+type LetterCount struct {
+	Letter string `json:"letter"`
+	Count  int64  `json:"count"`
+}
+
+// parseLetter normalizes a letter filter to its bucket of store.Letters:
+// letters regardless of case, a digit or 0-9 for names starting with one,
+// and other for the rest.
+func parseLetter(value string) (string, bool) {
+	if value == "" {
+		return "", true
+	}
+	letter := strings.ToUpper(value)
+	if len(letter) == 1 {
+		return store.LetterOf(letter), true
+	}
+	if strings.EqualFold(letter, store.LetterOther) {
+		return store.LetterOther, true
+	}
+	return letter, letter == store.LetterDigits
+}
+
+// getTitleLetters counts the titles by the first character of their name,
+// every bucket of the alphabet index in order, for a browse bar to tell the
+// empty ones.
+func (s *Server) getTitleLetters(c *gin.Context) {
+	onlyWithPictures := c.DefaultQuery("only_with_pictures", "false") == "true"
+	system := c.Query("system")
+
+	etag, modified := s.catalogValidators()
+	if notModified(c, s.config.CacheLists, etag, modified) {
+		return
+	}
+
+	debugFilters(c.Request.Context(), gin.H{"system": system, "only_with_pictures": onlyWithPictures})
+	query := s.db.WithContext(c.Request.Context()).Model(&Title{})
+	if onlyWithPictures {
+		query = query.Where("EXISTS (SELECT 1 FROM pictures WHERE pictures.title_id = titles.title_id)")
+	}
+	var initials []struct {
+		Initial string
+		Count   int64
+	}
+	err := store.FilterBySystem(query, system).
+		Select(store.NameInitial + " AS initial, COUNT(*) AS count").
+		Group("initial").Scan(&initials).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	counts := make(map[string]int64, len(store.Letters))
+	for _, initial := range initials {
+		counts[store.LetterOf(initial.Initial)] += initial.Count
+	}
+	items := make([]LetterCount, len(store.Letters))
+	for i, letter := range store.Letters {
+		items[i] = LetterCount{Letter: letter, Count: counts[letter]}
+	}
+
+	setCacheHeaders(c, s.config.CacheLists)
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestTitleLetters(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	s.db.Create(&[]Title{
+		{TitleID: "00000007", Name: "007 Legends", Systems: []string{"XBOX360"}},
+		{TitleID: "0000000E", Name: "Ōkami", Systems: []string{"PC"}},
+		{TitleID: "0000000A", Name: "alan Wake", Systems: []string{"PC"}},
+	})
+
+	letters := func(target string) map[string]int64 {
+		t.Helper()
+		w := doRequest(s, "GET", target, nil)
+		var resp struct{ Items []LetterCount }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Items) != 28 {
+			t.Fatalf("%s: status = %d; body: %s", target, w.Code, w.Body.String())
+		}
+		counts := map[string]int64{}
+		for _, item := range resp.Items {
+			if item.Count > 0 {
+				counts[item.Letter] = item.Count
+			}
+		}
+		return counts
+	}
+	if got, want := letters("/api/v1/titles/letters"), map[string]int64{"A": 1, "C": 1, "H": 2, "M": 1, "0-9": 1, "other": 1}; !maps.Equal(got, want) {
+		t.Errorf("letters = %v, want %v", got, want)
+	}
+	if got, want := letters("/api/v1/titles/letters?system=pc"), map[string]int64{"A": 1, "M": 1, "other": 1}; !maps.Equal(got, want) {
+		t.Errorf("letters on PC = %v, want %v", got, want)
+	}
+	if got, want := letters("/api/v1/titles/letters?only_with_pictures=true"), map[string]int64{"H": 1, "M": 1}; !maps.Equal(got, want) {
+		t.Errorf("letters with pictures = %v, want %v", got, want)
+	}
+
+	for target, want := range map[string][]string{
+		"/api/v1/titles?letter=h":                         {"4D5307E6", "4D530802"},
+		"/api/v1/titles?letter=A":                         {"0000000A"},
+		"/api/v1/titles?letter=0-9":                       {"00000007"},
+		"/api/v1/titles?letter=7":                         {"00000007"},
+		"/api/v1/titles?letter=Other":                     {"0000000E"},
+		"/api/v1/titles?letter=h&only_with_pictures=true": {"4D5307E6"},
+		"/api/v1/titles?letter=z":                         nil,
+	} {
+		w := doRequest(s, "GET", target, nil)
+		var resp struct{ Items []Title }
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var ids []string
+		for _, t := range resp.Items {
+			ids = append(ids, t.TitleID)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("%s: got %v, want %v", target, ids, want)
+		}
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles?letter=ab", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid letter: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
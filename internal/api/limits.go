@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Problem is an RFC 9457 problem details object.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func abortWithProblem(c *gin.Context, status int, detail string) {
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(status, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+}
+
+// bodyLimit returns the maximum request body size for the matched route.
+func (s *Server) bodyLimit(c *gin.Context) int64 {
+	switch c.FullPath() {
+	case "/api/v1/admin/titles/:id/pictures", "/api/v1/uploads/titles/:id/pictures":
+		return s.config.PictureMaxUploadSize
+	}
+	return s.config.MaxBodySize
+}
+
+// requestLimits rejects oversized URLs, search terms, id batches and bodies
+// before they reach the handlers.
+func (s *Server) requestLimits(c *gin.Context) {
+	if s.config.MaxURLLength > 0 && len(c.Request.RequestURI) > s.config.MaxURLLength {
+		abortWithProblem(c, http.StatusRequestURITooLong, "The request URL is too long")
+		return
+	}
+	if s.config.MaxQueryLength > 0 && utf8.RuneCountInString(c.Query("q")) > s.config.MaxQueryLength {
+		abortWithProblem(c, http.StatusBadRequest, "Query parameter 'q' is too long")
+		return
+	}
+	if ids := c.Query("ids"); s.config.MaxBatchIDs > 0 && strings.Count(ids, ",")+1 > s.config.MaxBatchIDs {
+		abortWithProblem(c, http.StatusBadRequest, "Query parameter 'ids' contains too many title IDs")
+		return
+	}
+
+	if limit := s.bodyLimit(c); limit > 0 && c.Request.Body != nil {
+		if c.Request.ContentLength > limit {
+			abortWithProblem(c, http.StatusRequestEntityTooLarge, "The request body is too large")
+			return
+		}
+		// Chunked bodies have no length up front, so cap what handlers can read
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+
+	c.Next()
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets on.
+const listenFDsStart = 3
+
+// unixAddressPrefix marks an ADDRESS entry as the path of a unix socket.
+const unixAddressPrefix = "unix:"
+
+// inheritedListenFDs returns the sockets passed by systemd socket activation,
+// as described by LISTEN_PID and LISTEN_FDS, if any. Sockets meant for
+// another process, e.g. a parent that didn't clear the variables, are ignored.
+func inheritedListenFDs(getenv func(string) string, pid int) ([]int, error) {
+	fds := getenv("LISTEN_FDS")
+	if fds == "" || getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	inherited := make([]int, n)
+	for i := range inherited {
+		inherited[i] = listenFDsStart + i
+	}
+	return inherited, nil
+}
+
+type listenAddress struct {
+	Network string
+	Address string
+}
+
+// listenAddresses splits ADDRESS into its comma separated entries:
+// unix:/run/xtitles.sock is a unix socket, anything else a TCP address.
+func listenAddresses(address string) []listenAddress {
+	var addrs []listenAddress
+	for _, addr := range parseList(address) {
+		if path, ok := strings.CutPrefix(addr, unixAddressPrefix); ok {
+			addrs = append(addrs, listenAddress{"unix", path})
+		} else {
+			addrs = append(addrs, listenAddress{"tcp", addr})
+		}
+	}
+	return addrs
+}
+
+// listen opens the sockets to serve on: the ones inherited from systemd
+// socket activation when there are some, else those of ADDRESS, with TCP
+// ports shared with other processes when LISTEN_REUSE_PORT is set. Either way
+// a new binary can start accepting connections before the old one stops.
+func (s *Server) listen() ([]net.Listener, error) {
+	fds, err := inheritedListenFDs(os.Getenv, os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) > 0 {
+		// Children must not think the sockets are theirs
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+
+		var lns []net.Listener
+		for _, fd := range fds {
+			f := os.NewFile(uintptr(fd), "systemd socket")
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				closeListeners(lns)
+				return nil, fmt.Errorf("using the inherited socket %d: %w", fd, err)
+			}
+			log.Printf("Listening on the socket from the service manager (%s)\n", ln.Addr())
+			lns = append(lns, ln)
+		}
+		return lns, nil
+	}
+
+	addrs := listenAddresses(s.config.Address)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address to listen on")
+	}
+	var lns []net.Listener
+	for _, addr := range addrs {
+		ln, err := s.listenOn(addr)
+		if err != nil {
+			closeListeners(lns)
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func (s *Server) listenOn(addr listenAddress) (net.Listener, error) {
+	if addr.Network == "tcp" {
+		var lc net.ListenConfig
+		if s.config.ListenReusePort {
+			lc.Control = reusePort
+		}
+		return lc.Listen(context.Background(), addr.Network, addr.Address)
+	}
+
+	// A socket left behind by a process that didn't exit cleanly is in the way
+	if fi, err := os.Stat(addr.Address); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(addr.Address); err != nil {
+			return nil, fmt.Errorf("removing the stale socket: %w", err)
+		}
+	}
+	ln, err := net.Listen(addr.Network, addr.Address)
+	if err != nil {
+		return nil, err
+	}
+	// Let the reverse proxy connect, whatever the umask
+	if err := os.Chmod(addr.Address, s.config.ListenSocketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting the socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+func closeListeners(lns []net.Listener) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+// serve serves on every listener until the server is shut down or one of
+// them fails, which stops the others.
+func (s *Server) serve(lns []net.Listener) error {
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() { errs <- s.httpServer.Serve(ln) }()
+	}
+	err := <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		s.httpServer.Close()
+	}
+	return err
+}
+
+// drain fails readiness checks for DRAIN_TIMEOUT before shutting down, while
+// still serving, so that load balancers stop sending requests first.
+func (s *Server) drain(ctx context.Context) {
+	if s.config.DrainTimeout <= 0 {
+		return
+	}
+	s.startup.draining.Store(true)
+	log.Printf("Draining for %s...\n", s.config.DrainTimeout)
+
+	timer := time.NewTimer(s.config.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package api
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return errors.New("LISTEN_REUSE_PORT is not supported on this platform")
+}
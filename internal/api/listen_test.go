@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	tests := []struct {
+		name string
+		vars map[string]string
+		fds  string
+		err  bool
+	}{
+		{"no socket", map[string]string{}, "[]", false},
+		{"socket", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "1"}, "[3]", false},
+		{"sockets", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2"}, "[3 4]", false},
+		{"another process' socket", map[string]string{"LISTEN_PID": "41", "LISTEN_FDS": "1"}, "[]", false},
+		{"invalid count", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "none"}, "[]", true},
+	}
+	for _, tt := range tests {
+		fds, err := inheritedListenFDs(env(tt.vars), 42)
+		if fmt.Sprint(fds) != tt.fds || (err != nil) != tt.err {
+			t.Errorf("%s: fds = %v, err = %v", tt.name, fds, err)
+		}
+	}
+
+	// With SO_REUSEPORT a new process can listen before the old one stops
+	cfg := testConfig(t, "")
+	cfg.Address = "127.0.0.1:0"
+	cfg.ListenReusePort = true
+	s := &Server{config: cfg}
+	old, err := s.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeListeners(old)
+	s.config.Address = old[0].Addr().String()
+	next, err := s.listen()
+	if err != nil {
+		t.Fatalf("listening on a port in use with LISTEN_REUSE_PORT: %v", err)
+	}
+	closeListeners(next)
+	s.config.ListenReusePort = false
+	if lns, err := s.listen(); err == nil {
+		closeListeners(lns)
+		t.Error("listening on a port in use without LISTEN_REUSE_PORT succeeded")
+	}
+}
+
+func TestListenAddresses(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	sock := filepath.Join(t.TempDir(), "xtitles.sock")
+	// A stale socket is replaced
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s.config.Address = "127.0.0.1:0, unix:" + sock
+	lns, err := s.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lns) != 2 {
+		t.Fatalf("listening on %d sockets, want 2", len(lns))
+	}
+	if fi, err := os.Stat(sock); err != nil || fi.Mode().Perm() != 0660 {
+		t.Errorf("socket mode = %v, %v, want 0660", fi.Mode().Perm(), err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.serve(lns) }()
+
+	clients := map[string]*http.Client{
+		"tcp": {},
+		"unix": {Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		}}},
+	}
+	for network, client := range clients {
+		resp, err := client.Get("http://" + lns[0].Addr().String() + "/healthz")
+		if err != nil {
+			t.Errorf("%s: %v", network, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status = %d", network, resp.StatusCode)
+		}
+	}
+
+	s.httpServer.Shutdown(context.Background())
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("serve = %v, want ErrServerClosed", err)
+	}
+	if _, err := os.Stat(sock); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket left behind after shutdown: %v", err)
+	}
+}
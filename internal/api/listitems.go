@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pictureKindGamerpic is the kind of every picture of the catalog so far.
+const pictureKindGamerpic = "gamerpic"
+
+// TitleListItem is a title in a listing, with what a grid needs to show it:
+// how many pictures it has and the first of them.
+type TitleListItem struct {
+	Title
+	// Pictures shadows those of Title, to leave them out with
+	// pictures=summary
+	Pictures       *[]Picture      `json:"pictures,omitempty"`
+	PictureCount   int             `json:"picture_count"`
+	PrimaryPicture *PrimaryPicture `json:"primary_picture"`
+}
+
+// PrimaryPicture is the picture a listing shows for a title, the first by
+// name.
+type PrimaryPicture struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+}
+
+// pictureSummary is what a listing tells about the pictures of a title.
+type pictureSummary struct {
+	TitleID string
+	Count   int
+	First   string
+}
+
+// summaryOnly tells whether a listing leaves out the pictures of its titles,
+// with pictures=summary.
+func summaryOnly(c *gin.Context) bool {
+	return c.Query("pictures") == "summary"
+}
+
+// pictureSummaries counts the pictures of the titles with the given ids and
+// finds their first, for listings that don't load them.
+func (s *Server) pictureSummaries(ctx context.Context, ids []string) (map[string]pictureSummary, error) {
+	summaries := make(map[string]pictureSummary, len(ids))
+	if len(ids) == 0 {
+		return summaries, nil
+	}
+	var rows []pictureSummary
+	err := s.db.WithContext(ctx).Model(&Picture{}).
+		Select("title_id, COUNT(*) AS count, MIN(name) AS first").
+		Where("title_id IN ?", ids).Group("title_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		summaries[row.TitleID] = row
+	}
+	return summaries, nil
+}
+
+// listItems adds their picture summary to titles. Titles loaded without
+// their pictures get it from the database, the others from their pictures,
+// which are left out with pictures=summary.
+func (s *Server) listItems(c *gin.Context, titles []Title, picturesLoaded bool) ([]TitleListItem, error) {
+	var summaries map[string]pictureSummary
+	if !picturesLoaded {
+		ids := make([]string, len(titles))
+		for i, title := range titles {
+			ids[i] = title.TitleID
+		}
+		var err error
+		if summaries, err = s.pictureSummaries(c.Request.Context(), ids); err != nil {
+			return nil, err
+		}
+	}
+
+	baseURL := s.requestBaseURL(c)
+	summary := summaryOnly(c)
+	items := make([]TitleListItem, len(titles))
+	for i, title := range titles {
+		item := TitleListItem{Title: title}
+		if picturesLoaded {
+			item.PictureCount = len(title.Pictures)
+			if len(title.Pictures) > 0 {
+				first := slices.MinFunc(title.Pictures, func(a, b Picture) int {
+					return strings.Compare(a.Name, b.Name)
+				})
+				item.PrimaryPicture = &PrimaryPicture{Name: first.Name}
+			}
+			if !summary {
+				pictures := title.Pictures
+				if pictures == nil {
+					pictures = []Picture{}
+				}
+				item.Pictures = &pictures
+			}
+		} else if row, ok := summaries[title.TitleID]; ok {
+			item.PictureCount = row.Count
+			item.PrimaryPicture = &PrimaryPicture{Name: row.First}
+		}
+		if item.PrimaryPicture != nil {
+			item.PrimaryPicture.Kind = pictureKindGamerpic
+			item.PrimaryPicture.URL = s.pictureURL(baseURL, title.TitleID, item.PrimaryPicture.Name)
+		}
+		items[i] = item
+	}
+	return items, nil
+}
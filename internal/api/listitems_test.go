@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListPictureSummary(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	for _, target := range []string{"/api/v1/titles", "/api/v1/titles?pictures=summary", "/api/v1/search?q=halo"} {
+		w := doRequest(s, "GET", target, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", target, w.Code)
+		}
+		var body struct {
+			Items []struct {
+				TitleID        string          `json:"title_id"`
+				Pictures       json.RawMessage `json:"pictures"`
+				PictureCount   int             `json:"picture_count"`
+				PrimaryPicture *PrimaryPicture `json:"primary_picture"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range body.Items {
+			switch item.TitleID {
+			case "4D5307E6":
+				if item.PictureCount != 2 || item.PrimaryPicture == nil || item.PrimaryPicture.Name != "20400" ||
+					item.PrimaryPicture.Kind != "gamerpic" || !strings.HasSuffix(item.PrimaryPicture.URL, "/api/v1/titles/4d5307e6/20400.png") {
+					t.Errorf("%s: Halo 3 = %+v", target, item)
+				}
+			case "415607F7":
+				if item.PictureCount != 0 || item.PrimaryPicture != nil {
+					t.Errorf("%s: Call of Duty 4 = %+v", target, item)
+				}
+			}
+			if summary := strings.Contains(target, "summary"); summary != (item.Pictures == nil) {
+				t.Errorf("%s: %s pictures = %s", target, item.TitleID, item.Pictures)
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// manifestPrefixPattern matches the title ID prefixes manifests are filtered by.
+var manifestPrefixPattern = regexp.MustCompile(`^[0-9a-f]{1,8}$`)
+
+// TitleManifest lists the files of a title, so that tools copying them
+// elsewhere, like the art folders of a console drive, only download those
+// whose hash changed.
+type TitleManifest struct {
+	TitleID string          `json:"title_id"`
+	Assets  []ManifestAsset `json:"assets"`
+	// Total size of the assets, in bytes
+	Size int64 `json:"size"`
+	// Changes whenever an asset is added, removed or changed
+	Hash string `json:"hash"`
+}
+
+type ManifestAsset struct {
+	// Path relative to the title, e.g. 20452.png
+	Path string `json:"path"`
+	// Size and hash are left out for pictures indexed before they were
+	// captured, until a rescan
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	URL    string `json:"url"`
+}
+
+// ManifestSummary is the top of a hash tree over the assets of the catalog:
+// titles are grouped in buckets by the first two characters of their ID,
+// each hashing the hashes of its titles, and the root hashes the buckets.
+// A mirror compares the root, then the buckets, and only lists the titles of
+// the buckets that differ.
+type ManifestSummary struct {
+	Hash    string           `json:"hash"`
+	Titles  int              `json:"titles"`
+	Assets  int              `json:"assets"`
+	Size    int64            `json:"size"`
+	Buckets []ManifestBucket `json:"buckets"`
+}
+
+type ManifestBucket struct {
+	// Lowercase title ID prefix, the prefix to list the bucket with
+	Prefix string `json:"prefix"`
+	Hash   string `json:"hash"`
+	Titles int    `json:"titles"`
+	Assets int    `json:"assets"`
+	Size   int64  `json:"size"`
+}
+
+// titleManifest lists pictures, all of titleID, by name.
+func (s *Server) titleManifest(titleID string, pictures []Picture, baseURL string) TitleManifest {
+	manifest := TitleManifest{TitleID: titleID, Assets: []ManifestAsset{}}
+	for _, p := range pictures {
+		manifest.Assets = append(manifest.Assets, ManifestAsset{
+			Path:   p.Name + s.config.PicturesSuffix,
+			Size:   p.Size,
+			SHA256: p.SHA256,
+			URL:    s.pictureURL(baseURL, titleID, p.Name),
+		})
+		manifest.Size += p.Size
+	}
+	slices.SortFunc(manifest.Assets, func(a, b ManifestAsset) int { return strings.Compare(a.Path, b.Path) })
+
+	h := sha256.New()
+	for _, a := range manifest.Assets {
+		fmt.Fprintf(h, "%s %d %s\n", a.Path, a.Size, a.SHA256)
+	}
+	manifest.Hash = hex.EncodeToString(h.Sum(nil))
+	return manifest
+}
+
+// manifestTitles returns the pictures of the titles that have any, grouped
+// by title in title ID order, for a page of titles whose ID starts with
+// prefix. A limit of 0 returns them all.
+func (s *Server) manifestTitles(prefix string, offset, limit int) ([][]Picture, int64, error) {
+	titles := func(tx *gorm.DB) *gorm.DB {
+		query := tx.Model(&Picture{})
+		if prefix != "" {
+			query = query.Where("title_id LIKE ?", strings.ToUpper(prefix)+"%")
+		}
+		return query
+	}
+	var total int64
+	var pictures []Picture
+	err := store.ReadSnapshot(s.ctx, s.db, func(tx *gorm.DB) error {
+		if err := titles(tx).Distinct("title_id").Count(&total).Error; err != nil {
+			return err
+		}
+
+		query := tx.Select("title_id, name, size, sha256").Order("title_id, name")
+		if limit > 0 {
+			var ids []string
+			err := titles(tx).Distinct("title_id").Order("title_id").Offset(offset).Limit(limit).Pluck("title_id", &ids).Error
+			if err != nil {
+				return err
+			}
+			query = query.Where("title_id IN ?", ids)
+		} else if prefix != "" {
+			query = query.Where("title_id LIKE ?", strings.ToUpper(prefix)+"%")
+		}
+		return query.Find(&pictures).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var groups [][]Picture
+	for i, p := range pictures {
+		if i == 0 || p.TitleID != pictures[i-1].TitleID {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], p)
+	}
+	return groups, total, nil
+}
+
+// manifestSummary hashes the manifests of every title into buckets and a root.
+func (s *Server) manifestSummary() (ManifestSummary, error) {
+	summary := ManifestSummary{Buckets: []ManifestBucket{}}
+	groups, _, err := s.manifestTitles("", 0, 0)
+	if err != nil {
+		return summary, err
+	}
+
+	root := sha256.New()
+	var bucket *ManifestBucket
+	var bucketHash hash.Hash
+	closeBucket := func() {
+		if bucket != nil {
+			bucket.Hash = hex.EncodeToString(bucketHash.Sum(nil))
+			fmt.Fprintf(root, "%s %s\n", bucket.Prefix, bucket.Hash)
+			summary.Buckets = append(summary.Buckets, *bucket)
+		}
+	}
+	for _, pictures := range groups {
+		manifest := s.titleManifest(pictures[0].TitleID, pictures, "")
+		prefix := strings.ToLower(manifest.TitleID[:min(2, len(manifest.TitleID))])
+		if bucket == nil || bucket.Prefix != prefix {
+			closeBucket()
+			bucket = &ManifestBucket{Prefix: prefix}
+			bucketHash = sha256.New()
+		}
+		fmt.Fprintf(bucketHash, "%s %s\n", manifest.TitleID, manifest.Hash)
+		bucket.Titles++
+		bucket.Assets += len(manifest.Assets)
+		bucket.Size += manifest.Size
+		summary.Titles++
+		summary.Assets += len(manifest.Assets)
+		summary.Size += manifest.Size
+	}
+	closeBucket()
+	summary.Hash = hex.EncodeToString(root.Sum(nil))
+	return summary, nil
+}
+
+func (s *Server) getTitleManifest(c *gin.Context) {
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.titleNotFound(c, c.Param("id"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	manifest := s.titleManifest(title.TitleID, title.Pictures, s.requestBaseURL(c))
+	// Rescans change pictures without touching the title, so only the
+	// content of the manifest tells whether it changed
+	if notModified(c, s.config.CacheDetails, `"`+manifest.Hash[:16]+`"`, time.Time{}) {
+		return
+	}
+	setCacheHeaders(c, s.config.CacheDetails)
+	c.JSON(http.StatusOK, manifest)
+}
+
+// getManifest lists the manifests of the titles with pictures, a page at a
+// time, optionally only those whose ID starts with prefix.
+func (s *Server) getManifest(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	prefix := strings.ToLower(c.Query("prefix"))
+	if prefix != "" && !manifestPrefixPattern.MatchString(prefix) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prefix, expected up to 8 hexadecimal characters"})
+		return
+	}
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+	offset := (page - 1) * limit
+
+	etag, modified := s.catalogValidators()
+	if notModified(c, s.config.CacheLists, etag, modified) {
+		return
+	}
+
+	groups, total, err := s.manifestTitles(prefix, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	baseURL := s.requestBaseURL(c)
+	manifests := make([]TitleManifest, 0, len(groups))
+	for _, pictures := range groups {
+		manifests = append(manifests, s.titleManifest(pictures[0].TitleID, pictures, baseURL))
+	}
+
+	setCacheHeaders(c, s.config.CacheLists)
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Items:      manifests,
+		Total:      total,
+		Limit:      limit,
+		Offset:     offset,
+		Page:       page,
+		Pages:      int((total + int64(limit) - 1) / int64(limit)),
+		Generation: catalogGeneration(etag),
+	})
+}
+
+func (s *Server) getManifestSummary(c *gin.Context) {
+	etag, modified := s.catalogValidators()
+	if notModified(c, s.config.CacheLists, etag, modified) {
+		return
+	}
+
+	summary, err := s.manifestSummary()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	setCacheHeaders(c, s.config.CacheLists)
+	c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTitleManifest(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	data, err := os.ReadFile(filepath.Join(s.config.PicturesFolder, "4d5307e6", "20400.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := doRequest(s, "GET", "/api/v1/titles/4D5307E6/manifest.json", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("manifest: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	var manifest TitleManifest
+	if err := json.Unmarshal(w.Body.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.TitleID != "4D5307E6" || len(manifest.Assets) == 0 || manifest.Assets[0].Path != "20400.png" {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+	asset := manifest.Assets[0]
+	if asset.Size != int64(len(data)) || asset.SHA256 != fmt.Sprintf("%x", sha256.Sum256(data)) {
+		t.Errorf("asset = %+v, want size %d", asset, len(data))
+	}
+	if asset.URL != "http://example.com/api/v1/titles/4d5307e6/20400.png" {
+		t.Errorf("asset URL = %s", asset.URL)
+	}
+
+	etag := w.Header().Get("ETag")
+	w = doRequest(s, "GET", "/api/v1/titles/4d5307e6/manifest.json", map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("unchanged manifest: status = %d, want 304", w.Code)
+	}
+
+	if w := doRequest(s, "GET", "/api/v1/titles/00000000/manifest.json", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown title: status = %d, want 404", w.Code)
+	}
+}
+
+func TestCatalogManifest(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	summary := func() ManifestSummary {
+		t.Helper()
+		w := doRequest(s, "GET", "/api/v1/manifest/summary", nil)
+		var summary ManifestSummary
+		if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("summary: status = %d; body: %s", w.Code, w.Body.String())
+		}
+		return summary
+	}
+	before := summary()
+	if before.Titles != 2 || before.Assets != 3 || len(before.Buckets) != 2 ||
+		before.Buckets[0].Prefix != "4d" || before.Buckets[1].Prefix != "58" {
+		t.Fatalf("summary = %+v", before)
+	}
+
+	w := doRequest(s, "GET", "/api/v1/manifest?limit=1&page=2", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title_id":"584109EB"`) ||
+		!strings.Contains(w.Body.String(), `"total":2`) || strings.Contains(w.Body.String(), "4D5307E6") {
+		t.Errorf("second page: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	w = doRequest(s, "GET", "/api/v1/manifest?prefix=4D", nil)
+	var page struct {
+		Items []TitleManifest `json:"items"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Items) != 1 || page.Items[0].TitleID != "4D5307E6" || len(page.Items[0].Assets) != 2 {
+		t.Errorf("prefix 4d: body: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/manifest?prefix=xyz", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid prefix: status = %d, want 400", w.Code)
+	}
+
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	body, contentType := multipartPicture(t, "8000.png", pngData.Bytes())
+	w = doRequestBody(s, "POST", "/api/v1/admin/titles/4d530802/pictures",
+		map[string]string{"Authorization": "Bearer test-token", "Content-Type": contentType}, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	after := summary()
+	if after.Hash == before.Hash || after.Buckets[0].Hash == before.Buckets[0].Hash || after.Buckets[0].Titles != 2 {
+		t.Errorf("bucket 4d did not change: %+v", after)
+	}
+	if after.Buckets[1] != before.Buckets[1] {
+		t.Errorf("bucket 58 changed: %+v, was %+v", after.Buckets[1], before.Buckets[1])
+	}
+}
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	mediaProviderSpotify   = "spotify"
+	mediaProviderYouTube   = "youtube"
+	mediaProviderKhinsider = "khinsider"
+
+	soundtracksJobKind = "soundtracks"
+)
+
+// mediaCategories are the categories media links can have.
+var mediaCategories = []string{store.MediaSoundtrack}
+
+// mediaProviders are the sites media links can point to, by display name,
+// with the hosts of their links.
+var mediaProviders = map[string]struct {
+	Name  string
+	Hosts []string
+}{
+	mediaProviderSpotify:   {"Spotify", []string{"open.spotify.com"}},
+	mediaProviderYouTube:   {"YouTube", []string{"www.youtube.com", "youtube.com", "music.youtube.com", "youtu.be"}},
+	mediaProviderKhinsider: {"KHInsider", []string{"downloads.khinsider.com"}},
+}
+
+var errInvalidMediaLink = errors.New("invalid media link")
+
+// mediaProviderName is the display name of a media provider.
+func mediaProviderName(provider string) string {
+	if p, ok := mediaProviders[provider]; ok {
+		return p.Name
+	}
+	return provider
+}
+
+// mediaProvider returns the provider of an https link, or errInvalidMediaLink.
+func mediaProvider(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "https" || u.User != nil {
+		return "", errInvalidMediaLink
+	}
+	for provider, p := range mediaProviders {
+		if slices.Contains(p.Hosts, strings.ToLower(u.Host)) {
+			return provider, nil
+		}
+	}
+	return "", errInvalidMediaLink
+}
+
+type MediaLinkInput struct {
+	Category string `json:"category"`
+	URL      string `json:"url"`
+	Label    string `json:"label"`
+}
+
+func (s *Server) createMediaLink(c *gin.Context) {
+	s.titleEditMu.Lock()
+	defer s.titleEditMu.Unlock()
+
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var input MediaLinkInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	input.Category = strings.ToLower(strings.TrimSpace(input.Category))
+	if input.Category == "" {
+		input.Category = store.MediaSoundtrack
+	}
+	if !slices.Contains(mediaCategories, input.Category) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown media category, expected one of " + strings.Join(mediaCategories, ", ")})
+		return
+	}
+	input.URL = strings.TrimSpace(input.URL)
+	provider, err := mediaProvider(input.URL)
+	if err != nil || len(input.URL) > 512 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url, expected an https link to Spotify, YouTube or KHInsider"})
+		return
+	}
+	if len(input.Label) > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Label is too long"})
+		return
+	}
+
+	link := MediaLink{
+		TitleID:  title.TitleID,
+		Category: input.Category,
+		Provider: provider,
+		URL:      input.URL,
+		Label:    strings.TrimSpace(input.Label),
+		Origin:   externalIDOriginAdmin,
+	}
+	var count int64
+	if err := s.db.Model(&MediaLink{}).Where("title_id = ? AND url = ?", title.TitleID, link.URL).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Media link already exists"})
+		return
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&link).Error; err != nil {
+			return err
+		}
+		// Title responses embed their media
+		return tx.Model(&Title{TitleID: title.TitleID}).UpdateColumn("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	s.catalogChanged()
+	c.JSON(http.StatusCreated, link)
+}
+
+func (s *Server) deleteMediaLink(c *gin.Context) {
+	s.titleEditMu.Lock()
+	defer s.titleEditMu.Unlock()
+
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	id, ok := mediaLinkID(c.Param("media"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media link id"})
+		return
+	}
+	result := s.db.Where("title_id = ? AND id = ?", title.TitleID, id).Delete(&MediaLink{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media link not found"})
+		return
+	}
+	s.db.Model(&Title{TitleID: title.TitleID}).UpdateColumn("updated_at", time.Now())
+	s.catalogChanged()
+
+	c.Status(http.StatusNoContent)
+}
+
+// replaceMediaLinks replaces the links an enricher added to a title with the
+// ones it found now. Links maintainers added are left alone and never
+// duplicated.
+func (s *Server) replaceMediaLinks(titleID, origin string, links []MediaLink) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("title_id = ? AND origin = ?", titleID, origin).Delete(&MediaLink{}).Error; err != nil {
+			return err
+		}
+		for _, link := range links {
+			var count int64
+			if err := tx.Model(&MediaLink{}).Where("title_id = ? AND url = ?", titleID, link.URL).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			link.Origin = origin
+			if err := tx.Create(&link).Error; err != nil {
+				return err
+			}
+		}
+		// Title responses embed their media
+		return tx.Model(&Title{TitleID: titleID}).UpdateColumn("updated_at", time.Now()).Error
+	})
+}
+
+// khinsiderAlbumPattern matches the album links of a KHInsider search page.
+var khinsiderAlbumPattern = regexp.MustCompile(`<a href="(/game-soundtracks/album/[a-z0-9-]+)">([^<]+)</a>`)
+
+// soundtrackWords describe an album rather than being part of the game name.
+var soundtrackWords = map[string]bool{
+	"original": true, "soundtrack": true, "ost": true, "music": true, "score": true, "game": true,
+}
+
+// searchKhinsider finds the album of a title on KHInsider, which is only found
+// when exactly one album has the name of the title once words like "Original
+// Soundtrack" are left out.
+func (s *Server) searchKhinsider(ctx context.Context, t Title) (MediaLink, bool, error) {
+	query := url.Values{"search": {t.Name}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.KhinsiderURL+"search?"+query.Encode(), nil)
+	if err != nil {
+		return MediaLink{}, false, fmt.Errorf("request failed: %w", err)
+	}
+
+	client := &http.Client{Timeout: s.config.UpstreamTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return MediaLink{}, false, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return MediaLink{}, false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, 2<<20))
+	if err != nil {
+		return MediaLink{}, false, fmt.Errorf("read failed: %w", err)
+	}
+
+	name := nameWords(t.Name)
+	var matches []MediaLink
+	for _, m := range khinsiderAlbumPattern.FindAllStringSubmatch(string(page), -1) {
+		label := html.UnescapeString(m[2])
+		album := slices.DeleteFunc(nameWords(label), func(w string) bool { return soundtrackWords[w] })
+		if !slices.Equal(album, name) {
+			continue
+		}
+		link := strings.TrimSuffix(s.config.KhinsiderURL, "/") + m[1]
+		if !slices.ContainsFunc(matches, func(l MediaLink) bool { return l.URL == link }) {
+			matches = append(matches, MediaLink{
+				TitleID:  t.TitleID,
+				Category: store.MediaSoundtrack,
+				Provider: mediaProviderKhinsider,
+				URL:      link,
+				Label:    label,
+			})
+		}
+	}
+	if len(matches) != 1 {
+		return MediaLink{}, false, nil
+	}
+	return matches[0], true, nil
+}
+
+// syncSoundtracks links titles to their soundtrack on KHInsider.
+func (s *Server) syncSoundtracks(ctx context.Context, job *Job) error {
+	var titles []Title
+	if err := s.db.WithContext(ctx).Order("title_id ASC").Find(&titles).Error; err != nil {
+		return err
+	}
+
+	found := 0
+	// Title responses embed their media
+	wrote := false
+	defer func() {
+		if wrote {
+			s.catalogChanged()
+		}
+	}()
+
+	for i, t := range titles {
+		job.SetProgress(i, len(titles))
+
+		// Stay well within what a scraper should ask of the site
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.config.KhinsiderDelay):
+			}
+		}
+
+		link, ok, err := s.searchKhinsider(ctx, t)
+		if err != nil {
+			return fmt.Errorf("searching %s failed: %w", t.TitleID, err)
+		}
+		var links []MediaLink
+		if ok {
+			links = append(links, link)
+			found++
+		}
+		if err := s.replaceMediaLinks(t.TitleID, mediaProviderKhinsider, links); err != nil {
+			return err
+		}
+		wrote = true
+	}
+
+	job.SetProgress(len(titles), len(titles))
+	job.SetResult(fmt.Sprintf("%d soundtracks found for %d titles", found, len(titles)))
+	return nil
+}
+
+func (s *Server) createSoundtracksJob(c *gin.Context) {
+	if !s.config.KhinsiderEnricher {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "The soundtrack enricher is disabled"})
+		return
+	}
+
+	job := s.jobs.Start(soundtracksJobKind, s.syncSoundtracks)
+	c.Header("Location", "/api/v1/admin/soundtracks/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+func (s *Server) getSoundtracksJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != soundtracksJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enricherJobResponse(job))
+}
+
+// soundtrackLinks filters the soundtrack links of a title, for templates.
+func soundtrackLinks(media []MediaLink) []MediaLink {
+	var links []MediaLink
+	for _, m := range media {
+		if m.Category == store.MediaSoundtrack {
+			links = append(links, m)
+		}
+	}
+	return links
+}
+
+// mediaLinkID parses the id of a media link.
+func mediaLinkID(value string) (uint, bool) {
+	id, err := strconv.ParseUint(value, 10, 32)
+	return uint(id), err == nil && id > 0
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSoundtrackLinks(t *testing.T) {
+	khinsider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("search") {
+		case "Halo 3":
+			io.WriteString(w, `<table><tr><td><a href="/game-soundtracks/album/halo-3-original-soundtrack">Halo 3 Original Soundtrack</a></td></tr>
+<tr><td><a href="/game-soundtracks/album/halo-3-odst">Halo 3: ODST</a></td></tr></table>`)
+		case "Minecraft":
+			io.WriteString(w, `<a href="/game-soundtracks/album/minecraft">Minecraft</a><a href="/game-soundtracks/album/minecraft-ost">Minecraft OST</a>`)
+		}
+	}))
+	t.Cleanup(khinsider.Close)
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.KhinsiderEnricher = true
+		cfg.KhinsiderURL = khinsider.URL + "/"
+		cfg.KhinsiderDelay = 0
+	})
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+
+	for _, body := range []string{`{"url":"http://open.spotify.com/album/1"}`, `{"url":"https://example.com/halo3.mp3"}`, `{"category":"manual","url":"https://youtu.be/x"}`} {
+		if w := doRequestBody(s, "POST", "/api/v1/admin/titles/4d5307e6/media", admin, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	etag := doRequest(s, "GET", "/api/v1/titles", nil).Header().Get("ETag")
+	w := doRequestBody(s, "POST", "/api/v1/admin/titles/4d5307e6/media", admin, `{"url":"https://open.spotify.com/album/1","label":"Halo 3 (Original Soundtrack)"}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"provider":"spotify"`) {
+		t.Fatalf("adding a link: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("listing not revalidated after adding a link: status = %d", w.Code)
+	}
+	var spotify MediaLink
+	json.Unmarshal(w.Body.Bytes(), &spotify)
+	if w := doRequestBody(s, "POST", "/api/v1/admin/titles/4d5307e6/media", admin, `{"url":"https://open.spotify.com/album/1"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate link: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// Enricher runs replace their own links and keep the maintainers' ones
+	for range 2 {
+		etag = doRequest(s, "GET", "/api/v1/titles", nil).Header().Get("ETag")
+		w = doRequest(s, "POST", "/api/v1/admin/soundtracks", admin)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("starting the enricher: status = %d; body: %s", w.Code, w.Body.String())
+		}
+		s.jobs.Wait()
+		if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+			t.Errorf("listing not revalidated after the enricher: status = %d", w.Code)
+		}
+	}
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), "1 soundtracks found for 4 titles") {
+		t.Fatalf("enricher did not finish as expected: %s", w.Body.String())
+	}
+	title, _ := s.findTitle("4D5307E6")
+	if len(title.Media) != 2 || title.Media[0].Origin != "admin" || title.Media[1].URL != khinsider.URL+"/game-soundtracks/album/halo-3-original-soundtrack" {
+		t.Errorf("unexpected media: %+v", title.Media)
+	}
+
+	w = doRequest(s, "GET", "/titles/4d5307e6", nil)
+	if !strings.Contains(w.Body.String(), `Halo 3 (Original Soundtrack) on Spotify</a>, <a href="`+khinsider.URL) {
+		t.Errorf("title page does not list the soundtracks:\n%s", w.Body.String())
+	}
+
+	if w := doRequest(s, "DELETE", "/api/v1/admin/titles/4d5307e6/media/"+strconv.Itoa(int(spotify.ID)), admin); w.Code != http.StatusNoContent {
+		t.Errorf("deleting a link: status = %d", w.Code)
+	}
+	if w := doRequest(s, "DELETE", "/api/v1/admin/titles/4d5307e6/media/"+strconv.Itoa(int(spotify.ID)), admin); w.Code != http.StatusNotFound {
+		t.Errorf("deleting a missing link: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
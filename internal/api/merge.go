@@ -0,0 +1,194 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Rules of the merge command for titles both catalogs have, but differently.
+const (
+	// mergePreferManual keeps the curated side, or the newer one when both
+	// or neither are
+	mergePreferManual = "manual"
+	// mergePreferNewer keeps the side updated last
+	mergePreferNewer = "newer"
+)
+
+// errMergeDryRun rolls back the transaction of a dry run.
+var errMergeDryRun = errors.New("dry run")
+
+// CatalogMerge counts what merging another catalog into this one did.
+type CatalogMerge struct {
+	Added int
+	// Updated titles took the other side of a conflict, Kept ones this side
+	Updated   int
+	Kept      int
+	Unchanged int
+	Skipped   int
+	Pictures  int
+}
+
+func (m CatalogMerge) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d kept, %d unchanged, %d skipped, %d pictures",
+		m.Added, m.Updated, m.Kept, m.Unchanged, m.Skipped, m.Pictures)
+}
+
+// mergeConflict is the outcome of a title both catalogs have differently.
+type mergeConflict struct {
+	TitleID string
+	// Theirs tells whether the title of the other catalog won
+	Theirs bool
+	Reason string
+}
+
+func (c mergeConflict) String() string {
+	side := "kept ours"
+	if c.Theirs {
+		side = "took theirs"
+	}
+	return fmt.Sprintf("%s: %s (%s)", c.TitleID, side, c.Reason)
+}
+
+// resolveMergeConflict picks between our title and theirs by the rule of
+// prefer. Ties keep ours.
+func resolveMergeConflict(ours, theirs Title, prefer string) mergeConflict {
+	conflict := mergeConflict{TitleID: ours.TitleID}
+	if prefer == mergePreferManual && ours.Curated != theirs.Curated {
+		conflict.Theirs = theirs.Curated
+		conflict.Reason = "curated"
+		return conflict
+	}
+	switch {
+	case theirs.UpdatedAt.After(ours.UpdatedAt):
+		conflict.Theirs = true
+		conflict.Reason = "newer"
+	case ours.UpdatedAt.After(theirs.UpdatedAt):
+		conflict.Reason = "newer"
+	default:
+		conflict.Reason = "same age"
+	}
+	return conflict
+}
+
+// mergedSightings combines when two catalogs first and last saw a title.
+func mergedSightings(ours, theirs Title) (first, last *time.Time) {
+	first, last = ours.FirstSeenAt, ours.LastSeenAt
+	if theirs.FirstSeenAt != nil && (first == nil || theirs.FirstSeenAt.Before(*first)) {
+		first = theirs.FirstSeenAt
+	}
+	if theirs.LastSeenAt != nil && (last == nil || theirs.LastSeenAt.After(*last)) {
+		last = theirs.LastSeenAt
+	}
+	return first, last
+}
+
+// mergeCatalog merges the titles of another catalog into this one in a
+// single transaction, rolled back with dryRun. Titles only they have are
+// added, outside of dry runs with the pictures found on disk for them,
+// titles both have differently go to the side prefer picks, and sightings
+// are combined. Conflicts are reported as they are resolved.
+func (s *Server) mergeCatalog(titles []Title, prefer string, dryRun bool, report func(mergeConflict)) (CatalogMerge, error) {
+	var merge CatalogMerge
+	titles, rejects := s.validateTitles(titles)
+	for _, r := range rejects {
+		log.Printf("Skipping %s: %s\n", r.TitleID, r.Reason)
+	}
+	merge.Skipped = len(rejects)
+
+	var existing []Title
+	if err := s.db.WithContext(s.ctx).Find(&existing).Error; err != nil {
+		return merge, fmt.Errorf("loading existing titles failed: %w", err)
+	}
+	byID := make(map[string]Title, len(existing))
+	for _, t := range existing {
+		byID[strings.ToLower(t.TitleID)] = t
+	}
+
+	err := s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		var added []Title
+		for _, theirs := range titles {
+			ours, ok := byID[strings.ToLower(theirs.TitleID)]
+			if !ok {
+				// Pictures are files of their own, indexed from disk below
+				theirs.Pictures = nil
+				added = append(added, theirs)
+				continue
+			}
+
+			columns := map[string]any{}
+			first, last := mergedSightings(ours, theirs)
+			if first != ours.FirstSeenAt {
+				columns["first_seen_at"] = first
+			}
+			if last != ours.LastSeenAt {
+				columns["last_seen_at"] = last
+			}
+			if titleChanged(ours, theirs) {
+				conflict := resolveMergeConflict(ours, theirs, prefer)
+				if report != nil {
+					report(conflict)
+				}
+				if conflict.Theirs {
+					columns["name"] = theirs.Name
+					columns["systems"] = theirs.Systems
+					columns["bing_id"] = theirs.BingID
+					columns["service_config_id"] = theirs.ServiceConfigID
+					columns["pfn"] = theirs.PFN
+					columns["curated"] = theirs.Curated
+					// Merging again finds them the same age, unless the
+					// export didn't tell
+					columns["updated_at"] = theirs.UpdatedAt
+					if theirs.UpdatedAt.IsZero() {
+						columns["updated_at"] = time.Now()
+					}
+					merge.Updated++
+				} else {
+					merge.Kept++
+				}
+			} else {
+				merge.Unchanged++
+			}
+
+			if len(columns) > 0 {
+				if err := tx.Model(&Title{TitleID: ours.TitleID}).UpdateColumns(columns).Error; err != nil {
+					return fmt.Errorf("updating title %s failed: %w", ours.TitleID, err)
+				}
+			}
+		}
+
+		if len(added) > 0 {
+			log.Printf("Inserting %d new titles into database...\n", len(added))
+			if err := tx.Omit(clause.Associations).CreateInBatches(added, 100).Error; err != nil {
+				return fmt.Errorf("inserting titles failed: %w", err)
+			}
+			merge.Added = len(added)
+		}
+		if dryRun {
+			// Indexing pictures means reading every file of the new titles,
+			// too much for a preview
+			return errMergeDryRun
+		}
+		if len(added) > 0 {
+			n, err := s.insertPicturesFor(tx, added)
+			if err != nil {
+				return err
+			}
+			merge.Pictures = n
+		}
+		return nil
+	})
+	if errors.Is(err, errMergeDryRun) {
+		return merge, nil
+	}
+	if err != nil {
+		return merge, err
+	}
+	s.catalogChanged()
+	return merge, nil
+}
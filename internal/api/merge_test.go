@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeCatalog(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	etag := doRequest(s, "GET", "/api/v1/titles", nil).Header().Get("ETag")
+	theirs := []Title{{TitleID: "5841125A", Name: "Terraria", Systems: []string{"XBOX360"}}}
+
+	merge, err := s.mergeCatalog(theirs, mergePreferManual, true, nil)
+	if err != nil || merge.Added != 1 || merge.Pictures != 0 {
+		t.Fatalf("dry run: %v, err = %v", merge, err)
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("after the dry run: status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	if _, err := s.mergeCatalog(theirs, mergePreferManual, false, nil); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("after the merge: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := doRequest(s, "GET", "/api/v1/search?q=terraria", nil); !strings.Contains(w.Body.String(), `"total":1`) {
+		t.Errorf("merged title not searchable: %s", w.Body.String())
+	}
+}
+
+func TestMergeCommand(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	s.db.Model(&Title{TitleID: "4D5307E6"}).UpdateColumns(map[string]any{"name": "Halo 3 (ours)", "curated": true})
+	s.Close()
+
+	future := time.Now().Add(time.Hour)
+	past := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	export := []Title{
+		// Newer, but ours is curated
+		{TitleID: "4D5307E6", Name: "Halo 3 (theirs)", Systems: []string{"XBOX360"}, UpdatedAt: future},
+		// Older, but theirs is curated
+		{TitleID: "4D530802", Name: "Halo 3: ODST (theirs)", Systems: []string{"XBOX360"}, Curated: true, UpdatedAt: past},
+		// The same, seen before we did
+		{TitleID: "415607F7", Name: "Call of Duty 4", Systems: []string{"XBOX360"}, FirstSeenAt: &past},
+		{TitleID: "5841125A", Name: "Terraria", Systems: []string{"XBOX360"}},
+	}
+	data, _ := json.Marshal(export)
+	file := filepath.Join(t.TempDir(), "titles.full.json")
+	os.WriteFile(file, data, 0644)
+
+	names := func() map[string]string {
+		t.Helper()
+		db, err := openDatabase(s.config.DBDriver, s.dbDSN())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeDB(db)
+		var titles []Title
+		db.Find(&titles)
+		names := map[string]string{}
+		for _, title := range titles {
+			names[title.TitleID] = title.Name
+			if title.TitleID == "415607F7" && (title.FirstSeenAt == nil || !title.FirstSeenAt.Equal(past)) {
+				t.Errorf("first sighting = %v", title.FirstSeenAt)
+			}
+		}
+		return names
+	}
+
+	if err := runCommand(s.config, []string{"merge", "-prefer", "oldest", file}); err == nil || !strings.Contains(err.Error(), "invalid -prefer") {
+		t.Errorf("invalid -prefer: err = %v", err)
+	}
+	if err := runCommand(s.config, []string{"merge", s.dbDSN()}); err == nil || !strings.Contains(err.Error(), "into itself") {
+		t.Errorf("merging into itself: err = %v", err)
+	}
+
+	if err := runCommand(s.config, []string{"merge", "-dry-run", file}); err != nil {
+		t.Fatalf("merge -dry-run: %v", err)
+	}
+	db, _ := openDatabase(s.config.DBDriver, s.dbDSN())
+	var count int64
+	db.Model(&Title{}).Count(&count)
+	closeDB(db)
+	if count != 4 {
+		t.Errorf("the dry run wrote %d titles", count)
+	}
+
+	if err := runCommand(s.config, []string{"merge", file}); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	got := names()
+	if got["4D5307E6"] != "Halo 3 (ours)" || got["4D530802"] != "Halo 3: ODST (theirs)" || got["5841125A"] != "Terraria" {
+		t.Errorf("merge -prefer manual: %v", got)
+	}
+
+	if err := runCommand(s.config, []string{"merge", "-prefer", "newer", file}); err != nil {
+		t.Fatalf("merge -prefer newer: %v", err)
+	}
+	if got := names(); got["4D5307E6"] != "Halo 3 (theirs)" {
+		t.Errorf("merge -prefer newer: %v", got)
+	}
+
+	// Another instance's database
+	other := filepath.Join(t.TempDir(), "other.db")
+	db, err := openDatabase(dbDriverSQLite, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(dbModels...)
+	db.Create(&Title{TitleID: "4D530A5D", Name: "Halo: Spartan Assault", Systems: []string{"PC"}})
+	closeDB(db)
+	if err := runCommand(s.config, []string{"merge", "-from", "sqlite", other}); err != nil {
+		t.Fatalf("merge from a database: %v", err)
+	}
+	if got := names(); got["4D530A5D"] != "Halo: Spartan Assault" || len(got) != 6 {
+		t.Errorf("merge from a database: %v", got)
+	}
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Bucket upper bounds, in seconds.
+var (
+	requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	queryDurationBuckets   = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+	imageWaitBuckets       = []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+	syncDurationBuckets    = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800}
+)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// metricFamily is a named set of counters, gauges or histograms, one per
+// label set.
+type metricFamily struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // nil for counters and gauges
+	gauge   bool
+
+	counters   map[string]float64
+	histograms map[string]*histogram
+}
+
+func newCounter(name, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, labels: labels, counters: make(map[string]float64)}
+}
+
+func newGauge(name, help string, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, labels: labels, gauge: true, counters: make(map[string]float64)}
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, labels: labels, buckets: buckets, histograms: make(map[string]*histogram)}
+}
+
+// labelKey renders label values as the {...} part of a sample line.
+func (f *metricFamily) labelKey(values []string) string {
+	if len(f.labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(f.labels))
+	for i, label := range f.labels {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		pairs[i] = fmt.Sprintf(`%s="%s"`, label, value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f *metricFamily) add(v float64, values ...string) {
+	f.counters[f.labelKey(values)] += v
+}
+
+func (f *metricFamily) set(v float64, values ...string) {
+	f.counters[f.labelKey(values)] = v
+}
+
+func (f *metricFamily) observe(v float64, values ...string) {
+	key := f.labelKey(values)
+	h, ok := f.histograms[key]
+	if !ok {
+		h = &histogram{buckets: f.buckets, counts: make([]uint64, len(f.buckets))}
+		f.histograms[key] = h
+	}
+	h.observe(v)
+}
+
+func formatSample(name, labels, extra string, value float64) string {
+	switch {
+	case labels != "" && extra != "":
+		labels = "{" + labels + "," + extra + "}"
+	case labels != "" || extra != "":
+		labels = "{" + labels + extra + "}"
+	}
+	return name + labels + " " + strconv.FormatFloat(value, 'g', -1, 64) + "\n"
+}
+
+// write renders the family in the Prometheus text format.
+func (f *metricFamily) write(w io.Writer) {
+	if f.buckets == nil {
+		kind := "counter"
+		if f.gauge {
+			kind = "gauge"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, kind)
+		for _, key := range slices.Sorted(maps.Keys(f.counters)) {
+			io.WriteString(w, formatSample(f.name, key, "", f.counters[key]))
+		}
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", f.name, f.help, f.name)
+	for _, key := range slices.Sorted(maps.Keys(f.histograms)) {
+		h := f.histograms[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += h.counts[i]
+			le := `le="` + strconv.FormatFloat(bound, 'g', -1, 64) + `"`
+			io.WriteString(w, formatSample(f.name+"_bucket", key, le, float64(cumulative)))
+		}
+		io.WriteString(w, formatSample(f.name+"_bucket", key, `le="+Inf"`, float64(h.count)))
+		io.WriteString(w, formatSample(f.name+"_sum", key, "", h.sum))
+		io.WriteString(w, formatSample(f.name+"_count", key, "", float64(h.count)))
+	}
+}
+
+// serverMetrics holds the metrics of one server, exposed on /metrics.
+type serverMetrics struct {
+	mu sync.Mutex
+
+	requests            *metricFamily
+	requestDuration     *metricFamily
+	queryDuration       *metricFamily
+	syncRuns            *metricFamily
+	syncDuration        *metricFamily
+	picturesServed      *metricFamily
+	pictureBytes        *metricFamily
+	picturesNotModified *metricFamily
+	imageRunning        *metricFamily
+	imageWaiting        *metricFamily
+	imageQueueWait      *metricFamily
+	imageRejected       *metricFamily
+	diskUsage           *metricFamily
+	diskLimit           *metricFamily
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:            newCounter("xtitles_http_requests_total", "HTTP requests by route and status.", "method", "route", "status"),
+		requestDuration:     newHistogram("xtitles_http_request_duration_seconds", "HTTP request latency by route.", requestDurationBuckets, "method", "route"),
+		queryDuration:       newHistogram("xtitles_db_query_duration_seconds", "Database statement latency by operation.", queryDurationBuckets, "operation"),
+		syncRuns:            newCounter("xtitles_sync_runs_total", "Upstream syncs by outcome.", "outcome"),
+		syncDuration:        newHistogram("xtitles_sync_duration_seconds", "Upstream sync duration by outcome.", syncDurationBuckets, "outcome"),
+		picturesServed:      newCounter("xtitles_pictures_served_total", "Pictures served by variant and format.", "variant", "format"),
+		pictureBytes:        newCounter("xtitles_picture_bytes_total", "Bytes of pictures served."),
+		picturesNotModified: newCounter("xtitles_pictures_not_modified_total", "Picture requests answered with 304 Not Modified."),
+		imageRunning:        newGauge("xtitles_image_operations_running", "Image operations running."),
+		imageWaiting:        newGauge("xtitles_image_operations_waiting", "Image operations waiting for a worker."),
+		imageQueueWait:      newHistogram("xtitles_image_queue_wait_seconds", "Time image operations waited for a worker.", imageWaitBuckets, "operation"),
+		imageRejected:       newCounter("xtitles_image_operations_rejected_total", "Image operations refused because the queue was full.", "operation"),
+		diskUsage:           newGauge("xtitles_disk_usage_bytes", "Bytes used by the directories the janitor manages, as of its last run.", "dir"),
+		diskLimit:           newGauge("xtitles_disk_limit_bytes", "Size the janitor keeps each directory under, 0 for none.", "dir"),
+	}
+}
+
+func (m *serverMetrics) families() []*metricFamily {
+	return []*metricFamily{
+		m.requests, m.requestDuration, m.queryDuration, m.syncRuns,
+		m.syncDuration, m.picturesServed, m.pictureBytes, m.picturesNotModified,
+		m.imageRunning, m.imageWaiting, m.imageQueueWait, m.imageRejected,
+		m.diskUsage, m.diskLimit,
+	}
+}
+
+func (m *serverMetrics) record(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn()
+}
+
+// observeSync records the outcome of a sync that ran for d.
+func (m *serverMetrics) observeSync(d time.Duration, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	m.record(func() {
+		m.syncRuns.add(1, outcome)
+		m.syncDuration.observe(d.Seconds(), outcome)
+	})
+}
+
+// observePicture records a picture response once it has been written.
+func (m *serverMetrics) observePicture(c *gin.Context, variant, format string) {
+	m.record(func() {
+		switch status := c.Writer.Status(); {
+		case status == http.StatusNotModified:
+			m.picturesNotModified.add(1)
+		case status < 300:
+			m.picturesServed.add(1, variant, format)
+			m.pictureBytes.add(float64(max(c.Writer.Size(), 0)))
+		}
+	})
+}
+
+// metricsMiddleware counts requests and their latency per route template, so
+// that ids in paths don't create a series each.
+func (s *Server) metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	s.metrics.record(func() {
+		s.metrics.requests.add(1, c.Request.Method, route, status)
+		s.metrics.requestDuration.observe(time.Since(start).Seconds(), c.Request.Method, route)
+	})
+}
+
+const metricsStartKey = "metrics:start"
+
+// registerQueryMetrics times every statement run through db.
+func (s *Server) registerQueryMetrics(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(metricsStartKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if start, ok := tx.InstanceGet(metricsStartKey); ok {
+				d := time.Since(start.(time.Time)).Seconds()
+				s.metrics.record(func() { s.metrics.queryDuration.observe(d, operation) })
+			}
+		}
+	}
+
+	callbacks := db.Callback()
+	for _, p := range []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	} {
+		if err := p.before("metrics:before_"+p.operation, before); err != nil {
+			return err
+		}
+		if err := p.after("metrics:after_"+p.operation, after(p.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) getMetrics(c *gin.Context) {
+	if s.config.MetricsToken != "" {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.MetricsToken)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	s.metrics.record(func() {
+		s.metrics.imageRunning.set(float64(s.images.running.Load()))
+		s.metrics.imageWaiting.set(float64(s.images.waiting.Load()))
+		s.diskUsageMu.RLock()
+		for _, usage := range s.lastDiskUsage {
+			s.metrics.diskUsage.set(float64(usage.Bytes), usage.Name)
+			s.metrics.diskLimit.set(float64(usage.MaxBytes), usage.Name)
+		}
+		s.diskUsageMu.RUnlock()
+		for _, f := range s.metrics.families() {
+			f.write(c.Writer)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	doRequest(s, "GET", "/api/v1/titles/4d5307e6", nil)
+	doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400.png", nil)
+
+	w := doRequest(s, "GET", "/metrics", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	for _, want := range []string{
+		`xtitles_http_requests_total{method="GET",route="/api/v1/titles/:id",status="200"} 1`,
+		`xtitles_http_request_duration_seconds_bucket{method="GET",route="/api/v1/titles/:id",le="+Inf"} 1`,
+		`xtitles_db_query_duration_seconds_count{operation="query"}`,
+		`xtitles_sync_runs_total{outcome="success"} 1`,
+		`xtitles_pictures_served_total{variant="original",format="png"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, w.Body.String())
+		}
+	}
+
+	s = newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.MetricsToken = "metrics-token" })
+	if w := doRequest(s, "GET", "/metrics", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := doRequest(s, "GET", "/metrics", map[string]string{"Authorization": "Bearer metrics-token"}); w.Code != http.StatusOK {
+		t.Errorf("with token: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const pictureConversionTimeout = 30 * time.Second
+
+// pictureFormat is a format pictures can be converted to on request, by an
+// external encoder since the standard library only encodes PNG, JPEG and GIF.
+type pictureFormat struct {
+	Name    string
+	Mime    string
+	Command []string // with {input} and {output} placeholders
+}
+
+var knownPictureFormats = map[string]string{
+	"avif": "image/avif",
+	"webp": "image/webp",
+}
+
+// initPictureFormats enables the configured formats whose encoder is installed,
+// in order of preference.
+func (s *Server) initPictureFormats() {
+	for _, name := range s.config.PictureFormats {
+		name = strings.ToLower(name)
+		mime, ok := knownPictureFormats[name]
+		if !ok {
+			log.Printf("Warning: unknown picture format %q\n", name)
+			continue
+		}
+		command := strings.Fields(s.config.PictureEncoders[name])
+		if len(command) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(command[0]); err != nil {
+			log.Printf("Picture format %s disabled: %s not found\n", name, command[0])
+			continue
+		}
+		s.pictureFormats = append(s.pictureFormats, pictureFormat{Name: name, Mime: mime, Command: command})
+	}
+}
+
+// acceptsMime reports whether an Accept header explicitly allows mime. Wildcards
+// don't count: */* doesn't mean a client can decode every image format.
+func acceptsMime(accept, mime string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), mime) {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && key == "q" {
+				if q, err := strconv.ParseFloat(value, 64); err == nil && q <= 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// negotiatePictureFormat picks the preferred format the client accepts, or
+// nil to serve pictures as they are.
+func (s *Server) negotiatePictureFormat(c *gin.Context) *pictureFormat {
+	if len(s.pictureFormats) == 0 {
+		return nil
+	}
+	c.Header("Vary", "Accept")
+
+	accept := c.GetHeader("Accept")
+	for i := range s.pictureFormats {
+		if acceptsMime(accept, s.pictureFormats[i].Mime) {
+			return &s.pictureFormats[i]
+		}
+	}
+	return nil
+}
+
+// convertedPicture returns the path of the picture at path converted to
+// format, converting it first if needed. Converted files are cached next to
+// the source. It falls back to path if the conversion fails or does not make
+// the file smaller, and only fails when the image pool is saturated.
+func (s *Server) convertedPicture(ctx context.Context, path string, format *pictureFormat) (string, error) {
+	converted := strings.TrimSuffix(path, filepath.Ext(path)) + "." + format.Name
+
+	source, err := os.Stat(path)
+	if err != nil {
+		return path, nil
+	}
+	if info, err := os.Stat(converted); err == nil {
+		touchFile(converted)
+		return smallerPicture(path, source, converted, info), nil
+	}
+
+	// Requests for the same picture wait for a single conversion
+	result, err, _ := s.conversions.Do(converted, func() (any, error) {
+		info, err := os.Stat(converted)
+		if err != nil {
+			err := s.processImage(ctx, "convert", func() error { return s.convertPicture(path, converted, format) })
+			if errors.Is(err, errImagePoolBusy) {
+				return "", err
+			}
+			if err != nil {
+				log.Printf("Warning: converting %s to %s failed: %v\n", path, format.Name, err)
+				return path, nil
+			}
+			if info, err = os.Stat(converted); err != nil {
+				return path, nil
+			}
+		}
+		return smallerPicture(path, source, converted, info), nil
+	})
+	return result.(string), err
+}
+
+func smallerPicture(path string, source os.FileInfo, converted string, info os.FileInfo) string {
+	if info.Size() > source.Size() {
+		return path
+	}
+	return converted
+}
+
+// convertPicture runs the encoder of format on input, writing a temporary file
+// renamed to output once complete.
+func (s *Server) convertPicture(input, output string, format *pictureFormat) error {
+	tmp, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*.tmp."+format.Name)
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := make([]string, len(format.Command)-1)
+	for i, arg := range format.Command[1:] {
+		arg = strings.ReplaceAll(arg, "{input}", input)
+		args[i] = strings.ReplaceAll(arg, "{output}", tmp.Name())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pictureConversionTimeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, format.Command[0], args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(tmp.Name(), output)
+}
+
+// removeDerivedPictures deletes the thumbnails and converted copies of a
+// picture, which would otherwise outlive it or go stale when it is replaced.
+func (s *Server) removeDerivedPictures(id, name string) {
+	paths, _ := filepath.Glob(filepath.Join(s.config.ThumbnailDir, id, name+"_w[0-9]*"))
+	for format := range knownPictureFormats {
+		paths = append(paths, filepath.Join(s.config.PicturesFolder, id, name+"."+format))
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: removing %s failed: %v\n", path, err)
+		}
+	}
+}
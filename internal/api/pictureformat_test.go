@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPictureFormatNegotiation(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.PictureFormats = []string{"webp"}
+		cfg.PictureEncoders = map[string]string{"webp": "cp {input} {output}"}
+	})
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		etag        string
+	}{
+		{"webp accepted", "image/avif,image/webp,*/*", "image/webp", `"4d5307e6-20400-webp"`},
+		{"cached conversion", "image/webp", "image/webp", `"4d5307e6-20400-webp"`},
+		{"webp refused", "image/webp;q=0, */*", "image/png", `"4d5307e6-20400"`},
+		{"wildcard only", "*/*", "image/png", `"4d5307e6-20400"`},
+	}
+
+	for _, tt := range tests {
+		w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400.png", map[string]string{"Accept": tt.accept})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", tt.name, w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.name, got, tt.contentType)
+		}
+		if got := w.Header().Get("ETag"); got != tt.etag {
+			t.Errorf("%s: ETag = %q, want %q", tt.name, got, tt.etag)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("%s: Vary = %q, want %q", tt.name, got, "Accept")
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(s.config.PicturesFolder, "4d5307e6", "20400.webp")); err != nil {
+		t.Errorf("converted picture was not cached next to the original: %v", err)
+	}
+}
+
+func TestPictureConversionOnce(t *testing.T) {
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	encoder := filepath.Join(dir, "encode.sh")
+	os.WriteFile(encoder, []byte("#!/bin/sh\necho run >> "+runs+"\nsleep 0.2\ncp \"$1\" \"$2\"\n"), 0755)
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.PictureFormats = []string{"webp"}
+		cfg.PictureEncoders = map[string]string{"webp": encoder + " {input} {output}"}
+	})
+
+	// Requests for the same picture share one conversion, however many queue
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400.png", map[string]string{"Accept": "image/webp"})
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/webp" {
+				t.Errorf("status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+	wg.Wait()
+
+	if data, _ := os.ReadFile(runs); strings.Count(string(data), "run") != 1 {
+		t.Errorf("encoder ran %d times, want 1", strings.Count(string(data), "run"))
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PictureFolderScan is how a title folder looked when a rescan last read all
+// of its pictures. Rescans skip reading the folders that still look the same.
+type PictureFolderScan struct {
+	Folder string `gorm:"primaryKey"`
+	Files  int
+	Size   int64
+	// ModTime is in nanoseconds since the epoch, which every database keeps
+	// whole
+	ModTime   int64
+	ScannedAt time.Time
+}
+
+func newPictureFolderScan(name string, folder pictureFolder, now time.Time) PictureFolderScan {
+	return PictureFolderScan{
+		Folder:    name,
+		Files:     len(folder.Names),
+		Size:      folder.Size,
+		ModTime:   folder.ModTime.UnixNano(),
+		ScannedAt: now,
+	}
+}
+
+// matches tells whether folder looks the same as when it was scanned.
+func (f PictureFolderScan) matches(folder pictureFolder) bool {
+	return f.Files == len(folder.Names) && f.Size == folder.Size && f.ModTime == folder.ModTime.UnixNano()
+}
+
+// pictureFolderScans returns the recorded scans by folder.
+func (s *Server) pictureFolderScans() (map[string]PictureFolderScan, error) {
+	var rows []PictureFolderScan
+	if err := s.db.WithContext(s.ctx).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	scans := make(map[string]PictureFolderScan, len(rows))
+	for _, row := range rows {
+		scans[row.Folder] = row
+	}
+	return scans, nil
+}
+
+// savePictureFolderScans records the scans of updated folders and forgets
+// those of the folders in gone.
+func (s *Server) savePictureFolderScans(updated []PictureFolderScan, gone []string) error {
+	return s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		for folders := range slices.Chunk(gone, 500) {
+			if err := tx.Delete(&PictureFolderScan{}, "folder IN ?", folders).Error; err != nil {
+				return err
+			}
+		}
+		if len(updated) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "folder"}},
+			DoUpdates: clause.AssignmentColumns([]string{"files", "size", "mod_time", "scanned_at"}),
+		}).CreateInBatches(updated, 100).Error
+	})
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPictureRescan(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	writePictureTree(t, s.config.PicturesFolder, map[string][]string{"415607f7": {"20400", "8000"}})
+	os.Remove(filepath.Join(s.config.PicturesFolder, "4d5307e6", "20401.png"))
+	// Replaced files get their metadata refreshed
+	f, _ := os.Create(filepath.Join(s.config.PicturesFolder, "584109eb", "20400.png"))
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 64, 32)))
+	f.Close()
+
+	s.syncMu.Lock()
+	if w := doRequest(s, "POST", "/api/v1/admin/pictures/rescan", admin); w.Code != http.StatusConflict {
+		t.Errorf("rescan while syncing: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	s.syncMu.Unlock()
+
+	w := doRequest(s, "POST", "/api/v1/admin/pictures/rescan", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting a rescan: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"result":"2 pictures added, 1 updated, 1 removed, 0 thumbnails generated"`) {
+		t.Errorf("rescan job: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	// Every file on disk was read
+	if !strings.Contains(w.Body.String(), `"done":4,"total":4`) {
+		t.Errorf("rescan job progress: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles?only_with_pictures=true", nil); !strings.Contains(w.Body.String(), `"total":3`) {
+		t.Errorf("rescanned pictures are not listed: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20401.png", nil); w.Code != http.StatusNotFound {
+		t.Errorf("removed picture: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	title, _ := s.findTitle("584109EB")
+	if p := title.Pictures[0]; p.Width != 64 || p.Height != 32 || p.Size == 0 || len(p.SHA256) != 64 {
+		t.Errorf("replaced picture metadata: %+v", p)
+	}
+}
+
+func TestIncrementalPictureRescan(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+	rescan := func(full bool) (PictureRescan, int) {
+		t.Helper()
+		read := 0
+		r, err := s.rescanPictures(full, func(done, total int) { read = total })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, read
+	}
+
+	if _, read := rescan(false); read != 3 {
+		t.Errorf("first rescan read %d files, want 3", read)
+	}
+	if _, read := rescan(false); read != 0 {
+		t.Errorf("rescan of unchanged folders read %d files, want 0", read)
+	}
+
+	// Replacing a file changes its folder
+	path := filepath.Join(s.config.PicturesFolder, "584109eb", "20400.png")
+	f, _ := os.Create(path)
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 64, 32)))
+	f.Close()
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if r, read := rescan(false); read != 1 || r.Updated != 1 {
+		t.Errorf("rescan of a replaced file = %s, read %d files", r, read)
+	}
+
+	// Content changed behind the back of the heuristics is only seen by full rescans
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	os.Chtimes(path, later, later)
+	if r, read := rescan(false); read != 0 || r.Updated != 0 {
+		t.Errorf("rescan of a look-alike folder = %s, read %d files", r, read)
+	}
+	w := doRequest(s, "POST", "/api/v1/admin/pictures/rescan?full=true", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting a full rescan: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), `"done":3,"total":3`) || !strings.Contains(w.Body.String(), "1 updated") {
+		t.Errorf("full rescan job: %s", w.Body.String())
+	}
+
+	// Removed folders are forgotten
+	os.RemoveAll(filepath.Join(s.config.PicturesFolder, "584109eb"))
+	if r, _ := rescan(false); r.Removed != 1 {
+		t.Errorf("rescan of a removed folder = %s", r)
+	}
+	var scans []PictureFolderScan
+	s.db.Find(&scans)
+	if len(scans) != 1 || scans[0].Folder != "4d5307e6" || scans[0].Files != 2 {
+		t.Errorf("folder scans = %+v", scans)
+	}
+}
+
+func TestPictureMetadata(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	data, err := os.ReadFile(filepath.Join(s.config.PicturesFolder, "4d5307e6", "20400.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	w := doRequest(s, "GET", "/api/v1/titles/4d5307e6", nil)
+	want := fmt.Sprintf(`"name":"20400","alt":"Halo 3 gamerpic 20400","width":1,"height":1,"size":%d,"sha256":"%x"`, len(data), sum)
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("indexed picture metadata: want %s in %s", want, w.Body.String())
+	}
+
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 16, 8)))
+	body, contentType := multipartPicture(t, "8000.png", pngData.Bytes())
+	w = doRequestBody(s, "POST", "/api/v1/admin/titles/4d530802/pictures",
+		map[string]string{"Authorization": "Bearer test-token", "Content-Type": contentType}, body)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"width":16,"height":8`) {
+		t.Errorf("uploaded picture: status = %d; body: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/s3"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	pictureStorageLocal = "local"
+	pictureStorageS3    = "s3"
+)
+
+// pictureStorage holds the picture files, one folder per title named after
+// its lowercase ID: PICTURES_FOLDER on disk, or a bucket of an S3 compatible
+// object store. Missing files are fs.ErrNotExist.
+type pictureStorage interface {
+	// List returns the pictures of each title folder.
+	List(ctx context.Context) (map[string]pictureFolder, error)
+	Open(ctx context.Context, p Picture) (io.ReadCloser, error)
+	Put(ctx context.Context, p Picture, data []byte) error
+	Delete(ctx context.Context, p Picture) error
+}
+
+// initPictureStorage sets up the PICTURE_STORAGE backend.
+func (s *Server) initPictureStorage() error {
+	switch s.config.PictureStorage {
+	case pictureStorageLocal, "":
+		s.pictures = diskPictures{folder: s.config.PicturesFolder, suffix: s.config.PicturesSuffix, workers: s.config.PictureScanWorkers}
+		return nil
+	case pictureStorageS3:
+		client, err := s3.New(s3.Config{
+			Endpoint:  s.config.S3Endpoint,
+			Region:    s.config.S3Region,
+			Bucket:    s.config.S3Bucket,
+			AccessKey: s.config.S3AccessKey,
+			SecretKey: s.config.S3SecretKey,
+			PathStyle: s.config.S3PathStyle,
+		}, &http.Client{Timeout: s.config.UpstreamTimeout})
+		if err != nil {
+			return err
+		}
+		s.pictures = bucketPictures{client: client, prefix: s.config.S3Prefix, suffix: s.config.PicturesSuffix}
+		return nil
+	}
+	return fmt.Errorf("unknown picture storage %q", s.config.PictureStorage)
+}
+
+// pictureFolder is what a title folder holds. Its size and modification time
+// change along with any of its pictures, which lets rescans skip the folders
+// that didn't.
+type pictureFolder struct {
+	Names []string
+	// Size is the total size of the pictures
+	Size int64
+	// ModTime is the latest modification of the folder or its pictures
+	ModTime time.Time
+}
+
+// diskPictures keeps pictures in a folder, where they can be served and
+// converted in place.
+type diskPictures struct {
+	folder string
+	suffix string
+	// workers is how many title folders are listed at once
+	workers int
+}
+
+func (d diskPictures) path(p Picture) string {
+	return filepath.Join(d.folder, strings.ToLower(p.TitleID), p.Name+d.suffix)
+}
+
+// List walks the title folders concurrently, which matters on trees of
+// hundreds of thousands of files, on network file systems above all.
+func (d diskPictures) List(ctx context.Context) (map[string]pictureFolder, error) {
+	entries, err := os.ReadDir(d.folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var listErr error
+	dirPngs := make(map[string]pictureFolder)
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for range max(d.workers, 1) {
+		wg.Go(func() {
+			for dir := range queue {
+				folder, err := d.listDir(dir)
+				mu.Lock()
+				if err != nil && listErr == nil {
+					listErr = err
+				}
+				if len(folder.Names) > 0 {
+					dirPngs[dir] = folder
+				}
+				mu.Unlock()
+			}
+		})
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		select {
+		case queue <- entry.Name():
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if listErr == nil {
+		listErr = ctx.Err()
+	}
+	return dirPngs, listErr
+}
+
+// listDir returns the pictures in the folder of a title, those in subfolders
+// by their path below it.
+func (d diskPictures) listDir(dir string) (pictureFolder, error) {
+	root := filepath.Join(d.folder, dir)
+	var folder pictureFolder
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		isPicture := !entry.IsDir() && strings.HasSuffix(strings.ToLower(entry.Name()), d.suffix)
+		if !isPicture && !entry.IsDir() {
+			return nil
+		}
+		// Folders count too, as deleting a file only touches its folder
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(folder.ModTime) {
+			folder.ModTime = info.ModTime()
+		}
+		if isPicture {
+			rel, _ := filepath.Rel(root, path)
+			folder.Names = append(folder.Names, strings.TrimSuffix(rel, d.suffix))
+			folder.Size += info.Size()
+		}
+		return nil
+	})
+	return folder, err
+}
+
+func (d diskPictures) Open(ctx context.Context, p Picture) (io.ReadCloser, error) {
+	return os.Open(d.path(p))
+}
+
+func (d diskPictures) Put(ctx context.Context, p Picture, data []byte) error {
+	return writeFileAtomic(d.path(p), data)
+}
+
+func (d diskPictures) Delete(ctx context.Context, p Picture) error {
+	return os.Remove(d.path(p))
+}
+
+// bucketPictures keeps pictures in a bucket, under an optional key prefix.
+type bucketPictures struct {
+	client *s3.Client
+	prefix string
+	suffix string
+}
+
+func (b bucketPictures) key(p Picture) string {
+	return b.prefix + strings.ToLower(p.TitleID) + "/" + p.Name + b.suffix
+}
+
+func (b bucketPictures) List(ctx context.Context) (map[string]pictureFolder, error) {
+	dirPngs := make(map[string]pictureFolder)
+	err := b.client.List(ctx, b.prefix, func(obj s3.Object) error {
+		dir, name, ok := strings.Cut(strings.TrimPrefix(obj.Key, b.prefix), "/")
+		if ok && !strings.Contains(name, "/") && strings.HasSuffix(strings.ToLower(name), b.suffix) {
+			folder := dirPngs[dir]
+			folder.Names = append(folder.Names, strings.TrimSuffix(name, b.suffix))
+			folder.Size += obj.Size
+			if obj.LastModified.After(folder.ModTime) {
+				folder.ModTime = obj.LastModified
+			}
+			dirPngs[dir] = folder
+		}
+		return nil
+	})
+	return dirPngs, err
+}
+
+func (b bucketPictures) Open(ctx context.Context, p Picture) (io.ReadCloser, error) {
+	return b.client.Get(ctx, b.key(p))
+}
+
+func (b bucketPictures) Put(ctx context.Context, p Picture, data []byte) error {
+	return b.client.Put(ctx, b.key(p), data, mime.TypeByExtension(b.suffix))
+}
+
+func (b bucketPictures) Delete(ctx context.Context, p Picture) error {
+	return b.client.Delete(ctx, b.key(p))
+}
+
+// readPicture returns the content of the file of p.
+func (s *Server) readPicture(ctx context.Context, p Picture) ([]byte, error) {
+	f, err := s.pictures.Open(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// serveBucketPicture answers with an original picture from the bucket:
+// a redirect to S3_PUBLIC_URL when set, else to a presigned URL valid for
+// S3_PRESIGN_EXPIRY, or the picture itself streamed through the server when
+// that is 0.
+func (s *Server) serveBucketPicture(c *gin.Context, bucket bucketPictures, p Picture) {
+	switch {
+	case s.config.S3PublicURL != "":
+		c.Redirect(http.StatusFound, strings.TrimSuffix(s.config.S3PublicURL, "/")+"/"+bucket.key(p))
+	case s.config.S3PresignExpiry > 0:
+		// The redirect must not outlive the URL it points to
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.config.S3PresignExpiry/2/time.Second)))
+		c.Redirect(http.StatusFound, bucket.client.PresignGet(bucket.key(p), s.config.S3PresignExpiry))
+	default:
+		body, err := bucket.Open(c.Request.Context(), p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Picture not found"})
+				return
+			}
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch picture"})
+			return
+		}
+		defer body.Close()
+		c.DataFromReader(http.StatusOK, -1, mime.TypeByExtension(s.config.PicturesSuffix), body, nil)
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskPicturesList(t *testing.T) {
+	dir := t.TempDir()
+	pictures := map[string][]string{}
+	for i := range 50 {
+		pictures[fmt.Sprintf("%08x", i)] = []string{"20400", "20401"}
+	}
+	writePictureTree(t, dir, pictures)
+	os.MkdirAll(filepath.Join(dir, "00000000", "extra"), 0755)
+	os.WriteFile(filepath.Join(dir, "00000000", "extra", "1.png"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "00000000", "notes.txt"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "stray.png"), nil, 0644)
+	os.Mkdir(filepath.Join(dir, "empty"), 0755)
+
+	for _, workers := range []int{0, 1, 8} {
+		got, err := diskPictures{folder: dir, suffix: ".png", workers: workers}.List(context.Background())
+		if err != nil {
+			t.Fatalf("workers %d: %v", workers, err)
+		}
+		pictures["00000000"] = []string{"20400", "20401", filepath.Join("extra", "1")}
+		if !maps.EqualFunc(got, pictures, func(f pictureFolder, names []string) bool { return slices.Equal(f.Names, names) }) {
+			t.Errorf("workers %d: List = %v", workers, got)
+		}
+		if f := got["00000001"]; f.Size == 0 || f.ModTime.IsZero() {
+			t.Errorf("workers %d: folder = %+v, want its size and modification time", workers, f)
+		}
+	}
+
+	if _, err := (diskPictures{folder: filepath.Join(dir, "missing"), suffix: ".png"}).List(context.Background()); err == nil {
+		t.Error("listing a missing folder succeeded")
+	}
+}
+
+// fakeBucket serves objects path style, the way an S3 compatible store does,
+// listing them all on one page.
+func fakeBucket(t *testing.T, objects map[string][]byte) *httptest.Server {
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		key := strings.TrimPrefix(r.URL.Path, "/pictures/")
+		switch {
+		case r.Method == http.MethodGet && key == "":
+			fmt.Fprint(w, "<ListBucketResult>")
+			for _, k := range slices.Sorted(maps.Keys(objects)) {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(objects[k]))
+				}
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		case r.Method == http.MethodGet:
+			data, ok := objects[key]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case r.Method == http.MethodPut:
+			objects[key], _ = io.ReadAll(r.Body)
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBucketPictureStorage(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	objects := map[string][]byte{"titles/README.md": []byte("not a picture")}
+	for id, names := range testPictures {
+		for _, name := range names {
+			objects["titles/"+id+"/"+name+".png"] = pngData.Bytes()
+		}
+	}
+	bucket := fakeBucket(t, objects)
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.PictureStorage = pictureStorageS3
+		cfg.S3Endpoint = bucket.URL
+		cfg.S3Bucket = "pictures"
+		cfg.S3Prefix = "titles/"
+		cfg.S3AccessKey = "key"
+		cfg.S3SecretKey = "secret"
+		cfg.S3PresignExpiry = time.Hour
+	})
+	// Only the bucket holds the pictures
+	os.RemoveAll(s.config.PicturesFolder)
+
+	w := doRequest(s, "GET", "/api/v1/titles/4d5307e6", nil)
+	if !strings.Contains(w.Body.String(), `"name":"20401","alt":"Halo 3 gamerpic 20401","width":1,"height":1`) {
+		t.Errorf("pictures were not indexed from the bucket: %s", w.Body.String())
+	}
+
+	w = doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400.png", nil)
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusFound || !strings.HasPrefix(location, bucket.URL+"/pictures/titles/4d5307e6/20400.png?X-Amz-Algorithm=") {
+		t.Errorf("presigned redirect: status = %d, Location = %s", w.Code, location)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=1800" {
+		t.Errorf("presigned redirect: Cache-Control = %q", got)
+	}
+
+	s.config.S3PublicURL = "https://cdn.example.com/"
+	w = doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400.png", nil)
+	if location := w.Header().Get("Location"); location != "https://cdn.example.com/titles/4d5307e6/20400.png" {
+		t.Errorf("public redirect: Location = %s", location)
+	}
+
+	s.config.S3PublicURL = ""
+	s.config.S3PresignExpiry = 0
+	w = doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400.png", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), pngData.Bytes()) || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("streamed picture: status = %d, Content-Type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20402.png", nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing picture: status = %d", w.Code)
+	}
+
+	// Thumbnails are made from the bucket and cached on disk
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20401.png?w=1", nil); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("thumbnail: status = %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(s.config.ThumbnailDir, "4d5307e6", "20401_w1h0.png")); err != nil {
+		t.Errorf("thumbnail was not cached: %v", err)
+	}
+
+	body, contentType := multipartPicture(t, "8000.png", pngData.Bytes())
+	w = doRequestBody(s, "POST", "/api/v1/admin/titles/4d530802/pictures",
+		map[string]string{"Authorization": "Bearer test-token", "Content-Type": contentType}, body)
+	if _, ok := objects["titles/4d530802/8000.png"]; w.Code != http.StatusCreated || !ok {
+		t.Errorf("upload: status = %d, stored in the bucket: %v", w.Code, ok)
+	}
+
+	if w := doRequest(s, "POST", "/api/v1/admin/pictures/dedupe", map[string]string{"Authorization": "Bearer test-token"}); w.Code != http.StatusBadRequest {
+		t.Errorf("dedupe of a bucket: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build example_plugin
+
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// exampleRandomPlugin shows how plugins are written: it serves a random title
+// at /api/v1/plugins/random and logs every sync. Build with
+// `go build -tags example_plugin` to include it.
+type exampleRandomPlugin struct{}
+
+func init() {
+	if err := RegisterPlugin(exampleRandomPlugin{}); err != nil {
+		panic(err)
+	}
+}
+
+func (exampleRandomPlugin) Name() string {
+	return "random"
+}
+
+func (exampleRandomPlugin) Routes(s *Server, api, admin *gin.RouterGroup) {
+	api.GET("", func(c *gin.Context) {
+		var title Title
+		err := s.DB().Preload("Pictures").Order("RANDOM()").First(&title).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		c.JSON(http.StatusOK, title)
+	})
+}
+
+func (exampleRandomPlugin) AfterSync(ctx context.Context, s *Server, run SyncRun) {
+	log.Printf("random: sync run %d added %d titles\n", run.ID, run.Added)
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Plugin adds routes, enrichers or hooks to the server without changes to the
+// rest of its code. Plugins are compiled in: another module registers one
+// with plugin.Register and runs the server from its own main with
+// plugin.Main, while a file of this package, usually behind a build tag so
+// that `go build -tags <tag>` opts into it, calls RegisterPlugin from init.
+// See plugin_example.go.
+//
+// Besides a name, a plugin implements any of PluginRoutes, PluginEnricher,
+// PluginLifecycle and PluginSyncHook.
+type Plugin interface {
+	// Name identifies the plugin in its URLs and job kinds
+	Name() string
+}
+
+// PluginRoutes serves extra routes under /api/v1/plugins/<name>, the admin
+// ones under /api/v1/admin/plugins/<name> behind the admin token.
+type PluginRoutes interface {
+	Routes(s *Server, api, admin *gin.RouterGroup)
+}
+
+// PluginEnricher enriches titles in a background job, started with
+// POST /api/v1/admin/plugins/<name>/enrich like the built-in enrichers.
+type PluginEnricher interface {
+	Enrich(ctx context.Context, s *Server, job *Job) error
+}
+
+// PluginLifecycle is told when the server starts serving, once the catalog is
+// loaded, and when it shuts down. An error from Start aborts the startup.
+type PluginLifecycle interface {
+	Start(ctx context.Context, s *Server) error
+	Stop(ctx context.Context) error
+}
+
+// PluginSyncHook runs after every sync with upstream, failed ones included.
+type PluginSyncHook interface {
+	AfterSync(ctx context.Context, s *Server, run SyncRun)
+}
+
+var pluginNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// registeredPlugins are the plugins compiled into the binary, by name.
+var (
+	registeredPluginsMu sync.Mutex
+	registeredPlugins   = make(map[string]Plugin)
+)
+
+// RegisterPlugin makes a plugin part of every server built afterwards, usually
+// from an init function. Names must be lowercase letters, digits and dashes,
+// and unique.
+func RegisterPlugin(p Plugin) error {
+	name := p.Name()
+	if !pluginNamePattern.MatchString(name) {
+		return fmt.Errorf("plugin name %q must be lowercase letters, digits and dashes", name)
+	}
+
+	registeredPluginsMu.Lock()
+	defer registeredPluginsMu.Unlock()
+	if _, ok := registeredPlugins[name]; ok {
+		return fmt.Errorf("plugin %q registered twice", name)
+	}
+	registeredPlugins[name] = p
+	return nil
+}
+
+func (s *Server) initPlugins() {
+	registeredPluginsMu.Lock()
+	defer registeredPluginsMu.Unlock()
+	for _, name := range slices.Sorted(maps.Keys(registeredPlugins)) {
+		s.plugins = append(s.plugins, registeredPlugins[name])
+		log.Printf("Plugin %s enabled\n", name)
+	}
+}
+
+// DB returns the catalog database. Plugins writing titles or pictures call
+// CatalogChanged afterwards.
+func (s *Server) DB() *gorm.DB {
+	return s.db
+}
+
+// CatalogChanged tells cached listings and the search index that titles or
+// pictures were written.
+func (s *Server) CatalogChanged() {
+	s.catalogChanged()
+}
+
+// registerPluginRoutes adds the routes of the plugins and the jobs of their
+// enrichers.
+func (s *Server) registerPluginRoutes(api, admin *gin.RouterGroup) {
+	admin.GET("/plugins", s.getPlugins)
+	for _, p := range s.plugins {
+		if r, ok := p.(PluginRoutes); ok {
+			r.Routes(s, api.Group("/plugins/"+p.Name()), admin.Group("/plugins/"+p.Name()))
+		}
+		if e, ok := p.(PluginEnricher); ok {
+			kind := "plugin:" + p.Name()
+			s.syncRoutes["/api/v1/admin/plugins/"+p.Name()+"/enrich"] = true
+			admin.POST("/plugins/"+p.Name()+"/enrich", func(c *gin.Context) {
+				job := s.jobs.Start(kind, func(ctx context.Context, job *Job) error {
+					return e.Enrich(ctx, s, job)
+				})
+				c.Header("Location", "/api/v1/admin/plugins/"+p.Name()+"/enrich/"+job.ID())
+				c.JSON(http.StatusAccepted, enricherJobResponse(job))
+			})
+			admin.GET("/plugins/"+p.Name()+"/enrich/:id", func(c *gin.Context) {
+				job, ok := s.jobs.Get(c.Param("id"))
+				if !ok || job.Status().Kind != kind {
+					c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+					return
+				}
+				c.JSON(http.StatusOK, enricherJobResponse(job))
+			})
+		}
+	}
+}
+
+// startPlugins runs the Start hooks, stopping the plugins already started
+// when one fails.
+func (s *Server) startPlugins() error {
+	for _, p := range s.plugins {
+		l, ok := p.(PluginLifecycle)
+		if !ok {
+			continue
+		}
+		if err := l.Start(s.ctx, s); err != nil {
+			s.stopPlugins(context.Background())
+			return fmt.Errorf("starting plugin %s: %w", p.Name(), err)
+		}
+		s.startedPlugins = append(s.startedPlugins, p)
+	}
+	return nil
+}
+
+// stopPlugins runs the Stop hooks of the started plugins in reverse order.
+func (s *Server) stopPlugins(ctx context.Context) error {
+	var errs []error
+	for _, p := range slices.Backward(s.startedPlugins) {
+		if err := p.(PluginLifecycle).Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping plugin %s: %w", p.Name(), err))
+		}
+	}
+	s.startedPlugins = nil
+	return errors.Join(errs...)
+}
+
+// afterSync runs the sync hooks of the plugins.
+func (s *Server) afterSync(run SyncRun) {
+	for _, p := range s.plugins {
+		if h, ok := p.(PluginSyncHook); ok {
+			h.AfterSync(s.ctx, s, run)
+		}
+	}
+}
+
+// PluginInfo describes a plugin and the hooks it implements.
+type PluginInfo struct {
+	Name  string   `json:"name"`
+	Hooks []string `json:"hooks"`
+}
+
+type PluginsResponse struct {
+	Items []PluginInfo `json:"items"`
+}
+
+func (s *Server) getPlugins(c *gin.Context) {
+	items := make([]PluginInfo, 0, len(s.plugins))
+	for _, p := range s.plugins {
+		info := PluginInfo{Name: p.Name(), Hooks: []string{}}
+		if _, ok := p.(PluginRoutes); ok {
+			info.Hooks = append(info.Hooks, "routes")
+		}
+		if _, ok := p.(PluginEnricher); ok {
+			info.Hooks = append(info.Hooks, "enricher")
+		}
+		if _, ok := p.(PluginLifecycle); ok {
+			info.Hooks = append(info.Hooks, "lifecycle")
+		}
+		if _, ok := p.(PluginSyncHook); ok {
+			info.Hooks = append(info.Hooks, "sync")
+		}
+		items = append(items, info)
+	}
+	c.JSON(http.StatusOK, PluginsResponse{Items: items})
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testPlugin implements every plugin hook, recording its calls.
+type testPlugin struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (p *testPlugin) Name() string { return "test" }
+
+func (p *testPlugin) record(call string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, call)
+}
+
+func (p *testPlugin) Routes(s *Server, api, admin *gin.RouterGroup) {
+	api.GET("/hello", func(c *gin.Context) { c.String(http.StatusOK, "hello from a plugin") })
+}
+
+func (p *testPlugin) Enrich(ctx context.Context, s *Server, job *Job) error {
+	p.record("enrich")
+	job.SetResult("enriched")
+	return nil
+}
+
+func (p *testPlugin) Start(ctx context.Context, s *Server) error {
+	p.record("start")
+	return nil
+}
+
+func (p *testPlugin) Stop(ctx context.Context) error {
+	p.record("stop")
+	return nil
+}
+
+func (p *testPlugin) AfterSync(ctx context.Context, s *Server, run SyncRun) {
+	p.record(fmt.Sprintf("sync %d", run.ID))
+}
+
+func TestPlugins(t *testing.T) {
+	plugin := &testPlugin{}
+	if err := RegisterPlugin(plugin); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(registeredPlugins, plugin.Name()) })
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.APIKeys = []string{"ci:ci-secret:read+sync"} })
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	if w := doRequest(s, "GET", "/api/v1/plugins/test/hello", nil); w.Body.String() != "hello from a plugin" {
+		t.Errorf("plugin route: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	w := doRequest(s, "GET", "/api/v1/admin/plugins", admin)
+	if want := `{"name":"test","hooks":["routes","enricher","lifecycle","sync"]}`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("plugins = %s, want them to contain %s", w.Body.String(), want)
+	}
+
+	w = doRequest(s, "POST", "/api/v1/admin/plugins/test/enrich", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting the enricher: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	if w := doRequest(s, "POST", "/api/v1/admin/plugins/test/enrich", map[string]string{"X-API-Key": "ci-secret"}); w.Code != http.StatusAccepted {
+		t.Errorf("starting the enricher with a sync key: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), `"kind":"plugin:test","status":"done"`) || !strings.Contains(w.Body.String(), `"result":"enriched"`) {
+		t.Errorf("enricher job: %s", w.Body.String())
+	}
+
+	doRequest(s, "POST", "/api/v1/admin/sync", admin)
+	s.jobs.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	want := []string{"sync 1", "start", "enrich", "enrich", "sync 2", "stop"}
+	if !slices.Equal(plugin.calls, want) {
+		t.Errorf("calls = %v, want %v", plugin.calls, want)
+	}
+
+	if err := RegisterPlugin(plugin); err == nil {
+		t.Errorf("registering a plugin twice did not fail")
+	}
+	if err := RegisterPlugin(&namedPlugin{"Not Valid"}); err == nil {
+		t.Errorf("registering a plugin with an invalid name did not fail")
+	}
+
+	// Another server without the plugin scopes its routes on its own
+	delete(registeredPlugins, plugin.Name())
+	other := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.APIKeys = []string{"ci:ci-secret:read+sync"} })
+	if other.syncRoutes["/api/v1/admin/plugins/test/enrich"] || !s.syncRoutes["/api/v1/admin/plugins/test/enrich"] {
+		t.Errorf("plugin sync routes leaked between servers")
+	}
+}
+
+// namedPlugin is a plugin with nothing but a name.
+type namedPlugin struct{ name string }
+
+func (p *namedPlugin) Name() string { return p.name }
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerPprof serves the runtime profiles of net/http/pprof under
+// /debug/pprof, to admins only: profiles reveal what is in memory.
+func (s *Server) registerPprof(r *gin.Engine) {
+	debug := r.Group("/debug/pprof", s.requireAdmin)
+	debug.GET("/", gin.WrapF(pprof.Index))
+	debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/profile", gin.WrapF(pprof.Profile))
+	debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/trace", gin.WrapF(pprof.Trace))
+	// The index serves the named profiles, like heap and goroutine
+	debug.GET("/:profile", gin.WrapF(pprof.Index))
+}
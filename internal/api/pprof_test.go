@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPprof(t *testing.T) {
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+	if w := doRequest(newTestServer(t, testTitles), "GET", "/debug/pprof/heap", admin); w.Code != http.StatusNotFound {
+		t.Errorf("disabled: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.Pprof = true
+		cfg.APIKeys = []string{"ops:ops-secret:read+write+sync"}
+	})
+	tests := []struct {
+		target string
+		header map[string]string
+		status int
+	}{
+		{"/debug/pprof/", nil, http.StatusUnauthorized},
+		{"/debug/pprof/", admin, http.StatusOK},
+		{"/debug/pprof/heap?debug=1", admin, http.StatusOK},
+		// Like the keys themselves, only ADMIN_TOKEN gets there
+		{"/debug/pprof/goroutine", map[string]string{"X-API-Key": "ops-secret"}, http.StatusForbidden},
+		{"/debug/pprof/cmdline", admin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := doRequest(s, "GET", tt.target, tt.header); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.target, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	priceChartingSource  = "pricecharting"
+	priceChartingJobKind = "pricecharting"
+)
+
+// priceChartingConsoles are the PriceCharting console names of the systems
+// with retail releases.
+var priceChartingConsoles = map[string]string{
+	"XBOX":    "Xbox",
+	"XBOX360": "Xbox 360",
+	"XBOXONE": "Xbox One",
+	"PC":      "PC Games",
+}
+
+// priceChartingProduct is the response of the product API.
+type priceChartingProduct struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error-message"`
+	ID           string `json:"id"`
+	ProductName  string `json:"product-name"`
+	ConsoleName  string `json:"console-name"`
+	LoosePrice   int    `json:"loose-price"`
+	CIBPrice     int    `json:"cib-price"`
+}
+
+var errPriceChartingNotFound = errors.New("no such product")
+
+// fetchPriceChartingProduct looks a product up by id, or else by name.
+func (s *Server) fetchPriceChartingProduct(ctx context.Context, id, name string) (priceChartingProduct, error) {
+	var product priceChartingProduct
+
+	query := url.Values{"t": {s.config.PriceChartingToken}}
+	if id != "" {
+		query.Set("id", id)
+	} else {
+		query.Set("q", name)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.PriceChartingURL+"product?"+query.Encode(), nil)
+	if err != nil {
+		return product, fmt.Errorf("request failed: %w", err)
+	}
+
+	client := &http.Client{Timeout: s.config.UpstreamTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return product, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return product, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&product); err != nil {
+		return product, fmt.Errorf("decode failed: %w", err)
+	}
+	if product.Status != "success" {
+		return product, errPriceChartingNotFound
+	}
+	return product, nil
+}
+
+// syncMarketValues refreshes the market values of retail titles older than
+// PRICECHARTING_TTL, or all of them when force is set. Titles are looked up by
+// their pricecharting external id, or else by name; a name match is only kept
+// when the product has the same name on the console of the title.
+func (s *Server) syncMarketValues(ctx context.Context, job *Job, force bool) error {
+	var titles []Title
+	err := s.db.WithContext(ctx).
+		Preload("ExternalIDs", "source = ?", priceChartingSource).
+		Order("title_id ASC").Find(&titles).Error
+	if err != nil {
+		return err
+	}
+
+	var fresh []string
+	if !force {
+		err := s.db.Model(&MarketValue{}).Where("fetched_at > ?", time.Now().Add(-s.config.PriceChartingTTL)).Pluck("title_id", &fresh).Error
+		if err != nil {
+			return err
+		}
+	}
+	skip := make(map[string]bool, len(fresh))
+	for _, id := range fresh {
+		skip[id] = true
+	}
+
+	fetched, looked := 0, 0
+	// Title responses embed their ids and market values
+	wrote := false
+	defer func() {
+		if wrote {
+			s.catalogChanged()
+		}
+	}()
+
+	for i, t := range titles {
+		job.SetProgress(i, len(titles))
+		if skip[t.TitleID] {
+			continue
+		}
+
+		// Stay well within the API rate limits
+		if looked > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.config.PriceChartingDelay):
+			}
+		}
+		looked++
+
+		var product priceChartingProduct
+		var err error
+		if len(t.ExternalIDs) > 0 {
+			product, err = s.fetchPriceChartingProduct(ctx, t.ExternalIDs[0].ExternalID, "")
+		} else {
+			product, err = s.matchPriceChartingProduct(ctx, t)
+		}
+		if errors.Is(err, errPriceChartingNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("looking up %s failed: %w", t.TitleID, err)
+		}
+
+		if len(t.ExternalIDs) == 0 {
+			_, err := s.setExternalID(t.TitleID, priceChartingSource, product.ID, priceChartingSource)
+			if errors.Is(err, errExternalIDTaken) || errors.Is(err, errExternalIDCurated) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			wrote = true
+		}
+		if err := s.saveMarketValue(t.TitleID, product); err != nil {
+			return err
+		}
+		wrote = true
+		fetched++
+	}
+
+	job.SetProgress(len(titles), len(titles))
+	job.SetResult(fmt.Sprintf("%d market values fetched, %d still fresh", fetched, len(fresh)))
+	return nil
+}
+
+// matchPriceChartingProduct searches PriceCharting for a title on each of its
+// systems, returning errPriceChartingNotFound unless a product matches exactly.
+func (s *Server) matchPriceChartingProduct(ctx context.Context, t Title) (priceChartingProduct, error) {
+	for _, system := range t.Systems {
+		console, ok := priceChartingConsoles[system]
+		if !ok {
+			continue
+		}
+		product, err := s.fetchPriceChartingProduct(ctx, "", t.Name+" "+console)
+		if errors.Is(err, errPriceChartingNotFound) {
+			continue
+		} else if err != nil {
+			return product, err
+		}
+		if product.ConsoleName == console && normalizeName(product.ProductName) == normalizeName(t.Name) {
+			return product, nil
+		}
+	}
+	return priceChartingProduct{}, errPriceChartingNotFound
+}
+
+func (s *Server) saveMarketValue(titleID string, product priceChartingProduct) error {
+	value := MarketValue{TitleID: titleID, Loose: product.LoosePrice, CIB: product.CIBPrice, Currency: "USD", FetchedAt: time.Now()}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "title_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"loose", "cib", "currency", "fetched_at"}),
+		}).Create(&value).Error
+		if err != nil {
+			return err
+		}
+		// Title responses embed their market value
+		return tx.Model(&Title{TitleID: titleID}).UpdateColumn("updated_at", time.Now()).Error
+	})
+}
+
+func (s *Server) createMarketValuesJob(c *gin.Context) {
+	if s.config.PriceChartingToken == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Market values are disabled"})
+		return
+	}
+
+	force, _ := strconv.ParseBool(c.Query("force"))
+	job := s.jobs.Start(priceChartingJobKind, func(ctx context.Context, job *Job) error {
+		return s.syncMarketValues(ctx, job, force)
+	})
+	c.Header("Location", "/api/v1/admin/pricecharting/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+func (s *Server) getMarketValuesJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != priceChartingJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enricherJobResponse(job))
+}
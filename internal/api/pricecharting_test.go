@@ -0,0 +1,84 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMarketValues(t *testing.T) {
+	var requests atomic.Int32
+	pc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		q := r.URL.Query()
+		switch {
+		case q.Get("t") != "pc-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case q.Get("id") == "7":
+			io.WriteString(w, `{"status":"success","id":"7","product-name":"Call of Duty 4","console-name":"Xbox 360","loose-price":499,"cib-price":899}`)
+		case q.Get("q") == "Halo 3 Xbox 360":
+			io.WriteString(w, `{"status":"success","id":"1","product-name":"Halo 3","console-name":"Xbox 360","loose-price":350,"cib-price":700}`)
+		case q.Get("q") == "Halo 3: ODST Xbox 360":
+			io.WriteString(w, `{"status":"success","id":"2","product-name":"Halo 3 [Legendary Edition]","console-name":"Xbox 360","loose-price":1500}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"status":"error","error-message":"No such product"}`)
+		}
+	}))
+	t.Cleanup(pc.Close)
+
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+	if w := doRequest(s, "POST", "/api/v1/admin/pricecharting", admin); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("market values should be disabled by default: status = %d", w.Code)
+	}
+
+	s = newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.PriceChartingURL = pc.URL + "/api/"
+		cfg.PriceChartingToken = "pc-token"
+		cfg.PriceChartingDelay = 0
+	})
+	if _, err := s.setExternalID("415607F7", priceChartingSource, "7", externalIDOriginAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	etag := doRequest(s, "GET", "/api/v1/titles", nil).Header().Get("ETag")
+	w := doRequest(s, "POST", "/api/v1/admin/pricecharting", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting the refresh: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("listing not revalidated after the refresh: status = %d", w.Code)
+	}
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), "2 market values fetched") {
+		t.Fatalf("refresh did not finish as expected: %s", w.Body.String())
+	}
+
+	for id, want := range map[string]string{
+		"4d5307e6": `"market_value":{"loose_price":350,"cib_price":700,"currency":"USD","fetched_at":`,
+		"415607f7": `"market_value":{"loose_price":499,"cib_price":899`,
+	} {
+		if w := doRequest(s, "GET", "/api/v1/titles/"+id, nil); !strings.Contains(w.Body.String(), want) {
+			t.Errorf("title %s does not contain %q: %s", id, want, w.Body.String())
+		}
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d530802", nil); strings.Contains(w.Body.String(), `"market_value"`) {
+		t.Errorf("products with another name should not be matched: %s", w.Body.String())
+	}
+
+	// Fresh values are not fetched again, only ODST and Minecraft (twice, on
+	// both its systems) are looked up
+	before := requests.Load()
+	doRequest(s, "POST", "/api/v1/admin/pricecharting", admin)
+	s.jobs.Wait()
+	var fetched int64
+	s.db.Model(&MarketValue{}).Count(&fetched)
+	if fetched != 2 || requests.Load()-before != 3 {
+		t.Errorf("second refresh made %d requests for %d values, want 3 for 2", requests.Load()-before, fetched)
+	}
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// exportRoutes are the routes whose bytes count against the export quota.
+var exportRoutes = map[string]bool{
+	"/api/v1/export":               true,
+	"/api/v1/exports/:id/download": true,
+}
+
+// APIKeyUsage counts what an API key used on a day (UTC), against its
+// daily quotas.
+type APIKeyUsage struct {
+	APIKey      string `json:"-" gorm:"primaryKey"`
+	Day         string `json:"day" gorm:"primaryKey"`
+	Requests    int64  `json:"requests"`
+	ExportBytes int64  `json:"export_bytes"`
+}
+
+// QuotaStatus is the use of one quota. A limit of 0 means unlimited, and
+// leaves out what remains.
+type QuotaStatus struct {
+	Used      int64  `json:"used"`
+	Limit     int64  `json:"limit"`
+	Remaining *int64 `json:"remaining,omitempty"`
+}
+
+type QuotaResponse struct {
+	APIKey      string      `json:"api_key"`
+	Day         string      `json:"day"`
+	Requests    QuotaStatus `json:"requests"`
+	ExportBytes QuotaStatus `json:"export_bytes"`
+	ResetAt     time.Time   `json:"reset_at"`
+}
+
+type quotaKey struct {
+	key string
+	day string
+}
+
+// quotaCounter keeps the usage of the API keys seen today, loaded from the
+// database on first use, and buffers the increments between flushes like
+// usageCounter does.
+type quotaCounter struct {
+	mu      sync.Mutex
+	used    map[quotaKey]*APIKeyUsage
+	pending map[quotaKey]*APIKeyUsage
+}
+
+func newQuotaCounter() *quotaCounter {
+	return &quotaCounter{used: map[quotaKey]*APIKeyUsage{}, pending: map[quotaKey]*APIKeyUsage{}}
+}
+
+// usage returns the counts of key for day, loading them the first time.
+// Callers hold mu.
+func (q *quotaCounter) usage(db *gorm.DB, key, day string) (*APIKeyUsage, error) {
+	k := quotaKey{key, day}
+	if u, ok := q.used[k]; ok {
+		return u, nil
+	}
+	u := &APIKeyUsage{APIKey: key, Day: day}
+	if err := db.Where("api_key = ? AND day = ?", key, day).Limit(1).Find(u).Error; err != nil {
+		return nil, err
+	}
+	// Buffered increments of a flush that failed are not in the database
+	if p, ok := q.pending[k]; ok {
+		u.Requests += p.Requests
+		u.ExportBytes += p.ExportBytes
+	}
+	q.used[k] = u
+	return u, nil
+}
+
+// add counts requests and export bytes for key on day.
+func (q *quotaCounter) add(key, day string, requests, exportBytes int64) {
+	k := quotaKey{key, day}
+	if u, ok := q.used[k]; ok {
+		u.Requests += requests
+		u.ExportBytes += exportBytes
+	}
+	p, ok := q.pending[k]
+	if !ok {
+		p = &APIKeyUsage{APIKey: key, Day: day}
+		q.pending[k] = p
+	}
+	p.Requests += requests
+	p.ExportBytes += exportBytes
+}
+
+// take empties the buffered increments and drops the counts of past days.
+func (q *quotaCounter) take(today string) map[quotaKey]*APIKeyUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.pending
+	q.pending = map[quotaKey]*APIKeyUsage{}
+	for k := range q.used {
+		if k.day != today {
+			delete(q.used, k)
+		}
+	}
+	return pending
+}
+
+// restore adds back increments that couldn't be flushed.
+func (q *quotaCounter) restore(pending map[quotaKey]*APIKeyUsage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for k, u := range pending {
+		p, ok := q.pending[k]
+		if !ok {
+			q.pending[k] = u
+			continue
+		}
+		p.Requests += u.Requests
+		p.ExportBytes += u.ExportBytes
+	}
+}
+
+// forget drops what is known of a deleted key.
+func (q *quotaCounter) forget(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for k := range q.used {
+		if k.key == key {
+			delete(q.used, k)
+		}
+	}
+	for k := range q.pending {
+		if k.key == key {
+			delete(q.pending, k)
+		}
+	}
+}
+
+// flushQuotas adds the buffered quota usage to the database.
+func (s *Server) flushQuotas() error {
+	pending := s.quotas.take(usageDay(time.Now()))
+	if len(pending) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, u := range pending {
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "api_key"}, {Name: "day"}},
+				DoUpdates: clause.Assignments(map[string]any{
+					"requests":     gorm.Expr("api_key_usages.requests + ?", u.Requests),
+					"export_bytes": gorm.Expr("api_key_usages.export_bytes + ?", u.ExportBytes),
+				}),
+			}).Create(u).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.quotas.restore(pending)
+	}
+	return err
+}
+
+// quotaLimits returns the daily quotas of a key, 0 for unlimited.
+func (s *Server) quotaLimits(id apiKeyIdentity) (requests, exportBytes int64) {
+	requests, exportBytes = id.DailyRequests, id.DailyExportBytes
+	if requests == 0 {
+		requests = int64(s.config.APIKeyDailyRequests)
+	}
+	if exportBytes == 0 {
+		exportBytes = int64(s.config.APIKeyDailyExportMB) << 20
+	}
+	return requests, exportBytes
+}
+
+// quotaReset returns when the quotas of day t start over, at the next
+// midnight UTC.
+func quotaReset(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+func quotaStatus(used, limit int64) QuotaStatus {
+	status := QuotaStatus{Used: used, Limit: limit}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		status.Remaining = &remaining
+	}
+	return status
+}
+
+// enforceQuotas counts the requests made with an API key against its daily
+// quotas, refusing them with 429 once one is used up. Requests with a key
+// are exempt from abuse protection instead, so third-party apps that
+// identify themselves aren't mistaken for scrapers. Unknown keys are
+// refused rather than ignored, so that a typo doesn't go unnoticed.
+func (s *Server) enforceQuotas(c *gin.Context) {
+	// Checking the quotas must keep working once they are used up
+	if c.GetHeader("X-API-Key") == "" || c.FullPath() == "/api/v1/me/quota" {
+		c.Next()
+		return
+	}
+	id, ok := s.requestAPIKey(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unknown API key"})
+		return
+	}
+
+	now := time.Now()
+	day := usageDay(now)
+	resetAt := quotaReset(now)
+	maxRequests, maxExportBytes := s.quotaLimits(id)
+	export := exportRoutes[c.FullPath()]
+
+	s.quotas.mu.Lock()
+	usage, err := s.quotas.usage(s.db, id.Name, day)
+	if err != nil {
+		s.quotas.mu.Unlock()
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	exceeded := ""
+	switch {
+	case maxRequests > 0 && usage.Requests >= maxRequests:
+		exceeded = "Daily request quota exceeded"
+	case export && maxExportBytes > 0 && usage.ExportBytes >= maxExportBytes:
+		exceeded = "Daily export quota exceeded"
+	default:
+		s.quotas.add(id.Name, day, 1, 0)
+	}
+	used := usage.Requests
+	s.quotas.mu.Unlock()
+
+	if maxRequests > 0 {
+		c.Header("X-RateLimit-Limit", strconv.FormatInt(maxRequests, 10))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(max(maxRequests-used, 0), 10))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+	}
+	if exceeded != "" {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(resetAt).Seconds())+1))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": exceeded, "reset_at": resetAt})
+		return
+	}
+
+	c.Next()
+
+	if export && c.Writer.Size() > 0 {
+		s.quotas.mu.Lock()
+		s.quotas.add(id.Name, day, 0, int64(c.Writer.Size()))
+		s.quotas.mu.Unlock()
+	}
+}
+
+func (s *Server) getQuota(c *gin.Context) {
+	id, ok := s.requestAPIKey(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "X-API-Key header is required"})
+		return
+	}
+
+	now := time.Now()
+	day := usageDay(now)
+	s.quotas.mu.Lock()
+	usage, err := s.quotas.usage(s.db, id.Name, day)
+	var current APIKeyUsage
+	if usage != nil {
+		current = *usage
+	}
+	s.quotas.mu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	maxRequests, maxExportBytes := s.quotaLimits(id)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, QuotaResponse{
+		APIKey:      id.Name,
+		Day:         day,
+		Requests:    quotaStatus(current.Requests, maxRequests),
+		ExportBytes: quotaStatus(current.ExportBytes, maxExportBytes),
+		ResetAt:     quotaReset(now),
+	})
+}
+
+// purgeQuotas deletes the quota usage of the days past usage retention.
+func (s *Server) purgeQuotas() {
+	if err := s.flushQuotas(); err != nil {
+		log.Printf("Janitor: error flushing API key usage: %v\n", err)
+		return
+	}
+	oldest := usageDay(time.Now().AddDate(0, 0, 1-s.config.UsageRetentionDays))
+	if err := s.db.Where("day < ?", oldest).Delete(&APIKeyUsage{}).Error; err != nil {
+		log.Printf("Janitor: error purging API key usage: %v\n", err)
+	}
+}
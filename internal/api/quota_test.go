@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyQuotas(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.APIKeys = []string{"app:app-secret:read"}
+		cfg.APIKeyDailyRequests = 3
+		cfg.AbuseAction = abuseActionBlock
+		cfg.AbuseDuplicateLimit = 1
+	})
+	app := map[string]string{"X-API-Key": "app-secret"}
+
+	// Keyed clients are exempt from abuse rules, anonymous ones are not
+	if w := doRequest(s, "GET", "/api/v1/titles?page=1", nil); w.Code != http.StatusOK {
+		t.Fatalf("anonymous request: status = %d", w.Code)
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles?page=1", nil); w.Code != http.StatusForbidden {
+		t.Errorf("duplicate anonymous request: status = %d, want 403", w.Code)
+	}
+	for i := range 3 {
+		w := doRequest(s, "GET", "/api/v1/titles?page=1", app)
+		if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != strconv.Itoa(2-i) {
+			t.Errorf("request %d: status = %d, remaining = %q", i+1, w.Code, w.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+	w := doRequest(s, "GET", "/api/v1/titles?page=1", app)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" ||
+		!strings.Contains(w.Body.String(), `"reset_at":"`) {
+		t.Errorf("over quota: status = %d, headers %v; body: %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = doRequest(s, "GET", "/api/v1/me/quota", app)
+	var quota QuotaResponse
+	json.Unmarshal(w.Body.Bytes(), &quota)
+	if w.Code != http.StatusOK || quota.APIKey != "app" || quota.Requests.Used != 3 || quota.Requests.Limit != 3 ||
+		quota.Requests.Remaining == nil || *quota.Requests.Remaining != 0 || quota.ExportBytes.Remaining != nil {
+		t.Errorf("quota: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/me/quota", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("quota without a key: status = %d, want 401", w.Code)
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"X-API-Key": "typo"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: status = %d, want 401", w.Code)
+	}
+
+	// Usage survives restarts through the database
+	if err := s.flushQuotas(); err != nil {
+		t.Fatal(err)
+	}
+	s.quotas = newQuotaCounter()
+	if w := doRequest(s, "GET", "/api/v1/titles", app); w.Code != http.StatusTooManyRequests {
+		t.Errorf("over quota after a restart: status = %d, want 429", w.Code)
+	}
+
+	w = doRequestBody(s, "POST", "/api/v1/admin/keys", map[string]string{"Authorization": "Bearer test-token"},
+		`{"name":"mirror","scopes":["read"],"daily_requests":100,"daily_export_bytes":10}`)
+	var created CreatedAPIKey
+	json.Unmarshal(w.Body.Bytes(), &created)
+	mirror := map[string]string{"X-API-Key": created.Key}
+	if w := doRequest(s, "GET", "/api/v1/export", mirror); w.Code != http.StatusOK || w.Body.Len() <= 10 {
+		t.Fatalf("first export: status = %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if w := doRequest(s, "GET", "/api/v1/export", mirror); w.Code != http.StatusTooManyRequests ||
+		!strings.Contains(w.Body.String(), "export quota") {
+		t.Errorf("second export: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles", mirror); w.Code != http.StatusOK {
+		t.Errorf("listing after the export quota is used: status = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a fixed-window limiter keyed by an arbitrary string (usually the client IP).
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Allow records a hit for key and reports whether it is within the limit.
+// A non-positive limit disables limiting.
+func (l *rateLimiter) Allow(key string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	w.count++
+	return w.count <= l.limit
+}
+
+// Exceeded reports whether key used up its limit in the current window,
+// without recording a hit.
+func (l *rateLimiter) Exceeded(key string) bool {
+	if l.limit <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	return ok && time.Since(w.start) < l.window && w.count >= l.limit
+}
+
+// sweep drops expired windows so the map doesn't grow with every client ever seen.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// expiringSet remembers keys for a fixed duration.
+type expiringSet struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]time.Time
+	lastSweep time.Time
+}
+
+func newExpiringSet(ttl time.Duration) *expiringSet {
+	return &expiringSet{
+		ttl:     ttl,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// Add inserts key and reports whether it was not already present.
+func (s *expiringSet) Add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= s.ttl {
+		for k, added := range s.entries {
+			if now.Sub(added) >= s.ttl {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if added, ok := s.entries[key]; ok && now.Sub(added) < s.ttl {
+		return false
+	}
+	s.entries[key] = now
+	return true
+}
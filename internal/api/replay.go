@@ -0,0 +1,43 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// UsedNonce is the nonce of a signed request that was already served, kept
+// until the signature expires so that a captured request can't be replayed.
+// Past that, the expiry refuses it on its own.
+type UsedNonce struct {
+	Nonce     string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// useNonce records nonce as used until expires. It returns false if it
+// already was, which makes the request a replay. The database has the last
+// word, so that concurrent requests and other instances agree.
+func (s *Server) useNonce(nonce string, expires time.Time) (bool, error) {
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&UsedNonce{Nonce: nonce, ExpiresAt: expires})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// releaseNonce forgets a nonce whose request failed, so that it can be
+// retried.
+func (s *Server) releaseNonce(nonce string) {
+	if err := s.db.Where("nonce = ?", nonce).Delete(&UsedNonce{}).Error; err != nil {
+		log.Printf("Warning: releasing nonce failed: %v\n", err)
+	}
+}
+
+// purgeNonces deletes the nonces of expired signatures.
+func (s *Server) purgeNonces() {
+	result := s.db.Where("expires_at <= ?", time.Now()).Delete(&UsedNonce{})
+	if result.Error != nil {
+		log.Printf("Janitor: error purging used nonces: %v\n", result.Error)
+	}
+}
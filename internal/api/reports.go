@@ -0,0 +1,587 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	reportJobKind = "report"
+
+	reportQuality  = "quality"
+	reportCoverage = "coverage"
+	reportDiff     = "diff"
+
+	minReportSchedule     = time.Minute
+	reportDeliveryTimeout = 10 * time.Second
+	reportSampleSize      = 50
+)
+
+// Report is a saved, parameterized report run on demand or on a schedule.
+type Report struct {
+	ID         uint              `json:"id" gorm:"primaryKey"`
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Params     map[string]string `json:"params" gorm:"serializer:json"`
+	Schedule   string            `json:"schedule"`
+	WebhookURL string            `json:"webhook_url"`
+	Email      string            `json:"email"`
+	NextRunAt  *time.Time        `json:"next_run_at" gorm:"index"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// ReportRun is one execution of a report and its result.
+type ReportRun struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	ReportID   uint       `json:"report_id" gorm:"index"`
+	JobID      string     `json:"job_id"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Error      string     `json:"error,omitempty"`
+	Delivery   string     `json:"delivery,omitempty"`
+	Result     string     `json:"-"`
+}
+
+// ReportRunResponse is a run along with its result, as JSON.
+type ReportRunResponse struct {
+	ReportRun
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+type ReportInput struct {
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Params     map[string]string `json:"params"`
+	Schedule   string            `json:"schedule"`
+	WebhookURL string            `json:"webhook_url"`
+	Email      string            `json:"email"`
+}
+
+// validate returns why the input is not a valid report, or "" if it is fine.
+func (in ReportInput) validate() string {
+	if strings.TrimSpace(in.Name) == "" {
+		return "name is required"
+	}
+	// The name goes into mail headers
+	if strings.ContainsFunc(in.Name, unicode.IsControl) {
+		return "name must not contain control characters"
+	}
+	switch in.Kind {
+	case reportQuality, reportCoverage:
+	case reportDiff:
+		if since, ok := in.Params["since"]; ok {
+			if d, err := time.ParseDuration(since); err != nil || d <= 0 {
+				return "params.since must be a positive duration"
+			}
+		}
+	default:
+		return fmt.Sprintf("unknown kind %q, expected quality, coverage or diff", in.Kind)
+	}
+	if in.Schedule != "" {
+		if d, err := time.ParseDuration(in.Schedule); err != nil || d < minReportSchedule {
+			return fmt.Sprintf("schedule must be a duration of at least %s", minReportSchedule)
+		}
+	}
+	if in.WebhookURL != "" {
+		if u, err := url.Parse(in.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "webhook_url must be an http(s) URL"
+		}
+	}
+	if in.Email != "" {
+		if _, err := mail.ParseAddress(in.Email); err != nil {
+			return "email is not a valid address"
+		}
+	}
+	return ""
+}
+
+type ReportCheck struct {
+	Count  int64    `json:"count"`
+	Sample []string `json:"sample"`
+}
+
+type QualityReport struct {
+	Titles                 int64       `json:"titles"`
+	WithoutPictures        ReportCheck `json:"without_pictures"`
+	WithoutBingID          ReportCheck `json:"without_bing_id"`
+	WithoutServiceConfigID ReportCheck `json:"without_service_config_id"`
+	WithoutPFN             ReportCheck `json:"without_pfn"`
+}
+
+type CoverageItem struct {
+	System       string  `json:"system"`
+	Name         string  `json:"name"`
+	Titles       int64   `json:"titles"`
+	WithPictures int64   `json:"with_pictures"`
+	Coverage     float64 `json:"coverage"`
+}
+
+type DiffReport struct {
+	Since    time.Time    `json:"since"`
+	Added    []string     `json:"added"`
+	Updated  []string     `json:"updated"`
+	SyncRuns []SyncRun    `json:"sync_runs"`
+	Audit    []AuditEntry `json:"audit"`
+}
+
+func (s *Server) reportCheck(system, condition string) (ReportCheck, error) {
+	check := ReportCheck{Sample: []string{}}
+	query := func() *gorm.DB {
+		return store.FilterBySystem(s.db.Model(&Title{}), system).Where(condition)
+	}
+	if err := query().Count(&check.Count).Error; err != nil {
+		return check, err
+	}
+	err := query().Order("title_id ASC").Limit(reportSampleSize).Pluck("title_id", &check.Sample).Error
+	return check, err
+}
+
+func (s *Server) qualityReport(params map[string]string) (QualityReport, error) {
+	var report QualityReport
+	system := params["system"]
+	if err := store.FilterBySystem(s.db.Model(&Title{}), system).Count(&report.Titles).Error; err != nil {
+		return report, err
+	}
+
+	checks := []struct {
+		check     *ReportCheck
+		condition string
+	}{
+		{&report.WithoutPictures, "NOT EXISTS (SELECT 1 FROM pictures WHERE pictures.title_id = titles.title_id)"},
+		{&report.WithoutBingID, "(bing_id IS NULL OR bing_id = '')"},
+		{&report.WithoutServiceConfigID, "(service_config_id IS NULL OR service_config_id = '')"},
+		{&report.WithoutPFN, "(pfn IS NULL OR pfn = '')"},
+	}
+	for _, c := range checks {
+		check, err := s.reportCheck(system, c.condition)
+		if err != nil {
+			return report, err
+		}
+		*c.check = check
+	}
+	return report, nil
+}
+
+func (s *Server) coverageReport() ([]CoverageItem, error) {
+	items := []CoverageItem{}
+	err := s.db.Raw(`SELECT json_each.value AS system, COUNT(*) AS titles,
+			SUM(CASE WHEN EXISTS (SELECT 1 FROM pictures WHERE pictures.title_id = titles.title_id) THEN 1 ELSE 0 END) AS with_pictures
+		FROM titles, ` + store.SystemsTable(s.db) + `
+		GROUP BY json_each.value
+		ORDER BY titles DESC, system ASC`).Scan(&items).Error
+	for i := range items {
+		items[i].Name = systemName(items[i].System)
+		if items[i].Titles > 0 {
+			items[i].Coverage = float64(items[i].WithPictures) / float64(items[i].Titles)
+		}
+	}
+	return items, err
+}
+
+// diffReport lists what changed since params["since"] ago, or by default
+// since the previous successful run of the report.
+func (s *Server) diffReport(report Report, params map[string]string) (DiffReport, error) {
+	diff := DiffReport{Added: []string{}, Updated: []string{}, SyncRuns: []SyncRun{}, Audit: []AuditEntry{}}
+
+	if d, err := time.ParseDuration(params["since"]); err == nil {
+		diff.Since = time.Now().Add(-d)
+	} else {
+		var previous ReportRun
+		err := s.db.Where("report_id = ? AND status = ?", report.ID, jobDone).Order("id DESC").First(&previous).Error
+		if err == nil {
+			diff.Since = previous.StartedAt
+		} else {
+			diff.Since = time.Now().Add(-24 * time.Hour)
+		}
+	}
+
+	err := s.db.Model(&Title{}).Where("created_at > ?", diff.Since).Order("title_id ASC").Pluck("title_id", &diff.Added).Error
+	if err != nil {
+		return diff, err
+	}
+	err = s.db.Model(&Title{}).Where("created_at <= ? AND updated_at > ?", diff.Since, diff.Since).Order("title_id ASC").Pluck("title_id", &diff.Updated).Error
+	if err != nil {
+		return diff, err
+	}
+	if err := s.db.Where("started_at > ?", diff.Since).Order("id ASC").Find(&diff.SyncRuns).Error; err != nil {
+		return diff, err
+	}
+	err = s.db.Where("created_at > ?", diff.Since).Order("id ASC").Find(&diff.Audit).Error
+	return diff, err
+}
+
+func (s *Server) reportResult(report Report) (any, error) {
+	switch report.Kind {
+	case reportQuality:
+		return s.qualityReport(report.Params)
+	case reportCoverage:
+		return s.coverageReport()
+	case reportDiff:
+		return s.diffReport(report, report.Params)
+	}
+	return nil, fmt.Errorf("unknown report kind %q", report.Kind)
+}
+
+// startReport records a new run of report and runs it as a background job.
+func (s *Server) startReport(report Report) (ReportRun, error) {
+	run := ReportRun{ReportID: report.ID, Status: jobPending, StartedAt: time.Now()}
+	if err := s.db.Create(&run).Error; err != nil {
+		return run, err
+	}
+
+	job := s.jobs.Start(reportJobKind, func(ctx context.Context, job *Job) error {
+		return s.runReport(ctx, report, run.ID)
+	})
+	run.JobID = job.ID()
+	return run, s.db.Model(&run).Update("job_id", run.JobID).Error
+}
+
+func (s *Server) runReport(ctx context.Context, report Report, runID uint) error {
+	s.db.Model(&ReportRun{ID: runID}).Update("status", jobRunning)
+
+	updates := map[string]any{"status": jobDone}
+	result, err := s.reportResult(report)
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(result)
+	}
+	if err != nil {
+		updates["status"] = jobFailed
+		updates["error"] = err.Error()
+	} else {
+		updates["result"] = string(data)
+		updates["delivery"] = s.deliverReport(ctx, report, runID, data)
+	}
+	updates["finished_at"] = time.Now()
+
+	if dbErr := s.db.Model(&ReportRun{ID: runID}).Updates(updates).Error; dbErr != nil && err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// deliverReport sends a result to the destinations of report, returning a
+// summary of how each delivery went.
+func (s *Server) deliverReport(ctx context.Context, report Report, runID uint, result []byte) string {
+	var outcomes []string
+
+	if report.WebhookURL != "" {
+		if err := postReportWebhook(ctx, report, runID, result); err != nil {
+			outcomes = append(outcomes, "webhook failed: "+err.Error())
+		} else {
+			outcomes = append(outcomes, "webhook delivered")
+		}
+	}
+
+	if report.Email != "" {
+		if err := s.mailReport(report, result); err != nil {
+			outcomes = append(outcomes, "email failed: "+err.Error())
+		} else {
+			outcomes = append(outcomes, "email sent")
+		}
+	}
+
+	return strings.Join(outcomes, "; ")
+}
+
+func postReportWebhook(ctx context.Context, report Report, runID uint, result []byte) error {
+	body, err := json.Marshal(gin.H{
+		"report": report,
+		"run_id": runID,
+		"result": json.RawMessage(result),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, report.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: reportDeliveryTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *Server) mailReport(report Report, result []byte) error {
+	if s.config.SMTPAddr == "" {
+		return errors.New("SMTP_ADDR is not configured")
+	}
+
+	var auth smtp.Auth
+	if s.config.SMTPUsername != "" {
+		host, _, _ := strings.Cut(s.config.SMTPAddr, ":")
+		auth = smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, host)
+	}
+	return smtp.SendMail(s.config.SMTPAddr, auth, s.config.SMTPFrom, []string{report.Email}, s.reportMessage(report, result))
+}
+
+// reportMessage returns the mail carrying a report result. The subject is
+// encoded, names of reports saved before they were validated could break
+// out of it otherwise.
+func (s *Server) reportMessage(report Report, result []byte) []byte {
+	var body bytes.Buffer
+	json.Indent(&body, result, "", "  ")
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.config.SMTPFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", report.Email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "xtitles report: "+report.Name))
+	fmt.Fprintf(&msg, "Content-Type: application/json; charset=utf-8\r\n\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes()
+}
+
+// runDueReports starts the scheduled reports whose next run is due.
+func (s *Server) runDueReports() {
+	var due []Report
+	if err := s.db.Where("schedule <> '' AND next_run_at <= ?", time.Now()).Find(&due).Error; err != nil {
+		log.Printf("Reports: error loading due reports: %v\n", err)
+		return
+	}
+
+	for _, report := range due {
+		schedule, err := time.ParseDuration(report.Schedule)
+		if err != nil {
+			continue
+		}
+		// Claim the run, replicas sharing the database may race for it
+		next := time.Now().Add(schedule)
+		result := s.db.Model(&Report{}).Where("id = ? AND next_run_at = ?", report.ID, report.NextRunAt).UpdateColumn("next_run_at", next)
+		if result.Error != nil {
+			log.Printf("Reports: error scheduling report %d: %v\n", report.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		if _, err := s.startReport(report); err != nil {
+			log.Printf("Reports: error starting report %d: %v\n", report.ID, err)
+		}
+	}
+}
+
+func (s *Server) runReportScheduler(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.runDueReports()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) findReport(c *gin.Context) (Report, bool) {
+	var report Report
+	err := s.db.First(&report, "id = ?", c.Param("id")).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report not found"})
+			return report, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return report, false
+	}
+	return report, true
+}
+
+func (s *Server) getReports(c *gin.Context) {
+	reports := []Report{}
+	if err := s.db.Order("id ASC").Find(&reports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": reports})
+}
+
+func (s *Server) createReport(c *gin.Context) {
+	var input ReportInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if reason := input.validate(); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report: " + reason})
+		return
+	}
+
+	report := Report{
+		Name:       strings.TrimSpace(input.Name),
+		Kind:       input.Kind,
+		Params:     input.Params,
+		Schedule:   input.Schedule,
+		WebhookURL: input.WebhookURL,
+		Email:      input.Email,
+	}
+	if report.Params == nil {
+		report.Params = map[string]string{}
+	}
+	if schedule, err := time.ParseDuration(report.Schedule); err == nil {
+		next := time.Now().Add(schedule)
+		report.NextRunAt = &next
+	}
+
+	if err := s.db.Create(&report).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/api/v1/admin/reports/%d", report.ID))
+	c.JSON(http.StatusCreated, report)
+}
+
+func (s *Server) getReport(c *gin.Context) {
+	if report, ok := s.findReport(c); ok {
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+func (s *Server) deleteReport(c *gin.Context) {
+	report, ok := s.findReport(c)
+	if !ok {
+		return
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("report_id = ?", report.ID).Delete(&ReportRun{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&report).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) createReportRun(c *gin.Context) {
+	report, ok := s.findReport(c)
+	if !ok {
+		return
+	}
+
+	run, err := s.startReport(report)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/api/v1/admin/reports/%d/runs/%d", report.ID, run.ID))
+	c.JSON(http.StatusAccepted, run)
+}
+
+func (s *Server) getReportRuns(c *gin.Context) {
+	report, ok := s.findReport(c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	offset := (page - 1) * limit
+
+	// Results can be large, they are fetched run by run
+	var runs []ReportRun
+	var total int64
+	err := store.ReadSnapshot(c.Request.Context(), s.db, func(tx *gorm.DB) error {
+		query := tx.Model(&ReportRun{}).Where("report_id = ?", report.ID)
+		if err := query.Count(&total).Error; err != nil {
+			return err
+		}
+		return query.Omit("result").Order("id DESC").Offset(offset).Limit(limit).Find(&runs).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Items:  runs,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		Page:   page,
+		Pages:  int((total + int64(limit) - 1) / int64(limit)),
+	})
+}
+
+func (s *Server) findReportRun(c *gin.Context) (ReportRun, bool) {
+	var run ReportRun
+	err := s.db.First(&run, "id = ? AND report_id = ?", c.Param("run"), c.Param("id")).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Report run not found"})
+			return run, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return run, false
+	}
+	return run, true
+}
+
+func (s *Server) getReportRun(c *gin.Context) {
+	if run, ok := s.findReportRun(c); ok {
+		resp := ReportRunResponse{ReportRun: run}
+		if run.Result != "" {
+			resp.Result = json.RawMessage(run.Result)
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (s *Server) downloadReportRun(c *gin.Context) {
+	run, ok := s.findReportRun(c)
+	if !ok {
+		return
+	}
+	if run.Status != jobDone {
+		c.JSON(http.StatusConflict, gin.H{"error": "Report run has no result"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="xtitles-report-%d-run-%d.json"`, run.ReportID, run.ID))
+	c.Data(http.StatusOK, "application/json", []byte(run.Result))
+}
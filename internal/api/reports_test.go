@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReports(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+
+	webhooks := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body bytes.Buffer
+		body.ReadFrom(r.Body)
+		webhooks <- body.String()
+	}))
+	defer hook.Close()
+
+	// runReport starts a run of report and waits for it to finish
+	runReport := func(location string) ReportRunResponse {
+		t.Helper()
+		w := doRequestBody(s, "POST", location+"/runs", admin, "")
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("run %s: status = %d, want %d; body: %s", location, w.Code, http.StatusAccepted, w.Body.String())
+		}
+		runLocation := w.Header().Get("Location")
+		for range 100 {
+			var run ReportRunResponse
+			json.Unmarshal(doRequest(s, "GET", runLocation, admin).Body.Bytes(), &run)
+			if run.Status == jobDone || run.Status == jobFailed {
+				return run
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("run %s did not finish", runLocation)
+		return ReportRunResponse{}
+	}
+
+	invalid := []string{
+		`{"name":"x","kind":"nope"}`,
+		`{"name":"x","kind":"quality","schedule":"1s"}`,
+		`{"name":"x","kind":"quality","webhook_url":"ftp://example.com"}`,
+		`{"name":"x","kind":"quality","email":"not an address"}`,
+		`{"name":"x\r\nBcc: someone@example.com","kind":"quality"}`,
+	}
+	for _, body := range invalid {
+		if w := doRequestBody(s, "POST", "/api/v1/admin/reports", admin, body); w.Code != http.StatusBadRequest {
+			t.Errorf("create %s: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		contains string
+	}{
+		{"quality", `{"name":"Quality","kind":"quality","webhook_url":"` + hook.URL + `"}`, `"without_pictures":{"count":2,"sample":["415607F7","4D530802"]}`},
+		{"coverage", `{"name":"Coverage","kind":"coverage","params":{}}`, `{"system":"XBOX360","name":"Xbox 360","titles":4,"with_pictures":2,"coverage":0.5}`},
+		{"diff", `{"name":"Diff","kind":"diff","params":{"since":"1h"}}`, `"added":["415607F7","4D5307E6","4D530802","584109EB"]`},
+	}
+	for _, tt := range tests {
+		w := doRequestBody(s, "POST", "/api/v1/admin/reports", admin, tt.body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: create status = %d, want %d; body: %s", tt.name, w.Code, http.StatusCreated, w.Body.String())
+		}
+		run := runReport(w.Header().Get("Location"))
+		if run.Status != jobDone || !strings.Contains(string(run.Result), tt.contains) {
+			t.Errorf("%s: run %s (%s) does not contain %q: %s", tt.name, run.Status, run.Error, tt.contains, run.Result)
+		}
+	}
+
+	select {
+	case body := <-webhooks:
+		if !strings.Contains(body, `"name":"Quality"`) || !strings.Contains(body, `"without_pictures"`) {
+			t.Errorf("webhook body is missing the report: %s", body)
+		}
+	case <-time.After(time.Second):
+		t.Error("webhook was not delivered")
+	}
+
+	w := doRequest(s, "GET", "/api/v1/admin/reports/1/runs/1/download", admin)
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("download: status = %d, Content-Disposition = %q", w.Code, w.Header().Get("Content-Disposition"))
+	}
+
+	msg := string(s.reportMessage(Report{Name: "Nightly\r\nBcc: someone@example.com", Email: "ops@example.com"}, []byte(`{}`)))
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Errorf("report name broke out of the subject:\n%s", msg)
+	}
+
+	// Scheduled reports run once due
+	w = doRequestBody(s, "POST", "/api/v1/admin/reports", admin, `{"name":"Nightly","kind":"coverage","schedule":"24h"}`)
+	var scheduled Report
+	json.Unmarshal(w.Body.Bytes(), &scheduled)
+	s.runDueReports()
+	s.db.Model(&scheduled).UpdateColumn("next_run_at", time.Now().Add(-time.Minute))
+	// Only one of the replicas sharing a database runs a due report
+	var replicas sync.WaitGroup
+	for range 2 {
+		replicas.Go(s.runDueReports)
+	}
+	replicas.Wait()
+	s.jobs.Wait()
+	var runs int64
+	s.db.Model(&ReportRun{}).Where("report_id = ?", scheduled.ID).Count(&runs)
+	if runs != 1 {
+		t.Errorf("scheduled report ran %d times, want 1", runs)
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	retroAchievementsSource  = "retroachievements"
+	retroAchievementsJobKind = "retroachievements"
+)
+
+// retroAchievementsGame is an entry of API_GetGameList.
+type retroAchievementsGame struct {
+	ID              int    `json:"ID"`
+	Title           string `json:"Title"`
+	NumAchievements int    `json:"NumAchievements"`
+	Points          int    `json:"Points"`
+}
+
+// parseConsoleIDs parses a SYSTEM=id list mapping systems to RetroAchievements
+// console ids.
+func parseConsoleIDs(value string) map[string]int {
+	consoles := make(map[string]int)
+	for _, pair := range parseList(value) {
+		system, id, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(id))
+		if !ok || err != nil || n <= 0 {
+			continue
+		}
+		consoles[strings.ToUpper(strings.TrimSpace(system))] = n
+	}
+	return consoles
+}
+
+// fetchRetroAchievementsGames lists the games RetroAchievements knows for a console.
+func (s *Server) fetchRetroAchievementsGames(ctx context.Context, consoleID int) ([]retroAchievementsGame, error) {
+	query := url.Values{"i": {strconv.Itoa(consoleID)}, "y": {s.config.RetroAchievementsAPIKey}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.RetroAchievementsURL+"API_GetGameList.php?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	client := &http.Client{Timeout: s.config.UpstreamTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var games []retroAchievementsGame
+	if err := json.NewDecoder(resp.Body).Decode(&games); err != nil {
+		return nil, fmt.Errorf("decode failed: %w", err)
+	}
+	return games, nil
+}
+
+// syncRetroAchievements maps titles to RetroAchievements games and refreshes
+// their achievement counts. Titles keep the game a maintainer or a previous
+// run mapped them to, others are matched by name when exactly one game of
+// their console has it. Tagged entries (hacks, homebrew...) are never matched.
+func (s *Server) syncRetroAchievements(ctx context.Context, job *Job) error {
+	matched, updated := 0, 0
+	// Title responses embed their ids and achievement sets
+	wrote := false
+	defer func() {
+		if wrote {
+			s.catalogChanged()
+		}
+	}()
+
+	step := 0
+	for system, consoleID := range s.config.RetroAchievementsConsoles {
+		games, err := s.fetchRetroAchievementsGames(ctx, consoleID)
+		if err != nil {
+			return fmt.Errorf("listing console %d failed: %w", consoleID, err)
+		}
+
+		byID := make(map[string]retroAchievementsGame, len(games))
+		byName := make(map[string][]retroAchievementsGame, len(games))
+		for _, g := range games {
+			byID[strconv.Itoa(g.ID)] = g
+			if !strings.HasPrefix(g.Title, "~") {
+				name := normalizeName(g.Title)
+				byName[name] = append(byName[name], g)
+			}
+		}
+
+		var titles []Title
+		err = store.FilterBySystem(s.db.WithContext(ctx), system).
+			Preload("ExternalIDs", "source = ?", retroAchievementsSource).
+			Order("title_id ASC").Find(&titles).Error
+		if err != nil {
+			return err
+		}
+
+		for _, t := range titles {
+			game, ok := retroAchievementsGame{}, false
+			if len(t.ExternalIDs) > 0 {
+				game, ok = byID[t.ExternalIDs[0].ExternalID]
+			} else if candidates := byName[normalizeName(t.Name)]; len(candidates) == 1 {
+				game, ok = candidates[0], true
+				_, err := s.setExternalID(t.TitleID, retroAchievementsSource, strconv.Itoa(game.ID), retroAchievementsSource)
+				switch {
+				case errors.Is(err, errExternalIDTaken), errors.Is(err, errExternalIDCurated):
+					ok = false
+				case err != nil:
+					return err
+				default:
+					wrote = true
+				}
+			}
+			if !ok {
+				continue
+			}
+
+			matched++
+			changed, err := s.saveAchievementSet(t.TitleID, game)
+			if err != nil {
+				return err
+			}
+			if changed {
+				updated++
+				wrote = true
+			}
+		}
+
+		step++
+		job.SetProgress(step, len(s.config.RetroAchievementsConsoles))
+	}
+
+	job.SetResult(fmt.Sprintf("%d titles matched, %d achievement sets updated", matched, updated))
+	return nil
+}
+
+// saveAchievementSet stores the counts of game for titleID, reporting whether
+// they changed.
+func (s *Server) saveAchievementSet(titleID string, game retroAchievementsGame) (bool, error) {
+	set := AchievementSet{TitleID: titleID, GameID: game.ID, Achievements: game.NumAchievements, Points: game.Points}
+
+	var current AchievementSet
+	err := s.db.First(&current, "title_id = ?", titleID).Error
+	if err == nil && current.GameID == set.GameID && current.Achievements == set.Achievements && current.Points == set.Points {
+		return false, nil
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "title_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"game_id", "achievements", "points", "updated_at"}),
+		}).Create(&set).Error
+		if err != nil {
+			return err
+		}
+		// Title responses embed their achievement set
+		return tx.Model(&Title{TitleID: titleID}).UpdateColumn("updated_at", time.Now()).Error
+	})
+	return err == nil, err
+}
+
+func (s *Server) createRetroAchievementsJob(c *gin.Context) {
+	if s.config.RetroAchievementsAPIKey == "" || len(s.config.RetroAchievementsConsoles) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "RetroAchievements is not configured"})
+		return
+	}
+
+	job := s.jobs.Start(retroAchievementsJobKind, s.syncRetroAchievements)
+	c.Header("Location", "/api/v1/admin/retroachievements/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+func (s *Server) getRetroAchievementsJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != retroAchievementsJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enricherJobResponse(job))
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRetroAchievements(t *testing.T) {
+	ra := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/API/API_GetGameList.php" || r.URL.Query().Get("i") != "99" || r.URL.Query().Get("y") != "ra-key" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `[
+			{"ID": 10, "Title": "Halo 3", "NumAchievements": 25, "Points": 400},
+			{"ID": 11, "Title": "Minecraft", "NumAchievements": 0, "Points": 0},
+			{"ID": 12, "Title": "~Hack~ Halo 3: ODST", "NumAchievements": 5, "Points": 50},
+			{"ID": 13, "Title": "Call of Duty 4: Modern Warfare", "NumAchievements": 30, "Points": 300}
+		]`)
+	}))
+	t.Cleanup(ra.Close)
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.RetroAchievementsURL = ra.URL + "/API/"
+		cfg.RetroAchievementsAPIKey = "ra-key"
+		cfg.RetroAchievementsConsoles = parseConsoleIDs("xbox360=99, pc=x")
+	})
+	if _, err := s.setExternalID("415607F7", retroAchievementsSource, "13", externalIDOriginAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+	etag := doRequest(s, "GET", "/api/v1/titles", nil).Header().Get("ETag")
+	w := doRequest(s, "POST", "/api/v1/admin/retroachievements", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting the sync: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("listing not revalidated after the sync: status = %d", w.Code)
+	}
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), `"status":"done"`) || !strings.Contains(w.Body.String(), "3 titles matched") {
+		t.Fatalf("sync did not finish as expected: %s", w.Body.String())
+	}
+
+	for id, want := range map[string]string{
+		"4d5307e6": `"achievements":{"game_id":10,"achievements":25,"points":400`,
+		"415607f7": `"achievements":{"game_id":13,"achievements":30`,
+		"584109eb": `"available":false`,
+	} {
+		if w := doRequest(s, "GET", "/api/v1/titles/"+id, nil); !strings.Contains(w.Body.String(), want) {
+			t.Errorf("title %s does not contain %q: %s", id, want, w.Body.String())
+		}
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d530802", nil); strings.Contains(w.Body.String(), `"achievements"`) {
+		t.Errorf("tagged entries should not be matched: %s", w.Body.String())
+	}
+
+	w = doRequest(s, "GET", "/titles/4d5307e6", nil)
+	if !strings.Contains(w.Body.String(), `href="https://retroachievements.org/game/10"`) || !strings.Contains(w.Body.String(), "25 achievements, 400 points") {
+		t.Errorf("title page does not link the achievement set: %s", w.Body.String())
+	}
+
+	w = doRequest(s, "DELETE", "/api/v1/admin/titles/4d5307e6/external/retroachievements", admin)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("deleting the mapping: status = %d", w.Code)
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6", nil); strings.Contains(w.Body.String(), `"achievements"`) {
+		t.Errorf("achievement set outlived its mapping: %s", w.Body.String())
+	}
+}
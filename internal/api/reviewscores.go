@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	reviewScoresJobKind = "reviewscores"
+	openCriticSource    = "opencritic"
+)
+
+var errReviewScoreNotFound = errors.New("no review score")
+
+// reviewScoreProvider is a database of aggregate review scores. Its game ids
+// are stored as external ids of its source.
+type reviewScoreProvider interface {
+	Source() string
+	// Match searches a game by the name of a title, returning
+	// errReviewScoreNotFound unless exactly one game has that name.
+	Match(ctx context.Context, t Title) (string, error)
+	// Score fetches the score of a game, returning errReviewScoreNotFound if
+	// it has none yet.
+	Score(ctx context.Context, id string) (ReviewScore, error)
+}
+
+// reviewScoreProviders are the providers REVIEW_SCORE_PROVIDER can select.
+var reviewScoreProviders = map[string]func(cfg Config) reviewScoreProvider{
+	openCriticSource: newOpenCriticProvider,
+}
+
+// initReviewScores sets up the configured review score provider, if any.
+func (s *Server) initReviewScores() error {
+	if s.config.ReviewScoreProvider == "" {
+		return nil
+	}
+	newProvider, ok := reviewScoreProviders[s.config.ReviewScoreProvider]
+	if !ok {
+		return fmt.Errorf("unsupported review score provider %q (available: %s)",
+			s.config.ReviewScoreProvider, strings.Join(slices.Sorted(maps.Keys(reviewScoreProviders)), ", "))
+	}
+	s.reviewScores = newProvider(s.config)
+	return nil
+}
+
+// openCriticProvider reads top critic scores from the OpenCritic API.
+type openCriticProvider struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func newOpenCriticProvider(cfg Config) reviewScoreProvider {
+	return &openCriticProvider{
+		baseURL: cfg.OpenCriticURL,
+		apiKey:  cfg.OpenCriticAPIKey,
+		client:  &http.Client{Timeout: cfg.UpstreamTimeout},
+	}
+}
+
+func (p *openCriticProvider) Source() string {
+	return openCriticSource
+}
+
+func (p *openCriticProvider) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	// The public API is served through RapidAPI
+	if p.apiKey != "" {
+		req.Header.Set("X-RapidAPI-Key", p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errReviewScoreNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode failed: %w", err)
+	}
+	return nil
+}
+
+func (p *openCriticProvider) Match(ctx context.Context, t Title) (string, error) {
+	var results []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := p.get(ctx, "game/search?"+url.Values{"criteria": {t.Name}}.Encode(), &results); err != nil {
+		return "", err
+	}
+
+	var ids []string
+	for _, r := range results {
+		if normalizeName(r.Name) == normalizeName(t.Name) {
+			ids = append(ids, strconv.Itoa(r.ID))
+		}
+	}
+	if len(ids) != 1 {
+		return "", errReviewScoreNotFound
+	}
+	return ids[0], nil
+}
+
+func (p *openCriticProvider) Score(ctx context.Context, id string) (ReviewScore, error) {
+	var game struct {
+		TopCriticScore      float64 `json:"topCriticScore"`
+		NumTopCriticReviews int     `json:"numTopCriticReviews"`
+		URL                 string  `json:"url"`
+	}
+	if err := p.get(ctx, "game/"+url.PathEscape(id), &game); err != nil {
+		return ReviewScore{}, err
+	}
+	// Games without enough reviews have a score of -1
+	if game.TopCriticScore < 0 || game.NumTopCriticReviews == 0 {
+		return ReviewScore{}, errReviewScoreNotFound
+	}
+	if game.URL == "" {
+		game.URL = "https://opencritic.com/game/" + id
+	}
+	return ReviewScore{
+		Source:  openCriticSource,
+		Score:   int(math.Round(game.TopCriticScore)),
+		Reviews: game.NumTopCriticReviews,
+		URL:     game.URL,
+	}, nil
+}
+
+// syncReviewScores refreshes the review scores older than REVIEW_SCORE_TTL,
+// or all of them when force is set. Titles are looked up by their external id
+// in the provider's source, or else by name.
+func (s *Server) syncReviewScores(ctx context.Context, job *Job, force bool) error {
+	provider := s.reviewScores
+	source := provider.Source()
+
+	var titles []Title
+	err := s.db.WithContext(ctx).
+		Preload("ExternalIDs", "source = ?", source).
+		Order("title_id ASC").Find(&titles).Error
+	if err != nil {
+		return err
+	}
+
+	var fresh []string
+	if !force {
+		err := s.db.Model(&ReviewScore{}).Where("source = ? AND fetched_at > ?", source, time.Now().Add(-s.config.ReviewScoreTTL)).Pluck("title_id", &fresh).Error
+		if err != nil {
+			return err
+		}
+	}
+	skip := make(map[string]bool, len(fresh))
+	for _, id := range fresh {
+		skip[id] = true
+	}
+
+	fetched, looked := 0, 0
+	// Title responses embed their ids and scores, and listings sort by them
+	wrote := false
+	defer func() {
+		if wrote {
+			s.catalogChanged()
+		}
+	}()
+
+	for i, t := range titles {
+		job.SetProgress(i, len(titles))
+		if skip[t.TitleID] {
+			continue
+		}
+
+		// Stay well within the API rate limits
+		if looked > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.config.ReviewScoreDelay):
+			}
+		}
+		looked++
+
+		id := ""
+		if len(t.ExternalIDs) > 0 {
+			id = t.ExternalIDs[0].ExternalID
+		} else {
+			id, err = provider.Match(ctx, t)
+			if errors.Is(err, errReviewScoreNotFound) {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("looking up %s failed: %w", t.TitleID, err)
+			}
+			_, err := s.setExternalID(t.TitleID, source, id, source)
+			if errors.Is(err, errExternalIDTaken) || errors.Is(err, errExternalIDCurated) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			wrote = true
+		}
+
+		score, err := provider.Score(ctx, id)
+		if errors.Is(err, errReviewScoreNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("fetching the score of %s failed: %w", t.TitleID, err)
+		}
+		score.TitleID = t.TitleID
+		if err := s.saveReviewScore(score); err != nil {
+			return err
+		}
+		wrote = true
+		fetched++
+	}
+
+	job.SetProgress(len(titles), len(titles))
+	job.SetResult(fmt.Sprintf("%d review scores fetched, %d still fresh", fetched, len(fresh)))
+	return nil
+}
+
+func (s *Server) saveReviewScore(score ReviewScore) error {
+	score.FetchedAt = time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "title_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"source", "score", "reviews", "url", "fetched_at"}),
+		}).Create(&score).Error
+		if err != nil {
+			return err
+		}
+		// Title responses embed their review score
+		return tx.Model(&Title{TitleID: score.TitleID}).UpdateColumn("updated_at", time.Now()).Error
+	})
+}
+
+func (s *Server) createReviewScoresJob(c *gin.Context) {
+	if s.reviewScores == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Review scores are disabled"})
+		return
+	}
+
+	force, _ := strconv.ParseBool(c.Query("force"))
+	job := s.jobs.Start(reviewScoresJobKind, func(ctx context.Context, job *Job) error {
+		return s.syncReviewScores(ctx, job, force)
+	})
+	c.Header("Location", "/api/v1/admin/reviewscores/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+func (s *Server) getReviewScoresJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != reviewScoresJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enricherJobResponse(job))
+}
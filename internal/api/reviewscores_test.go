@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReviewScores(t *testing.T) {
+	oc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/game/search":
+			switch r.URL.Query().Get("criteria") {
+			case "Halo 3":
+				io.WriteString(w, `[{"id":1,"name":"Halo 3","dist":0},{"id":2,"name":"Halo 3: ODST","dist":6}]`)
+			case "Minecraft":
+				io.WriteString(w, `[{"id":3,"name":"Minecraft","dist":0}]`)
+			default:
+				io.WriteString(w, `[]`)
+			}
+		case "/api/game/1":
+			io.WriteString(w, `{"id":1,"name":"Halo 3","topCriticScore":94.4,"numTopCriticReviews":80,"url":"https://opencritic.com/game/1/halo-3"}`)
+		case "/api/game/3":
+			io.WriteString(w, `{"id":3,"name":"Minecraft","topCriticScore":-1,"numTopCriticReviews":0}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(oc.Close)
+
+	cfg := testConfig(t, "http://127.0.0.1:0")
+	cfg.ReviewScoreProvider = "metacritic"
+	if _, err := NewServer(cfg); err == nil || !strings.Contains(err.Error(), "unsupported review score provider") {
+		t.Fatalf("expected an unsupported provider error, got %v", err)
+	}
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.ReviewScoreProvider = openCriticSource
+		cfg.OpenCriticURL = oc.URL + "/api/"
+		cfg.ReviewScoreDelay = 0
+	})
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+	etag := doRequest(s, "GET", "/api/v1/titles?sort=score", nil).Header().Get("ETag")
+	w := doRequest(s, "POST", "/api/v1/admin/reviewscores", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting the refresh: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	if w := doRequest(s, "GET", "/api/v1/titles?sort=score", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("ranking not revalidated after the refresh: status = %d", w.Code)
+	}
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), "1 review scores fetched") {
+		t.Fatalf("refresh did not finish as expected: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6", nil); !strings.Contains(w.Body.String(), `"review_score":{"source":"opencritic","score":94,"reviews":80`) {
+		t.Errorf("title does not embed its score: %s", w.Body.String())
+	}
+
+	s.db.Create(&ReviewScore{TitleID: "415607F7", Source: openCriticSource, Score: 90})
+	for target, want := range map[string][]string{
+		"/api/v1/titles?sort=score":              {"4D5307E6", "415607F7", "4D530802", "584109EB"},
+		"/api/v1/titles?sort=score&reverse=true": {"415607F7", "4D5307E6", "584109EB", "4D530802"},
+		"/api/v1/titles?sort=score&order=asc":    {"415607F7", "4D5307E6", "584109EB", "4D530802"},
+		"/api/v1/titles?min_score=91":            {"4D5307E6"},
+	} {
+		w := doRequest(s, "GET", target, nil)
+		var resp struct{ Items []Title }
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var ids []string
+		for _, t := range resp.Items {
+			ids = append(ids, t.TitleID)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("%s: got %v, want %v", target, ids, want)
+		}
+	}
+	for _, target := range []string{"/api/v1/titles?sort=rating", "/api/v1/titles?min_score=101"} {
+		if w := doRequest(s, "GET", target, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/birabittoh/xtitles/internal/tracing"
+	"github.com/lithammer/fuzzysearch/fuzzy"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// The search index is a standalone FTS5 table rather than an external content
+// one: titles are keyed by title_id, and their rowids are not stable across
+// VACUUM INTO backups. Triggers keep it in sync with the titles table.
+var searchIndexStatements = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS titles_fts USING fts5(
+		title_id UNINDEXED,
+		name,
+		tokenize = 'unicode61 remove_diacritics 2'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS titles_fts_insert AFTER INSERT ON titles BEGIN
+		INSERT INTO titles_fts (title_id, name) VALUES (new.title_id, new.name);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS titles_fts_delete AFTER DELETE ON titles BEGIN
+		DELETE FROM titles_fts WHERE title_id = old.title_id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS titles_fts_update AFTER UPDATE OF name ON titles BEGIN
+		UPDATE titles_fts SET name = new.name WHERE title_id = old.title_id;
+	END`,
+}
+
+// initSearchIndex creates the full-text index and rebuilds it when it does
+// not match the titles table, e.g. on databases created before it existed.
+// It is SQLite only, other databases use the fuzzy backend.
+func (s *Server) initSearchIndex() error {
+	if !isSQLite(s.db) {
+		return nil
+	}
+	for _, stmt := range searchIndexStatements {
+		if err := s.db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("creating search index failed: %w", err)
+		}
+	}
+
+	var titles, indexed int64
+	s.db.Model(&Title{}).Count(&titles)
+	s.db.Raw("SELECT COUNT(*) FROM titles_fts").Scan(&indexed)
+	if titles == indexed {
+		return nil
+	}
+
+	log.Printf("Rebuilding search index for %d titles...\n", titles)
+	return s.db.Exec(`DELETE FROM titles_fts;
+		INSERT INTO titles_fts (title_id, name) SELECT title_id, name FROM titles`).Error
+}
+
+// ftsQuery turns free text into an FTS5 query matching titles that contain
+// every word, each as a prefix. It returns "" if q has no searchable words.
+func ftsQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+const (
+	searchBackendFTS   = "fts"
+	searchBackendFuzzy = "fuzzy"
+)
+
+// searchEntry is what the fuzzy index needs to know about a title besides its name.
+type searchEntry struct {
+	titleID     string
+	systems     []string
+	hasPictures bool
+}
+
+// fuzzyIndex holds the normalized names of every title, built once and
+// refreshed after writes, so that fuzzy searches don't touch the database
+// until the page of results is loaded.
+type fuzzyIndex struct {
+	mu      sync.RWMutex
+	names   []string
+	entries []searchEntry
+}
+
+var nameNormalizer = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// normalizeName strips diacritics and case so that names only need to be
+// normalized once, when the index is built.
+func normalizeName(name string) string {
+	normalized, _, err := transform.String(nameNormalizer, name)
+	if err != nil {
+		normalized = name
+	}
+	return strings.ToLower(normalized)
+}
+
+// Search returns the titles matching q ranked by edit distance, best matches
+// first and ties in title id order. Unless systems is empty, only titles on
+// one of them match.
+func (idx *fuzzyIndex) Search(q string, onlyWithPictures bool, systems []string) []rankedTitle {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	matches := fuzzy.RankFind(normalizeName(q), idx.names)
+	// Entries are in title id order, which the sort keeps for ties
+	sort.Stable(matches)
+
+	ranked := make([]rankedTitle, 0, len(matches))
+	for _, m := range matches {
+		entry := idx.entries[m.OriginalIndex]
+		if onlyWithPictures && !entry.hasPictures {
+			continue
+		}
+		if len(systems) > 0 && !slices.ContainsFunc(entry.systems, func(s string) bool { return slices.Contains(systems, s) }) {
+			continue
+		}
+		ranked = append(ranked, rankedTitle{TitleID: entry.titleID, Rank: float64(m.Distance)})
+	}
+	return ranked
+}
+
+// refreshSearchIndex rebuilds the fuzzy index from the database. It is a no-op
+// with the FTS backend, whose index is kept up to date by triggers.
+func (s *Server) refreshSearchIndex() {
+	if s.config.SearchBackend != searchBackendFuzzy {
+		return
+	}
+
+	var titles []Title
+	if err := s.db.Select("title_id", "name", "systems").Order("title_id ASC").Find(&titles).Error; err != nil {
+		log.Printf("Warning: refreshing search index failed: %v\n", err)
+		return
+	}
+	var pictureTitleIDs []string
+	if err := s.db.Model(&Picture{}).Distinct().Pluck("title_id", &pictureTitleIDs).Error; err != nil {
+		log.Printf("Warning: refreshing search index failed: %v\n", err)
+		return
+	}
+	withPictures := make(map[string]bool, len(pictureTitleIDs))
+	for _, id := range pictureTitleIDs {
+		withPictures[id] = true
+	}
+
+	names := make([]string, len(titles))
+	entries := make([]searchEntry, len(titles))
+	for i, t := range titles {
+		names[i] = normalizeName(t.Name)
+		entries[i] = searchEntry{
+			titleID:     t.TitleID,
+			systems:     t.Systems,
+			hasPictures: withPictures[t.TitleID],
+		}
+	}
+
+	s.searchIndex.mu.Lock()
+	s.searchIndex.names = names
+	s.searchIndex.entries = entries
+	s.searchIndex.mu.Unlock()
+}
+
+// searchPage selects a page of search results: Limit results from Offset, or
+// right after the result After points at.
+type searchPage struct {
+	Offset int
+	Limit  int
+	After  *pageCursor
+}
+
+// searchResults is a page of search results, with the cursor of the next page
+// unless it is the last one.
+type searchResults struct {
+	Titles []Title
+	Total  int64
+	Next   *pageCursor
+}
+
+// rankedTitle is a search result by its rank, lowest first.
+type rankedTitle struct {
+	TitleID string
+	Rank    float64
+}
+
+// after tells whether r comes after the result cursor points at.
+func (r rankedTitle) after(cursor pageCursor) bool {
+	return r.Rank > cursor.Rank || (r.Rank == cursor.Rank && r.TitleID > cursor.TitleID)
+}
+
+// search returns a page of titles matching q with the SEARCH_BACKEND.
+func (s *Server) search(ctx context.Context, q string, onlyWithPictures bool, system string, page searchPage) (searchResults, error) {
+	search := s.searchFTS
+	if s.config.SearchBackend == searchBackendFuzzy {
+		search = s.searchFuzzy
+	}
+	ctx, span := s.tracer.Start(ctx, "search", tracing.KindInternal,
+		tracing.String("xtitles.search.backend", s.config.SearchBackend),
+		tracing.String("xtitles.search.query", q))
+	defer span.End()
+	defer debugSearch(ctx, time.Now())
+	results, err := search(ctx, q, onlyWithPictures, system, page)
+	span.SetAttributes(tracing.Int("xtitles.search.total", results.Total))
+	span.SetError(err)
+	return results, err
+}
+
+// searchFTS returns a page of titles matching q using the full-text index.
+func (s *Server) searchFTS(ctx context.Context, q string, onlyWithPictures bool, system string, page searchPage) (searchResults, error) {
+	results := searchResults{Titles: []Title{}}
+
+	match := ftsQuery(q)
+	if match == "" {
+		return results, nil
+	}
+
+	err := store.ReadSnapshot(ctx, s.db, func(tx *gorm.DB) error {
+		matching := func() *gorm.DB {
+			query := tx.Model(&Title{}).
+				Joins("JOIN titles_fts ON titles_fts.title_id = titles.title_id").
+				Where("titles_fts MATCH ?", match)
+			if onlyWithPictures {
+				query = query.Where("EXISTS (SELECT 1 FROM pictures WHERE pictures.title_id = titles.title_id)")
+			}
+			return store.FilterBySystem(query, system)
+		}
+
+		if err := matching().Count(&results.Total).Error; err != nil {
+			return err
+		}
+
+		// Best matches first, ties in catalog order. Ranks are selected in a
+		// subquery, for cursors to be compared with them.
+		query := tx.Table("(?) AS ranked", matching().Select("titles.title_id, bm25(titles_fts) AS rank"))
+		if page.After != nil {
+			query = query.Where("rank > ? OR (rank = ? AND title_id > ?)", page.After.Rank, page.After.Rank, page.After.TitleID)
+		} else {
+			query = query.Offset(page.Offset)
+		}
+		var ranked []rankedTitle
+		if err := query.Order("rank, title_id").Limit(page.Limit + 1).Scan(&ranked).Error; err != nil {
+			return err
+		}
+		return loadRankedPage(tx, ranked, page.Limit, &results)
+	})
+	return results, err
+}
+
+// searchFuzzy returns a page of titles matching q using the in-memory fuzzy index.
+func (s *Server) searchFuzzy(ctx context.Context, q string, onlyWithPictures bool, system string, page searchPage) (searchResults, error) {
+	ranked := s.searchIndex.Search(q, onlyWithPictures, parseSystems(system))
+	results := searchResults{Titles: []Title{}, Total: int64(len(ranked))}
+
+	start := min(page.Offset, len(ranked))
+	if page.After != nil {
+		start = slices.IndexFunc(ranked, func(r rankedTitle) bool { return r.after(*page.After) })
+		if start < 0 {
+			start = len(ranked)
+		}
+	}
+	ranked = ranked[start:min(start+page.Limit+1, len(ranked))]
+	err := loadRankedPage(s.db.WithContext(ctx), ranked, page.Limit, &results)
+	return results, err
+}
+
+// loadRankedPage loads the titles of the first limit results of ranked into
+// results, in the same order. A result past them means there is a next page.
+func loadRankedPage(db *gorm.DB, ranked []rankedTitle, limit int, results *searchResults) error {
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+		last := ranked[len(ranked)-1]
+		results.Next = &pageCursor{TitleID: last.TitleID, Rank: last.Rank}
+	}
+	if len(ranked) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(ranked))
+	for i, r := range ranked {
+		ids[i] = r.TitleID
+	}
+	var titles []Title
+	if err := db.Preload("Pictures").Where("title_id IN ?", ids).Find(&titles).Error; err != nil {
+		return err
+	}
+
+	// Restore the ranking order
+	byID := make(map[string]Title, len(titles))
+	for _, t := range titles {
+		byID[t.TitleID] = t
+	}
+	for _, id := range ids {
+		if t, ok := byID[id]; ok {
+			results.Titles = append(results.Titles, t)
+		}
+	}
+	return nil
+}
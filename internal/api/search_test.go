@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFuzzySearch(t *testing.T) {
+	r := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.SearchBackend = searchBackendFuzzy
+	})
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+
+	steps := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		status   int
+		contains string
+	}{
+		{"subsequence", "GET", "/api/v1/search?q=hlo", "", http.StatusOK, `"total":2`},
+		{"best match first", "GET", "/api/v1/search?q=halo+3", "", http.StatusOK, `"items":[{"title_id":"4D5307E6"`},
+		{"by system", "GET", "/api/v1/search?q=halo&system=pc", "", http.StatusOK, `"total":0`},
+		{"by systems", "GET", "/api/v1/search?q=halo&system=pc,xbox360", "", http.StatusOK, `"total":2`},
+		{"with pictures", "GET", "/api/v1/search?q=halo&only_with_pictures=true", "", http.StatusOK, `"total":1`},
+		{"create", "POST", "/api/v1/admin/titles", `{"title_id":"4D5307F1","name":"Halo 3 Beta","systems":["XBOX360"]}`, http.StatusCreated, `"title_id":"4D5307F1"`},
+		{"index refreshed", "GET", "/api/v1/search?q=beta", "", http.StatusOK, `"total":1`},
+	}
+
+	for _, step := range steps {
+		w := doRequestBody(r, step.method, step.target, admin, step.body)
+		if w.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d; body: %s", step.name, w.Code, step.status, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), step.contains) {
+			t.Errorf("%s: body does not contain %q: %s", step.name, step.contains, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchGroup is the page of the results of a search on one system, for
+// frontends to show each system in a tab.
+type SearchGroup struct {
+	System string `json:"system"`
+	Name   string `json:"name"`
+	// Total counts the results on the system across all pages
+	Total int64 `json:"total"`
+	Pages int   `json:"pages"`
+	Items any   `json:"items"`
+}
+
+// GroupedSearchResponse is what /search returns with group_by=system.
+type GroupedSearchResponse struct {
+	Groups []SearchGroup `json:"groups"`
+	// Total counts the titles matching on any system once, although titles on
+	// several systems are in several groups
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	Page       int    `json:"page"`
+	Generation string `json:"generation,omitempty"`
+}
+
+// searchGroups searches q on each system of the catalog, or each of those
+// system lists, and returns the same page of the results of each. Systems
+// without results are left out, the others come by number of results. The
+// best result on any system comes with the groups, in a page of its own.
+func (s *Server) searchGroups(c *gin.Context, q string, onlyWithPictures bool, system string, page searchPage) ([]SearchGroup, searchResults, error) {
+	ctx := c.Request.Context()
+	best, err := s.search(ctx, q, onlyWithPictures, system, searchPage{Limit: 1})
+	if err != nil || best.Total == 0 {
+		return []SearchGroup{}, best, err
+	}
+
+	counts, err := systemCounts(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, best, err
+	}
+	wanted := parseSystems(system)
+	groups := []SearchGroup{}
+	for _, count := range counts {
+		if len(wanted) > 0 && !slices.Contains(wanted, count.System) {
+			continue
+		}
+		results, err := s.search(ctx, q, onlyWithPictures, count.System, page)
+		if err != nil {
+			return nil, best, err
+		}
+		if results.Total == 0 {
+			continue
+		}
+
+		group := SearchGroup{
+			System: count.System,
+			Name:   count.Name,
+			Total:  results.Total,
+			Pages:  int((results.Total + int64(page.Limit) - 1) / int64(page.Limit)),
+		}
+		if isSlim(c) {
+			items := make([]SlimTitle, len(results.Titles))
+			for i, title := range results.Titles {
+				items[i] = s.slimTitle(title)
+			}
+			group.Items = items
+		} else if group.Items, err = s.listItems(c, results.Titles, true); err != nil {
+			return nil, best, err
+		}
+		groups = append(groups, group)
+	}
+	slices.SortStableFunc(groups, func(a, b SearchGroup) int {
+		return cmp.Compare(b.Total, a.Total)
+	})
+	return groups, best, nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestSearchGroupBySystem(t *testing.T) {
+	titles := append(slices.Clone(testTitles), Title{TitleID: "4D530A5D", Name: "Halo: Spartan Assault", Systems: []string{"PC"}})
+	s := newTestServer(t, titles)
+
+	type group struct {
+		System string          `json:"system"`
+		Name   string          `json:"name"`
+		Total  int64           `json:"total"`
+		Pages  int             `json:"pages"`
+		Items  []TitleListItem `json:"items"`
+	}
+	search := func(target string) (groups []group, total int64) {
+		t.Helper()
+		w := doRequest(s, "GET", target, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d; body: %s", target, w.Code, w.Body.String())
+		}
+		var body struct {
+			Groups []group `json:"groups"`
+			Total  int64   `json:"total"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Groups, body.Total
+	}
+
+	groups, total := search("/api/v1/search?q=halo&group_by=system&limit=1")
+	if total != 3 || len(groups) != 2 {
+		t.Fatalf("groups = %+v, total = %d", groups, total)
+	}
+	if g := groups[0]; g.System != "XBOX360" || g.Name != "Xbox 360" || g.Total != 2 || g.Pages != 2 ||
+		len(g.Items) != 1 || g.Items[0].TitleID != "4D5307E6" || g.Items[0].PictureCount != 2 {
+		t.Errorf("first group = %+v", g)
+	}
+	if g := groups[1]; g.System != "PC" || g.Total != 1 || g.Pages != 1 || len(g.Items) != 1 || g.Items[0].TitleID != "4D530A5D" {
+		t.Errorf("second group = %+v", g)
+	}
+
+	if groups, total := search("/api/v1/search?q=halo&group_by=system&system=pc"); total != 1 || len(groups) != 1 || groups[0].System != "PC" {
+		t.Errorf("system=pc: groups = %+v, total = %d", groups, total)
+	}
+	if groups, total := search("/api/v1/search?q=zelda&group_by=system"); total != 0 || groups == nil || len(groups) != 0 {
+		t.Errorf("no results: groups = %+v, total = %d", groups, total)
+	}
+
+	for _, target := range []string{"/api/v1/search?q=halo&group_by=publisher", "/api/v1/search?q=halo&group_by=system&cursor=" + (pageCursor{TitleID: "4D5307E6"}).String()} {
+		if w := doRequest(s, "GET", target, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", target, w.Code)
+		}
+	}
+}
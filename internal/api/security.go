@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultContentSecurityPolicy = "default-src 'self'; img-src 'self' data:; " +
+	"style-src 'self' 'unsafe-inline'; script-src 'self' 'unsafe-inline'; " +
+	"connect-src 'self'; object-src 'none'; base-uri 'self'"
+
+// ginMode returns the Gin mode for value, falling back to release mode in
+// production and debug mode everywhere else.
+func ginMode(value, environment string) string {
+	switch value {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+		return value
+	case "":
+	default:
+		log.Printf("Warning: unknown GIN_MODE %q, using the default\n", value)
+	}
+	if environment == "production" {
+		return gin.ReleaseMode
+	}
+	return gin.DebugMode
+}
+
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// secureHeaders sets the headers every response gets: no MIME sniffing and no
+// framing outside of the configured ancestors.
+func (s *Server) secureHeaders(c *gin.Context) {
+	h := c.Writer.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	h.Set("Content-Security-Policy", "frame-ancestors "+s.config.FrameAncestors)
+	if s.config.FrameAncestors == "'none'" {
+		h.Set("X-Frame-Options", "DENY")
+	}
+	c.Next()
+}
+
+// frontendCSP replaces the default policy with the one for HTML pages.
+func (s *Server) frontendCSP(c *gin.Context) {
+	c.Header("Content-Security-Policy", s.frontendPolicy())
+	c.Next()
+}
+
+func (s *Server) frontendPolicy() string {
+	policy := "frame-ancestors " + s.config.FrameAncestors
+	if s.config.ContentSecurityPolicy != "" {
+		policy = s.config.ContentSecurityPolicy + "; " + policy
+	}
+	return policy
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	r := newTestServer(t, testTitles)
+
+	tests := []struct {
+		name   string
+		target string
+		csp    string
+	}{
+		{"frontend", "/", "script-src 'self' 'unsafe-inline'"},
+		{"api", "/api/v1/titles", "frame-ancestors 'none'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, "GET", tt.target, nil)
+			if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+			}
+			if got := w.Header().Get("Content-Security-Policy"); !strings.Contains(got, tt.csp) {
+				t.Errorf("Content-Security-Policy = %q, want it to contain %q", got, tt.csp)
+			}
+		})
+	}
+}
@@ -0,0 +1,1548 @@
+// Package api is the xtitles server: its configuration, the HTTP routes and
+// handlers over the catalog, the background jobs and the commands of the
+// binary. Catalog storage is in internal/store, the upstream fetcher in
+// internal/sync.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/script"
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/birabittoh/xtitles/internal/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+type Config struct {
+	BaseURL         string
+	Limit           int
+	Systems         []string
+	DataDir         string
+	PicturesFolder  string
+	PicturesSuffix  string
+	PictureStorage  string
+	S3Endpoint      string
+	S3Region        string
+	S3Bucket        string
+	S3Prefix        string
+	S3AccessKey     string
+	S3SecretKey     string
+	S3PathStyle     bool
+	S3PublicURL     string
+	S3PresignExpiry time.Duration
+	Address         string
+	Environment     string
+	DBFile          string
+	DBDriver        string
+	DBDSN           string
+	ViewRateLimit   int
+	ViewDedupWindow time.Duration
+	AdminToken      string
+	APIKeys         []string
+
+	// Views older than TrendingWindow don't count towards trending titles,
+	// the others count half as much every TrendingHalfLife. Rankings are
+	// kept for TrendingCacheTTL.
+	TrendingWindow   time.Duration
+	TrendingHalfLife time.Duration
+	TrendingCacheTTL time.Duration
+
+	APIKeyDailyRequests int
+	APIKeyDailyExportMB int
+
+	UsageRetentionDays int
+	UsageMinCount      int
+
+	GinMode               string
+	PublicURL             string
+	TrustedProxies        []string
+	DeprecatedRoutes      []string
+	SecureHeaders         bool
+	ContentSecurityPolicy string
+	FrameAncestors        string
+
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	CORSMaxAge         time.Duration
+
+	Compression        bool
+	CompressionMinSize int
+	CompressionTypes   []string
+
+	CacheLists    CachePolicy
+	CacheDetails  CachePolicy
+	CachePictures CachePolicy
+	CacheExport   CachePolicy
+
+	SlimMaxPictures int
+
+	PictureMaxUploadSize int64
+	UploadSigningKey     string
+	UploadURLTTL         time.Duration
+
+	MaxBodySize    int64
+	MaxURLLength   int
+	MaxQueryLength int
+	MaxBatchIDs    int
+
+	IdempotencyTTL time.Duration
+
+	SearchBackend string
+
+	CleanupConfirmTTL time.Duration
+
+	SQLConsole        bool
+	SQLConsoleMaxRows int
+	SQLConsoleTimeout time.Duration
+
+	ExportDir        string
+	ExportSigningKey string
+	ExportURLTTL     time.Duration
+	ExportMaxSize    int64
+
+	PictureFormats      []string
+	PictureEncoders     map[string]string
+	PictureCacheMaxSize int64
+
+	ThumbnailDir               string
+	ThumbnailMaxDimension      int
+	ThumbnailCacheMaxSize      int64
+	ThumbnailPrecompute        []string
+	ThumbnailPrecomputeWorkers int
+	ImageWorkers               int
+	ImageQueue                 int
+	PictureScanWorkers         int
+
+	ReportSchedulerInterval time.Duration
+	SMTPAddr                string
+	SMTPFrom                string
+	SMTPUsername            string
+	SMTPPassword            string
+
+	JanitorInterval  time.Duration
+	JanitorTmpMaxAge time.Duration
+	// QuarantineMaxSize caps the corrupt databases kept aside after a restore
+	QuarantineMaxSize int64
+
+	DBIntegrityCheck string
+	BackupDir        string
+	DBBackupKeep     int
+
+	MaxTitleNameLength int
+
+	UpstreamTimeout    time.Duration
+	UpstreamRetries    int
+	UpstreamBackoff    time.Duration
+	UpstreamMaxBackoff time.Duration
+	UpstreamWorkers    int
+	UpstreamInterval   time.Duration
+
+	ChaosErrorRate     float64
+	ChaosMalformedRate float64
+	ChaosLatency       time.Duration
+
+	SyncOnStartup bool
+	SyncSchedule  string
+	ScriptsDir    string
+
+	Metrics      bool
+	MetricsToken string
+	Pprof        bool
+
+	OTLPEndpoint     string
+	OTLPHeaders      string
+	OTelServiceName  string
+	TraceSampleRatio float64
+
+	TheGamesDBFacade bool
+
+	RetroAchievementsURL      string
+	RetroAchievementsAPIKey   string
+	RetroAchievementsConsoles map[string]int
+
+	PriceChartingURL   string
+	PriceChartingToken string
+	PriceChartingTTL   time.Duration
+	PriceChartingDelay time.Duration
+
+	ReviewScoreProvider string
+	ReviewScoreTTL      time.Duration
+	ReviewScoreDelay    time.Duration
+	OpenCriticURL       string
+	OpenCriticAPIKey    string
+
+	WikidataSPARQLURL string
+	WikidataPlatforms map[string]string
+
+	ArchiveLinks bool
+	ArchiveURL   string
+	ArchiveDelay time.Duration
+
+	KhinsiderEnricher bool
+	KhinsiderURL      string
+	KhinsiderDelay    time.Duration
+
+	AssetsDir string
+	Branding  Branding
+	AboutFile string
+
+	AbuseAction          string
+	AbuseBlockEmptyUA    bool
+	AbuseMaxPage         int
+	AbuseDuplicateLimit  int
+	AbuseDuplicateWindow time.Duration
+	AbuseTarpitDelay     time.Duration
+
+	ListenReusePort bool
+	// ListenSocketMode is the permissions of the unix sockets of ADDRESS
+	ListenSocketMode os.FileMode
+	DrainTimeout     time.Duration
+	ShutdownTimeout  time.Duration
+}
+
+// The catalog models live in the store package.
+type (
+	Title          = store.Title
+	Picture        = store.Picture
+	SystemList     = store.SystemList
+	ExternalID     = store.ExternalID
+	AchievementSet = store.AchievementSet
+	MarketValue    = store.MarketValue
+	ReviewScore    = store.ReviewScore
+	ArchiveItem    = store.ArchiveItem
+	MediaLink      = store.MediaLink
+)
+
+type PaginatedResponse struct {
+	Items  any   `json:"items"`
+	Total  int64 `json:"total"`
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+	Page   int   `json:"page"`
+	Pages  int   `json:"pages"`
+	// Catalog generation of listings of the catalog, which changes whenever
+	// titles or pictures do
+	Generation string `json:"generation,omitempty"`
+	// Cursor of the next page, where there is one and the listing supports
+	// them
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type ExportedTitle struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Pictures []string `json:"pictures"`
+}
+
+// Server is one catalog instance with its database, configuration and
+// in-memory state. Several servers can coexist in the same process.
+type Server struct {
+	db     *gorm.DB
+	titles store.TitleStore
+	config Config
+	router *gin.Engine
+
+	// ctx is cancelled on shutdown to stop syncs, jobs and background loops
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
+	httpServer *http.Server
+
+	startup          startupState
+	jobs             *jobRegistry
+	metrics          *serverMetrics
+	syncMu           sync.Mutex
+	syncSchedule     *cronSchedule
+	titleEditMu      sync.Mutex
+	searchIndex      fuzzyIndex
+	catalog          catalogVersion
+	viewLimiter      *rateLimiter
+	viewDedup        *expiringSet
+	trending         trendingCache
+	usage            *usageCounter
+	quotas           *quotaCounter
+	tracer           *tracing.Tracer
+	duplicateLimiter *rateLimiter
+	recentBlocks     blockLog
+	exportSigningKey []byte
+	uploadSigningKey []byte
+	cleanupKey       []byte
+	reviewScores     reviewScoreProvider
+	plugins          []Plugin
+	transforms       []*script.Script
+	startedPlugins   []Plugin
+
+	idempotencyMu       sync.Mutex
+	idempotencyInFlight map[string]bool
+
+	pictures       pictureStorage
+	pictureFormats []pictureFormat
+	images         *imagePool
+	thumbnailBoxes []thumbnailBox
+	conversions    singleflight.Group
+
+	apiKeys        []configuredAPIKey
+	apiKeyMisses   *rateLimiter
+	trustedProxies []netip.Prefix
+	// syncRoutes are the global ones and those of the plugin enrichers
+	syncRoutes map[string]bool
+
+	deprecations map[string]routeDeprecation
+
+	about About
+
+	managedDirs   []managedDir
+	diskUsageMu   sync.RWMutex
+	lastDiskUsage map[string]DiskUsage
+}
+
+func loadConfig() Config {
+	// Load .env file if it exists
+	godotenv.Load()
+
+	dataDir := getEnv("DATA_DIR", "data")
+	environment := getEnv("ENVIRONMENT", "development")
+	return Config{
+		BaseURL:         getEnv("BASE_URL", "https://dbox.tools/api/title_ids/"),
+		Limit:           getEnvInt("LIMIT", 100),
+		Systems:         parseSystems(getEnv("SYSTEMS", getEnv("SYSTEM", "XBOX360"))),
+		DataDir:         dataDir,
+		PicturesFolder:  getEnv("PICTURES_FOLDER", "titles"),
+		PicturesSuffix:  getEnv("PICTURES_SUFFIX", ".png"),
+		PictureStorage:  getEnv("PICTURE_STORAGE", pictureStorageLocal),
+		S3Endpoint:      getEnv("S3_ENDPOINT", ""),
+		S3Region:        getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:        getEnv("S3_BUCKET", ""),
+		S3Prefix:        getEnv("S3_PREFIX", ""),
+		S3AccessKey:     getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:     getEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:     getEnvBool("S3_PATH_STYLE", true),
+		S3PublicURL:     getEnv("S3_PUBLIC_URL", ""),
+		S3PresignExpiry: getEnvDuration("S3_PRESIGN_EXPIRY", time.Hour),
+		Address:         getEnv("ADDRESS", ":8081"),
+		Environment:     environment,
+		DBFile:          getEnv("DB_FILE", "titles.db"),
+		DBDriver:        strings.ToLower(getEnv("DB_DRIVER", dbDriverSQLite)),
+		DBDSN:           getEnv("DB_DSN", ""),
+		ViewRateLimit:   getEnvInt("VIEW_RATE_LIMIT", 10),
+		ViewDedupWindow: getEnvDuration("VIEW_DEDUP_WINDOW", time.Hour),
+		AdminToken:      getEnv("ADMIN_TOKEN", ""),
+		APIKeys:         parseList(getEnv("API_KEYS", "")),
+
+		TrendingWindow:   getEnvDuration("TRENDING_WINDOW", 7*24*time.Hour),
+		TrendingHalfLife: getEnvDuration("TRENDING_HALF_LIFE", 48*time.Hour),
+		TrendingCacheTTL: getEnvDuration("TRENDING_CACHE_TTL", time.Minute),
+
+		APIKeyDailyRequests: getEnvInt("API_KEY_DAILY_REQUESTS", 0),
+		APIKeyDailyExportMB: getEnvInt("API_KEY_DAILY_EXPORT_MB", 0),
+
+		UsageRetentionDays: max(getEnvInt("USAGE_RETENTION_DAYS", 90), 1),
+		UsageMinCount:      getEnvInt("USAGE_MIN_COUNT", 5),
+
+		GinMode:               ginMode(os.Getenv("GIN_MODE"), environment),
+		PublicURL:             strings.TrimSuffix(getEnv("PUBLIC_URL", ""), "/"),
+		TrustedProxies:        parseList(getEnv("TRUSTED_PROXIES", "")),
+		DeprecatedRoutes:      parseList(getEnv("DEPRECATED_ROUTES", "")),
+		SecureHeaders:         getEnvBool("SECURE_HEADERS", true),
+		ContentSecurityPolicy: getEnv("CONTENT_SECURITY_POLICY", defaultContentSecurityPolicy),
+		FrameAncestors:        getEnv("FRAME_ANCESTORS", "'none'"),
+
+		CORSAllowedOrigins: parseList(getEnv("CORS_ALLOWED_ORIGINS", "")),
+		CORSAllowedMethods: parseList(getEnv("CORS_ALLOWED_METHODS", "GET, HEAD, POST")),
+		CORSAllowedHeaders: parseList(getEnv("CORS_ALLOWED_HEADERS", defaultCORSAllowedHeaders)),
+		CORSMaxAge:         getEnvDuration("CORS_MAX_AGE", 10*time.Minute),
+
+		Compression:        getEnvBool("COMPRESSION", true),
+		CompressionMinSize: getEnvInt("COMPRESSION_MIN_SIZE", 1024),
+		CompressionTypes:   parseList(getEnv("COMPRESSION_TYPES", defaultCompressionTypes)),
+
+		CacheLists:    loadCachePolicy("lists", CachePolicy{MaxAge: 60}),
+		CacheDetails:  loadCachePolicy("details", CachePolicy{MaxAge: 300}),
+		CachePictures: loadCachePolicy("pictures", CachePolicy{MaxAge: 31536000, Immutable: true}),
+		CacheExport:   loadCachePolicy("export", CachePolicy{MaxAge: 3600}),
+
+		SlimMaxPictures: getEnvInt("SLIM_MAX_PICTURES", 8),
+
+		PictureMaxUploadSize: int64(getEnvInt("PICTURE_MAX_UPLOAD_SIZE_MB", 5)) << 20,
+		UploadSigningKey:     getEnv("UPLOAD_SIGNING_KEY", ""),
+		UploadURLTTL:         getEnvDuration("UPLOAD_URL_TTL", 15*time.Minute),
+
+		MaxBodySize:    int64(getEnvInt("MAX_BODY_SIZE_KB", 1024)) << 10,
+		MaxURLLength:   getEnvInt("MAX_URL_LENGTH", 2048),
+		MaxQueryLength: getEnvInt("MAX_QUERY_LENGTH", 200),
+		MaxBatchIDs:    getEnvInt("MAX_BATCH_IDS", 100),
+
+		IdempotencyTTL: getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+
+		SearchBackend: getEnv("SEARCH_BACKEND", searchBackendFTS),
+
+		CleanupConfirmTTL: getEnvDuration("CLEANUP_CONFIRM_TTL", 10*time.Minute),
+
+		SQLConsole:        getEnvBool("SQL_CONSOLE", false),
+		SQLConsoleMaxRows: getEnvInt("SQL_CONSOLE_MAX_ROWS", 1000),
+		SQLConsoleTimeout: getEnvDuration("SQL_CONSOLE_TIMEOUT", 5*time.Second),
+
+		ExportDir:        getEnv("EXPORT_DIR", filepath.Join(dataDir, "exports")),
+		ExportSigningKey: getEnv("EXPORT_SIGNING_KEY", ""),
+		ExportURLTTL:     getEnvDuration("EXPORT_URL_TTL", 24*time.Hour),
+		ExportMaxSize:    int64(getEnvInt("EXPORT_MAX_SIZE_MB", 2048)) << 20,
+
+		PictureFormats: parseList(getEnv("PICTURE_FORMATS", "avif,webp")),
+		PictureEncoders: map[string]string{
+			"avif": getEnv("AVIF_COMMAND", "avifenc -q 60 {input} {output}"),
+			"webp": getEnv("WEBP_COMMAND", "cwebp -quiet -q 80 {input} -o {output}"),
+		},
+		PictureCacheMaxSize: int64(getEnvInt("PICTURE_CACHE_MAX_SIZE_MB", 1024)) << 20,
+
+		ThumbnailDir:               getEnv("THUMBNAIL_DIR", filepath.Join(dataDir, "thumbnails")),
+		ThumbnailMaxDimension:      getEnvInt("THUMBNAIL_MAX_DIMENSION", 1024),
+		ThumbnailCacheMaxSize:      int64(getEnvInt("THUMBNAIL_CACHE_MAX_SIZE_MB", 512)) << 20,
+		ThumbnailPrecompute:        parseList(getEnv("THUMBNAIL_PRECOMPUTE", "")),
+		ThumbnailPrecomputeWorkers: getEnvInt("THUMBNAIL_PRECOMPUTE_WORKERS", 2),
+		ImageWorkers:               getEnvInt("IMAGE_WORKERS", runtime.NumCPU()),
+		ImageQueue:                 getEnvInt("IMAGE_QUEUE", 16),
+		PictureScanWorkers:         getEnvInt("PICTURE_SCAN_WORKERS", runtime.NumCPU()),
+
+		ReportSchedulerInterval: getEnvDuration("REPORT_SCHEDULER_INTERVAL", time.Minute),
+		SMTPAddr:                getEnv("SMTP_ADDR", ""),
+		SMTPFrom:                getEnv("SMTP_FROM", "xtitles@localhost"),
+		SMTPUsername:            getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:            getEnv("SMTP_PASSWORD", ""),
+
+		JanitorInterval:  getEnvDuration("JANITOR_INTERVAL", 10*time.Minute),
+		JanitorTmpMaxAge: getEnvDuration("JANITOR_TMP_MAX_AGE", 6*time.Hour),
+
+		QuarantineMaxSize: int64(getEnvInt("QUARANTINE_MAX_SIZE_MB", 1024)) << 20,
+
+		DBIntegrityCheck: getEnv("DB_INTEGRITY_CHECK", integrityCheckQuick),
+		BackupDir:        getEnv("BACKUP_DIR", filepath.Join(dataDir, "backups")),
+		DBBackupKeep:     getEnvInt("DB_BACKUP_KEEP", 3),
+
+		MaxTitleNameLength: getEnvInt("MAX_TITLE_NAME_LENGTH", 256),
+
+		UpstreamTimeout:    getEnvDuration("UPSTREAM_TIMEOUT", 30*time.Second),
+		UpstreamRetries:    getEnvInt("UPSTREAM_RETRIES", 4),
+		UpstreamBackoff:    getEnvDuration("UPSTREAM_BACKOFF", time.Second),
+		UpstreamMaxBackoff: getEnvDuration("UPSTREAM_MAX_BACKOFF", 30*time.Second),
+		UpstreamWorkers:    getEnvInt("UPSTREAM_WORKERS", 4),
+		UpstreamInterval:   getEnvDuration("UPSTREAM_INTERVAL", 100*time.Millisecond),
+
+		ChaosErrorRate:     getEnvFloat("CHAOS_ERROR_RATE", 0),
+		ChaosMalformedRate: getEnvFloat("CHAOS_MALFORMED_RATE", 0),
+		ChaosLatency:       getEnvDuration("CHAOS_LATENCY", 0),
+
+		SyncOnStartup: getEnvBool("SYNC_ON_STARTUP", true),
+		SyncSchedule:  getEnv("SYNC_SCHEDULE", ""),
+		ScriptsDir:    getEnv("SCRIPTS_DIR", filepath.Join(dataDir, "scripts")),
+
+		Metrics:      getEnvBool("METRICS", true),
+		MetricsToken: getEnv("METRICS_TOKEN", ""),
+		Pprof:        getEnvBool("PPROF", false),
+
+		OTLPEndpoint:     getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		OTLPHeaders:      getEnv("OTEL_EXPORTER_OTLP_HEADERS", ""),
+		OTelServiceName:  getEnv("OTEL_SERVICE_NAME", "xtitles"),
+		TraceSampleRatio: getEnvFloat("OTEL_TRACES_SAMPLER_ARG", 1),
+
+		TheGamesDBFacade: getEnvBool("THEGAMESDB_FACADE", false),
+
+		RetroAchievementsURL:      getEnv("RETROACHIEVEMENTS_URL", "https://retroachievements.org/API/"),
+		RetroAchievementsAPIKey:   getEnv("RETROACHIEVEMENTS_API_KEY", ""),
+		RetroAchievementsConsoles: parseConsoleIDs(getEnv("RETROACHIEVEMENTS_CONSOLES", "")),
+
+		PriceChartingURL:   getEnv("PRICECHARTING_URL", "https://www.pricecharting.com/api/"),
+		PriceChartingToken: getEnv("PRICECHARTING_TOKEN", ""),
+		PriceChartingTTL:   getEnvDuration("PRICECHARTING_TTL", 7*24*time.Hour),
+		PriceChartingDelay: getEnvDuration("PRICECHARTING_DELAY", time.Second),
+
+		ReviewScoreProvider: strings.ToLower(getEnv("REVIEW_SCORE_PROVIDER", "")),
+		ReviewScoreTTL:      getEnvDuration("REVIEW_SCORE_TTL", 30*24*time.Hour),
+		ReviewScoreDelay:    getEnvDuration("REVIEW_SCORE_DELAY", time.Second),
+		OpenCriticURL:       getEnv("OPENCRITIC_URL", "https://api.opencritic.com/api/"),
+		OpenCriticAPIKey:    getEnv("OPENCRITIC_API_KEY", ""),
+
+		WikidataSPARQLURL: getEnv("WIKIDATA_SPARQL_URL", "https://query.wikidata.org/sparql"),
+		WikidataPlatforms: parsePlatformQIDs(getEnv("WIKIDATA_PLATFORMS", "XBOX=Q132020,XBOX360=Q48263,XBOXONE=Q13361286,PC=Q1406")),
+
+		ArchiveLinks: getEnvBool("ARCHIVE_LINKS", false),
+		ArchiveURL:   getEnv("ARCHIVE_URL", "https://archive.org/"),
+		ArchiveDelay: getEnvDuration("ARCHIVE_DELAY", time.Second),
+
+		KhinsiderEnricher: getEnvBool("KHINSIDER_ENRICHER", false),
+		KhinsiderURL:      getEnv("KHINSIDER_URL", "https://downloads.khinsider.com/"),
+		KhinsiderDelay:    getEnvDuration("KHINSIDER_DELAY", 2*time.Second),
+
+		AssetsDir: getEnv("ASSETS_DIR", ""),
+		Branding:  loadBranding(),
+		AboutFile: getEnv("ABOUT_FILE", filepath.Join(dataDir, "about.json")),
+
+		AbuseAction:          getEnv("ABUSE_ACTION", abuseActionOff),
+		AbuseBlockEmptyUA:    getEnvBool("ABUSE_BLOCK_EMPTY_UA", true),
+		AbuseMaxPage:         getEnvInt("ABUSE_MAX_PAGE", 500),
+		AbuseDuplicateLimit:  getEnvInt("ABUSE_DUPLICATE_LIMIT", 20),
+		AbuseDuplicateWindow: getEnvDuration("ABUSE_DUPLICATE_WINDOW", 10*time.Second),
+		AbuseTarpitDelay:     getEnvDuration("ABUSE_TARPIT_DELAY", 5*time.Second),
+
+		ListenReusePort:  getEnvBool("LISTEN_REUSE_PORT", false),
+		ListenSocketMode: getEnvFileMode("LISTEN_SOCKET_MODE", 0660),
+		DrainTimeout:     getEnvDuration("DRAIN_TIMEOUT", 0),
+		ShutdownTimeout:  getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if intVal, err := strconv.Atoi(value); err == nil {
+			return intVal
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// getEnvFileMode reads permissions written in octal, like 0660.
+func getEnvFileMode(key string, defaultValue os.FileMode) os.FileMode {
+	if value := os.Getenv(key); value != "" {
+		if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+			return os.FileMode(mode).Perm()
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+func (s *Server) initDB() error {
+	// Ensure data directory exists
+	if err := os.MkdirAll(s.config.DataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	var err error
+	s.db, err = s.openDB()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	if !isSQLite(s.db) && s.config.SearchBackend == searchBackendFTS {
+		log.Printf("Full-text search needs SQLite, using the %s search backend\n", searchBackendFuzzy)
+		s.config.SearchBackend = searchBackendFuzzy
+	}
+
+	if s.config.DBIntegrityCheck != integrityCheckOff && isSQLite(s.db) {
+		err := s.checkIntegrity(s.db)
+		var corrupt *corruptionError
+		if err != nil && !errors.As(err, &corrupt) {
+			// The live database may well be fine, restoring would lose
+			// every write since the backup
+			return fmt.Errorf("database integrity check failed: %w", err)
+		}
+		if corrupt != nil {
+			log.Printf("Database integrity check failed: %v\n", err)
+			closeDB(s.db)
+
+			if err := s.restoreLatestBackup(s.dbDSN()); err != nil {
+				return fmt.Errorf("database is corrupt and could not be recovered: %w", err)
+			}
+			if s.db, err = s.openDB(); err != nil {
+				return fmt.Errorf("failed to connect to database: %w", err)
+			}
+			if err := s.checkIntegrity(s.db); err != nil {
+				return fmt.Errorf("restored database is corrupt too: %w", err)
+			}
+		}
+	}
+
+	if err := s.registerQueryMetrics(s.db); err != nil {
+		return fmt.Errorf("failed to register query metrics: %w", err)
+	}
+	if err := s.registerQueryDebug(s.db); err != nil {
+		return fmt.Errorf("failed to register query debugging: %w", err)
+	}
+	if s.tracer != nil {
+		if err := s.registerQueryTracing(s.db); err != nil {
+			return fmt.Errorf("failed to register query tracing: %w", err)
+		}
+	}
+
+	// Auto migrate the schema
+	if err := s.db.AutoMigrate(dbModels...); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	titles := store.NewGormStore(s.db)
+	titles.MarketValues = s.config.PriceChartingToken != ""
+	titles.ReviewScores = s.reviewScores != nil
+	s.titles = titles
+
+	if err := s.initSearchIndex(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) loadTitlesToDB() error {
+	// Check if we already have data
+	count, err := s.titles.Count(s.ctx)
+	if err != nil {
+		return fmt.Errorf("counting titles failed: %w", err)
+	}
+	if count > 0 && !s.config.SyncOnStartup {
+		log.Printf("Database already contains %d titles\n", count)
+		return nil
+	}
+
+	if err := s.startupSync(); err != nil {
+		if count > 0 {
+			// Stale data is better than no data
+			log.Printf("Warning: sync failed, serving existing %d titles: %v\n", count, err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// startupSync runs the sync of Start as a job, for readyz and the page served
+// meanwhile to tell how far it got.
+func (s *Server) startupSync() error {
+	if !s.syncMu.TryLock() {
+		return errSyncInProgress
+	}
+	job := s.jobs.Start(syncJobKind, func(_ context.Context, job *Job) error {
+		defer s.syncMu.Unlock()
+		_, err := s.runSync(&syncReporter{job: job})
+		return err
+	})
+	s.startup.sync.Store(job)
+	return job.Wait()
+}
+
+func (s *Server) setupRoutes() (*gin.Engine, error) {
+	gin.SetMode(s.config.GinMode)
+
+	r := gin.Default()
+
+	// Only trust forwarding headers from the configured proxies
+	if err := r.SetTrustedProxies(s.config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	var err error
+	if s.trustedProxies, err = parseTrustedProxies(s.config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	// Groups only inherit middleware registered before they are created
+	if s.tracer != nil {
+		r.Use(s.traceRequests)
+	}
+	if s.config.Metrics {
+		r.Use(s.metricsMiddleware)
+	}
+	if s.config.SecureHeaders {
+		r.Use(s.secureHeaders)
+	}
+	r.Use(s.requestLimits)
+	if len(s.config.CORSAllowedOrigins) > 0 {
+		// Before requireReady, so that preflights don't fail while starting
+		r.Use(s.cors)
+	}
+	if s.config.Compression {
+		r.Use(s.compress)
+	}
+
+	// Probes must keep working while the server starts
+	r.GET("/healthz", s.healthz)
+	r.GET("/readyz", s.readyz)
+	if s.config.Metrics {
+		r.GET("/metrics", s.getMetrics)
+	}
+	r.Use(s.requireReady)
+	if len(s.deprecations) > 0 {
+		r.Use(s.warnDeprecated)
+	}
+
+	frontend := r.Group("/")
+	if s.config.SecureHeaders {
+		frontend.Use(s.frontendCSP)
+	}
+
+	assets := s.assets()
+
+	// Serve static files (frontend)
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		return nil, fmt.Errorf("loading static files: %w", err)
+	}
+	r.StaticFS("/static", http.FS(static))
+	tmpl, err := template.New("").Funcs(template.FuncMap{
+		"lower":             strings.ToLower,
+		"systemName":        systemName,
+		"soundtracks":       soundtrackLinks,
+		"mediaProviderName": mediaProviderName,
+		"brand":             func() Branding { return s.config.Branding },
+	}).ParseFS(assets, "templates/*")
+	if err != nil {
+		return nil, fmt.Errorf("loading templates: %w", err)
+	}
+	r.SetHTMLTemplate(tmpl)
+
+	// Serve OpenAPI spec from static file
+	r.GET("/api/openapi.json", func(c *gin.Context) {
+		c.FileFromFS("docs/openapi.json", http.FS(assets))
+	})
+
+	// Frontend route
+	frontend.GET("/", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "index.html", gin.H{
+			"title": s.config.Branding.Name,
+		})
+	})
+
+	frontend.GET("/compare", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "compare.html", gin.H{
+			"title": "Compare Titles - " + s.config.Branding.Name,
+			"ids":   c.Query("ids"),
+		})
+	})
+
+	frontend.GET("/titles/:id", s.titlePage)
+	frontend.GET("/feed.xml", s.getFeed)
+	frontend.GET("/usage", s.usagePage)
+
+	if s.config.TheGamesDBFacade {
+		s.registerTGDBRoutes(r.Group("/thegamesdb", s.abuseProtection))
+	}
+	if s.config.Pprof {
+		s.registerPprof(r)
+	}
+
+	api := r.Group("/api/v1", s.shapeJSON, s.debugRequests, s.enforceQuotas, s.abuseProtection, s.countUsage, s.idempotency)
+	{
+		api.GET("/search", s.searchTitles)
+		api.GET("/compare", s.compareTitles)
+		api.GET("/systems", s.getSystems)
+		api.GET("/about", s.getAbout)
+		api.GET("/stats", s.getStats)
+		api.GET("/titles", s.getTitles)
+		api.GET("/titles/trending", s.getTrendingTitles)
+		api.GET("/titles/letters", s.getTitleLetters)
+		api.GET("/titles/:id", s.getTitleByID)
+		api.GET("/titles/by-pfn/:pfn", s.getTitleByPFN)
+		api.GET("/titles/:id/archive", s.getTitleArchiveItems)
+		api.GET("/titles/:id/manifest.json", s.getTitleManifest)
+		api.GET("/manifest", s.getManifest)
+		api.GET("/manifest/summary", s.getManifestSummary)
+		api.GET("/export", s.exportDataset)
+		api.GET("/titles/:id/:picture", s.getTitlePicture)
+		api.GET("/external/:source/:id", s.getTitleByExternalID)
+		api.POST("/titles/:id/view", s.recordTitleView)
+		api.GET("/usage", s.getUsageStats)
+		api.GET("/me/quota", s.getQuota)
+		api.GET("/exports/:id/download", s.downloadExport)
+		api.POST("/uploads/titles/:id/pictures", s.verifyUploadURL, s.uploadPicture)
+
+		admin := api.Group("/admin", s.requireAdmin)
+		{
+			admin.GET("/blocks", s.getRecentBlocks)
+			admin.GET("/keys", s.getAPIKeys)
+			admin.POST("/keys", s.createAPIKey)
+			admin.DELETE("/keys/:id", s.deleteAPIKey)
+			admin.POST("/titles", s.createTitle)
+			admin.PUT("/titles/:id", s.updateTitle)
+			admin.DELETE("/titles/:id", s.deleteTitle)
+			admin.POST("/titles/:id/pictures", s.uploadPicture)
+			admin.POST("/titles/:id/pictures/upload-url", s.createUploadURL)
+			admin.PUT("/titles/:id/external/:source", s.putExternalID)
+			admin.DELETE("/titles/:id/external/:source", s.deleteExternalID)
+			admin.POST("/titles/:id/media", s.createMediaLink)
+			admin.DELETE("/titles/:id/media/:media", s.deleteMediaLink)
+			admin.POST("/retroachievements", s.createRetroAchievementsJob)
+			admin.GET("/retroachievements/:id", s.getRetroAchievementsJob)
+			admin.POST("/pricecharting", s.createMarketValuesJob)
+			admin.GET("/pricecharting/:id", s.getMarketValuesJob)
+			admin.POST("/reviewscores", s.createReviewScoresJob)
+			admin.GET("/reviewscores/:id", s.getReviewScoresJob)
+			admin.POST("/wikidata", s.createWikidataJob)
+			admin.GET("/wikidata/:id", s.getWikidataJob)
+			admin.POST("/archive", s.createArchiveJob)
+			admin.GET("/archive/:id", s.getArchiveJob)
+			admin.POST("/soundtracks", s.createSoundtracksJob)
+			admin.GET("/soundtracks/:id", s.getSoundtracksJob)
+			admin.POST("/exports", s.createExportJob)
+			admin.GET("/exports/:id", s.getExportJob)
+			admin.GET("/disk", s.getDiskUsage)
+			admin.GET("/ingest/rejects", s.getIngestRejects)
+			admin.GET("/sync", s.getSyncStatus)
+			admin.POST("/sync", s.triggerSync)
+			admin.GET("/sync/:id", s.getSyncJob)
+			admin.GET("/sync/:id/events", s.streamSyncJob)
+			admin.POST("/pictures/rescan", s.triggerPictureRescan)
+			admin.GET("/pictures/rescan/:id", s.getPictureRescanJob)
+			admin.GET("/pictures/duplicates", s.getDuplicatePictures)
+			admin.POST("/pictures/dedupe", s.triggerPictureDedupe)
+			admin.GET("/pictures/dedupe/:id", s.getPictureDedupeJob)
+			admin.POST("/cleanup/pictures", s.cleanupPictures)
+			admin.POST("/cleanup/titles", s.cleanupTitles)
+			admin.GET("/audit", s.getAuditLog)
+			admin.POST("/query", s.runQuery)
+			admin.GET("/reports", s.getReports)
+			admin.POST("/reports", s.createReport)
+			admin.GET("/reports/:id", s.getReport)
+			admin.DELETE("/reports/:id", s.deleteReport)
+			admin.GET("/reports/:id/runs", s.getReportRuns)
+			admin.POST("/reports/:id/runs", s.createReportRun)
+			admin.GET("/reports/:id/runs/:run", s.getReportRun)
+			admin.GET("/reports/:id/runs/:run/download", s.downloadReportRun)
+			s.registerPluginRoutes(api, admin)
+		}
+	}
+
+	if err := s.checkDeprecations(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// titleSorts are the sorts of title listings, and whether they list the
+// largest values first unless reversed.
+var titleSorts = map[string]bool{
+	"title_id":   false,
+	"name":       false,
+	"score":      true,
+	"first_seen": true,
+	"updated_at": true,
+}
+
+func (s *Server) getTitles(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	onlyWithPictures := c.DefaultQuery("only_with_pictures", "false") == "true"
+	reverse := c.DefaultQuery("reverse", "false") == "true"
+	system := c.Query("system")
+
+	sortBy := c.DefaultQuery("sort", "title_id")
+	descending, ok := titleSorts[sortBy]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort, expected title_id, name, score, first_seen or updated_at"})
+		return
+	}
+	// order takes over from reverse, which flips the natural order of a sort
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		reverse = descending
+	case "desc":
+		reverse = !descending
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order, expected asc or desc"})
+		return
+	}
+	minScore := 0
+	if value := c.Query("min_score"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_score, expected 0 to 100"})
+			return
+		}
+		minScore = n
+	}
+	var addedSince time.Time
+	if value := c.Query("added_since"); value != "" {
+		t, err := parseDateOrTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid added_since, expected a date like 2026-01-31 or an RFC 3339 time"})
+			return
+		}
+		addedSince = t
+	}
+	letter, ok := parseLetter(c.Query("letter"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid letter, expected A to Z, 0-9 or other"})
+		return
+	}
+	pfn := strings.TrimSpace(c.Query("pfn"))
+	var cursor *pageCursor
+	if value := c.Query("cursor"); value != "" {
+		var err error
+		if cursor, err = parsePageCursor(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		// Other orders aren't keyed on the title id alone
+		if sortBy != "title_id" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursors only work with sort=title_id"})
+			return
+		}
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	offset := (page - 1) * limit
+
+	etag, modified := s.catalogValidators()
+	if notModified(c, s.config.CacheLists, etag, modified) {
+		return
+	}
+
+	query := store.TitleQuery{
+		System:           system,
+		OnlyWithPictures: onlyWithPictures,
+		MinScore:         minScore,
+		AddedSince:       addedSince,
+		SortByScore:      sortBy == "score",
+		SortByFirstSeen:  sortBy == "first_seen",
+		SortByName:       sortBy == "name",
+		SortByUpdated:    sortBy == "updated_at",
+		PFN:              pfn,
+		Reverse:          reverse,
+		Letter:           letter,
+		Offset:           offset,
+		// One more tells whether there is a next page
+		Limit: limit + 1,
+		// Summaries are counted apart, slim listings name the pictures
+		SkipPictures: summaryOnly(c) && !isSlim(c),
+	}
+	if cursor != nil {
+		query.After = cursor.TitleID
+		// Pages are relative to the cursor
+		query.Offset, page, offset = 0, 0, 0
+	}
+	debugFilters(c.Request.Context(), query)
+	titles, total, err := s.titles.Titles(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	more := len(titles) > limit
+	titles = titles[:min(len(titles), limit)]
+	var next string
+	if sortBy == "title_id" {
+		next = nextCursor(titles, more)
+	}
+
+	pages := int((total + int64(limit) - 1) / int64(limit))
+
+	setCacheHeaders(c, s.config.CacheLists)
+	if isSlim(c) {
+		response := s.slimPaginatedResponse(titles, total, page, pages, catalogGeneration(etag))
+		response.NextCursor = next
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	items, err := s.listItems(c, titles, !query.SkipPictures)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Items:      items,
+		Total:      total,
+		Limit:      limit,
+		Offset:     offset,
+		Page:       page,
+		Pages:      pages,
+		Generation: catalogGeneration(etag),
+		NextCursor: next,
+	})
+}
+
+// parseDateOrTime parses a date, taken as midnight UTC, or an RFC 3339 time.
+func parseDateOrTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (s *Server) searchTitles(c *gin.Context) {
+	q := c.Query("q")
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'q' is required"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	onlyWithPictures := c.DefaultQuery("only_with_pictures", "false") == "true"
+	system := c.Query("system")
+
+	var cursor *pageCursor
+	if value := c.Query("cursor"); value != "" {
+		var err error
+		if cursor, err = parsePageCursor(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+	groupBy := c.Query("group_by")
+	switch groupBy {
+	case "":
+	case "system":
+		// Each group has its own pages
+		if cursor != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursors don't work with group_by"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group_by, expected system"})
+		return
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	offset := (page - 1) * limit
+
+	etag, modified := s.catalogValidators()
+	if notModified(c, s.config.CacheLists, etag, modified) {
+		return
+	}
+
+	debugFilters(c.Request.Context(), gin.H{
+		"q": q, "backend": s.config.SearchBackend, "system": system, "only_with_pictures": onlyWithPictures,
+		"offset": offset, "limit": limit, "cursor": cursor, "group_by": groupBy,
+	})
+	if groupBy == "system" {
+		groups, best, err := s.searchGroups(c, q, onlyWithPictures, system, searchPage{Offset: offset, Limit: limit})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if page == 1 {
+			var top string
+			if len(best.Titles) > 0 {
+				top = best.Titles[0].TitleID
+			}
+			s.usage.search(top)
+		}
+		setCacheHeaders(c, s.config.CacheLists)
+		c.JSON(http.StatusOK, GroupedSearchResponse{
+			Groups:     groups,
+			Total:      best.Total,
+			Limit:      limit,
+			Offset:     offset,
+			Page:       page,
+			Generation: catalogGeneration(etag),
+		})
+		return
+	}
+	results, err := s.search(c.Request.Context(), q, onlyWithPictures, system, searchPage{Offset: offset, Limit: limit, After: cursor})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	// Count searches, not every page of their results
+	if page == 1 && cursor == nil {
+		var top string
+		if len(results.Titles) > 0 {
+			top = results.Titles[0].TitleID
+		}
+		s.usage.search(top)
+	}
+	var next string
+	if results.Next != nil {
+		next = results.Next.String()
+	}
+	if cursor != nil {
+		// Pages are relative to the cursor
+		page, offset = 0, 0
+	}
+
+	pages := int((results.Total + int64(limit) - 1) / int64(limit))
+
+	setCacheHeaders(c, s.config.CacheLists)
+	if isSlim(c) {
+		response := s.slimPaginatedResponse(results.Titles, results.Total, page, pages, catalogGeneration(etag))
+		response.NextCursor = next
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	items, err := s.listItems(c, results.Titles, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Items:      items,
+		Total:      results.Total,
+		Limit:      limit,
+		Offset:     offset,
+		Page:       page,
+		Pages:      pages,
+		Generation: catalogGeneration(etag),
+		NextCursor: next,
+	})
+}
+
+// findTitle looks up a title with its pictures and external ids, ignoring the case of id.
+func (s *Server) findTitle(id string) (Title, error) {
+	return s.titles.Title(s.ctx, id)
+}
+
+func (s *Server) getTitleByID(c *gin.Context) {
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			s.titleNotFound(c, c.Param("id"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := s.flagDuplicatePictures(&title); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	s.renderTitle(c, title)
+}
+
+// getTitleByPFN resolves a Package Family Name to its title, regardless of
+// case. When several titles share it, the first by title id wins; the pfn
+// filter of /titles lists them all.
+func (s *Server) getTitleByPFN(c *gin.Context) {
+	var match Title
+	err := s.db.WithContext(c.Request.Context()).Select("title_id").Order("title_id ASC").
+		First(&match, "LOWER(pfn) = ?", strings.ToLower(strings.TrimSpace(c.Param("pfn")))).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No title has this PFN"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	title, err := s.findTitle(match.TitleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if err := s.flagDuplicatePictures(&title); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.Header("Content-Location", "/api/v1/titles/"+strings.ToLower(title.TitleID))
+	s.renderTitle(c, title)
+}
+
+// renderTitle writes title with its validators and cache headers.
+func (s *Server) renderTitle(c *gin.Context, title Title) {
+	// Admin edits must send this back in If-Match
+	if notModified(c, s.config.CacheDetails, titleETag(title), title.UpdatedAt) {
+		return
+	}
+
+	setCacheHeaders(c, s.config.CacheDetails)
+	if isSlim(c) {
+		c.JSON(http.StatusOK, s.slimTitle(title))
+		return
+	}
+	c.JSON(http.StatusOK, title)
+}
+
+func (s *Server) getTitlePicture(c *gin.Context) {
+	id := strings.ToLower(c.Param("id"))
+	picture := strings.TrimSuffix(strings.ToLower(c.Param("picture")), s.config.PicturesSuffix)
+
+	// Validate id and picture
+	if len(id) != 8 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid title ID"})
+		return
+	}
+	if len(picture) == 0 || len(picture) > 10 || strings.ContainsAny(picture, `/\`) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid picture name"})
+		return
+	}
+
+	w, okW := s.parseThumbnailDimension(c, "w")
+	h, okH := s.parseThumbnailDimension(c, "h")
+	if !okW || !okH {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("w and h must be between 1 and %d", s.config.ThumbnailMaxDimension)})
+		return
+	}
+
+	setCacheHeaders(c, s.config.CachePictures)
+	format := s.negotiatePictureFormat(c)
+	if _, remote := s.pictures.(bucketPictures); remote && w == 0 && h == 0 {
+		// Originals in a bucket are served from there as they are
+		format = nil
+	}
+
+	// Set ETag based on file path for better cache validation
+	variant := picture
+	if w > 0 || h > 0 {
+		variant += fmt.Sprintf("-w%dh%d", w, h)
+	}
+	if format != nil {
+		variant += "-" + format.Name
+	}
+	etag := fmt.Sprintf(`"%s-%s"`, id, variant)
+	c.Header("ETag", etag)
+
+	// Check if client has cached version
+	if match := c.GetHeader("If-None-Match"); match == etag {
+		c.Status(http.StatusNotModified)
+		s.metrics.observePicture(c, "", "")
+		return
+	}
+
+	// Serve the actual file
+	bucket, remote := s.pictures.(bucketPictures)
+	if remote && w == 0 && h == 0 {
+		s.serveBucketPicture(c, bucket, Picture{TitleID: id, Name: picture})
+		s.metrics.observePicture(c, "original", strings.TrimPrefix(s.config.PicturesSuffix, "."))
+		return
+	}
+	picturePath := filepath.Join(s.config.PicturesFolder, id, picture+s.config.PicturesSuffix)
+	if w > 0 || h > 0 {
+		var err error
+		if picturePath, err = s.thumbnailPath(c.Request.Context(), id, picture, w, h); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Picture not found"})
+				return
+			}
+			if s.imagesBusy(c, err) {
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate thumbnail"})
+			return
+		}
+	}
+	if format != nil {
+		var err error
+		if picturePath, err = s.convertedPicture(c.Request.Context(), picturePath, format); s.imagesBusy(c, err) {
+			return
+		}
+	}
+	c.File(picturePath)
+
+	pictureVariant := "original"
+	if w > 0 || h > 0 {
+		pictureVariant = "thumbnail"
+	}
+	s.metrics.observePicture(c, pictureVariant, strings.TrimPrefix(filepath.Ext(picturePath), "."))
+}
+
+func createJSON(titles any, filename string, indent string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	if indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	if err := encoder.Encode(titles); err != nil {
+		return fmt.Errorf("error encoding JSON: %w", err)
+	}
+
+	return nil
+}
+
+func exportedTitles(titles []Title) []ExportedTitle {
+	var toBeExported []ExportedTitle
+	for i := range titles {
+		var pics []string
+		for _, pic := range titles[i].Pictures {
+			pics = append(pics, pic.Name)
+		}
+		toBeExported = append(toBeExported, ExportedTitle{
+			ID:       titles[i].TitleID,
+			Name:     titles[i].Name,
+			Pictures: pics,
+		})
+	}
+	return toBeExported
+}
+
+func (s *Server) exportToJSON() {
+	var titles []Title
+	err := s.db.Model(&Title{}).Group("titles.title_id").Order("titles.title_id ASC").Preload("Pictures").Find(&titles).Error
+	if err != nil {
+		log.Printf("Error exporting to JSON: %v\n", err)
+		return
+	}
+
+	toBeExported := exportedTitles(titles)
+
+	if err := createJSON(titles, "titles.full.json", "  "); err != nil {
+		log.Printf("Error creating titles.full.json: %v\n", err)
+		return
+	}
+
+	if err := createJSON(titles, "titles.full.min.json", ""); err != nil {
+		log.Printf("Error creating titles.full.min.json: %v\n", err)
+		return
+	}
+
+	if err := createJSON(toBeExported, "titles.json", "  "); err != nil {
+		log.Printf("Error creating titles.json: %v\n", err)
+		return
+	}
+
+	if err := createJSON(toBeExported, "titles.min.json", ""); err != nil {
+		log.Printf("Error creating titles.min.json: %v\n", err)
+		return
+	}
+
+	var filtered []ExportedTitle
+	for _, t := range toBeExported {
+		if len(t.Pictures) > 0 {
+			filtered = append(filtered, t)
+		}
+	}
+
+	if err := createJSON(filtered, "titles.filtered.json", "  "); err != nil {
+		log.Printf("Error creating titles.filtered.json: %v\n", err)
+		return
+	}
+
+	if err := createJSON(filtered, "titles.filtered.min.json", ""); err != nil {
+		log.Printf("Error creating titles.filtered.min.json: %v\n", err)
+		return
+	}
+}
+
+// NewServer builds a server and starts it, returning once it is ready.
+func NewServer(cfg Config) (*Server, error) {
+	s, err := newServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewEngine builds a server like NewServer and returns its router alone, for
+// callers that mount it in their own http.Server. The server runs until the
+// process exits, since it can only be closed through NewServer's *Server.
+func NewEngine(cfg Config) (*gin.Engine, error) {
+	s, err := NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s.router, nil
+}
+
+// newServer builds a server that answers health checks but refuses every
+// other request until Start is done.
+func newServer(cfg Config) (*Server, error) {
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		config:        cfg,
+		ctx:           ctx,
+		cancel:        cancel,
+		jobs:          newJobRegistry(ctx),
+		metrics:       newServerMetrics(),
+		usage:         newUsageCounter(),
+		quotas:        newQuotaCounter(),
+		catalog:       catalogVersion{epoch: now.UnixNano(), modified: now},
+		cleanupKey:    []byte(newJobID()),
+		lastDiskUsage: make(map[string]DiskUsage),
+		syncRoutes:    maps.Clone(syncRoutes),
+
+		idempotencyInFlight: make(map[string]bool),
+		images:              newImagePool(cfg.ImageWorkers, cfg.ImageQueue),
+	}
+	s.httpServer = &http.Server{Addr: cfg.Address, Handler: s}
+
+	s.initViews()
+	s.initAbuseProtection()
+	s.initPictureFormats()
+	s.initUploadURLs()
+	s.initPlugins()
+
+	if err := s.initTransforms(); err != nil {
+		return nil, fmt.Errorf("loading ingest transforms: %w", err)
+	}
+	if err := s.initSyncSchedule(); err != nil {
+		return nil, fmt.Errorf("invalid SYNC_SCHEDULE: %w", err)
+	}
+	if err := s.initPictureStorage(); err != nil {
+		return nil, fmt.Errorf("invalid picture storage: %w", err)
+	}
+	if err := s.initAPIKeys(); err != nil {
+		return nil, fmt.Errorf("invalid API_KEYS: %w", err)
+	}
+	if err := s.initTracing(); err != nil {
+		return nil, fmt.Errorf("invalid tracing configuration: %w", err)
+	}
+	if err := s.initDeprecations(); err != nil {
+		return nil, fmt.Errorf("invalid DEPRECATED_ROUTES: %w", err)
+	}
+	if err := s.initThumbnailPrecompute(); err != nil {
+		return nil, fmt.Errorf("invalid THUMBNAIL_PRECOMPUTE: %w", err)
+	}
+	if err := s.initReviewScores(); err != nil {
+		return nil, fmt.Errorf("initializing review scores: %w", err)
+	}
+	if err := s.initExportJobs(); err != nil {
+		return nil, fmt.Errorf("initializing exports: %w", err)
+	}
+	if err := s.initAbout(); err != nil {
+		return nil, fmt.Errorf("invalid ABOUT_FILE: %w", err)
+	}
+	s.initManagedDirs()
+
+	router, err := s.setupRoutes()
+	if err != nil {
+		return nil, fmt.Errorf("setting up routes: %w", err)
+	}
+	s.router = router
+	return s, nil
+}
+
+// Start opens the database and loads the catalog, after which the server
+// serves every request.
+func (s *Server) Start() error {
+	err := s.start()
+	if err != nil {
+		s.startup.fail(err)
+		s.Close()
+	}
+	return err
+}
+
+func (s *Server) start() error {
+	if err := s.initDB(); err != nil {
+		return fmt.Errorf("initializing database: %w", err)
+	}
+	s.startup.dbOpen.Store(true)
+	if err := s.backupDB(s.db); err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+
+	if err := s.loadTitlesToDB(); err != nil {
+		return fmt.Errorf("loading data: %w", err)
+	}
+	s.refreshSearchIndex()
+	if err := s.startPlugins(); err != nil {
+		return err
+	}
+	s.startup.dataLoaded.Store(true)
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves HTTP on the configured address, or the socket passed
+// by the service manager, until Shutdown.
+func (s *Server) ListenAndServe() error {
+	lns, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return s.serve(lns)
+}
+
+// Shutdown stops accepting requests and cancels any running sync, then waits
+// for in-flight requests, jobs and background loops to finish before closing
+// the database. Work still running when ctx is done is abandoned, its
+// transactions roll back when the database is closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+
+	err := s.httpServer.Shutdown(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		s.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = errors.Join(err, fmt.Errorf("background work still running: %w", ctx.Err()))
+	}
+	err = errors.Join(err, s.stopPlugins(ctx))
+	if s.db != nil {
+		err = errors.Join(err, s.flushUsage(), s.flushQuotas())
+	}
+	err = errors.Join(err, s.tracer.Shutdown(ctx))
+
+	s.Close()
+	return err
+}
+
+// Close releases the database connection and stops exporting traces.
+func (s *Server) Close() {
+	s.cancel()
+	if s.db != nil {
+		closeDB(s.db)
+	}
+	if s.tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.tracer.Shutdown(ctx)
+	}
+}
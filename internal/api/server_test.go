@@ -0,0 +1,281 @@
+package api
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	upstream "github.com/birabittoh/xtitles/internal/sync"
+	"github.com/gin-gonic/gin"
+)
+
+var testTitles = []Title{
+	{TitleID: "4D5307E6", Name: "Halo 3", Systems: []string{"XBOX360"}, BingID: "66acd000-77fe-1000-9115-d8024d5307e6"},
+	{TitleID: "4D530802", Name: "Halo 3: ODST", Systems: []string{"XBOX360"}},
+	{TitleID: "415607F7", Name: "Call of Duty 4", Systems: []string{"XBOX360"}},
+	{TitleID: "584109EB", Name: "Minecraft", Systems: []string{"XBOX360", "PC"}},
+}
+
+var testPictures = map[string][]string{
+	"4d5307e6": {"20400", "20401"},
+	"584109eb": {"20400"},
+}
+
+// fakeUpstream serves titles the way the upstream title_ids API does.
+func fakeUpstream(t *testing.T, titles []Title) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var matching []Title
+		for _, title := range titles {
+			if slices.Contains(title.Systems, r.URL.Query().Get("system")) {
+				matching = append(matching, title)
+			}
+		}
+
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		end := min(offset+limit, len(matching))
+		offset = min(offset, end)
+		json.NewEncoder(w).Encode(upstream.Response{Items: matching[offset:end], Count: len(matching)})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// writePictureTree creates a PICTURES_FOLDER layout with tiny PNGs.
+func writePictureTree(t *testing.T, dir string, pictures map[string][]string) {
+	t.Helper()
+	for id, names := range pictures {
+		if err := os.MkdirAll(filepath.Join(dir, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			f, err := os.Create(filepath.Join(dir, id, name+".png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			png.Encode(f, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+			f.Close()
+		}
+	}
+}
+
+func testConfig(t *testing.T, upstreamURL string) Config {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := loadConfig()
+	cfg.BaseURL = upstreamURL + "/"
+	cfg.Limit = 2
+	cfg.Systems = []string{"XBOX360", "PC"}
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.DBFile = "titles.db"
+	cfg.PicturesFolder = filepath.Join(dir, "titles")
+	cfg.PicturesSuffix = ".png"
+	cfg.Environment = "test"
+	cfg.GinMode = gin.TestMode
+	cfg.AdminToken = "test-token"
+	cfg.ExportDir = filepath.Join(dir, "exports")
+	cfg.ThumbnailDir = filepath.Join(dir, "thumbnails")
+	cfg.BackupDir = filepath.Join(dir, "backups")
+	cfg.ScriptsDir = filepath.Join(dir, "scripts")
+	cfg.AboutFile = filepath.Join(dir, "about.json")
+	cfg.UpstreamBackoff = time.Millisecond
+	cfg.UpstreamInterval = 0
+	cfg.AbuseAction = abuseActionOff
+	return cfg
+}
+
+// newTestServer builds a full server against a temporary database populated
+// from a fake upstream serving titles and a fake picture tree.
+func newTestServer(t *testing.T, titles []Title) *Server {
+	t.Helper()
+	return newTestServerWithConfig(t, titles, func(*Config) {})
+}
+
+// newTestServerWithConfig is newTestServer with a hook to adjust the config.
+func newTestServerWithConfig(t *testing.T, titles []Title, configure func(*Config)) *Server {
+	t.Helper()
+
+	upstream := fakeUpstream(t, titles)
+	cfg := testConfig(t, upstream.URL)
+	configure(&cfg)
+	writePictureTree(t, cfg.PicturesFolder, testPictures)
+
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func doRequest(r http.Handler, method, target string, header map[string]string) *httptest.ResponseRecorder {
+	return doRequestBody(r, method, target, header, "")
+}
+
+func doRequestBody(r http.Handler, method, target string, header map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("User-Agent", "xtitles-test")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestEndpoints(t *testing.T) {
+	r := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		header   map[string]string
+		status   int
+		contains string
+	}{
+		{"list titles", "GET", "/api/v1/titles", nil, http.StatusOK, `"total":4`},
+		{"list titles with pictures", "GET", "/api/v1/titles?only_with_pictures=true", nil, http.StatusOK, `"total":2`},
+		{"list titles reversed", "GET", "/api/v1/titles?reverse=true&limit=1", nil, http.StatusOK, `"title_id":"584109EB"`},
+		{"list titles slim", "GET", "/api/v1/titles?profile=slim&limit=1", nil, http.StatusOK, `"i":[{"i":"415607F7"`},
+		{"list titles by system", "GET", "/api/v1/titles?system=PC", nil, http.StatusOK, `"total":1`},
+		{"list titles by systems", "GET", "/api/v1/titles?system=PC,XBOX", nil, http.StatusOK, `"total":1`},
+		{"merged systems", "GET", "/api/v1/titles/584109eb", nil, http.StatusOK, `"systems":["XBOX360","PC"]`},
+		{"systems", "GET", "/api/v1/systems", nil, http.StatusOK, `{"system":"XBOX360","name":"Xbox 360","count":4},{"system":"PC","name":"PC","count":1}`},
+		{"title by id", "GET", "/api/v1/titles/4d5307e6", nil, http.StatusOK, `"name":"Halo 3"`},
+		{"title by id alt text", "GET", "/api/v1/titles/4D5307E6", nil, http.StatusOK, `"alt":"Halo 3 gamerpic 20400"`},
+		{"title not found", "GET", "/api/v1/titles/00000000", nil, http.StatusNotFound, "Title not found"},
+		{"search", "GET", "/api/v1/search?q=halo", nil, http.StatusOK, `"total":2`},
+		{"search by system", "GET", "/api/v1/search?q=minecraft&system=pc", nil, http.StatusOK, `"total":1`},
+		{"search by other system", "GET", "/api/v1/search?q=halo&system=pc", nil, http.StatusOK, `"total":0`},
+		{"search by systems", "GET", "/api/v1/search?q=halo&system=pc,xbox360", nil, http.StatusOK, `"total":2`},
+		{"search by prefix", "GET", "/api/v1/search?q=hal+od", nil, http.StatusOK, `"total":1`},
+		{"search with pictures", "GET", "/api/v1/search?q=halo&only_with_pictures=true", nil, http.StatusOK, `"total":1`},
+		{"search without words", "GET", "/api/v1/search?q=%3A%3A", nil, http.StatusOK, `"total":0`},
+		{"search without query", "GET", "/api/v1/search", nil, http.StatusBadRequest, "'q' is required"},
+		{"search query too long", "GET", "/api/v1/search?q=" + strings.Repeat("a", 201), nil, http.StatusBadRequest, `"status":400,"detail":"Query parameter 'q' is too long"`},
+		{"url too long", "GET", "/api/v1/titles?x=" + strings.Repeat("a", 2048), nil, http.StatusRequestURITooLong, "The request URL is too long"},
+		{"compare", "GET", "/api/v1/compare?ids=4d5307e6,4d530802", nil, http.StatusOK, `"differences":["title_id","name","bing_id","pictures"]`},
+		{"compare needs two ids", "GET", "/api/v1/compare?ids=4d5307e6", nil, http.StatusBadRequest, "exactly two"},
+		{"too many ids", "GET", "/api/v1/compare?ids=" + strings.Repeat("4d5307e6,", 100) + "4d5307e6", nil, http.StatusBadRequest, "too many title IDs"},
+		{"picture", "GET", "/api/v1/titles/4d5307e6/20400.png", nil, http.StatusOK, "PNG"},
+		{"invalid picture id", "GET", "/api/v1/titles/123/20400", nil, http.StatusBadRequest, "Invalid title ID"},
+		{"record view", "POST", "/api/v1/titles/4d5307e6/view", nil, http.StatusAccepted, `"counted":true`},
+		{"record view unknown title", "POST", "/api/v1/titles/00000000/view", nil, http.StatusNotFound, "Title not found"},
+		{"title page", "GET", "/titles/4d5307e6", nil, http.StatusOK, `"@type":"VideoGame"`},
+		{"admin without token", "GET", "/api/v1/admin/blocks", nil, http.StatusUnauthorized, "Unauthorized"},
+		{"admin with token", "GET", "/api/v1/admin/blocks", admin, http.StatusOK, `"items":[]`},
+		{"admin sync status", "GET", "/api/v1/admin/sync", admin, http.StatusOK, `"last_success":{"id":1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, tt.method, tt.target, tt.header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body: %s", w.Code, tt.status, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("body does not contain %q: %s", tt.contains, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	full := newTestServer(t, testTitles)
+	single := newTestServer(t, testTitles[:1])
+	engine, err := NewEngine(testConfig(t, fakeUpstream(t, testTitles[:2]).URL))
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		r     http.Handler
+		total string
+	}{
+		{"full catalog", full, `"total":4`},
+		{"single title catalog", single, `"total":1`},
+		{"bare engine", engine, `"total":2`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(tt.r, "GET", "/api/v1/titles", nil)
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.total) {
+				t.Fatalf("status = %d, body: %s; want %s", w.Code, w.Body.String(), tt.total)
+			}
+		})
+	}
+}
+
+func TestTitleByPFN(t *testing.T) {
+	// Both Halo 3 titles share the package of the first
+	pfn := "Microsoft.Halo3_8wekyb3d8bbwe"
+	titles := slices.Clone(testTitles)
+	titles[0].PFN, titles[1].PFN = &pfn, &pfn
+	s := newTestServer(t, titles)
+
+	w := doRequest(s, "GET", "/api/v1/titles/by-pfn/microsoft.halo3_8wekyb3d8bbwe", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title_id":"4D5307E6"`) ||
+		w.Header().Get("Content-Location") != "/api/v1/titles/4d5307e6" {
+		t.Errorf("status = %d, headers = %v; body: %s", w.Code, w.Header(), w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/by-pfn/Microsoft.Forza_8wekyb3d8bbwe", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown PFN: status = %d", w.Code)
+	}
+
+	w = doRequest(s, "GET", "/api/v1/titles?pfn=MICROSOFT.HALO3_8WEKYB3D8BBWE", nil)
+	if !strings.Contains(w.Body.String(), `"total":2`) {
+		t.Errorf("pfn filter: %s", w.Body.String())
+	}
+	// Title pictures still resolve next to the lookup
+	if w := doRequest(s, "GET", "/api/v1/titles/4d5307e6/20400", nil); w.Code != http.StatusOK {
+		t.Errorf("picture: status = %d", w.Code)
+	}
+}
+
+func TestTitleSort(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	s.db.Model(&Title{}).Where("title_id = ?", "415607F7").UpdateColumn("updated_at", time.Now().Add(time.Hour))
+	s.db.Model(&Title{}).Where("title_id = ?", "4D5307E6").UpdateColumn("updated_at", time.Now().Add(-time.Hour))
+
+	for target, want := range map[string][]string{
+		"/api/v1/titles?sort=name":                           {"415607F7", "4D5307E6", "4D530802", "584109EB"},
+		"/api/v1/titles?sort=name&order=desc":                {"584109EB", "4D530802", "4D5307E6", "415607F7"},
+		"/api/v1/titles?sort=name&reverse=true":              {"584109EB", "4D530802", "4D5307E6", "415607F7"},
+		"/api/v1/titles?sort=name&order=asc&reverse=true":    {"415607F7", "4D5307E6", "4D530802", "584109EB"},
+		"/api/v1/titles?sort=name&system=pc":                 {"584109EB"},
+		"/api/v1/titles?sort=title_id&order=desc":            {"584109EB", "4D530802", "4D5307E6", "415607F7"},
+		"/api/v1/titles?sort=updated_at&limit=1":             {"415607F7"},
+		"/api/v1/titles?sort=updated_at&order=asc&limit=1":   {"4D5307E6"},
+		"/api/v1/titles?sort=name&limit=2&page=2":            {"4D530802", "584109EB"},
+		"/api/v1/titles?sort=name&order=desc&limit=1&page=4": {"415607F7"},
+	} {
+		w := doRequest(s, "GET", target, nil)
+		var resp struct{ Items []Title }
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var ids []string
+		for _, t := range resp.Items {
+			ids = append(ids, t.TitleID)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("%s: got %v, want %v", target, ids, want)
+		}
+	}
+
+	for _, target := range []string{"/api/v1/titles?order=up", "/api/v1/titles?sort=name&cursor=eyJpZCI6IjRENTMwN0U2In0"} {
+		if w := doRequest(s, "GET", target, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// The slim profile (?profile=slim) trades readability for size: it shortens
+// field names, drops empty fields and caps nested arrays so that responses fit
+// in the tiny memory budgets of homebrew HTTP clients running on the console.
+
+type SlimTitle struct {
+	ID              string   `json:"i"`
+	Name            string   `json:"n"`
+	Systems         []string `json:"s,omitempty"`
+	BingID          string   `json:"b,omitempty"`
+	ServiceConfigID *string  `json:"c,omitempty"`
+	PFN             *string  `json:"f,omitempty"`
+	Pictures        []string `json:"p,omitempty"`
+}
+
+type SlimPaginatedResponse struct {
+	Items []SlimTitle `json:"i"`
+	Total int64       `json:"t"`
+	Page  int         `json:"p"`
+	Pages int         `json:"n"`
+	// Catalog generation
+	Generation string `json:"g,omitempty"`
+	// Cursor of the next page
+	NextCursor string `json:"c,omitempty"`
+}
+
+func isSlim(c *gin.Context) bool {
+	return c.Query("profile") == "slim"
+}
+
+func (s *Server) slimTitle(title Title) SlimTitle {
+	slim := SlimTitle{
+		ID:              title.TitleID,
+		Name:            title.Name,
+		Systems:         title.Systems,
+		BingID:          title.BingID,
+		ServiceConfigID: title.ServiceConfigID,
+		PFN:             title.PFN,
+	}
+	for i, pic := range title.Pictures {
+		if s.config.SlimMaxPictures > 0 && i >= s.config.SlimMaxPictures {
+			break
+		}
+		slim.Pictures = append(slim.Pictures, pic.Name)
+	}
+	return slim
+}
+
+func (s *Server) slimPaginatedResponse(titles []Title, total int64, page, pages int, generation string) SlimPaginatedResponse {
+	items := make([]SlimTitle, len(titles))
+	for i, title := range titles {
+		items[i] = s.slimTitle(title)
+	}
+	return SlimPaginatedResponse{
+		Items:      items,
+		Total:      total,
+		Page:       page,
+		Pages:      pages,
+		Generation: generation,
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSlimProfile(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.SlimMaxPictures = 1 })
+	doRequest(s, "POST", "/api/v1/titles/4d5307e6/view", nil)
+
+	tests := []struct {
+		name     string
+		target   string
+		contains []string
+		excludes []string
+	}{
+		{"details", "/api/v1/titles/4d5307e6?profile=slim",
+			[]string{`{"i":"4D5307E6","n":"Halo 3","s":["XBOX360"],"b":"66acd000-77fe-1000-9115-d8024d5307e6","p":["20400"]}`},
+			nil},
+		{"empty fields dropped", "/api/v1/titles/415607f7?profile=slim",
+			[]string{`{"i":"415607F7","n":"Call of Duty 4","s":["XBOX360"]}`},
+			[]string{`"b"`, `"c"`, `"f"`, `"p"`}},
+		{"list", "/api/v1/titles?profile=slim&limit=2",
+			[]string{`{"i":[{"i":"415607F7","n":"Call of Duty 4","s":["XBOX360"]},{"i":"4D5307E6"`, `"t":4,"p":1,"n":2,"g":"`, `"c":"`},
+			[]string{"title_id", "items", "20401"}},
+		{"search", "/api/v1/search?q=halo&profile=slim",
+			[]string{`"i":[{"i":"4D5307E6"`, `"t":2`},
+			[]string{"title_id"}},
+		{"trending", "/api/v1/titles/trending?profile=slim",
+			[]string{`{"i":[{"i":"4D5307E6","n":"Halo 3"`, `"t":1`},
+			[]string{"title_id"}},
+		{"full profile", "/api/v1/titles/4d5307e6",
+			[]string{`"title_id":"4D5307E6"`, `"name":"20401"`},
+			nil},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d; body: %s", tt.name, w.Code, w.Body.String())
+			continue
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: body does not contain %s: %s", tt.name, want, w.Body.String())
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(w.Body.String(), unwanted) {
+				t.Errorf("%s: body contains %s: %s", tt.name, unwanted, w.Body.String())
+			}
+		}
+	}
+}
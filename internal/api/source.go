@@ -0,0 +1,37 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	upstream "github.com/birabittoh/xtitles/internal/sync"
+	"github.com/birabittoh/xtitles/internal/tracing"
+)
+
+// newTitleSource returns the upstream source of the titles of system, calling
+// onCount, when set, with the number of titles upstream lists for it.
+func (s *Server) newTitleSource(system string, onCount func(count int)) upstream.Source {
+	client := &http.Client{Timeout: s.config.UpstreamTimeout}
+	if s.config.ChaosErrorRate > 0 || s.config.ChaosMalformedRate > 0 || s.config.ChaosLatency > 0 {
+		log.Printf("Warning: upstream fault injection enabled (errors %.2f, malformed %.2f, latency %s)\n",
+			s.config.ChaosErrorRate, s.config.ChaosMalformedRate, s.config.ChaosLatency)
+		client.Transport = &upstream.ChaosTransport{
+			Next:          http.DefaultTransport,
+			ErrorRate:     s.config.ChaosErrorRate,
+			MalformedRate: s.config.ChaosMalformedRate,
+			Latency:       s.config.ChaosLatency,
+		}
+	}
+	if s.tracer != nil {
+		// Outermost, so that injected faults show up in the traces
+		client.Transport = &tracing.Transport{Tracer: s.tracer, Next: client.Transport}
+	}
+
+	source := upstream.NewHTTPSource(client, s.config.BaseURL, system)
+	source.OnCount = onCount
+	return upstream.NewRetryingSource(source, upstream.Retry{
+		Attempts:   s.config.UpstreamRetries + 1,
+		Backoff:    s.config.UpstreamBackoff,
+		MaxBackoff: s.config.UpstreamMaxBackoff,
+	})
+}
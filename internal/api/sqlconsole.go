@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readOnlyQueryPattern accepts the statements the SQL console runs. It is only
+// a first check, writes are refused by the database itself, through query_only
+// on SQLite and read-only transactions elsewhere.
+var readOnlyQueryPattern = regexp.MustCompile(`(?is)^\s*(SELECT|WITH)\b`)
+
+type QueryRequest struct {
+	SQL   string `json:"sql"`
+	Limit int    `json:"limit"`
+}
+
+type QueryResult struct {
+	Columns    []string `json:"columns"`
+	Rows       [][]any  `json:"rows"`
+	RowCount   int      `json:"row_count"`
+	Truncated  bool     `json:"truncated"`
+	DurationMS int64    `json:"duration_ms"`
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// readOnlyQuerier returns where to run a console query and how to release it
+// afterwards. On SQLite that is a connection switched to query_only, on other
+// databases a read-only transaction.
+func (s *Server) readOnlyQuerier(ctx context.Context) (querier, func(), error) {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !isSQLite(s.db) {
+		tx, err := sqlDB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return nil, nil, err
+		}
+		return tx, func() { tx.Rollback() }, nil
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, func() {
+		if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = OFF"); err != nil {
+			// Don't hand a read-only connection back to the pool
+			log.Printf("Warning: resetting query_only failed: %v\n", err)
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// runReadOnlyQuery runs query without write access, reading at most limit rows.
+func (s *Server) runReadOnlyQuery(ctx context.Context, query string, limit int) (QueryResult, error) {
+	result := QueryResult{Rows: [][]any{}}
+
+	q, release, err := s.readOnlyQuerier(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer release()
+
+	// database/sql stops reading rows once ctx is done
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	if result.Columns, err = rows.Columns(); err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if len(result.Rows) == limit {
+			result.Truncated = true
+			break
+		}
+		values := make([]any, len(result.Columns))
+		pointers := make([]any, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return result, err
+		}
+		for i, v := range values {
+			// Text comes back as bytes from some expressions
+			if b, ok := v.([]byte); ok && utf8.Valid(b) {
+				values[i] = string(b)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	result.RowCount = len(result.Rows)
+	result.DurationMS = time.Since(start).Milliseconds()
+	return result, nil
+}
+
+func (s *Server) runQuery(c *gin.Context) {
+	if !s.config.SQLConsole {
+		c.JSON(http.StatusForbidden, gin.H{"error": "SQL console is disabled"})
+		return
+	}
+
+	var req QueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	query := strings.TrimRight(strings.TrimSpace(req.SQL), "; \t\r\n")
+	if !readOnlyQueryPattern.MatchString(query) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only SELECT statements are allowed"})
+		return
+	}
+	if strings.Contains(query, ";") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only a single statement is allowed"})
+		return
+	}
+
+	limit := s.config.SQLConsoleMaxRows
+	if req.Limit > 0 && req.Limit < limit {
+		limit = req.Limit
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), s.config.SQLConsoleTimeout)
+	defer cancel()
+
+	// The SQLite driver only looks at ctx between rows, so a single slow step
+	// like a large sort would hold the request past its deadline. The query
+	// gives its connection back when that step ends.
+	type outcome struct {
+		result QueryResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := s.runReadOnlyQuery(ctx, query, limit)
+		done <- outcome{result, err}
+	}()
+	var result QueryResult
+	var err error
+	select {
+	case o := <-done:
+		result, err = o.result, o.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": fmt.Sprintf("Query exceeded the %s time limit", s.config.SQLConsoleTimeout)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query failed: " + err.Error()})
+		return
+	}
+
+	log.Printf("SQL console: %d rows in %dms for %q\n", result.RowCount, result.DurationMS, query)
+
+	if c.Query("format") == "csv" {
+		writeQueryCSV(c, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// writeQueryCSV writes a query result as CSV, flagging truncation in a header
+// since the body has nowhere to say so.
+func writeQueryCSV(c *gin.Context, result QueryResult) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("X-Result-Truncated", strconv.FormatBool(result.Truncated))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write(result.Columns)
+	record := make([]string, len(result.Columns))
+	for _, row := range result.Rows {
+		for i, v := range row {
+			switch v := v.(type) {
+			case nil:
+				record[i] = ""
+			case []byte:
+				record[i] = fmt.Sprintf("%x", v)
+			case time.Time:
+				record[i] = v.Format(time.RFC3339Nano)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLConsole(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.SQLConsole = true
+		cfg.SQLConsoleTimeout = 200 * time.Millisecond
+	})
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		status   int
+		contains string
+	}{
+		{"select", "/api/v1/admin/query", `{"sql":"SELECT title_id, name FROM titles ORDER BY title_id"}`, http.StatusOK, `"rows":[["415607F7","Call of Duty 4"]`},
+		{"row limit", "/api/v1/admin/query", `{"sql":"SELECT title_id FROM titles","limit":2}`, http.StatusOK, `"row_count":2,"truncated":true`},
+		{"csv", "/api/v1/admin/query?format=csv", `{"sql":"SELECT title_id, name FROM titles WHERE title_id = '4D530802';"}`, http.StatusOK, "title_id,name\n4D530802,Halo 3: ODST\n"},
+		{"not a select", "/api/v1/admin/query", `{"sql":"DELETE FROM titles"}`, http.StatusBadRequest, "Only SELECT"},
+		{"several statements", "/api/v1/admin/query", `{"sql":"SELECT 1; DELETE FROM titles"}`, http.StatusBadRequest, "single statement"},
+		{"write in a CTE", "/api/v1/admin/query", `{"sql":"WITH x AS (SELECT 1) DELETE FROM titles"}`, http.StatusBadRequest, "Query failed"},
+		{"syntax error", "/api/v1/admin/query", `{"sql":"SELECT FROM"}`, http.StatusBadRequest, "Query failed"},
+		{"timeout", "/api/v1/admin/query", `{"sql":"WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n LIMIT 2000000) SELECT count(*) FROM n"}`, http.StatusGatewayTimeout, "time limit"},
+		{"writes still work", "/api/v1/admin/titles", `{"title_id":"4D5307F1","name":"Halo 3 Beta","systems":["XBOX360"]}`, http.StatusCreated, `"title_id":"4D5307F1"`},
+	}
+
+	for _, tt := range tests {
+		w := doRequestBody(s, "POST", tt.target, admin, tt.body)
+		if w.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d; body: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s: body does not contain %q: %s", tt.name, tt.contains, w.Body.String())
+		}
+	}
+
+	disabled := newTestServer(t, testTitles)
+	if w := doRequestBody(disabled, "POST", "/api/v1/admin/query", admin, `{"sql":"SELECT 1"}`); w.Code != http.StatusForbidden {
+		t.Errorf("disabled console: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
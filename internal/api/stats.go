@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CatalogStats sums up the catalog, for dashboards and the frontend footer.
+type CatalogStats struct {
+	Titles             int64         `json:"titles"`
+	TitlesWithPictures int64         `json:"titles_with_pictures"`
+	Pictures           int64         `json:"pictures"`
+	Systems            []SystemCount `json:"systems"`
+	// DatabaseSize is in bytes
+	DatabaseSize int64 `json:"database_size"`
+	// LastSyncAt is when the last successful sync finished, unset until one
+	// has
+	LastSyncAt *time.Time `json:"last_sync_at"`
+}
+
+// catalogStats counts the catalog in one snapshot, so that the counts agree.
+func (s *Server) catalogStats(c *gin.Context) (CatalogStats, error) {
+	var stats CatalogStats
+	err := store.ReadSnapshot(c.Request.Context(), s.db, func(tx *gorm.DB) error {
+		if err := tx.Model(&Title{}).Count(&stats.Titles).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Picture{}).Distinct("title_id").Count(&stats.TitlesWithPictures).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Picture{}).Count(&stats.Pictures).Error; err != nil {
+			return err
+		}
+		var err error
+		stats.Systems, err = systemCounts(tx)
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if stats.DatabaseSize, err = databaseSize(s.db.WithContext(c.Request.Context())); err != nil {
+		return stats, err
+	}
+	run, err := s.lastSuccessfulSync()
+	if err == nil {
+		stats.LastSyncAt = run.FinishedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return stats, err
+	}
+	return stats, nil
+}
+
+func (s *Server) getStats(c *gin.Context) {
+	stats, err := s.catalogStats(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	setCacheHeaders(c, s.config.CacheLists)
+	c.JSON(http.StatusOK, stats)
+}
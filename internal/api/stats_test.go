@@ -0,0 +1,28 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCatalogStats(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	w := doRequest(s, "GET", "/api/v1/stats", nil)
+	var stats CatalogStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status = %d, err = %v; body: %s", w.Code, err, w.Body.String())
+	}
+	if stats.Titles != 4 || stats.TitlesWithPictures != 2 || stats.Pictures != 3 || stats.DatabaseSize <= 0 || stats.LastSyncAt == nil {
+		t.Errorf("stats = %+v", stats)
+	}
+	if len(stats.Systems) != 2 || stats.Systems[0] != (SystemCount{"XBOX360", "Xbox 360", 4}) {
+		t.Errorf("systems = %+v", stats.Systems)
+	}
+
+	if w := doRequest(s, "GET", "/", nil); !strings.Contains(w.Body.String(), `id="catalogStats"`) {
+		t.Error("index has no stats footer")
+	}
+}
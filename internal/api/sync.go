@@ -0,0 +1,663 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	upstream "github.com/birabittoh/xtitles/internal/sync"
+	"github.com/birabittoh/xtitles/internal/tracing"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SyncRun records the outcome of one synchronization with upstream.
+type SyncRun struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Added      int        `json:"added"`
+	Updated    int        `json:"updated"`
+	Unchanged  int        `json:"unchanged"`
+	Rejected   int        `json:"rejected"`
+	Pictures   int        `json:"pictures"`
+	Error      string     `json:"error,omitempty"`
+}
+
+var errSyncInProgress = errors.New("a sync is already in progress")
+
+const (
+	syncJobKind          = "sync"
+	pictureRescanJobKind = "picture_rescan"
+
+	// syncEventsHeartbeat is how often an idle event stream gets a comment
+	syncEventsHeartbeat = 15 * time.Second
+)
+
+const (
+	syncPhaseFetching = "fetching"
+	syncPhaseMerging  = "merging"
+	syncPhaseDone     = "done"
+)
+
+// SyncProgress is how far a running sync got: the pages fetched so far, then
+// what was written to the catalog.
+type SyncProgress struct {
+	Phase   string `json:"phase"`
+	System  string `json:"system,omitempty"`
+	Pages   int    `json:"pages"`
+	Fetched int    `json:"fetched"`
+	// Titles upstream lists for the systems fetched so far, zero when it
+	// doesn't tell
+	Total     int `json:"total"`
+	Rejected  int `json:"rejected"`
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Pictures  int `json:"pictures"`
+}
+
+// syncReporter publishes the progress of a sync on its job. A nil reporter,
+// for syncs that don't run as a job, ignores updates.
+type syncReporter struct {
+	job      *Job
+	progress SyncProgress
+}
+
+func (r *syncReporter) update(fn func(p *SyncProgress)) {
+	if r == nil {
+		return
+	}
+	fn(&r.progress)
+	r.job.SetDetail(r.progress)
+}
+
+// lastSuccessfulSync returns the most recent sync run that completed without errors.
+func (s *Server) lastSuccessfulSync() (SyncRun, error) {
+	var run SyncRun
+	err := s.db.Where("finished_at IS NOT NULL AND error = ''").Order("finished_at DESC").First(&run).Error
+	return run, err
+}
+
+// fetchUpstreamTitles fetches, validates and merges the titles of every configured system.
+func (s *Server) fetchUpstreamTitles(run *SyncRun, reporter *syncReporter) ([]Title, error) {
+	var titles []Title
+	// Titles upstream listed for the systems fetched before this one
+	var listed int
+	for _, system := range s.config.Systems {
+		log.Printf("Fetching %s titles from API...\n", system)
+		reporter.update(func(p *SyncProgress) { p.Phase, p.System = syncPhaseFetching, system })
+		var count atomic.Int64
+		fetcher := upstream.Fetcher{
+			Limit:    s.config.Limit,
+			Workers:  s.config.UpstreamWorkers,
+			Interval: s.config.UpstreamInterval,
+			OnPage: func(page []Title) {
+				reporter.update(func(p *SyncProgress) {
+					p.Pages++
+					p.Fetched += len(page)
+					if n := int(count.Load()); n > 0 {
+						p.Total = listed + n
+					}
+				})
+			},
+		}
+		ctx, span := s.tracer.Start(s.ctx, "sync fetch", tracing.KindInternal, tracing.String("xtitles.system", system))
+		fetched, err := fetcher.FetchAll(ctx, s.newTitleSource(system, func(n int) { count.Store(int64(n)) }))
+		span.SetAttributes(tracing.Int("xtitles.fetched", int64(len(fetched))))
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			return nil, fmt.Errorf("fetching %s titles failed: %w", system, err)
+		}
+
+		// Keep bad upstream rows out of the catalog, but record them for review
+		fetched, rejects := s.validateTitles(fetched)
+		if len(rejects) > 0 {
+			log.Printf("Rejected %d invalid %s titles, see the ingest review table\n", len(rejects), system)
+			if err := s.db.CreateInBatches(rejects, 100).Error; err != nil {
+				return nil, fmt.Errorf("inserting rejected titles failed: %w", err)
+			}
+			run.Rejected += len(rejects)
+			reporter.update(func(p *SyncProgress) { p.Rejected = run.Rejected })
+		}
+
+		titles = append(titles, fetched...)
+		listed += max(int(count.Load()), len(fetched))
+	}
+	return mergeTitles(titles), nil
+}
+
+// titleChanged reports whether the catalog fields of fetched differ from existing.
+func titleChanged(existing, fetched Title) bool {
+	fetched.TitleID = existing.TitleID
+	fetched.Pictures = existing.Pictures
+	return len(diffTitles(existing, fetched)) > 0
+}
+
+// syncTitles fetches the upstream catalog and applies the differences to the
+// database: new titles are inserted along with their pictures, changed titles
+// are updated unless a maintainer curated them, and everything else is left
+// untouched.
+func (s *Server) syncTitles() (SyncRun, error) {
+	if !s.syncMu.TryLock() {
+		return SyncRun{}, errSyncInProgress
+	}
+	defer s.syncMu.Unlock()
+	return s.runSync(nil)
+}
+
+// runSync is syncTitles for callers already holding syncMu, reporting its
+// progress to reporter.
+func (s *Server) runSync(reporter *syncReporter) (SyncRun, error) {
+	run := SyncRun{StartedAt: time.Now()}
+	s.db.Create(&run)
+
+	err := s.applySync(&run, reporter)
+	if run.Added > 0 || run.Updated > 0 {
+		s.catalogChanged()
+	}
+	finished := time.Now()
+	s.metrics.observeSync(finished.Sub(run.StartedAt), err)
+	run.FinishedAt = &finished
+	if err != nil {
+		run.Error = err.Error()
+	}
+	s.db.Save(&run)
+	s.afterSync(run)
+
+	return run, err
+}
+
+func (s *Server) applySync(run *SyncRun, reporter *syncReporter) error {
+	titles, err := s.fetchUpstreamTitles(run, reporter)
+	if err != nil {
+		return err
+	}
+	reporter.update(func(p *SyncProgress) { p.Phase, p.System = syncPhaseMerging, "" })
+	if err := s.mergeTitlesIntoCatalog(run, titles); err != nil {
+		return err
+	}
+	if err := s.markTitlesSeen(titles, run.StartedAt); err != nil {
+		return err
+	}
+	reporter.update(func(p *SyncProgress) {
+		p.Phase = syncPhaseDone
+		p.Added, p.Updated, p.Unchanged, p.Pictures = run.Added, run.Updated, run.Unchanged, run.Pictures
+	})
+
+	log.Printf("Sync finished: %d added, %d updated, %d unchanged, %d rejected\n",
+		run.Added, run.Updated, run.Unchanged, run.Rejected)
+	return nil
+}
+
+// mergeTitlesIntoCatalog adds new titles and updates changed ones in a single
+// transaction, counting what it did in run.
+func (s *Server) mergeTitlesIntoCatalog(run *SyncRun, titles []Title) error {
+	var existing []Title
+	if err := s.db.Find(&existing).Error; err != nil {
+		return fmt.Errorf("loading existing titles failed: %w", err)
+	}
+	byID := make(map[string]Title, len(existing))
+	for _, t := range existing {
+		byID[strings.ToLower(t.TitleID)] = t
+	}
+
+	// A sync cancelled or killed halfway must not leave half-written batches
+	return s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		return s.applyTitles(tx, run, titles, byID)
+	})
+}
+
+// markTitlesSeen records that upstream listed titles at seen. Titles without
+// a first sighting get seen, or their creation time if they were in the
+// catalog before sightings were recorded.
+func (s *Server) markTitlesSeen(titles []Title, seen time.Time) error {
+	ids := make([]string, len(titles))
+	for i, t := range titles {
+		ids[i] = t.TitleID
+	}
+	for batch := range slices.Chunk(ids, 500) {
+		err := s.db.WithContext(s.ctx).Model(&Title{}).Where("title_id IN ?", batch).UpdateColumns(map[string]any{
+			"last_seen_at":  seen,
+			"first_seen_at": gorm.Expr("COALESCE(first_seen_at, CASE WHEN created_at < ? THEN created_at ELSE ? END)", seen, seen),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("recording seen titles failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyTitles writes the differences between the fetched titles and the
+// existing ones, indexed by lowercase title id.
+func (s *Server) applyTitles(tx *gorm.DB, run *SyncRun, titles []Title, byID map[string]Title) error {
+	var added []Title
+	for _, t := range titles {
+		current, ok := byID[strings.ToLower(t.TitleID)]
+		if !ok {
+			t.SyncedAt = &run.StartedAt
+			added = append(added, t)
+			continue
+		}
+		// Maintainer edits win over upstream data
+		if current.Curated || !titleChanged(current, t) {
+			run.Unchanged++
+			continue
+		}
+
+		t.SyncedAt = &run.StartedAt
+		err := tx.Model(&Title{TitleID: current.TitleID}).
+			Select("name", "systems", "bing_id", "service_config_id", "pfn", "synced_at").
+			Updates(&t).Error
+		if err != nil {
+			return fmt.Errorf("updating title %s failed: %w", current.TitleID, err)
+		}
+		run.Updated++
+	}
+
+	if len(added) > 0 {
+		log.Printf("Inserting %d new titles into database...\n", len(added))
+		if err := tx.CreateInBatches(added, 100).Error; err != nil {
+			return fmt.Errorf("inserting titles failed: %w", err)
+		}
+		run.Added = len(added)
+
+		n, err := s.insertPicturesFor(tx, added)
+		if err != nil {
+			return err
+		}
+		run.Pictures = n
+	}
+	return nil
+}
+
+// insertPicturesFor indexes the pictures found on disk for titles through tx.
+func (s *Server) insertPicturesFor(tx *gorm.DB, titles []Title) (int, error) {
+	// Process pictures from filesystem
+	dirPngs, err := s.pictures.List(s.ctx)
+	if err != nil {
+		log.Printf("Warning: Error reading picture dirs: %v\n", err)
+		dirPngs = make(map[string]pictureFolder)
+	}
+
+	var allPictures []Picture
+	for _, title := range titles {
+		pngs := dirPngs[strings.ToLower(title.TitleID)].Names
+		for _, png := range pngs {
+			allPictures = append(allPictures, Picture{TitleID: title.TitleID, Name: png})
+		}
+	}
+	// Pictures that can't be read are indexed all the same, without metadata
+	if _, err := s.scanPictures(allPictures, nil); err != nil {
+		return 0, err
+	}
+
+	if len(allPictures) > 0 {
+		log.Println("Inserting pictures into database...")
+		if err := tx.CreateInBatches(allPictures, 100).Error; err != nil {
+			return 0, fmt.Errorf("inserting pictures failed: %w", err)
+		}
+	}
+	return len(allPictures), nil
+}
+
+// PictureRescan counts the changes a rescan made to the picture index, and
+// the thumbnails it generated.
+type PictureRescan struct {
+	Added      int
+	Updated    int
+	Removed    int
+	Thumbnails int
+}
+
+func (r PictureRescan) String() string {
+	return fmt.Sprintf("%d pictures added, %d updated, %d removed, %d thumbnails generated", r.Added, r.Updated, r.Removed, r.Thumbnails)
+}
+
+// scanPictures reads the files of pictures to fill in their metadata,
+// PICTURE_SCAN_WORKERS at a time, calling progress, when set, as it goes. It
+// returns why each picture that couldn't be read couldn't, by index.
+func (s *Server) scanPictures(pictures []Picture, progress func(done, total int)) ([]error, error) {
+	errs := make([]error, len(pictures))
+	start := time.Now()
+	var mu sync.Mutex
+	done := 0
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range max(s.config.PictureScanWorkers, 1) {
+		wg.Go(func() {
+			for i := range queue {
+				p := &pictures[i]
+				if errs[i] = s.scanPicture(p); errs[i] != nil {
+					log.Printf("Warning: Error reading picture %s/%s: %v\n", p.TitleID, p.Name, errs[i])
+				}
+
+				mu.Lock()
+				done++
+				if progress != nil {
+					progress(done, len(pictures))
+				}
+				if done%1000 == 0 {
+					log.Printf("Scanning pictures: %d/%d (%.0f/s)\n", done, len(pictures), float64(done)/time.Since(start).Seconds())
+				}
+				mu.Unlock()
+			}
+		})
+	}
+
+	for i := range pictures {
+		select {
+		case queue <- i:
+		case <-s.ctx.Done():
+		}
+		if s.ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if len(pictures) > 0 {
+		elapsed := time.Since(start)
+		log.Printf("Scanned %d pictures in %s (%.0f/s)\n", done, elapsed.Round(time.Millisecond), float64(done)/elapsed.Seconds())
+	}
+	return errs, s.ctx.Err()
+}
+
+// rescanPictures brings the picture index in line with the picture folder,
+// indexing new files, refreshing the metadata of changed ones and dropping
+// the rows of files that are gone. The known pictures of folders that look
+// the same as at the last rescan aren't read again, unless full is set. It
+// then generates the thumbnails of THUMBNAIL_PRECOMPUTE that are missing.
+// progress, when set, is called as files are read.
+func (s *Server) rescanPictures(full bool, progress func(done, total int)) (PictureRescan, error) {
+	var rescan PictureRescan
+	dirPngs, err := s.pictures.List(s.ctx)
+	if err != nil {
+		return rescan, fmt.Errorf("reading picture dirs failed: %w", err)
+	}
+
+	var titles []Title
+	if err := s.db.Select("title_id").Preload("Pictures").Find(&titles).Error; err != nil {
+		return rescan, err
+	}
+	scans, err := s.pictureFolderScans()
+	if err != nil {
+		return rescan, err
+	}
+
+	var known, newPictures []Picture
+	var goneIDs []uint
+	skipped := 0
+	for _, t := range titles {
+		dir := strings.ToLower(t.TitleID)
+		onDisk := dirPngs[dir].Names
+		scan, ok := scans[dir]
+		unchanged := !full && ok && scan.matches(dirPngs[dir])
+		if unchanged {
+			skipped++
+		}
+		for _, p := range t.Pictures {
+			if !slices.Contains(onDisk, p.Name) {
+				goneIDs = append(goneIDs, p.ID)
+				continue
+			}
+			if !unchanged {
+				known = append(known, p)
+			}
+		}
+		for _, name := range onDisk {
+			if !slices.ContainsFunc(t.Pictures, func(p Picture) bool { return p.Name == name }) {
+				newPictures = append(newPictures, Picture{TitleID: t.TitleID, Name: name})
+			}
+		}
+	}
+
+	// Known pictures are read into copies, to tell which changed. New ones
+	// that can't be read are indexed all the same, without metadata.
+	scanned := append(slices.Clone(known), newPictures...)
+	errs, err := s.scanPictures(scanned, progress)
+	if err != nil {
+		return rescan, err
+	}
+	var changed []Picture
+	for i, p := range known {
+		if errs[i] == nil && scanned[i] != p {
+			changed = append(changed, scanned[i])
+		}
+	}
+	newPictures = scanned[len(known):]
+	rescan = PictureRescan{Added: len(newPictures), Updated: len(changed), Removed: len(goneIDs)}
+	if len(newPictures) > 0 || len(changed) > 0 || len(goneIDs) > 0 {
+		if err := s.indexPictureChanges(newPictures, changed, goneIDs); err != nil {
+			return PictureRescan{}, err
+		}
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d unchanged picture folders\n", skipped)
+	}
+
+	// Folders with files that couldn't be read are read again next time
+	failed := map[string]bool{}
+	for i, err := range errs {
+		if err != nil {
+			failed[strings.ToLower(scanned[i].TitleID)] = true
+		}
+	}
+	var updated []PictureFolderScan
+	now := time.Now()
+	for dir, folder := range dirPngs {
+		if scan, ok := scans[dir]; !failed[dir] && (full || !ok || !scan.matches(folder)) {
+			updated = append(updated, newPictureFolderScan(dir, folder, now))
+		}
+	}
+	var gone []string
+	for dir := range scans {
+		if _, ok := dirPngs[dir]; !ok || failed[dir] {
+			gone = append(gone, dir)
+		}
+	}
+	if err := s.savePictureFolderScans(updated, gone); err != nil {
+		return rescan, err
+	}
+
+	if len(s.thumbnailBoxes) > 0 {
+		var pictures []Picture
+		if err := s.db.Select("title_id, name, width, height").Order("title_id, name").Find(&pictures).Error; err != nil {
+			return rescan, err
+		}
+		rescan.Thumbnails, err = s.precomputeThumbnails(s.ctx, pictures)
+	}
+	return rescan, err
+}
+
+// indexPictureChanges writes the outcome of a rescan to the picture index.
+func (s *Server) indexPictureChanges(newPictures, changed []Picture, goneIDs []uint) error {
+	err := s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		for ids := range slices.Chunk(goneIDs, 500) {
+			if err := tx.Delete(&Picture{}, ids).Error; err != nil {
+				return err
+			}
+		}
+		// Updated in batches too, as upserts of rows that all exist
+		if len(changed) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"width", "height", "size", "sha256"}),
+			}).CreateInBatches(changed, 100).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(newPictures) > 0 {
+			return tx.CreateInBatches(newPictures, 100).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.catalogChanged()
+	s.refreshSearchIndex()
+	return nil
+}
+
+// triggerPictureRescan rescans the picture folder in the background. It holds
+// the sync lock, so that a sync doesn't index the same files meanwhile.
+func (s *Server) triggerPictureRescan(c *gin.Context) {
+	if !s.syncMu.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Sync already in progress"})
+		return
+	}
+
+	full := c.DefaultQuery("full", "false") == "true"
+	job := s.jobs.Start(pictureRescanJobKind, func(_ context.Context, job *Job) error {
+		defer s.syncMu.Unlock()
+		rescan, err := s.rescanPictures(full, job.SetProgress)
+		if err != nil {
+			return err
+		}
+		job.SetResult(rescan.String())
+		return nil
+	})
+	c.Header("Location", "/api/v1/admin/pictures/rescan/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+func (s *Server) getPictureRescanJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != pictureRescanJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enricherJobResponse(job))
+}
+
+// SyncStatus describes the most recent sync runs.
+type SyncStatus struct {
+	LastSuccess *SyncRun  `json:"last_success"`
+	Runs        []SyncRun `json:"runs"`
+}
+
+func (s *Server) getSyncStatus(c *gin.Context) {
+	var status SyncStatus
+	if err := s.db.Order("id DESC").Limit(10).Find(&status.Runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if run, err := s.lastSuccessfulSync(); err == nil {
+		status.LastSuccess = &run
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// triggerSync starts a sync in the background. The lock is taken here rather
+// than in the job so that a second request is turned away right away.
+func (s *Server) triggerSync(c *gin.Context) {
+	if !s.syncMu.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Sync already in progress"})
+		return
+	}
+
+	job := s.jobs.Start(syncJobKind, func(_ context.Context, job *Job) error {
+		defer s.syncMu.Unlock()
+		run, err := s.runSync(&syncReporter{job: job})
+		if err != nil {
+			return err
+		}
+		job.SetResult(fmt.Sprintf("run %d: %d added, %d updated, %d unchanged, %d rejected",
+			run.ID, run.Added, run.Updated, run.Unchanged, run.Rejected))
+		return nil
+	})
+	c.Header("Location", "/api/v1/admin/sync/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+// SyncJobResponse is the status of a sync job with its progress.
+type SyncJobResponse struct {
+	EnricherJobResponse
+	Progress *SyncProgress `json:"progress,omitempty"`
+}
+
+func syncJobResponse(job *Job) SyncJobResponse {
+	response := SyncJobResponse{EnricherJobResponse: enricherJobResponse(job)}
+	if progress, ok := job.Detail().(SyncProgress); ok {
+		response.Progress = &progress
+	}
+	return response
+}
+
+func (s *Server) getSyncJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != syncJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, syncJobResponse(job))
+}
+
+// streamSyncJob streams the progress of a sync job as Server-Sent Events: a
+// progress event whenever it changes, then a done or failed event when the
+// job ends. Updates that come faster than the client reads are coalesced.
+func (s *Server) streamSyncJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != syncJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	// Keep nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(syncEventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		// Taken before the status so that no update is missed in between
+		changed := job.Changed()
+		response := syncJobResponse(job)
+
+		event := "progress"
+		switch response.Status {
+		case jobDone:
+			event = "done"
+		case jobFailed:
+			event = "failed"
+		}
+		c.SSEvent(event, response)
+		c.Writer.Flush()
+		if event != "progress" {
+			return
+		}
+
+	wait:
+		for {
+			select {
+			case <-changed:
+				break wait
+			case <-heartbeat.C:
+				// Comments keep proxies from closing an idle stream
+				io.WriteString(c.Writer, ": keepalive\n\n")
+				c.Writer.Flush()
+			case <-c.Request.Context().Done():
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownCancelsSync(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	requested := make(chan struct{}, 1)
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Several pages are requested at once
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hanging.Close)
+	s.config.BaseURL = hanging.URL + "/"
+
+	synced := make(chan error, 1)
+	go func() {
+		_, err := s.syncTitles()
+		synced <- err
+	}()
+	<-requested
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+
+	select {
+	case err := <-synced:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("sync error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync was not cancelled")
+	}
+}
+
+func TestSyncJob(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	s.syncMu.Lock()
+	if w := doRequest(s, "POST", "/api/v1/admin/sync", admin); w.Code != http.StatusConflict {
+		t.Errorf("sync while syncing: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	s.syncMu.Unlock()
+
+	w := doRequest(s, "POST", "/api/v1/admin/sync", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting a sync: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+
+	location := w.Header().Get("Location")
+	w = doRequest(s, "GET", location, admin)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"done"`) ||
+		!strings.Contains(w.Body.String(), "run 2: 0 added, 0 updated, 4 unchanged") {
+		t.Errorf("sync job: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/admin/sync", admin); !strings.Contains(w.Body.String(), `"last_success":{"id":2`) {
+		t.Errorf("sync status does not show the run: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/admin/archive/"+path.Base(location), admin); w.Code != http.StatusNotFound {
+		t.Errorf("sync job as another kind: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestTitleSightings(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	var synced Title
+	if err := s.db.First(&synced, "title_id = ?", "415607F7").Error; err != nil {
+		t.Fatal(err)
+	}
+	if synced.FirstSeenAt == nil || synced.LastSeenAt == nil || !synced.FirstSeenAt.Equal(*synced.LastSeenAt) {
+		t.Fatalf("synced title: first seen %v, last seen %v", synced.FirstSeenAt, synced.LastSeenAt)
+	}
+
+	// Titles found before sightings were recorded, and titles upstream never listed
+	s.db.Model(&Title{}).Where("title_id = ?", "4D5307E6").UpdateColumns(map[string]any{
+		"first_seen_at": nil, "created_at": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	s.db.Create(&Title{TitleID: "0000FFFF", Name: "Homebrew", Curated: true})
+	if _, err := s.syncTitles(); err != nil {
+		t.Fatal(err)
+	}
+
+	var backfilled, resynced Title
+	s.db.First(&backfilled, "title_id = ?", "4D5307E6")
+	s.db.First(&resynced, "title_id = ?", "415607F7")
+	if backfilled.FirstSeenAt == nil || backfilled.FirstSeenAt.Year() != 2020 {
+		t.Errorf("backfilled first seen = %v, want its creation time", backfilled.FirstSeenAt)
+	}
+	if !resynced.FirstSeenAt.Equal(*synced.FirstSeenAt) || !resynced.LastSeenAt.After(*synced.LastSeenAt) {
+		t.Errorf("resynced title: first seen %v, last seen %v", resynced.FirstSeenAt, resynced.LastSeenAt)
+	}
+
+	w := doRequest(s, "GET", "/api/v1/titles?added_since=2021-01-01", nil)
+	if !strings.Contains(w.Body.String(), `"total":3`) || strings.Contains(w.Body.String(), "4D5307E6") {
+		t.Errorf("added since 2021: %s", w.Body.String())
+	}
+	w = doRequest(s, "GET", "/api/v1/titles?sort=first_seen", nil)
+	var page struct {
+		Items []Title `json:"items"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if n := len(page.Items); n != 5 || page.Items[n-2].TitleID != "4D5307E6" || page.Items[n-1].TitleID != "0000FFFF" ||
+		page.Items[n-1].FirstSeenAt != nil {
+		t.Errorf("newest first: %s", w.Body.String())
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles?added_since=yesterday", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid added_since: status = %d, want 400", w.Code)
+	}
+}
+
+func TestSyncJobEvents(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	// Hold the sync after its first page so that it can be watched
+	titles := fakeUpstream(t, testTitles)
+	release := make(chan struct{})
+	gated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Pages are fetched ahead, so hold every one but the first
+		if r.URL.Query().Get("offset") != "0" {
+			<-release
+		}
+		titles.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(gated.Close)
+	s.config.BaseURL = gated.URL + "/"
+
+	w := doRequest(s, "POST", "/api/v1/admin/sync", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting a sync: status = %d; body: %s", w.Code, w.Body.String())
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+w.Header().Get("Location")+"/events", nil)
+	req.Header.Set("Authorization", admin["Authorization"])
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	events := bufio.NewScanner(resp.Body)
+	next := func() (event, data string) {
+		for events.Scan() {
+			line := events.Text()
+			if v, ok := strings.CutPrefix(line, "event:"); ok {
+				event = v
+			} else if v, ok := strings.CutPrefix(line, "data:"); ok {
+				data = v
+			} else if line == "" && event != "" {
+				return event, data
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return "", ""
+	}
+
+	for {
+		event, data := next()
+		if event != "progress" {
+			t.Fatalf("event %s before the sync was released: %s", event, data)
+		}
+		if strings.Contains(data, `"phase":"fetching","system":"XBOX360","pages":1,"fetched":2`) {
+			break
+		}
+	}
+	close(release)
+
+	for {
+		event, data := next()
+		if event == "progress" {
+			continue
+		}
+		if event != "done" || !strings.Contains(data, `"phase":"done"`) || !strings.Contains(data, `"pages":4,"fetched":5`) ||
+			!strings.Contains(data, `"unchanged":4`) {
+			t.Errorf("last event = %s: %s", event, data)
+		}
+		break
+	}
+
+	if w := doRequest(s, "GET", "/api/v1/admin/sync/nope/events", admin); w.Code != http.StatusNotFound {
+		t.Errorf("events of a missing job: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSyncWithInjectedFaults(t *testing.T) {
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		want      []string
+	}{
+		{"retried until it goes through", func(cfg *Config) {
+			cfg.ChaosErrorRate, cfg.ChaosMalformedRate, cfg.UpstreamRetries = 0.3, 0.3, 30
+		}, []string{`"status":"done"`, "run 2: 0 added, 0 updated, 4 unchanged"}},
+		{"given up on", func(cfg *Config) {
+			cfg.ChaosErrorRate, cfg.UpstreamRetries = 1, 2
+		}, []string{`"status":"failed"`, "injected upstream failure"}},
+	}
+	for _, tt := range tests {
+		// Faults only start after the first sync, which NewServer needs
+		s := newTestServer(t, testTitles)
+		tt.configure(&s.config)
+		w := doRequest(s, "POST", "/api/v1/admin/sync", admin)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s: status = %d; body: %s", tt.name, w.Code, w.Body.String())
+		}
+		s.jobs.Wait()
+		w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+		for _, want := range tt.want {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: sync job does not contain %q: %s", tt.name, want, w.Body.String())
+			}
+		}
+		// A failed sync leaves the catalog as it was
+		if w := doRequest(s, "GET", "/api/v1/titles", nil); !strings.Contains(w.Body.String(), `"total":4`) {
+			t.Errorf("%s: titles: %s", tt.name, w.Body.String())
+		}
+	}
+}
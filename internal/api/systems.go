@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SystemCount struct {
+	System string `json:"system"`
+	Name   string `json:"name"`
+	Count  int64  `json:"count"`
+}
+
+func parseSystems(value string) []string {
+	var systems []string
+	for _, s := range strings.Split(value, ",") {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if s != "" && !slices.Contains(systems, s) {
+			systems = append(systems, s)
+		}
+	}
+	return systems
+}
+
+// mergeTitles combines titles fetched for several systems, merging the
+// systems of titles that upstream lists under more than one of them.
+func mergeTitles(titles []Title) []Title {
+	merged := make([]Title, 0, len(titles))
+	index := make(map[string]int, len(titles))
+	for _, t := range titles {
+		key := strings.ToLower(t.TitleID)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, t)
+			continue
+		}
+		for _, s := range t.Systems {
+			if !slices.Contains(merged[i].Systems, s) {
+				merged[i].Systems = append(merged[i].Systems, s)
+			}
+		}
+	}
+	return merged
+}
+
+func (s *Server) getSystems(c *gin.Context) {
+	etag, modified := s.catalogValidators()
+	if notModified(c, s.config.CacheLists, etag, modified) {
+		return
+	}
+
+	counts, err := systemCounts(s.db.WithContext(c.Request.Context()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	setCacheHeaders(c, s.config.CacheLists)
+	c.JSON(http.StatusOK, gin.H{"items": counts})
+}
+
+// systemCounts counts the titles available on each system, the most common
+// first.
+func systemCounts(db *gorm.DB) ([]SystemCount, error) {
+	counts := []SystemCount{}
+	err := db.Raw(`SELECT json_each.value AS system, COUNT(*) AS count
+		FROM titles, ` + store.SystemsTable(db) + `
+		GROUP BY json_each.value
+		ORDER BY count DESC, system ASC`).Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range counts {
+		counts[i].Name = systemName(counts[i].System)
+	}
+	return counts, nil
+}
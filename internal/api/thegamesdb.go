@@ -0,0 +1,354 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// The TheGamesDB facade mimics the public API of thegamesdb.net for its Xbox
+// 360 platform, so that scrapers written for it can use this catalog instead.
+// Games are identified by their TheGamesDB id when one is mapped, otherwise by
+// their title id read as a number. API keys are accepted and ignored.
+
+const (
+	tgdbPlatformID = 15
+	tgdbSystem     = "XBOX360"
+	tgdbPageSize   = 20
+)
+
+var tgdbPlatform = gin.H{"id": tgdbPlatformID, "name": "Microsoft Xbox 360", "alias": "microsoft-xbox-360"}
+
+type tgdbGame struct {
+	ID          int64    `json:"id"`
+	GameTitle   string   `json:"game_title"`
+	ReleaseDate *string  `json:"release_date"`
+	Platform    int      `json:"platform"`
+	RegionID    int      `json:"region_id"`
+	CountryID   int      `json:"country_id"`
+	Overview    *string  `json:"overview"`
+	Players     *int     `json:"players"`
+	Rating      *string  `json:"rating"`
+	Developers  []int    `json:"developers"`
+	Genres      []int    `json:"genres"`
+	Publishers  []int    `json:"publishers"`
+	Alternates  []string `json:"alternates"`
+	LastUpdated string   `json:"last_updated"`
+}
+
+type tgdbImage struct {
+	ID         uint    `json:"id"`
+	Type       string  `json:"type"`
+	Side       string  `json:"side"`
+	Filename   string  `json:"filename"`
+	Resolution *string `json:"resolution"`
+}
+
+// tgdbResponse wraps data the way every TheGamesDB response does.
+func tgdbResponse(data gin.H) gin.H {
+	return gin.H{
+		"code":                        http.StatusOK,
+		"status":                      "Success",
+		"data":                        data,
+		"remaining_monthly_allowance": 0,
+		"extra_allowance":             0,
+		"allowance_refresh_timer":     nil,
+	}
+}
+
+func tgdbError(c *gin.Context, code int, status string) {
+	c.JSON(code, gin.H{"code": code, "status": status})
+}
+
+// tgdbPlatformRequested reports whether a filter[platform] parameter includes
+// the Xbox 360, which is all the facade serves.
+func tgdbPlatformRequested(c *gin.Context) bool {
+	filter := c.Query("filter[platform]")
+	if filter == "" {
+		return true
+	}
+	return slices.Contains(strings.Split(filter, ","), strconv.Itoa(tgdbPlatformID))
+}
+
+func tgdbIncludes(c *gin.Context, name string) bool {
+	return slices.Contains(strings.Split(c.Query("include"), ","), name)
+}
+
+func tgdbPage(c *gin.Context) int {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// tgdbPages links the previous and next pages of a paginated response.
+func (s *Server) tgdbPages(c *gin.Context, page int, total int64) gin.H {
+	link := func(page int) string {
+		query := c.Request.URL.Query()
+		query.Set("page", strconv.Itoa(page))
+		u := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+		return s.requestBaseURL(c) + u.String()
+	}
+	pages := gin.H{"previous": nil, "current": link(page), "next": nil}
+	if page > 1 {
+		pages["previous"] = link(page - 1)
+	}
+	if int64(page*tgdbPageSize) < total {
+		pages["next"] = link(page + 1)
+	}
+	return pages
+}
+
+// tgdbIDs returns the TheGamesDB ids of titles, keyed by title id.
+func (s *Server) tgdbIDs(titles []Title) map[string]int64 {
+	ids := make(map[string]int64, len(titles))
+	titleIDs := make([]string, len(titles))
+	for i, t := range titles {
+		titleIDs[i] = t.TitleID
+		ids[t.TitleID] = titleDatabaseID(t.TitleID)
+	}
+
+	var mappings []ExternalID
+	s.db.Where("source = ? AND title_id IN ?", "thegamesdb", titleIDs).Find(&mappings)
+	for _, m := range mappings {
+		if n, err := strconv.ParseInt(m.ExternalID, 10, 64); err == nil {
+			ids[m.TitleID] = n
+		}
+	}
+	return ids
+}
+
+// tgdbGamesData renders titles as the data and include parts of a games response.
+func (s *Server) tgdbGamesData(c *gin.Context, titles []Title) (gin.H, gin.H) {
+	ids := s.tgdbIDs(titles)
+	games := make([]tgdbGame, 0, len(titles))
+	boxart := gin.H{}
+	for _, t := range titles {
+		id := ids[t.TitleID]
+		games = append(games, tgdbGame{
+			ID:          id,
+			GameTitle:   t.Name,
+			Platform:    tgdbPlatformID,
+			LastUpdated: t.UpdatedAt.UTC().Format(time.DateTime),
+		})
+		if images := s.tgdbImages(t); len(images) > 0 {
+			boxart[strconv.FormatInt(id, 10)] = images
+		}
+	}
+
+	include := gin.H{}
+	if tgdbIncludes(c, "boxart") {
+		include["boxart"] = gin.H{"base_url": s.tgdbBaseURLs(c), "data": boxart}
+	}
+	if tgdbIncludes(c, "platform") {
+		include["platform"] = gin.H{strconv.Itoa(tgdbPlatformID): tgdbPlatform}
+	}
+	return gin.H{"count": len(games), "games": games}, include
+}
+
+// tgdbImages exposes the first picture of a title as its front boxart, which
+// is the image scrapers look for.
+func (s *Server) tgdbImages(title Title) []tgdbImage {
+	if len(title.Pictures) == 0 {
+		return nil
+	}
+	picture := title.Pictures[0]
+	return []tgdbImage{{
+		ID:       picture.ID,
+		Type:     "boxart",
+		Side:     "front",
+		Filename: strings.ToLower(title.TitleID) + "/" + picture.Name + s.config.PicturesSuffix,
+	}}
+}
+
+func (s *Server) tgdbBaseURLs(c *gin.Context) gin.H {
+	base := s.requestBaseURL(c) + "/api/v1/titles/"
+	return gin.H{
+		"original":             base,
+		"small":                base,
+		"thumb":                base,
+		"cropped_center_thumb": base,
+		"medium":               base,
+		"large":                base,
+	}
+}
+
+// tgdbFindTitles resolves TheGamesDB ids, mapped ids first.
+func (s *Server) tgdbFindTitles(raw string) ([]Title, error) {
+	var titleIDs []string
+	for _, id := range strings.Split(raw, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if err != nil || n < 0 {
+			continue
+		}
+		var mapping ExternalID
+		if s.db.Where("source = ? AND external_id = ?", "thegamesdb", strconv.FormatInt(n, 10)).First(&mapping).Error == nil {
+			titleIDs = append(titleIDs, mapping.TitleID)
+		} else if n <= 0xFFFFFFFF {
+			titleIDs = append(titleIDs, fmt.Sprintf("%08X", n))
+		}
+	}
+
+	titles := []Title{}
+	if len(titleIDs) == 0 {
+		return titles, nil
+	}
+	query := store.FilterBySystem(s.db.Model(&Title{}), tgdbSystem).Where("title_id IN ?", titleIDs)
+	err := query.Preload("Pictures").Order("title_id ASC").Find(&titles).Error
+	return titles, err
+}
+
+func (s *Server) tgdbGamesByName(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		tgdbError(c, http.StatusBadRequest, "Missing required param: name")
+		return
+	}
+	page := tgdbPage(c)
+
+	titles, total := []Title{}, int64(0)
+	if tgdbPlatformRequested(c) {
+		results, err := s.search(c.Request.Context(), name, false, tgdbSystem, searchPage{Offset: (page - 1) * tgdbPageSize, Limit: tgdbPageSize})
+		if err != nil {
+			tgdbError(c, http.StatusInternalServerError, "Database error")
+			return
+		}
+		titles, total = results.Titles, results.Total
+	}
+
+	data, include := s.tgdbGamesData(c, titles)
+	response := tgdbResponse(data)
+	response["include"] = include
+	response["pages"] = s.tgdbPages(c, page, total)
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) tgdbGamesByID(c *gin.Context) {
+	if c.Query("id") == "" {
+		tgdbError(c, http.StatusBadRequest, "Missing required param: id")
+		return
+	}
+	titles, err := s.tgdbFindTitles(c.Query("id"))
+	if err != nil {
+		tgdbError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	data, include := s.tgdbGamesData(c, titles)
+	response := tgdbResponse(data)
+	response["include"] = include
+	response["pages"] = s.tgdbPages(c, 1, int64(len(titles)))
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) tgdbGamesByPlatform(c *gin.Context) {
+	if c.Query("id") == "" {
+		tgdbError(c, http.StatusBadRequest, "Missing required param: id")
+		return
+	}
+	page := tgdbPage(c)
+
+	titles, total := []Title{}, int64(0)
+	if slices.Contains(strings.Split(c.Query("id"), ","), strconv.Itoa(tgdbPlatformID)) {
+		err := store.ReadSnapshot(c.Request.Context(), s.db, func(tx *gorm.DB) error {
+			query := store.FilterBySystem(tx.Model(&Title{}), tgdbSystem)
+			if err := query.Count(&total).Error; err != nil {
+				return err
+			}
+			return query.Preload("Pictures").Order("title_id ASC").
+				Offset((page - 1) * tgdbPageSize).Limit(tgdbPageSize).Find(&titles).Error
+		})
+		if err != nil {
+			tgdbError(c, http.StatusInternalServerError, "Database error")
+			return
+		}
+	}
+
+	data, include := s.tgdbGamesData(c, titles)
+	response := tgdbResponse(data)
+	response["include"] = include
+	response["pages"] = s.tgdbPages(c, page, total)
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) tgdbGameImages(c *gin.Context) {
+	if c.Query("games_id") == "" {
+		tgdbError(c, http.StatusBadRequest, "Missing required param: games_id")
+		return
+	}
+	titles, err := s.tgdbFindTitles(c.Query("games_id"))
+	if err != nil {
+		tgdbError(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	types := c.Query("filter[type]")
+	ids := s.tgdbIDs(titles)
+	images := gin.H{}
+	count := 0
+	for _, t := range titles {
+		var matching []tgdbImage
+		for _, image := range s.tgdbImages(t) {
+			if types == "" || slices.Contains(strings.Split(types, ","), image.Type) {
+				matching = append(matching, image)
+			}
+		}
+		if len(matching) > 0 {
+			images[strconv.FormatInt(ids[t.TitleID], 10)] = matching
+			count += len(matching)
+		}
+	}
+
+	response := tgdbResponse(gin.H{"count": count, "base_url": s.tgdbBaseURLs(c), "images": images})
+	response["pages"] = s.tgdbPages(c, 1, int64(count))
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) tgdbPlatforms(c *gin.Context) {
+	c.JSON(http.StatusOK, tgdbResponse(gin.H{
+		"count":     1,
+		"platforms": gin.H{strconv.Itoa(tgdbPlatformID): tgdbPlatform},
+	}))
+}
+
+func (s *Server) tgdbPlatformsByID(c *gin.Context) {
+	platforms := gin.H{}
+	if slices.Contains(strings.Split(c.Query("id"), ","), strconv.Itoa(tgdbPlatformID)) {
+		platforms[strconv.Itoa(tgdbPlatformID)] = tgdbPlatform
+	}
+	c.JSON(http.StatusOK, tgdbResponse(gin.H{"count": len(platforms), "platforms": platforms}))
+}
+
+// tgdbEmptyList serves lookup tables the catalog has no data for, which
+// scrapers still fetch before searching.
+func tgdbEmptyList(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, tgdbResponse(gin.H{"count": 0, key: gin.H{}}))
+	}
+}
+
+// registerTGDBRoutes mounts the facade under group, at the paths of both
+// versions of the API scrapers use.
+func (s *Server) registerTGDBRoutes(group *gin.RouterGroup) {
+	for _, version := range []string{"/v1", "/v1.1"} {
+		v := group.Group(version)
+		v.GET("/Games/ByGameName", s.tgdbGamesByName)
+		v.GET("/Games/ByGameID", s.tgdbGamesByID)
+		v.GET("/Games/ByPlatformID", s.tgdbGamesByPlatform)
+		v.GET("/Games/Images", s.tgdbGameImages)
+		v.GET("/Platforms", s.tgdbPlatforms)
+		v.GET("/Platforms/ByPlatformID", s.tgdbPlatformsByID)
+		v.GET("/Genres", tgdbEmptyList("genres"))
+		v.GET("/Developers", tgdbEmptyList("developers"))
+		v.GET("/Publishers", tgdbEmptyList("publishers"))
+	}
+}
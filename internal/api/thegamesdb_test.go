@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTheGamesDBFacade(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.TheGamesDBFacade = true })
+
+	w := doRequest(s, "GET", "/thegamesdb/v1/Games/ByGameName?apikey=x&name=halo&filter[platform]=15&include=boxart", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ByGameName: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	for _, want := range []string{`"count":2`, `"id":1297287142`, `"game_title":"Halo 3"`, `"filename":"4d5307e6/20400.png"`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("ByGameName does not contain %q: %s", want, w.Body.String())
+		}
+	}
+
+	w = doRequest(s, "GET", "/thegamesdb/v1/Games/ByGameName?name=halo&filter[platform]=1", nil)
+	if !strings.Contains(w.Body.String(), `"count":0`) {
+		t.Errorf("other platforms should have no games: %s", w.Body.String())
+	}
+
+	if _, err := s.setExternalID("4D5307E6", "thegamesdb", "100", externalIDOriginAdmin); err != nil {
+		t.Fatal(err)
+	}
+	w = doRequest(s, "GET", "/thegamesdb/v1.1/Games/ByGameID?id=100,1297287170", nil)
+	if !strings.Contains(w.Body.String(), `"count":2`) || !strings.Contains(w.Body.String(), `"id":100,"game_title":"Halo 3"`) {
+		t.Errorf("ByGameID does not resolve mapped and numeric ids: %s", w.Body.String())
+	}
+
+	w = doRequest(s, "GET", "/thegamesdb/v1/Games/Images?games_id=100&filter[type]=boxart", nil)
+	if !strings.Contains(w.Body.String(), `"100":[{`) {
+		t.Errorf("Images does not list the boxart: %s", w.Body.String())
+	}
+
+	s = newTestServer(t, testTitles)
+	if w := doRequest(s, "GET", "/thegamesdb/v1/Platforms", nil); w.Code != http.StatusNotFound {
+		t.Errorf("facade should be disabled by default: status = %d", w.Code)
+	}
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseThumbnailDimension parses the w or h query parameter, where 0 means
+// it was not given.
+func (s *Server) parseThumbnailDimension(c *gin.Context, name string) (int, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > s.config.ThumbnailMaxDimension {
+		return 0, false
+	}
+	return n, true
+}
+
+// thumbnailSize fits a srcW by srcH image within a w by h box keeping its
+// aspect ratio, where 0 leaves a side unbounded. Images are never enlarged.
+func thumbnailSize(srcW, srcH, w, h int) (int, int) {
+	scale := 1.0
+	if w > 0 {
+		scale = min(scale, float64(w)/float64(srcW))
+	}
+	if h > 0 {
+		scale = min(scale, float64(h)/float64(srcH))
+	}
+	return max(1, int(math.Round(float64(srcW)*scale))), max(1, int(math.Round(float64(srcH)*scale)))
+}
+
+// resizeImage shrinks src to width by height, averaging the source pixels
+// covered by each destination pixel.
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	srcW, srcH := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0 := y * srcH / height
+		y1 := max((y+1)*srcH/height, y0+1)
+		for x := range width {
+			x0 := x * srcW / width
+			x1 := max((x+1)*srcW/width, x0+1)
+
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[rgba.PixOffset(x0, sy):rgba.PixOffset(x1, sy)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += uint64(row[i])
+					sum[1] += uint64(row[i+1])
+					sum[2] += uint64(row[i+2])
+					sum[3] += uint64(row[i+3])
+				}
+			}
+
+			n := uint64((x1 - x0) * (y1 - y0))
+			off := dst.PixOffset(x, y)
+			for i := range sum {
+				dst.Pix[off+i] = uint8((sum[i] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// encodeImage encodes img in the format of the pictures suffix.
+func (s *Server) encodeImage(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch imageFormat(s.config.PicturesSuffix) {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+func (s *Server) thumbnailCachePath(id, picture string, w, h int) string {
+	return filepath.Join(s.config.ThumbnailDir, id, fmt.Sprintf("%s_w%dh%d%s", picture, w, h, s.config.PicturesSuffix))
+}
+
+// thumbnailPath returns the cached thumbnail of a picture for the requested
+// box, generating it first if needed. It returns the original picture when it
+// already fits in the box, or a copy of it in the cache when the original is
+// in a bucket.
+func (s *Server) thumbnailPath(ctx context.Context, id, picture string, w, h int) (string, error) {
+	cachePath := s.thumbnailCachePath(id, picture, w, h)
+	if _, err := os.Stat(cachePath); err == nil {
+		touchFile(cachePath)
+		return cachePath, nil
+	}
+
+	// Downloads don't hold a worker
+	p := Picture{TitleID: id, Name: picture}
+	original, err := s.readPicture(ctx, p)
+	if err != nil {
+		return "", err
+	}
+
+	path := cachePath
+	err = s.processImage(ctx, "thumbnail", func() error {
+		src, _, err := image.Decode(bytes.NewReader(original))
+		if err != nil {
+			return fmt.Errorf("decoding %s failed: %w", pictureKey(p), err)
+		}
+		b := src.Bounds()
+		width, height := thumbnailSize(b.Dx(), b.Dy(), w, h)
+		data := original
+		if width == b.Dx() && height == b.Dy() {
+			if disk, ok := s.pictures.(diskPictures); ok {
+				path = disk.path(p)
+				return nil
+			}
+		} else if data, err = s.encodeImage(resizeImage(src, width, height)); err != nil {
+			return fmt.Errorf("encoding thumbnail failed: %w", err)
+		}
+		if err := writeFileAtomic(cachePath, data); err != nil {
+			return fmt.Errorf("storing thumbnail failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// thumbnailBox is a thumbnail size generated ahead of requests, as the w and
+// h query parameters that ask for it, 0 leaving a side unbounded.
+type thumbnailBox struct {
+	W, H int
+}
+
+// initThumbnailPrecompute parses THUMBNAIL_PRECOMPUTE, a list of sizes like
+// 128 for a width or 256x256 for a box.
+func (s *Server) initThumbnailPrecompute() error {
+	for _, size := range s.config.ThumbnailPrecompute {
+		rawW, rawH, _ := strings.Cut(strings.ToLower(size), "x")
+		w, errW := strconv.Atoi(rawW)
+		h, errH := 0, error(nil)
+		if rawH != "" {
+			h, errH = strconv.Atoi(rawH)
+		}
+		if errW != nil || errH != nil || w < 0 || h < 0 || w+h == 0 ||
+			w > s.config.ThumbnailMaxDimension || h > s.config.ThumbnailMaxDimension {
+			return fmt.Errorf("invalid size %q", size)
+		}
+		s.thumbnailBoxes = append(s.thumbnailBoxes, thumbnailBox{W: w, H: h})
+	}
+	return nil
+}
+
+// precomputeThumbnails generates the THUMBNAIL_PRECOMPUTE sizes of pictures
+// that aren't cached yet, on a few workers that go through the image pool
+// like requests do. Generated files are kept, so a run that is interrupted
+// picks up where it stopped the next time.
+func (s *Server) precomputeThumbnails(ctx context.Context, pictures []Picture) (int, error) {
+	if len(s.thumbnailBoxes) == 0 {
+		return 0, nil
+	}
+
+	var generated atomic.Int64
+	queue := make(chan Picture)
+	var wg sync.WaitGroup
+	for range max(s.config.ThumbnailPrecomputeWorkers, 1) {
+		wg.Go(func() {
+			for p := range queue {
+				for _, box := range s.thumbnailBoxes {
+					if s.precomputeThumbnail(ctx, p, box) {
+						generated.Add(1)
+					}
+				}
+			}
+		})
+	}
+
+	for i, p := range pictures {
+		select {
+		case queue <- p:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if (i+1)%1000 == 0 {
+			log.Printf("Precomputing thumbnails: %d/%d pictures\n", i+1, len(pictures))
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return int(generated.Load()), ctx.Err()
+}
+
+// precomputeThumbnail generates one thumbnail unless it exists or would be
+// the original picture, returning whether it did. It waits while the image
+// pool is saturated, leaving room for requests.
+func (s *Server) precomputeThumbnail(ctx context.Context, p Picture, box thumbnailBox) bool {
+	if p.Width > 0 && p.Height > 0 {
+		if w, h := thumbnailSize(p.Width, p.Height, box.W, box.H); w == p.Width && h == p.Height {
+			return false
+		}
+	}
+	id := strings.ToLower(p.TitleID)
+	cachePath := s.thumbnailCachePath(id, p.Name, box.W, box.H)
+	if _, err := os.Stat(cachePath); err == nil {
+		return false
+	}
+
+	for {
+		path, err := s.thumbnailPath(ctx, id, p.Name, box.W, box.H)
+		if errors.Is(err, errImagePoolBusy) {
+			select {
+			case <-time.After(100 * time.Millisecond):
+				continue
+			case <-ctx.Done():
+				return false
+			}
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Warning: precomputing thumbnail %s/%s_w%dh%d failed: %v\n", id, p.Name, box.W, box.H, err)
+			}
+			return false
+		}
+		return path == cachePath
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestThumbnails(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	f, err := os.Create(filepath.Join(s.config.PicturesFolder, "4d5307e6", "8000.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+	f.Close()
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		width  int
+		height int
+	}{
+		{"original", "/api/v1/titles/4d5307e6/8000.png", http.StatusOK, 40, 20},
+		{"width", "/api/v1/titles/4d5307e6/8000.png?w=10", http.StatusOK, 10, 5},
+		{"cached", "/api/v1/titles/4d5307e6/8000.png?w=10", http.StatusOK, 10, 5},
+		{"box", "/api/v1/titles/4d5307e6/8000.png?w=30&h=5", http.StatusOK, 10, 5},
+		{"never enlarged", "/api/v1/titles/4d5307e6/8000.png?h=100", http.StatusOK, 40, 20},
+		{"invalid", "/api/v1/titles/4d5307e6/8000.png?w=0", http.StatusBadRequest, 0, 0},
+		{"too large", "/api/v1/titles/4d5307e6/8000.png?w=100000", http.StatusBadRequest, 0, 0},
+		{"missing", "/api/v1/titles/4d5307e6/8001.png?w=10", http.StatusNotFound, 0, 0},
+	}
+
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, nil)
+		if w.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d; body: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		cfg, err := png.DecodeConfig(w.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if cfg.Width != tt.width || cfg.Height != tt.height {
+			t.Errorf("%s: size = %dx%d, want %dx%d", tt.name, cfg.Width, cfg.Height, tt.width, tt.height)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(s.config.ThumbnailDir, "4d5307e6", "8000_w10h0.png")); err != nil {
+		t.Errorf("thumbnail was not cached: %v", err)
+	}
+}
+
+func TestPrecomputeThumbnails(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.ThumbnailPrecompute = []string{"10", "16x16"}
+	})
+	f, _ := os.Create(filepath.Join(s.config.PicturesFolder, "584109eb", "20400.png"))
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+	f.Close()
+
+	// Pictures that already fit, like the 1x1 ones, are left as they are
+	rescan, err := s.rescanPictures(false, nil)
+	if err != nil || rescan.String() != "0 pictures added, 1 updated, 0 removed, 2 thumbnails generated" {
+		t.Fatalf("rescan = %s, %v", rescan, err)
+	}
+	for _, name := range []string{"20400_w10h0.png", "20400_w16h16.png"} {
+		if _, err := os.Stat(filepath.Join(s.config.ThumbnailDir, "584109eb", name)); err != nil {
+			t.Errorf("thumbnail %s: %v", name, err)
+		}
+	}
+
+	// Another run only generates what is missing
+	os.Remove(filepath.Join(s.config.ThumbnailDir, "584109eb", "20400_w16h16.png"))
+	if rescan, err := s.rescanPictures(false, nil); err != nil || rescan.Thumbnails != 1 {
+		t.Errorf("second rescan = %s, %v", rescan, err)
+	}
+
+	for _, size := range []string{"0", "x16", "10x-1", "100000", "big"} {
+		bad := &Server{config: s.config}
+		bad.config.ThumbnailPrecompute = []string{size}
+		if err := bad.initThumbnailPrecompute(); err == nil {
+			t.Errorf("THUMBNAIL_PRECOMPUTE=%s was accepted", size)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"cmp"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// closestTitles is how many known ids a 404 suggests.
+const closestTitles = 5
+
+// TitleNotFound is the body of a 404 for a title id, which tells a typo from
+// a title the catalog doesn't have.
+type TitleNotFound struct {
+	Error string `json:"error"`
+	// Warning says what is wrong with a malformed id
+	Warning string `json:"warning,omitempty"`
+	TitleID string `json:"title_id,omitempty"`
+	// Publisher is the code in the upper half of a well-formed id, like MS
+	// for 4D5307E6, when it is printable
+	Publisher string `json:"publisher,omitempty"`
+	// Closest are the known ids of the same publisher nearest to it
+	Closest []TitleNeighbor `json:"closest,omitempty"`
+}
+
+// TitleNeighbor is a known title suggested in place of a missing one.
+type TitleNeighbor struct {
+	TitleID string `json:"title_id"`
+	Name    string `json:"name"`
+}
+
+// titlePublisher decodes the publisher code of a well-formed title id, the
+// two characters its first four hex digits spell.
+func titlePublisher(id string) string {
+	code, err := hex.DecodeString(id[:4])
+	if err != nil {
+		return ""
+	}
+	for _, b := range code {
+		if b < '0' || b > 'Z' || (b > '9' && b < 'A') {
+			return ""
+		}
+	}
+	return string(code)
+}
+
+// closestTitleIDs returns the known titles of the publisher of id with the
+// nearest ids, in order.
+func (s *Server) closestTitleIDs(c *gin.Context, id string) ([]TitleNeighbor, error) {
+	var below, above []TitleNeighbor
+	query := s.db.WithContext(c.Request.Context()).Model(&Title{}).Select("title_id, name").
+		Where("UPPER(title_id) LIKE ?", id[:4]+"%")
+	if err := query.Session(&gorm.Session{}).Where("UPPER(title_id) < ?", id).
+		Order("title_id DESC").Limit(closestTitles).Find(&below).Error; err != nil {
+		return nil, err
+	}
+	if err := query.Session(&gorm.Session{}).Where("UPPER(title_id) > ?", id).
+		Order("title_id ASC").Limit(closestTitles).Find(&above).Error; err != nil {
+		return nil, err
+	}
+
+	target, _ := strconv.ParseUint(id, 16, 32)
+	distance := func(n TitleNeighbor) uint64 {
+		v, _ := strconv.ParseUint(n.TitleID, 16, 32)
+		if v > target {
+			return v - target
+		}
+		return target - v
+	}
+	closest := append(below, above...)
+	slices.SortStableFunc(closest, func(a, b TitleNeighbor) int {
+		return cmp.Compare(distance(a), distance(b))
+	})
+	closest = closest[:min(len(closest), closestTitles)]
+	slices.SortFunc(closest, func(a, b TitleNeighbor) int {
+		return cmp.Compare(strings.ToUpper(a.TitleID), strings.ToUpper(b.TitleID))
+	})
+	return closest, nil
+}
+
+// titleNotFound answers a lookup of id that matched no title. Well-formed ids
+// get their publisher and the closest known ids, malformed ones a warning.
+func (s *Server) titleNotFound(c *gin.Context, id string) {
+	id = strings.ToUpper(strings.TrimSpace(id))
+	body := TitleNotFound{Error: "Title not found"}
+	if !titleIDPattern.MatchString(id) {
+		body.Warning = "Title ids are 8 hexadecimal digits"
+		c.JSON(http.StatusNotFound, body)
+		return
+	}
+
+	body.TitleID = id
+	body.Publisher = titlePublisher(id)
+	closest, err := s.closestTitleIDs(c, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	body.Closest = closest
+	c.JSON(http.StatusNotFound, body)
+}
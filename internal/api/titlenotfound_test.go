@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTitleNotFound(t *testing.T) {
+	r := newTestServer(t, testTitles)
+
+	w := doRequest(r, "GET", "/api/v1/titles/4d5307f0", nil)
+	var body TitleNotFound
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, err = %v; body: %s", w.Code, err, w.Body.String())
+	}
+	if body.Error != "Title not found" || body.TitleID != "4D5307F0" || body.Publisher != "MS" || body.Warning != "" {
+		t.Errorf("body = %+v", body)
+	}
+	// The closest ids come from the same publisher, nearest first then sorted
+	if len(body.Closest) != 2 || body.Closest[0].TitleID != "4D5307E6" || body.Closest[1] != (TitleNeighbor{"4D530802", "Halo 3: ODST"}) {
+		t.Errorf("closest = %+v", body.Closest)
+	}
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/api/v1/titles/00000000/manifest.json", `{"error":"Title not found","title_id":"00000000"}`},
+		{"/api/v1/titles/58410000/archive", `"publisher":"XA","closest":[{"title_id":"584109EB","name":"Minecraft"}]`},
+		{"/api/v1/titles/halo3", `{"error":"Title not found","warning":"Title ids are 8 hexadecimal digits"}`},
+	}
+	for _, tt := range tests {
+		w := doRequest(r, "GET", tt.target, nil)
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: status = %d, body = %s", tt.target, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(r, "POST", "/api/v1/titles/415607f8/view", nil); !strings.Contains(w.Body.String(), `"closest":[{"title_id":"415607F7"`) {
+		t.Errorf("view: status = %d, body = %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var systemNames = map[string]string{
+	"XBOX":    "Xbox",
+	"XBOX360": "Xbox 360",
+	"XBOXONE": "Xbox One",
+	"PC":      "PC",
+}
+
+// VideoGame is the subset of the schema.org VideoGame type we can fill from the catalog.
+type VideoGame struct {
+	Context      string   `json:"@context"`
+	Type         string   `json:"@type"`
+	Name         string   `json:"name"`
+	Identifier   string   `json:"identifier"`
+	URL          string   `json:"url"`
+	GamePlatform []string `json:"gamePlatform,omitempty"`
+	Image        []string `json:"image,omitempty"`
+}
+
+func systemName(system string) string {
+	if name, ok := systemNames[system]; ok {
+		return name
+	}
+	return system
+}
+
+// requestBaseURL returns the URL absolute links in responses start with:
+// PUBLIC_URL, or else the scheme and host of the request. X-Forwarded-Proto
+// is only honored from TRUSTED_PROXIES, since these links end up in cached
+// pages and signed URLs.
+func (s *Server) requestBaseURL(c *gin.Context) string {
+	if s.config.PublicURL != "" {
+		return s.config.PublicURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); (proto == "http" || proto == "https") && s.trustedProxy(c.RemoteIP()) {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
+
+// trustedProxy tells whether ip is one of TRUSTED_PROXIES.
+func (s *Server) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses TRUSTED_PROXIES, addresses or CIDR ranges.
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, proxy := range proxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (s *Server) pictureURL(baseURL, titleID, picture string) string {
+	return fmt.Sprintf("%s/api/v1/titles/%s/%s%s", baseURL, strings.ToLower(titleID), picture, s.config.PicturesSuffix)
+}
+
+func (s *Server) videoGameData(title Title, baseURL string) VideoGame {
+	game := VideoGame{
+		Context:    "https://schema.org",
+		Type:       "VideoGame",
+		Name:       title.Name,
+		Identifier: title.TitleID,
+		URL:        fmt.Sprintf("%s/titles/%s", baseURL, strings.ToLower(title.TitleID)),
+	}
+	for _, system := range title.Systems {
+		game.GamePlatform = append(game.GamePlatform, systemName(system))
+	}
+	for _, pic := range title.Pictures {
+		game.Image = append(game.Image, s.pictureURL(baseURL, title.TitleID, pic.Name))
+	}
+	return game
+}
+
+func (s *Server) titlePage(c *gin.Context) {
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == gorm.ErrRecordNotFound {
+			status = http.StatusNotFound
+		}
+		c.String(status, http.StatusText(status))
+		return
+	}
+
+	baseURL := s.requestBaseURL(c)
+	game := s.videoGameData(title, baseURL)
+	jsonLD, err := json.Marshal(game)
+	if err != nil {
+		c.String(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	setCacheHeaders(c, s.config.CacheDetails)
+	c.HTML(http.StatusOK, "title.html", gin.H{
+		"title":  fmt.Sprintf("%s (%s) - %s", title.Name, title.TitleID, s.config.Branding.Name),
+		"item":   title,
+		"game":   game,
+		"jsonld": template.JS(jsonLD),
+	})
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRequestBaseURL(t *testing.T) {
+	forwarded := map[string]string{"X-Forwarded-Proto": "https"}
+	ogURL := func(s *Server) string {
+		w := doRequest(s, "GET", "/titles/4d5307e6", forwarded)
+		_, rest, _ := strings.Cut(w.Body.String(), `property="og:url" content="`)
+		url, _, _ := strings.Cut(rest, `"`)
+		return url
+	}
+
+	// httptest requests come from 192.0.2.1
+	for _, tc := range []struct {
+		name      string
+		configure func(*Config)
+		want      string
+	}{
+		{"untrusted client", func(*Config) {}, "http://example.com/titles/4d5307e6"},
+		{"trusted proxy", func(cfg *Config) { cfg.TrustedProxies = []string{"192.0.2.0/24"} }, "https://example.com/titles/4d5307e6"},
+		{"public url", func(cfg *Config) { cfg.PublicURL = "https://xtitles.example.org" }, "https://xtitles.example.org/titles/4d5307e6"},
+	} {
+		if got := ogURL(newTestServerWithConfig(t, testTitles, tc.configure)); got != tc.want {
+			t.Errorf("%s: og:url = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTitlePageStructuredData(t *testing.T) {
+	titles := append(slices.Clone(testTitles), Title{TitleID: "4D5307F1", Name: "Halo </script><b>Beta</b>", Systems: []string{"PC"}})
+	s := newTestServer(t, titles)
+	pictures := func(id string, names ...string) []string {
+		var urls []string
+		for _, name := range names {
+			urls = append(urls, "http://example.com/api/v1/titles/"+id+"/"+name+".png")
+		}
+		return urls
+	}
+
+	tests := []struct {
+		target string
+		status int
+		want   VideoGame
+	}{
+		{"/titles/4d5307e6", http.StatusOK, VideoGame{
+			Name: "Halo 3", Identifier: "4D5307E6", URL: "http://example.com/titles/4d5307e6",
+			GamePlatform: []string{"Xbox 360"}, Image: pictures("4d5307e6", "20400", "20401"),
+		}},
+		{"/titles/584109EB", http.StatusOK, VideoGame{
+			Name: "Minecraft", Identifier: "584109EB", URL: "http://example.com/titles/584109eb",
+			GamePlatform: []string{"Xbox 360", "PC"}, Image: pictures("584109eb", "20400"),
+		}},
+		{"/titles/415607f7", http.StatusOK, VideoGame{
+			Name: "Call of Duty 4", Identifier: "415607F7", URL: "http://example.com/titles/415607f7",
+			GamePlatform: []string{"Xbox 360"},
+		}},
+		// Names can't close the script element
+		{"/titles/4d5307f1", http.StatusOK, VideoGame{
+			Name: "Halo </script><b>Beta</b>", Identifier: "4D5307F1", URL: "http://example.com/titles/4d5307f1",
+			GamePlatform: []string{"PC"},
+		}},
+		{"/titles/4d5307f0", http.StatusNotFound, VideoGame{}},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, nil)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.target, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		_, rest, _ := strings.Cut(w.Body.String(), `<script type="application/ld+json">`)
+		data, _, ok := strings.Cut(rest, "</script>")
+		var got VideoGame
+		if err := json.Unmarshal([]byte(data), &got); !ok || err != nil {
+			t.Errorf("%s: no JSON-LD (%v): %s", tt.target, err, data)
+			continue
+		}
+		tt.want.Context, tt.want.Type = "https://schema.org", "VideoGame"
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: JSON-LD = %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestTitlePageMarkup(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	tests := []struct {
+		target   string
+		contains []string
+		excludes []string
+	}{
+		{"/titles/4d5307e6", []string{
+			`<main>`,
+			`<article class="title-card" aria-labelledby="title-name">`,
+			`<h2 class="title-name" id="title-name">Halo 3</h2>`,
+			`<ul class="pictures-container" aria-label="Gamerpics">`,
+			`<img src="/api/v1/titles/4d5307e6/20400.png" alt="Halo 3 gamerpic 20400" loading="lazy">`,
+			`<img src="/api/v1/titles/4d5307e6/20401.png" alt="Halo 3 gamerpic 20401" loading="lazy">`,
+		}, []string{`alt=""`, `class="empty"`}},
+		{"/titles/415607f7", []string{
+			`<h2 class="title-name" id="title-name">Call of Duty 4</h2>`,
+			`<p class="empty">No pictures available</p>`,
+		}, []string{`<img`, `aria-label="Gamerpics"`}},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d", tt.target, w.Code)
+			continue
+		}
+		for _, want := range tt.contains {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: page does not contain %s", tt.target, want)
+			}
+		}
+		for _, unwanted := range tt.excludes {
+			if strings.Contains(w.Body.String(), unwanted) {
+				t.Errorf("%s: page contains %s", tt.target, unwanted)
+			}
+		}
+	}
+
+	// Third-party frontends get the same alt text from the API
+	for _, target := range []string{"/api/v1/titles/4d5307e6", "/api/v1/titles?q=halo", "/api/v1/search?q=minecraft"} {
+		w := doRequest(s, "GET", target, nil)
+		if !strings.Contains(w.Body.String(), `"alt":"`) || strings.Contains(w.Body.String(), `"alt":""`) {
+			t.Errorf("%s: missing alt text: %s", target, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/birabittoh/xtitles/internal/tracing"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const tracingSpanKey = "tracing:span"
+
+// initTracing starts exporting traces when OTEL_EXPORTER_OTLP_ENDPOINT is set.
+func (s *Server) initTracing() error {
+	if s.config.OTLPEndpoint == "" {
+		return nil
+	}
+	if u, err := url.Parse(s.config.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("expected an http(s) URL, got %q", s.config.OTLPEndpoint)
+	}
+	headers, err := parseOTLPHeaders(s.config.OTLPHeaders)
+	if err != nil {
+		return err
+	}
+	s.tracer = tracing.New(tracing.Config{
+		Endpoint:    s.config.OTLPEndpoint,
+		Headers:     headers,
+		ServiceName: s.config.OTelServiceName,
+		SampleRatio: s.config.TraceSampleRatio,
+	})
+	log.Printf("Exporting traces to %s\n", s.config.OTLPEndpoint)
+	return nil
+}
+
+// parseOTLPHeaders parses OTEL_EXPORTER_OTLP_HEADERS, key=value pairs with
+// URL encoded values, like Authorization=Bearer%20s3cr3t.
+func parseOTLPHeaders(value string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range parseList(value) {
+		key, raw, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("expected key=value headers, got %q", pair)
+		}
+		v, err := url.QueryUnescape(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+		headers[strings.TrimSpace(key)] = v
+	}
+	return headers, nil
+}
+
+// traceRequests records a server span for each request, continuing the trace
+// of its traceparent header. Handlers pass c.Request.Context() on for their
+// queries and calls to show up under it.
+func (s *Server) traceRequests(c *gin.Context) {
+	// Probes and scrapes would drown the rest
+	switch c.Request.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		c.Next()
+		return
+	}
+
+	name := c.Request.Method
+	if route := c.FullPath(); route != "" {
+		name += " " + route
+	}
+	ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+	ctx, span := s.tracer.Start(ctx, name, tracing.KindServer,
+		tracing.String("http.request.method", c.Request.Method),
+		tracing.String("http.route", c.FullPath()),
+		tracing.String("url.path", c.Request.URL.Path),
+		tracing.String("client.address", c.ClientIP()),
+		tracing.String("user_agent.original", c.Request.UserAgent()))
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(tracing.Int("http.response.status_code", int64(status)))
+	if status >= 500 {
+		span.SetError(errors.New(http.StatusText(status)))
+	}
+}
+
+// registerQueryTracing records a span for every statement run with the
+// context of a traced request or job. Statements without one, like those of
+// background work, aren't traced.
+func (s *Server) registerQueryTracing(db *gorm.DB) error {
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			if tx.Statement.Context == nil || tracing.SpanFromContext(tx.Statement.Context) == nil {
+				return
+			}
+			name := operation
+			if tx.Statement.Table != "" {
+				name += " " + tx.Statement.Table
+			}
+			_, span := s.tracer.Start(tx.Statement.Context, name, tracing.KindClient,
+				tracing.String("db.system.name", tx.Dialector.Name()),
+				tracing.String("db.operation.name", operation))
+			tx.InstanceSet(tracingSpanKey, span)
+		}
+	}
+	after := func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(tracingSpanKey)
+		if !ok {
+			return
+		}
+		span := value.(*tracing.Span)
+		span.SetAttributes(
+			tracing.String("db.query.text", tx.Statement.SQL.String()),
+			tracing.Int("db.response.returned_rows", tx.Statement.RowsAffected))
+		if tx.Statement.Table != "" {
+			span.SetAttributes(tracing.String("db.collection.name", tx.Statement.Table))
+		}
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			span.SetError(tx.Error)
+		}
+		span.End()
+	}
+
+	callbacks := db.Callback()
+	for _, p := range []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	} {
+		if err := p.before("tracing:before_"+p.operation, before(p.operation)); err != nil {
+			return err
+		}
+		if err := p.after("tracing:after_"+p.operation, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
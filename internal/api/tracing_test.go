@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Attributes   []struct {
+			Key   string            `json:"key"`
+			Value map[string]string `json:"value"`
+		} `json:"attributes"`
+	}
+	var mu sync.Mutex
+	var spans []span
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.OTLPEndpoint = collector.URL })
+	w := doRequest(s, "GET", "/api/v1/search?q=halo", map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("search: status = %d", w.Code)
+	}
+	doRequest(s, "GET", "/healthz", nil)
+	if err := s.tracer.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var request, search, query span
+	for _, sp := range spans {
+		switch {
+		case sp.Name == "GET /api/v1/search":
+			request = sp
+		case sp.Name == "search":
+			search = sp
+		case strings.HasPrefix(sp.Name, "query") && sp.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736":
+			for _, a := range sp.Attributes {
+				if a.Key == "db.query.text" && strings.Contains(a.Value["stringValue"], "titles_fts") {
+					query = sp
+				}
+			}
+		case sp.Name == "GET /healthz":
+			t.Errorf("probe traced")
+		}
+	}
+	if request.ParentSpanID != "00f067aa0ba902b7" || request.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("request span = %+v", request)
+	}
+	if search.ParentSpanID == "" || search.ParentSpanID != request.SpanID {
+		t.Errorf("search span = %+v, want child of %s", search, request.SpanID)
+	}
+	if query.ParentSpanID != search.SpanID {
+		t.Errorf("search query span = %+v, want child of %s", query, search.SpanID)
+	}
+}
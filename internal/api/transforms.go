@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/birabittoh/xtitles/internal/script"
+)
+
+// transformExt is the extension of the ingest transform scripts in SCRIPTS_DIR.
+const transformExt = ".transform"
+
+// initTransforms loads the ingest transforms, in file name order. A script
+// that doesn't parse stops the server rather than letting bad names in.
+func (s *Server) initTransforms() error {
+	entries, err := os.ReadDir(s.config.ScriptsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != transformExt {
+			continue
+		}
+		src, err := os.ReadFile(filepath.Join(s.config.ScriptsDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		transform, err := script.Parse(entry.Name(), string(src))
+		if err != nil {
+			return err
+		}
+		s.transforms = append(s.transforms, transform)
+		log.Printf("Loaded ingest transform %s\n", entry.Name())
+	}
+	return nil
+}
+
+// transformTitle runs the ingest transforms on t, returning false when one of
+// them dropped it.
+func (s *Server) transformTitle(t *Title) (bool, error) {
+	for _, transform := range s.transforms {
+		keep, err := transform.Run(t)
+		if err != nil {
+			return false, err
+		}
+		if !keep {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// transformTitles runs the ingest transforms on a batch. Titles a transform
+// fails on are rejected for review like invalid ones.
+func (s *Server) transformTitles(titles []Title) ([]Title, []IngestReject) {
+	if len(s.transforms) == 0 {
+		return titles, nil
+	}
+
+	kept := make([]Title, 0, len(titles))
+	var rejects []IngestReject
+	for _, t := range titles {
+		keep, err := s.transformTitle(&t)
+		if err != nil {
+			rejects = append(rejects, newIngestReject(t, fmt.Sprintf("transform failed: %v", err)))
+			continue
+		}
+		if keep {
+			kept = append(kept, t)
+		}
+	}
+	if dropped := len(titles) - len(kept) - len(rejects); dropped > 0 {
+		log.Printf("Ingest transforms dropped %d titles\n", dropped)
+	}
+	return kept, rejects
+}
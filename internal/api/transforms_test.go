@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIngestTransforms(t *testing.T) {
+	scripts := t.TempDir()
+	os.WriteFile(filepath.Join(scripts, "10-names.transform"), []byte(`# Tag the PC releases
+if has_system("PC")
+    name = name + " (PC)"
+end
+if starts_with(name, "Call of Duty")
+    drop
+end
+`), 0644)
+	os.WriteFile(filepath.Join(scripts, "20-fail.transform"), []byte("if title_id == \"4D530802\" and bing_id\nend\n"), 0644)
+	os.WriteFile(filepath.Join(scripts, "notes.txt"), []byte("not a script"), 0644)
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) { cfg.ScriptsDir = scripts })
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/api/v1/titles/584109eb", http.StatusOK, `"name":"Minecraft (PC)"`},
+		{"/api/v1/titles/4d5307e6", http.StatusOK, `"name":"Halo 3"`},
+		{"/api/v1/titles/415607f7", http.StatusNotFound, "Title not found"},
+		{"/api/v1/titles/4d530802", http.StatusNotFound, "Title not found"},
+		{"/api/v1/admin/ingest/rejects", http.StatusOK, `transform failed: 20-fail.transform: line 1: expected a boolean, got a string`},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, admin)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: status = %d, want %d; body: %s", tt.target, w.Code, tt.status, w.Body.String())
+		}
+	}
+
+	os.WriteFile(filepath.Join(scripts, "30-broken.transform"), []byte("name = shout(name)\n"), 0644)
+	cfg := testConfig(t, "http://127.0.0.1:0")
+	cfg.ScriptsDir = scripts
+	if _, err := newServer(cfg); err == nil || !strings.Contains(err.Error(), `30-broken.transform: line 1: unknown function "shout"`) {
+		t.Errorf("newServer with a broken script: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var pictureNamePattern = regexp.MustCompile(`^[0-9a-z_-]{1,10}$`)
+
+// imageFormat returns the image format name matching a pictures suffix.
+func imageFormat(suffix string) string {
+	format := strings.TrimPrefix(strings.ToLower(suffix), ".")
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
+
+// validatePicture checks that data is an image in the format of the picture
+// suffix, returning why it is not or "" if it is fine.
+func (s *Server) validatePicture(data []byte) string {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "file is not a supported image"
+	}
+	if want := imageFormat(s.config.PicturesSuffix); format != want {
+		return fmt.Sprintf("image is %s, expected %s", format, want)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return "image has no pixels"
+	}
+	return ""
+}
+
+// describePicture fills in the metadata of p from the content of its file.
+// Dimensions stay unset when the file isn't an image Go can decode.
+func describePicture(p *Picture, data []byte) {
+	sum := sha256.Sum256(data)
+	p.SHA256 = hex.EncodeToString(sum[:])
+	p.Size = int64(len(data))
+	p.Width, p.Height = 0, 0
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		p.Width, p.Height = cfg.Width, cfg.Height
+	}
+}
+
+// scanPicture reads the file of p to fill in its metadata.
+func (s *Server) scanPicture(p *Picture) error {
+	data, err := s.readPicture(s.ctx, *p)
+	if err != nil {
+		return err
+	}
+	describePicture(p, data)
+	return nil
+}
+
+func (s *Server) uploadPicture(c *gin.Context) {
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// The body is capped at PICTURE_MAX_UPLOAD_SIZE_MB by requestLimits
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Picture is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Form field 'file' is required"})
+		return
+	}
+
+	// Default to the uploaded file name, e.g. 20400.png
+	name := strings.ToLower(c.PostForm("name"))
+	if name == "" {
+		name = strings.ToLower(strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename)))
+	}
+	if !pictureNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid picture name"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read upload"})
+		return
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read upload"})
+		return
+	}
+	if reason := s.validatePicture(data); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid picture: " + reason})
+		return
+	}
+
+	var existing int64
+	s.db.Model(&Picture{}).Where("title_id = ? AND name = ?", title.TitleID, name).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Picture already exists"})
+		return
+	}
+
+	picture := Picture{TitleID: title.TitleID, Name: name}
+	if err := s.pictures.Put(c.Request.Context(), picture, data); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store picture"})
+		return
+	}
+	s.removeDerivedPictures(strings.ToLower(title.TitleID), name)
+
+	describePicture(&picture, data)
+	if err := s.db.Create(&picture).Error; err != nil {
+		s.pictures.Delete(context.WithoutCancel(c.Request.Context()), picture)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	picture.Alt = store.PictureAlt(title.Name, picture.Name)
+
+	// The pictures are part of the title as served, so its ETag must change
+	s.db.Model(&Title{TitleID: title.TitleID}).UpdateColumn("updated_at", time.Now())
+	s.catalogChanged()
+
+	c.Header("Location", fmt.Sprintf("/api/v1/titles/%s/%s%s", strings.ToLower(title.TitleID), name, s.config.PicturesSuffix))
+	c.JSON(http.StatusCreated, picture)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partial file. Concurrent writers each get
+// their own temporary file, the last rename wins.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// UploadURL lets whoever holds it upload a picture of a title once, without
+// admin credentials, until it expires.
+type UploadURL struct {
+	UploadURL string    `json:"upload_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *Server) initUploadURLs() {
+	s.uploadSigningKey = []byte(s.config.UploadSigningKey)
+	if len(s.uploadSigningKey) == 0 {
+		// Upload links won't survive a restart, nor work on other instances
+		s.uploadSigningKey = []byte(newJobID())
+	}
+}
+
+// uploadSignature binds an upload link to its title, expiry and nonce.
+func (s *Server) uploadSignature(titleID string, expires int64, nonce string) string {
+	mac := hmac.New(sha256.New, s.uploadSigningKey)
+	fmt.Fprintf(mac, "%s|%d|%s", titleID, expires, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) createUploadURL(c *gin.Context) {
+	title, err := s.findTitle(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Title not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	titleID := strings.ToLower(title.TitleID)
+	expiresAt := time.Now().Add(s.config.UploadURLTTL).Truncate(time.Second)
+	nonce := newJobID()
+	c.JSON(http.StatusOK, UploadURL{
+		UploadURL: fmt.Sprintf("%s/api/v1/uploads/titles/%s/pictures?expires=%d&nonce=%s&signature=%s",
+			s.requestBaseURL(c), titleID, expiresAt.Unix(), nonce, s.uploadSignature(titleID, expiresAt.Unix(), nonce)),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// verifyUploadURL checks the signature of an upload link and that it wasn't
+// used already, before uploadPicture handles it. A link whose upload fails
+// can be used again, until it expires.
+func (s *Server) verifyUploadURL(c *gin.Context) {
+	titleID := strings.ToLower(c.Param("id"))
+	nonce := c.Query("nonce")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || nonce == "" ||
+		!hmac.Equal([]byte(c.Query("signature")), []byte(s.uploadSignature(titleID, expires, nonce))) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Invalid signature"})
+		return
+	}
+	if time.Now().Unix() > expires {
+		c.AbortWithStatusJSON(http.StatusGone, gin.H{"error": "Upload link expired"})
+		return
+	}
+
+	fresh, err := s.useNonce("upload:"+nonce, time.Unix(expires, 0))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !fresh {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Upload link was already used"})
+		return
+	}
+
+	c.Next()
+
+	if status := c.Writer.Status(); status < 200 || status >= 300 {
+		s.releaseNonce("upload:" + nonce)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// multipartPicture builds an upload form with data as the file field.
+func multipartPicture(t *testing.T, filename string, data []byte) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	return buf.String(), mw.FormDataContentType()
+}
+
+func TestUploadPicture(t *testing.T) {
+	r := newTestServer(t, testTitles)
+
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+
+	steps := []struct {
+		name     string
+		target   string
+		filename string
+		data     []byte
+		status   int
+		contains string
+	}{
+		{"upload", "/api/v1/admin/titles/4d530802/pictures", "20402.png", pngData.Bytes(), http.StatusCreated, `"alt":"Halo 3: ODST gamerpic 20402"`},
+		{"upload duplicate", "/api/v1/admin/titles/4d530802/pictures", "20402.png", pngData.Bytes(), http.StatusConflict, "already exists"},
+		{"upload not an image", "/api/v1/admin/titles/4d530802/pictures", "20403.png", []byte("hello"), http.StatusBadRequest, "not a supported image"},
+		{"upload bad name", "/api/v1/admin/titles/4d530802/pictures", "Front Cover Art.png", pngData.Bytes(), http.StatusBadRequest, "Invalid picture name"},
+		{"upload unknown title", "/api/v1/admin/titles/00000000/pictures", "20402.png", pngData.Bytes(), http.StatusNotFound, "Title not found"},
+	}
+
+	for _, step := range steps {
+		body, contentType := multipartPicture(t, step.filename, step.data)
+		header := map[string]string{"Authorization": "Bearer test-token", "Content-Type": contentType}
+		w := doRequestBody(r, "POST", step.target, header, body)
+		if w.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d; body: %s", step.name, w.Code, step.status, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), step.contains) {
+			t.Errorf("%s: body does not contain %q: %s", step.name, step.contains, w.Body.String())
+		}
+	}
+
+	if w := doRequest(r, "GET", "/api/v1/titles/4d530802/20402.png", nil); w.Code != http.StatusOK {
+		t.Errorf("uploaded picture: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestSignedUploadURL(t *testing.T) {
+	s := newTestServer(t, testTitles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+
+	uploadURL := func() string {
+		t.Helper()
+		w := doRequest(s, "POST", "/api/v1/admin/titles/4D530802/pictures/upload-url", admin)
+		var created UploadURL
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &created) != nil {
+			t.Fatalf("creating upload URL: status = %d; body: %s", w.Code, w.Body.String())
+		}
+		_, target, _ := strings.Cut(created.UploadURL, "://example.com")
+		return target
+	}
+	target := uploadURL()
+	if !strings.HasPrefix(target, "/api/v1/uploads/titles/4d530802/pictures?expires=") {
+		t.Fatalf("upload URL = %q", target)
+	}
+
+	steps := []struct {
+		name     string
+		target   string
+		filename string
+		data     []byte
+		status   int
+		contains string
+	}{
+		{"failed upload", target, "20402.png", []byte("hello"), http.StatusBadRequest, "not a supported image"},
+		{"retry", target, "20402.png", pngData.Bytes(), http.StatusCreated, `"name":"20402"`},
+		{"replay", target, "20403.png", pngData.Bytes(), http.StatusConflict, "already used"},
+		{"other title", strings.Replace(uploadURL(), "4d530802", "4d5307e6", 1), "20403.png", pngData.Bytes(), http.StatusForbidden, "Invalid signature"},
+		{"no signature", "/api/v1/uploads/titles/4d530802/pictures", "20403.png", pngData.Bytes(), http.StatusForbidden, "Invalid signature"},
+	}
+	for _, step := range steps {
+		body, contentType := multipartPicture(t, step.filename, step.data)
+		w := doRequestBody(s, "POST", step.target, map[string]string{"Content-Type": contentType}, body)
+		if w.Code != step.status || !strings.Contains(w.Body.String(), step.contains) {
+			t.Errorf("%s: status = %d, want %d; body: %s", step.name, w.Code, step.status, w.Body.String())
+		}
+	}
+
+	s.config.UploadURLTTL = -time.Minute
+	body, contentType := multipartPicture(t, "20403.png", pngData.Bytes())
+	if w := doRequestBody(s, "POST", uploadURL(), map[string]string{"Content-Type": contentType}, body); w.Code != http.StatusGone {
+		t.Errorf("expired: status = %d, want %d", w.Code, http.StatusGone)
+	}
+
+	if w := doRequest(s, "POST", "/api/v1/admin/titles/00000000/pictures/upload-url", admin); w.Code != http.StatusNotFound {
+		t.Errorf("unknown title: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UsageDay counts the API requests and searches of a day (UTC). Together with
+// SearchHit it is all the traffic data kept: no addresses, no queries.
+type UsageDay struct {
+	Day      string `json:"day" gorm:"primaryKey"`
+	Requests int64  `json:"requests"`
+	Searches int64  `json:"searches"`
+}
+
+// SearchHit counts the searches of a day whose first result was a title.
+type SearchHit struct {
+	Day     string `gorm:"primaryKey"`
+	TitleID string `gorm:"primaryKey"`
+	Count   int64
+}
+
+type TitleUsage struct {
+	TitleID string `json:"title_id"`
+	Name    string `json:"name"`
+	Count   int64  `json:"count"`
+}
+
+type UsageStats struct {
+	Days         []UsageDay   `json:"days"`
+	TopSearched  []TitleUsage `json:"top_searched"`
+	MinCount     int          `json:"min_count"`
+	RetainedDays int          `json:"retained_days"`
+}
+
+// usageCounter buffers the counts between flushes, so that requests don't
+// each write to the database.
+type usageCounter struct {
+	mu   sync.Mutex
+	days map[string]*UsageDay
+	hits map[SearchHit]int64
+}
+
+func newUsageCounter() *usageCounter {
+	return &usageCounter{days: map[string]*UsageDay{}, hits: map[SearchHit]int64{}}
+}
+
+func usageDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+func (u *usageCounter) day(day string) *UsageDay {
+	d, ok := u.days[day]
+	if !ok {
+		d = &UsageDay{Day: day}
+		u.days[day] = d
+	}
+	return d
+}
+
+func (u *usageCounter) request() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.day(usageDay(time.Now())).Requests++
+}
+
+// search counts a search, and the title it led to when it found any.
+func (u *usageCounter) search(titleID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	day := usageDay(time.Now())
+	u.day(day).Searches++
+	if titleID != "" {
+		u.hits[SearchHit{Day: day, TitleID: titleID}]++
+	}
+}
+
+// take empties the counter, returning what it held.
+func (u *usageCounter) take() (map[string]*UsageDay, map[SearchHit]int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	days, hits := u.days, u.hits
+	u.days, u.hits = map[string]*UsageDay{}, map[SearchHit]int64{}
+	return days, hits
+}
+
+// restore adds back counts that couldn't be flushed.
+func (u *usageCounter) restore(days map[string]*UsageDay, hits map[SearchHit]int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for day, d := range days {
+		u.day(day).Requests += d.Requests
+		u.day(day).Searches += d.Searches
+	}
+	for hit, n := range hits {
+		u.hits[hit] += n
+	}
+}
+
+// countUsage counts the API requests that got past abuse protection.
+func (s *Server) countUsage(c *gin.Context) {
+	s.usage.request()
+	c.Next()
+}
+
+// flushUsage adds the buffered counts to the database.
+func (s *Server) flushUsage() error {
+	days, hits := s.usage.take()
+	if len(days) == 0 {
+		return nil
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, d := range days {
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "day"}},
+				DoUpdates: clause.Assignments(map[string]any{
+					"requests": gorm.Expr("usage_days.requests + ?", d.Requests),
+					"searches": gorm.Expr("usage_days.searches + ?", d.Searches),
+				}),
+			}).Create(d).Error
+			if err != nil {
+				return err
+			}
+		}
+		for hit, n := range hits {
+			hit.Count = n
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "day"}, {Name: "title_id"}},
+				DoUpdates: clause.Assignments(map[string]any{"count": gorm.Expr("search_hits.count + ?", n)}),
+			}).Create(&hit).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.usage.restore(days, hits)
+	}
+	return err
+}
+
+// purgeUsage flushes the usage counts and deletes the days past retention.
+func (s *Server) purgeUsage() {
+	if err := s.flushUsage(); err != nil {
+		log.Printf("Janitor: error flushing usage stats: %v\n", err)
+		return
+	}
+	oldest := usageDay(time.Now().AddDate(0, 0, 1-s.config.UsageRetentionDays))
+	if err := s.db.Where("day < ?", oldest).Delete(&UsageDay{}).Error; err != nil {
+		log.Printf("Janitor: error purging usage stats: %v\n", err)
+		return
+	}
+	if err := s.db.Where("day < ?", oldest).Delete(&SearchHit{}).Error; err != nil {
+		log.Printf("Janitor: error purging search stats: %v\n", err)
+	}
+}
+
+// usageStats aggregates the last days of usage, oldest first. Titles searched
+// fewer than USAGE_MIN_COUNT times are left out, so that a single person's
+// searches can't be told from the stats.
+func (s *Server) usageStats(days int) (UsageStats, error) {
+	stats := UsageStats{MinCount: s.config.UsageMinCount, RetainedDays: s.config.UsageRetentionDays}
+	if err := s.flushUsage(); err != nil {
+		return stats, err
+	}
+
+	now := time.Now()
+	since := usageDay(now.AddDate(0, 0, 1-days))
+	var stored []UsageDay
+	if err := s.db.Where("day >= ?", since).Find(&stored).Error; err != nil {
+		return stats, err
+	}
+	byDay := make(map[string]UsageDay, len(stored))
+	for _, d := range stored {
+		byDay[d.Day] = d
+	}
+	// Days without traffic are zeros, not gaps
+	for i := days - 1; i >= 0; i-- {
+		day := usageDay(now.AddDate(0, 0, -i))
+		d, ok := byDay[day]
+		if !ok {
+			d = UsageDay{Day: day}
+		}
+		stats.Days = append(stats.Days, d)
+	}
+
+	stats.TopSearched = []TitleUsage{}
+	err := s.db.Model(&SearchHit{}).
+		Select("search_hits.title_id, titles.name, SUM(search_hits.count) AS count").
+		Joins("JOIN titles ON titles.title_id = search_hits.title_id").
+		Where("search_hits.day >= ?", since).
+		Group("search_hits.title_id, titles.name").
+		Having("SUM(search_hits.count) >= ?", max(s.config.UsageMinCount, 1)).
+		Order("count DESC, search_hits.title_id").
+		Limit(10).
+		Scan(&stats.TopSearched).Error
+	return stats, err
+}
+
+// usageStatsDays reads the days query parameter, 30 days by default.
+func (s *Server) usageStatsDays(c *gin.Context) (int, bool) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > s.config.UsageRetentionDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days, expected 1 to " + strconv.Itoa(s.config.UsageRetentionDays)})
+		return 0, false
+	}
+	return days, true
+}
+
+func (s *Server) getUsageStats(c *gin.Context) {
+	days, ok := s.usageStatsDays(c)
+	if !ok {
+		return
+	}
+	stats, err := s.usageStats(days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+func (s *Server) usagePage(c *gin.Context) {
+	days := min(30, s.config.UsageRetentionDays)
+	stats, err := s.usageStats(days)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	var requests, searches, busiest int64
+	for _, d := range stats.Days {
+		requests += d.Requests
+		searches += d.Searches
+		busiest = max(busiest, d.Requests)
+	}
+	// Newest first, with bars relative to the busiest day
+	type usageRow struct {
+		UsageDay
+		Percent int64
+	}
+	rows := make([]usageRow, 0, len(stats.Days))
+	for _, d := range slices.Backward(stats.Days) {
+		rows = append(rows, usageRow{d, d.Requests * 100 / max(busiest, 1)})
+	}
+	c.HTML(http.StatusOK, "usage.html", gin.H{
+		"title":    "Usage - " + s.config.Branding.Name,
+		"days":     days,
+		"rows":     rows,
+		"top":      stats.TopSearched,
+		"minCount": stats.MinCount,
+		"requests": requests,
+		"searches": searches,
+	})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsageStats(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.UsageMinCount = 2
+	})
+
+	for _, target := range []string{"/api/v1/search?q=halo", "/api/v1/search?q=halo+3", "/api/v1/search?q=halo&page=2", "/api/v1/search?q=minecraft", "/api/v1/search?q=nothing"} {
+		if w := doRequest(s, "GET", target, nil); w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", target, w.Code)
+		}
+	}
+	// Flushed counts add up with the ones still buffered
+	if err := s.flushUsage(); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(s, "GET", "/api/v1/titles", nil)
+
+	w := doRequest(s, "GET", "/api/v1/usage?days=7", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d; body: %s", w.Code, w.Body.String())
+	}
+	var stats UsageStats
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	today := stats.Days[len(stats.Days)-1]
+	if len(stats.Days) != 7 || today.Day != usageDay(time.Now()) || today.Requests != 7 || today.Searches != 4 {
+		t.Errorf("unexpected days: %+v", stats.Days)
+	}
+	// Minecraft was searched once, below the threshold
+	if len(stats.TopSearched) != 1 || stats.TopSearched[0] != (TitleUsage{TitleID: "4D5307E6", Name: "Halo 3", Count: 2}) {
+		t.Errorf("unexpected top searched: %+v", stats.TopSearched)
+	}
+
+	if w := doRequest(s, "GET", "/api/v1/usage?days=0", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("days=0: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = doRequest(s, "GET", "/usage", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<a href="/titles/4d5307e6">Halo 3</a></td><td class="number">2</td>`) {
+		t.Errorf("usage page: status = %d; body: %s", w.Code, w.Body.String())
+	}
+}
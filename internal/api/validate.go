@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var titleIDPattern = regexp.MustCompile(`^[0-9A-Fa-f]{8}$`)
+
+// IngestReject is an upstream row that failed validation, kept for review
+// instead of being inserted into the catalog.
+type IngestReject struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	TitleID   string    `json:"title_id" gorm:"index"`
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason"`
+	Raw       string    `json:"raw"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// validateTitle returns why a title should not be ingested, or "" if it is fine.
+func (s *Server) validateTitle(t Title) string {
+	if !titleIDPattern.MatchString(t.TitleID) {
+		return "invalid title_id format"
+	}
+	name := strings.TrimSpace(t.Name)
+	if name == "" {
+		return "empty name"
+	}
+	if !utf8.ValidString(t.Name) {
+		return "name is not valid UTF-8"
+	}
+	if utf8.RuneCountInString(t.Name) > s.config.MaxTitleNameLength {
+		return fmt.Sprintf("name longer than %d characters", s.config.MaxTitleNameLength)
+	}
+	if strings.IndexFunc(t.Name, unicode.IsControl) >= 0 {
+		return "name contains control characters"
+	}
+	return ""
+}
+
+func newIngestReject(t Title, reason string) IngestReject {
+	raw, _ := json.Marshal(t)
+	return IngestReject{
+		TitleID: t.TitleID,
+		Name:    t.Name,
+		Reason:  reason,
+		Raw:     string(raw),
+	}
+}
+
+// validateTitles splits a batch into titles that can be inserted and rejects,
+// once the ingest transforms have run. Duplicate ids within the batch are
+// rejected after their first occurrence, and the others upper cased.
+func (s *Server) validateTitles(titles []Title) ([]Title, []IngestReject) {
+	titles, rejects := s.transformTitles(titles)
+	valid := make([]Title, 0, len(titles))
+	seen := make(map[string]bool, len(titles))
+
+	for _, t := range titles {
+		reason := s.validateTitle(t)
+		if reason == "" && seen[strings.ToUpper(t.TitleID)] {
+			reason = "duplicate title_id in batch"
+		}
+		if reason != "" {
+			rejects = append(rejects, newIngestReject(t, reason))
+			continue
+		}
+		// Ids are stored upper case, so that lookups can use the index
+		t.TitleID = strings.ToUpper(t.TitleID)
+		seen[t.TitleID] = true
+		valid = append(valid, t)
+	}
+
+	return valid, rejects
+}
+
+func (s *Server) getIngestRejects(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	offset := (page - 1) * limit
+
+	var rejects []IngestReject
+	var total int64
+	// A sync replaces the rejects as it goes
+	err := store.ReadSnapshot(c.Request.Context(), s.db, func(tx *gorm.DB) error {
+		if err := tx.Model(&IngestReject{}).Count(&total).Error; err != nil {
+			return err
+		}
+		return tx.Order("id DESC").Offset(offset).Limit(limit).Find(&rejects).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Items:  rejects,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		Page:   page,
+		Pages:  int((total + int64(limit) - 1) / int64(limit)),
+	})
+}
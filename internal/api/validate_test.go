@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateTitles(t *testing.T) {
+	s := &Server{config: Config{MaxTitleNameLength: 10}}
+
+	valid, rejects := s.validateTitles([]Title{
+		{TitleID: "4d5307e6", Name: "Halo 3"},
+		{TitleID: "4D5307E6", Name: "Halo 3 LE"},
+		{TitleID: "4D53XYZ", Name: "Bad id"},
+		{TitleID: "4D530802", Name: "  "},
+		{TitleID: "415607F7", Name: "Much too long"},
+		{TitleID: "584109EB", Name: "Mine\ncraft"},
+		{TitleID: "584109eb", Name: "Minecraft"},
+	})
+
+	var ids []string
+	for _, title := range valid {
+		ids = append(ids, title.TitleID)
+	}
+	if want := []string{"4D5307E6", "584109EB"}; !slices.Equal(ids, want) {
+		t.Errorf("valid = %v, want %v", ids, want)
+	}
+
+	var reasons []string
+	for _, reject := range rejects {
+		reasons = append(reasons, reject.TitleID+": "+reject.Reason)
+	}
+	want := []string{
+		"4D5307E6: duplicate title_id in batch",
+		"4D53XYZ: invalid title_id format",
+		"4D530802: empty name",
+		"415607F7: name longer than 10 characters",
+		"584109EB: name contains control characters",
+	}
+	if !slices.Equal(reasons, want) {
+		t.Errorf("rejects = %q, want %q", reasons, want)
+	}
+}
+
+func TestTitleIDCase(t *testing.T) {
+	titles := slices.Clone(testTitles)
+	titles[0].TitleID = strings.ToLower(titles[0].TitleID)
+	s := newTestServer(t, titles)
+
+	// Lower case upstream ids are stored upper case, and found either way
+	for _, target := range []string{"/api/v1/titles/4d5307e6", "/api/v1/titles/4D5307E6", "/api/v1/titles/%204d5307e6"} {
+		w := doRequest(s, "GET", target, nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title_id":"4D5307E6"`) {
+			t.Errorf("%s: status = %d; body: %s", target, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(s, "POST", "/api/v1/titles/4d5307e6/view", nil); w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"title_id":"4D5307E6"`) {
+		t.Errorf("view: status = %d; body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIngestRejects(t *testing.T) {
+	titles := append(slices.Clone(testTitles),
+		Title{TitleID: "XYZ", Name: "Bad id", Systems: []string{"XBOX360"}},
+		Title{TitleID: "4D5307F2", Name: "", Systems: []string{"XBOX360"}},
+		Title{TitleID: "4D5307F3", Name: "Bell\a", Systems: []string{"XBOX360"}},
+		Title{TitleID: "4d5307e6", Name: "Halo 3 again", Systems: []string{"XBOX360"}},
+		Title{TitleID: "4d5307f4", Name: "Halo 3 Beta", Systems: []string{"XBOX360"}},
+	)
+	s := newTestServer(t, titles)
+	admin := map[string]string{"Authorization": "Bearer test-token"}
+
+	// Rejected rows stay out of the catalog, the others go in
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/api/v1/titles/4d5307e6", http.StatusOK, `"name":"Halo 3"`},
+		{"/api/v1/titles/4d5307f4", http.StatusOK, `"title_id":"4D5307F4"`},
+		{"/api/v1/titles/4d5307f2", http.StatusNotFound, "Title not found"},
+		{"/api/v1/titles/4d5307f3", http.StatusNotFound, "Title not found"},
+		{"/api/v1/titles", http.StatusOK, `"total":5`},
+		{"/api/v1/admin/ingest/rejects", http.StatusOK, `"total":4`},
+		{"/api/v1/admin/ingest/rejects?limit=1&page=4", http.StatusOK, `"title_id":"XYZ","name":"Bad id","reason":"invalid title_id format","raw":"{`},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, admin)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: status = %d, want %d and %s; body: %s", tt.target, w.Code, tt.status, tt.want, w.Body.String())
+		}
+	}
+
+	var rejects []IngestReject
+	s.db.Order("id").Find(&rejects)
+	var reasons []string
+	for _, reject := range rejects {
+		reasons = append(reasons, reject.TitleID+": "+reject.Reason)
+	}
+	want := []string{
+		"XYZ: invalid title_id format",
+		"4D5307F2: empty name",
+		"4D5307F3: name contains control characters",
+		"4d5307e6: duplicate title_id in batch",
+	}
+	if !slices.Equal(reasons, want) {
+		t.Errorf("rejects = %q, want %q", reasons, want)
+	}
+	if w := doRequest(s, "GET", "/api/v1/admin/ingest/rejects", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("rejects without the admin token: status = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is the version of the binary, set by the main package from its
+// own, which release builds set with -ldflags "-X main.version=v1.2.3".
+var Version = "dev"
+
+// buildVersion returns the version of the binary. Builds without one tell
+// the commit they were built from, when Go recorded it.
+func buildVersion() string {
+	if Version != "dev" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Version
+	}
+	var revision string
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return Version
+	}
+	v := Version + "-" + revision[:min(len(revision), 12)]
+	if modified {
+		v += "-dirty"
+	}
+	return v
+}
+
+func versionCommand(cfg Config, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("version takes no arguments")
+	}
+	fmt.Printf("xtitles %s %s/%s %s\n", buildVersion(), runtime.GOOS, runtime.GOARCH, runtime.Version())
+	return nil
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TitleView is a single counted view of a title, used for popularity stats.
+type TitleView struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	TitleID   string    `json:"title_id" gorm:"index"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+type ViewResponse struct {
+	TitleID string `json:"title_id"`
+	Counted bool   `json:"counted"`
+	Views   int64  `json:"views"`
+}
+
+func (s *Server) initViews() {
+	s.viewLimiter = newRateLimiter(s.config.ViewRateLimit, time.Minute)
+	s.viewDedup = newExpiringSet(s.config.ViewDedupWindow)
+}
+
+func (s *Server) recordTitleView(c *gin.Context) {
+	ip := c.ClientIP()
+	if !s.viewLimiter.Allow(ip) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+		return
+	}
+
+	var title Title
+	err := s.db.Select("title_id").First(&title, "title_id = ?", strings.ToUpper(strings.TrimSpace(c.Param("id")))).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			s.titleNotFound(c, c.Param("id"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Only count one view per client and title within the dedup window
+	counted := s.viewDedup.Add(ip + "|" + title.TitleID)
+	if counted {
+		if err := s.db.Create(&TitleView{TitleID: title.TitleID}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	var views int64
+	s.db.Model(&TitleView{}).Where("title_id = ?", title.TitleID).Count(&views)
+
+	c.JSON(http.StatusAccepted, ViewResponse{
+		TitleID: title.TitleID,
+		Counted: counted,
+		Views:   views,
+	})
+}
+
+// purgeTitleViews deletes the views past TRENDING_WINDOW, which no longer
+// count towards anything.
+func (s *Server) purgeTitleViews() {
+	if s.config.TrendingWindow <= 0 {
+		return
+	}
+	oldest := time.Now().Add(-s.config.TrendingWindow)
+	if err := s.db.Where("created_at < ?", oldest).Delete(&TitleView{}).Error; err != nil {
+		log.Printf("Janitor: error purging title views: %v\n", err)
+	}
+}
+
+// trendingBuckets is how many slices of TRENDING_WINDOW views are weighed by.
+// Views within a slice count the same, as if viewed halfway through it.
+const trendingBuckets = 48
+
+// trendingScores ranks the titles viewed within TRENDING_WINDOW matching
+// system and onlyWithPictures, most popular first. Each view counts half as
+// much every TRENDING_HALF_LIFE, so that a burst of views last month doesn't
+// outweigh steady interest this week. The database sums the views of each
+// title by their age bucket, so only one row per title is read.
+func (s *Server) trendingScores(tx *gorm.DB, now time.Time, system string, onlyWithPictures bool) ([]string, error) {
+	score := "COUNT(*)"
+	var args []any
+	if width := s.config.TrendingWindow / trendingBuckets; s.config.TrendingHalfLife > 0 && width > 0 {
+		var b strings.Builder
+		b.WriteString("SUM(CASE")
+		for i := 1; i <= trendingBuckets; i++ {
+			weight := math.Exp2(-(float64(i) - 0.5) * float64(width) / float64(s.config.TrendingHalfLife))
+			b.WriteString(" WHEN title_views.created_at >= ? THEN " + strconv.FormatFloat(weight, 'f', -1, 64))
+			args = append(args, now.Add(-time.Duration(i)*width))
+		}
+		b.WriteString(" ELSE 0 END)")
+		score = b.String()
+	}
+
+	query := tx.Model(&TitleView{}).
+		Select("title_views.title_id, "+score+" AS score", args...).
+		Joins("JOIN titles ON titles.title_id = title_views.title_id").
+		Where("title_views.created_at >= ?", now.Add(-s.config.TrendingWindow))
+	query = store.FilterBySystem(query, system)
+	if onlyWithPictures {
+		query = query.Where("EXISTS (SELECT 1 FROM pictures WHERE pictures.title_id = titles.title_id)")
+	}
+
+	var scores []struct {
+		TitleID string
+		Score   float64
+	}
+	err := query.Group("title_views.title_id").
+		Order("score DESC").Order("title_views.title_id").
+		Scan(&scores).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(scores))
+	for i, score := range scores {
+		ids[i] = score.TitleID
+	}
+	return ids, nil
+}
+
+// trendingCacheSize bounds the rankings kept, one per set of filters.
+const trendingCacheSize = 64
+
+// trendingCache keeps rankings for TRENDING_CACHE_TTL, so that requests don't
+// aggregate the views of the window again. A catalog change drops them, since
+// they would list titles that changed or no longer exist.
+type trendingCache struct {
+	mu         sync.Mutex
+	generation string
+	entries    map[string]trendingEntry
+}
+
+type trendingEntry struct {
+	ids      []string
+	computed time.Time
+}
+
+// trendingRanking returns trendingScores, from the cache while it is fresh.
+func (s *Server) trendingRanking(tx *gorm.DB, generation, system string, onlyWithPictures bool) ([]string, error) {
+	now := time.Now()
+	key := system + "|" + strconv.FormatBool(onlyWithPictures)
+
+	cache := &s.trending
+	cache.mu.Lock()
+	if cache.generation != generation || len(cache.entries) >= trendingCacheSize {
+		cache.generation = generation
+		cache.entries = make(map[string]trendingEntry)
+	}
+	entry, ok := cache.entries[key]
+	cache.mu.Unlock()
+	if ok && now.Sub(entry.computed) < s.config.TrendingCacheTTL {
+		return entry.ids, nil
+	}
+
+	ids, err := s.trendingScores(tx, now, system, onlyWithPictures)
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	if cache.generation == generation {
+		cache.entries[key] = trendingEntry{ids: ids, computed: now}
+	}
+	cache.mu.Unlock()
+	return ids, nil
+}
+
+// getTrendingTitles lists the titles viewed the most lately, see
+// trendingScores. Titles nobody viewed within the window aren't listed.
+func (s *Server) getTrendingTitles(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	onlyWithPictures := c.DefaultQuery("only_with_pictures", "false") == "true"
+	system := c.Query("system")
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	offset := (page - 1) * limit
+
+	// Views keep coming in, so there are no validators to revalidate against.
+	// The ETag is only read for the catalog generation, which the response
+	// reports and the cached rankings go by.
+	etag, _ := s.catalogValidators()
+
+	debugFilters(c.Request.Context(), gin.H{
+		"system": system, "only_with_pictures": onlyWithPictures,
+		"window": s.config.TrendingWindow.String(), "half_life": s.config.TrendingHalfLife.String(),
+	})
+	var titles []Title
+	var total int64
+	err := store.ReadSnapshot(c.Request.Context(), s.db, func(tx *gorm.DB) error {
+		ids, err := s.trendingRanking(tx, catalogGeneration(etag), system, onlyWithPictures)
+		if err != nil {
+			return err
+		}
+		total = int64(len(ids))
+		ids = ids[min(offset, len(ids)):min(offset+limit, len(ids))]
+		if len(ids) == 0 {
+			return nil
+		}
+
+		query := tx.Preload("Pictures")
+		if s.reviewScores != nil {
+			query = query.Preload("ReviewScore")
+		}
+		if err := query.Where("title_id IN ?", ids).Find(&titles).Error; err != nil {
+			return err
+		}
+		rank := make(map[string]int, len(ids))
+		for i, id := range ids {
+			rank[id] = i
+		}
+		slices.SortFunc(titles, func(a, b Title) int {
+			return rank[a.TitleID] - rank[b.TitleID]
+		})
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if titles == nil {
+		titles = []Title{}
+	}
+
+	pages := int((total + int64(limit) - 1) / int64(limit))
+
+	setCacheHeaders(c, s.config.CacheLists)
+	if isSlim(c) {
+		c.JSON(http.StatusOK, s.slimPaginatedResponse(titles, total, page, pages, catalogGeneration(etag)))
+		return
+	}
+	items, err := s.listItems(c, titles, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Items:      items,
+		Total:      total,
+		Limit:      limit,
+		Offset:     offset,
+		Page:       page,
+		Pages:      pages,
+		Generation: catalogGeneration(etag),
+	})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrendingTitles(t *testing.T) {
+	s := newTestServer(t, testTitles)
+
+	now := time.Now()
+	var views []TitleView
+	for id, ages := range map[string][]time.Duration{
+		"415607F7": {144 * time.Hour, 144 * time.Hour, 144 * time.Hour},
+		"4D530802": {time.Minute, time.Hour},
+		"584109EB": {time.Minute},
+		// Outside of the window
+		"4D5307E6": {240 * time.Hour, 240 * time.Hour, 240 * time.Hour, 240 * time.Hour},
+	} {
+		for _, age := range ages {
+			views = append(views, TitleView{TitleID: id, CreatedAt: now.Add(-age)})
+		}
+	}
+	if err := s.db.Create(&views).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		total  int64
+		want   []string
+	}{
+		{"/api/v1/titles/trending", 3, []string{"4D530802", "584109EB", "415607F7"}},
+		{"/api/v1/titles/trending?limit=1&page=2", 3, []string{"584109EB"}},
+		{"/api/v1/titles/trending?system=pc", 1, []string{"584109EB"}},
+		{"/api/v1/titles/trending?only_with_pictures=true", 1, []string{"584109EB"}},
+		{"/api/v1/titles/trending?page=5", 3, nil},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "GET", tt.target, nil)
+		var page struct {
+			Items      []Title `json:"items"`
+			Total      int64   `json:"total"`
+			Generation string  `json:"generation"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &page) != nil {
+			t.Fatalf("%s: status = %d; body: %s", tt.target, w.Code, w.Body.String())
+		}
+		var got []string
+		for _, title := range page.Items {
+			got = append(got, title.TitleID)
+		}
+		if page.Total != tt.total || !slices.Equal(got, tt.want) || page.Generation == "" {
+			t.Errorf("%s: total = %d, items = %v, generation = %q; want %d, %v", tt.target, page.Total, got, page.Generation, tt.total, tt.want)
+		}
+	}
+
+	// Rankings are cached, so a new view only counts once they expire
+	doRequest(s, "POST", "/api/v1/titles/4d5307e6/view", nil)
+	if w := doRequest(s, "GET", "/api/v1/titles/trending", nil); !strings.Contains(w.Body.String(), `"total":3`) {
+		t.Errorf("cached ranking: %s", w.Body.String())
+	}
+	s.config.TrendingCacheTTL = 0
+	if w := doRequest(s, "GET", "/api/v1/titles/trending", nil); !strings.Contains(w.Body.String(), `"total":4`) {
+		t.Errorf("expired ranking: %s", w.Body.String())
+	}
+
+	// Without decay, every view within the window counts the same
+	s.config.TrendingHalfLife = 0
+	w := doRequest(s, "GET", "/api/v1/titles/trending?limit=1", nil)
+	if !strings.Contains(w.Body.String(), `"title_id":"415607F7"`) {
+		t.Errorf("without decay, the most viewed title should lead: %s", w.Body.String())
+	}
+
+	// The janitor deletes views past the window, deleting a title its views
+	countViews := func(id string) int64 {
+		var n int64
+		s.db.Model(&TitleView{}).Where("title_id = ?", id).Count(&n)
+		return n
+	}
+	s.runJanitorOnce()
+	if n := countViews("4D5307E6"); n != 1 {
+		t.Errorf("views of 4D5307E6 after purging = %d, want 1", n)
+	}
+	if n := countViews("415607F7"); n != 3 {
+		t.Errorf("views of 415607F7 after purging = %d, want 3", n)
+	}
+	if w := doRequest(s, "DELETE", "/api/v1/admin/titles/415607F7", map[string]string{"Authorization": "Bearer test-token", "If-Match": "*"}); w.Code != http.StatusNoContent {
+		t.Fatalf("delete title: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	if n := countViews("415607F7"); n != 0 {
+		t.Errorf("views of a deleted title = %d", n)
+	}
+}
+
+func TestRecordTitleView(t *testing.T) {
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.ViewRateLimit = 4
+		cfg.ViewDedupWindow = time.Hour
+		cfg.TrustedProxies = []string{"192.0.2.0/24"}
+	})
+	other := map[string]string{"X-Forwarded-For": "198.51.100.7"}
+
+	// Requests run in order against the same server
+	tests := []struct {
+		name    string
+		target  string
+		header  map[string]string
+		status  int
+		counted bool
+		views   int64
+	}{
+		{"first view", "/api/v1/titles/4d5307e6/view", nil, http.StatusAccepted, true, 1},
+		{"repeated view", "/api/v1/titles/4D5307E6/view", nil, http.StatusAccepted, false, 1},
+		{"other client", "/api/v1/titles/4d5307e6/view", other, http.StatusAccepted, true, 2},
+		{"other title", "/api/v1/titles/4d530802/view", nil, http.StatusAccepted, true, 1},
+		{"unknown title", "/api/v1/titles/nope/view", nil, http.StatusNotFound, false, 0},
+		{"rate limited", "/api/v1/titles/415607f7/view", nil, http.StatusTooManyRequests, false, 0},
+		{"limited per client", "/api/v1/titles/415607f7/view", other, http.StatusAccepted, true, 1},
+	}
+	for _, tt := range tests {
+		w := doRequest(s, "POST", tt.target, tt.header)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d; body: %s", tt.name, w.Code, tt.status, w.Body.String())
+			continue
+		}
+		var resp ViewResponse
+		if w.Code == http.StatusAccepted {
+			json.Unmarshal(w.Body.Bytes(), &resp)
+		}
+		if resp.Counted != tt.counted || resp.Views != tt.views {
+			t.Errorf("%s: counted = %t, views = %d; want %t, %d", tt.name, resp.Counted, resp.Views, tt.counted, tt.views)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/birabittoh/xtitles/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	wikidataSource    = "wikidata"
+	wikidataJobKind   = "wikidata"
+	wikidataEntityURL = "http://www.wikidata.org/entity/"
+
+	// wikidataUserAgent identifies the client, as the query service asks
+	wikidataUserAgent = "xtitles/1.0 (https://github.com/birabittoh/xtitles)"
+)
+
+var wikidataQIDPattern = regexp.MustCompile(`^Q[1-9][0-9]*$`)
+
+// wikidataGamesQuery lists the video games released on a platform with their
+// English labels.
+const wikidataGamesQuery = `SELECT ?game ?label WHERE {
+  ?game wdt:P31/wdt:P279* wd:Q7889;
+        wdt:P400 wd:%s;
+        rdfs:label ?label.
+  FILTER(LANG(?label) = "en")
+}`
+
+// wikidataGame is a video game item of Wikidata.
+type wikidataGame struct {
+	QID   string
+	Label string
+}
+
+// parsePlatformQIDs parses a SYSTEM=QID list mapping systems to the Wikidata
+// items of their platforms.
+func parsePlatformQIDs(value string) map[string]string {
+	platforms := make(map[string]string)
+	for _, pair := range parseList(value) {
+		system, qid, ok := strings.Cut(pair, "=")
+		qid = strings.ToUpper(strings.TrimSpace(qid))
+		if !ok || !wikidataQIDPattern.MatchString(qid) {
+			continue
+		}
+		platforms[strings.ToUpper(strings.TrimSpace(system))] = qid
+	}
+	return platforms
+}
+
+// fetchWikidataGames lists the games Wikidata knows for a platform.
+func (s *Server) fetchWikidataGames(ctx context.Context, platform string) ([]wikidataGame, error) {
+	query := url.Values{"query": {fmt.Sprintf(wikidataGamesQuery, platform)}, "format": {"json"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.WikidataSPARQLURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	req.Header.Set("Accept", "application/sparql-results+json")
+	req.Header.Set("User-Agent", wikidataUserAgent)
+
+	client := &http.Client{Timeout: s.config.UpstreamTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Results struct {
+			Bindings []struct {
+				Game  struct{ Value string } `json:"game"`
+				Label struct{ Value string } `json:"label"`
+			} `json:"bindings"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode failed: %w", err)
+	}
+
+	games := make([]wikidataGame, 0, len(result.Results.Bindings))
+	for _, b := range result.Results.Bindings {
+		qid, ok := strings.CutPrefix(b.Game.Value, wikidataEntityURL)
+		if !ok || !wikidataQIDPattern.MatchString(qid) {
+			continue
+		}
+		games = append(games, wikidataGame{QID: qid, Label: b.Label.Value})
+	}
+	return games, nil
+}
+
+// syncWikidata maps titles without a Wikidata id to the game of their
+// platform with the same name. A title is only mapped when its name points to
+// a single item across all of its platforms and no other title points to that
+// item, so that remasters and reboots sharing a name are left to maintainers.
+func (s *Server) syncWikidata(ctx context.Context, job *Job) error {
+	candidates := make(map[string]map[string]bool)
+	step := 0
+	for system, platform := range s.config.WikidataPlatforms {
+		games, err := s.fetchWikidataGames(ctx, platform)
+		if err != nil {
+			return fmt.Errorf("listing platform %s failed: %w", platform, err)
+		}
+
+		byName := make(map[string]map[string]bool, len(games))
+		for _, g := range games {
+			name := normalizeName(g.Label)
+			if byName[name] == nil {
+				byName[name] = make(map[string]bool)
+			}
+			// Items may have several English labels (en, en-gb...)
+			byName[name][g.QID] = true
+		}
+
+		var titles []Title
+		err = store.FilterBySystem(s.db.WithContext(ctx), system).
+			Where("NOT EXISTS (SELECT 1 FROM external_ids WHERE external_ids.title_id = titles.title_id AND external_ids.source = ?)", wikidataSource).
+			Order("title_id ASC").Find(&titles).Error
+		if err != nil {
+			return err
+		}
+
+		for _, t := range titles {
+			qids := byName[normalizeName(t.Name)]
+			if len(qids) == 0 {
+				continue
+			}
+			if candidates[t.TitleID] == nil {
+				candidates[t.TitleID] = make(map[string]bool)
+			}
+			for qid := range qids {
+				candidates[t.TitleID][qid] = true
+			}
+		}
+
+		step++
+		job.SetProgress(step, len(s.config.WikidataPlatforms)+1)
+	}
+
+	claims := make(map[string]int)
+	for _, qids := range candidates {
+		for qid := range qids {
+			claims[qid]++
+		}
+	}
+
+	mapped, ambiguous, taken := 0, 0, 0
+	// Title responses embed their external ids
+	defer func() {
+		if mapped > 0 {
+			s.catalogChanged()
+		}
+	}()
+	for _, titleID := range slices.Sorted(maps.Keys(candidates)) {
+		qids := slices.Collect(maps.Keys(candidates[titleID]))
+		if len(qids) != 1 || claims[qids[0]] != 1 {
+			ambiguous++
+			continue
+		}
+		qid := qids[0]
+
+		_, err := s.setExternalID(titleID, wikidataSource, qid, wikidataSource)
+		if errors.Is(err, errExternalIDTaken) || errors.Is(err, errExternalIDCurated) {
+			taken++
+			continue
+		} else if err != nil {
+			return err
+		}
+		mapped++
+	}
+
+	job.SetProgress(step+1, len(s.config.WikidataPlatforms)+1)
+	job.SetResult(fmt.Sprintf("%d titles mapped, %d ambiguous, %d ids already taken", mapped, ambiguous, taken))
+	return nil
+}
+
+func (s *Server) createWikidataJob(c *gin.Context) {
+	if len(s.config.WikidataPlatforms) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Wikidata is not configured"})
+		return
+	}
+
+	job := s.jobs.Start(wikidataJobKind, s.syncWikidata)
+	c.Header("Location", "/api/v1/admin/wikidata/"+job.ID())
+	c.JSON(http.StatusAccepted, enricherJobResponse(job))
+}
+
+func (s *Server) getWikidataJob(c *gin.Context) {
+	job, ok := s.jobs.Get(c.Param("id"))
+	if !ok || job.Status().Kind != wikidataJobKind {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enricherJobResponse(job))
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWikidata(t *testing.T) {
+	sparql := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		binding := func(qid, label string) string {
+			return `{"game":{"type":"uri","value":"http://www.wikidata.org/entity/` + qid + `"},"label":{"type":"literal","value":"` + label + `"}}`
+		}
+		var bindings []string
+		switch query := r.URL.Query().Get("query"); {
+		case strings.Contains(query, "wd:Q48263"):
+			bindings = []string{binding("Q1", "Halo 3"), binding("Q2", "Halo 3: ODST"), binding("Q3", "Halo 3: ODST"), binding("Q4", "Minecraft"), binding("Q5", "Call of Duty 4")}
+		case strings.Contains(query, "wd:Q1406"):
+			bindings = []string{binding("Q4", "Minecraft"), binding("Q4", "MINECRAFT")}
+		}
+		io.WriteString(w, `{"results":{"bindings":[`+strings.Join(bindings, ",")+`]}}`)
+	}))
+	t.Cleanup(sparql.Close)
+
+	s := newTestServerWithConfig(t, testTitles, func(cfg *Config) {
+		cfg.WikidataSPARQLURL = sparql.URL + "/sparql"
+		cfg.WikidataPlatforms = parsePlatformQIDs("XBOX360=Q48263, pc=q1406, XBOX=nope")
+	})
+	admin := map[string]string{"Authorization": "Bearer test-token", "Content-Type": "application/json"}
+
+	if w := doRequestBody(s, "PUT", "/api/v1/admin/titles/415607f7/external/wikidata", admin, `{"id":"42"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid QID: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := doRequestBody(s, "PUT", "/api/v1/admin/titles/415607f7/external/wikidata", admin, `{"id":"q6"}`); w.Code != http.StatusOK {
+		t.Fatalf("mapping a QID: status = %d; body: %s", w.Code, w.Body.String())
+	}
+
+	etag := doRequest(s, "GET", "/api/v1/titles", nil).Header().Get("ETag")
+	w := doRequest(s, "POST", "/api/v1/admin/wikidata", admin)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("starting the resolution: status = %d; body: %s", w.Code, w.Body.String())
+	}
+	s.jobs.Wait()
+	if w := doRequest(s, "GET", "/api/v1/titles", map[string]string{"If-None-Match": etag}); w.Code != http.StatusOK {
+		t.Errorf("listing not revalidated after the resolution: status = %d", w.Code)
+	}
+	w = doRequest(s, "GET", w.Header().Get("Location"), admin)
+	if !strings.Contains(w.Body.String(), "2 titles mapped, 1 ambiguous") {
+		t.Fatalf("resolution did not finish as expected: %s", w.Body.String())
+	}
+
+	for qid, want := range map[string]string{"q1": "4D5307E6", "Q4": "584109EB", "Q6": "415607F7"} {
+		w := doRequest(s, "GET", "/api/v1/external/wikidata/"+qid, nil)
+		if !strings.Contains(w.Body.String(), `"title_id":"`+want+`"`) {
+			t.Errorf("%s: status = %d; body: %s", qid, w.Code, w.Body.String())
+		}
+	}
+	if w := doRequest(s, "GET", "/api/v1/titles/4d530802", nil); strings.Contains(w.Body.String(), `"wikidata"`) {
+		t.Errorf("ambiguous names should not be mapped: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,338 @@
+// Package s3 is a small client for S3 compatible object stores, like AWS S3
+// or MinIO: enough to get, put, delete and list objects and to presign
+// downloads, with requests signed by AWS Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+type Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	// Path style puts the bucket in the path, as MinIO expects, rather than
+	// in the host name
+	pathStyle bool
+	http      *http.Client
+	now       func() time.Time
+}
+
+// Config describes a bucket and the credentials to access it.
+type Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
+func New(cfg Config, client *http.Client) (*Client, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("no bucket")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &Client{
+		endpoint:  endpoint,
+		region:    cfg.Region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		pathStyle: cfg.PathStyle,
+		http:      client,
+		now:       time.Now,
+	}, nil
+}
+
+// StatusError is an error answer of the object store.
+type StatusError struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *StatusError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("object store returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("object store returned status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is makes missing objects match fs.ErrNotExist.
+func (e *StatusError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+// objectURL returns the URL of key, or of the bucket if key is empty.
+func (c *Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path += "/" + c.bucket + "/" + key
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+	return &u
+}
+
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u := c.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	c.sign(req, hashHex(body), c.now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		statusErr := &StatusError{StatusCode: resp.StatusCode}
+		if data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10)); err == nil {
+			xml.Unmarshal(data, statusErr)
+		}
+		return nil, statusErr
+	}
+	return resp, nil
+}
+
+// Get returns the content of an object. Missing objects are fs.ErrNotExist.
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := c.do(ctx, http.MethodPut, key, nil, data, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Delete removes an object. Deleting a missing object is not an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Object is an entry of a listing.
+type Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type listBucketResult struct {
+	Contents              []Object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// List calls fn with every object whose key starts with prefix, a page at a
+// time, in key order.
+func (c *Client) List(ctx context.Context, prefix string, fn func(Object) error) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding the listing: %w", err)
+		}
+		for _, obj := range page.Contents {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// PresignGet returns a URL that downloads key without credentials until it
+// expires.
+func (c *Client) PresignGet(key string, expires time.Duration) string {
+	now := c.now().UTC()
+	u := c.objectURL(key)
+	query := url.Values{
+		"X-Amz-Algorithm":     {signingAlgorithm},
+		"X-Amz-Credential":    {c.accessKey + "/" + c.scope(now)},
+		"X-Amz-Date":          {now.Format(amzDateFormat)},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		canonicalPath(u),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + c.signature(now, canonical)
+	return u.String()
+}
+
+// sign adds the Signature Version 4 headers to req, signing the host and the
+// x-amz-*, content-type and range headers.
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-type" || k == "range" {
+			headers[k] = strings.Join(v, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, c.accessKey, c.scope(now), signedHeaders, c.signature(now, canonical)))
+}
+
+func (c *Client) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + c.region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request with a key derived from the secret
+// key for the day, region and service.
+func (c *Client) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		now.Format(amzDateFormat),
+		c.scope(now),
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalPath encodes each segment of the path of u, keeping the slashes.
+func canonicalPath(u *url.URL) string {
+	segments := strings.Split(u.Path, "/")
+	for i, s := range segments {
+		segments[i] = uriEncode(s)
+	}
+	if path := strings.Join(segments, "/"); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// canonicalQuery sorts and encodes query parameters the way signatures
+// expect, which url.Values.Encode doesn't quite do.
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' || strings.IndexByte("-_.~", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,392 @@
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNewline
+	tokIdent
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+// lex splits a script into tokens. Statements end at newlines, which are kept
+// as tokens, and comments run from # to the end of the line.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			tokens = append(tokens, token{tokNewline, "\n", line})
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(src) || src[i] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string", line)
+				}
+				if src[i] == '"' {
+					i++
+					break
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					switch src[i+1] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					case '"', '\\':
+						b.WriteByte(src[i+1])
+					default:
+						return nil, fmt.Errorf("line %d: unknown escape \\%c", line, src[i+1])
+					}
+					i += 2
+					continue
+				}
+				b.WriteByte(src[i])
+				i++
+			}
+			tokens = append(tokens, token{tokString, b.String(), line})
+		case isIdentByte(c) && (c < '0' || c > '9'):
+			start := i
+			for i < len(src) && isIdentByte(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], line})
+		case strings.HasPrefix(src[i:], "=="), strings.HasPrefix(src[i:], "!="):
+			tokens = append(tokens, token{tokOp, src[i : i+2], line})
+			i += 2
+		case strings.ContainsRune("=+(),", rune(c)):
+			tokens = append(tokens, token{tokOp, string(c), line})
+			i++
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", line, c)
+		}
+	}
+	return append(tokens, token{tokEOF, "", line}), nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+var keywords = map[string]bool{
+	"if": true, "else": true, "end": true, "drop": true,
+	"and": true, "or": true, "not": true, "true": true, "false": true,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// back undoes next, for errors to point at the offending token.
+func (p *parser) back(t token) {
+	if t.kind != tokEOF {
+		p.pos--
+	}
+}
+
+func (p *parser) is(kind tokenKind, text string) bool {
+	t := p.peek()
+	return t.kind == kind && t.text == text
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if !p.is(kind, text) {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	t := p.peek()
+	found := fmt.Sprintf("%q", t.text)
+	switch t.kind {
+	case tokEOF:
+		found = "end of script"
+	case tokNewline:
+		found = "end of line"
+	}
+	return fmt.Errorf("line %d: %s, found %s", t.line, fmt.Sprintf(format, args...), found)
+}
+
+// endLine consumes the end of a statement.
+func (p *parser) endLine() error {
+	switch p.peek().kind {
+	case tokNewline:
+		p.next()
+		return nil
+	case tokEOF:
+		return nil
+	}
+	return p.errorf("expected the end of the line")
+}
+
+func (p *parser) skipNewlines() {
+	for p.peek().kind == tokNewline {
+		p.next()
+	}
+}
+
+// block parses statements up to one of the keywords in until, which is left
+// for the caller.
+func (p *parser) block(until ...string) ([]stmt, error) {
+	var body []stmt
+	for {
+		p.skipNewlines()
+		t := p.peek()
+		if t.kind == tokEOF {
+			if len(until) > 0 {
+				return nil, p.errorf("expected %q", until[len(until)-1])
+			}
+			return body, nil
+		}
+		if t.kind == tokIdent && slices.Contains(until, t.text) {
+			return body, nil
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+}
+
+func (p *parser) statement() (stmt, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		p.back(t)
+		return nil, p.errorf("expected a statement")
+	}
+
+	switch t.text {
+	case "drop":
+		return dropStmt{}, p.endLine()
+
+	case "if":
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+		s := ifStmt{line: t.line, cond: cond}
+		if s.then, err = p.block("else", "end"); err != nil {
+			return nil, err
+		}
+		if p.is(tokIdent, "else") {
+			p.next()
+			if err := p.endLine(); err != nil {
+				return nil, err
+			}
+			if s.otherwise, err = p.block("end"); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(tokIdent, "end"); err != nil {
+			return nil, err
+		}
+		return s, p.endLine()
+	}
+
+	if keywords[t.text] {
+		p.back(t)
+		return nil, p.errorf("expected a statement")
+	}
+	if _, ok := fields[t.text]; !ok {
+		return nil, fmt.Errorf("line %d: unknown field %q", t.line, t.text)
+	}
+	if !fields[t.text].writable {
+		return nil, fmt.Errorf("line %d: field %q cannot be changed", t.line, t.text)
+	}
+	if err := p.expect(tokOp, "="); err != nil {
+		return nil, err
+	}
+	value, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return assignStmt{line: t.line, field: t.text, value: value}, p.endLine()
+}
+
+// Expressions, from the loosest binding: or, and, not, == and !=, +.
+func (p *parser) expr() (expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.is(tokIdent, "or") {
+		line := p.next().line
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{line: line, op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.is(tokIdent, "and") {
+		line := p.next().line
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{line: line, op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.is(tokIdent, "not") {
+		line := p.next().line
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{line: line, operand: operand}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.is(tokOp, "==") || p.is(tokOp, "!=") {
+		op := p.next()
+		right, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		return binaryExpr{line: op.line, op: op.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) sum() (expr, error) {
+	left, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is(tokOp, "+") {
+		line := p.next().line
+		right, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{line: line, op: "+", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokString:
+		return literal{t.text}, nil
+	case t.kind == tokOp && t.text == "(":
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(tokOp, ")")
+	case t.kind == tokIdent && (t.text == "true" || t.text == "false"):
+		return literal{t.text == "true"}, nil
+	case t.kind == tokIdent && p.is(tokOp, "("):
+		return p.call(t)
+	case t.kind == tokIdent && !keywords[t.text]:
+		if _, ok := fields[t.text]; !ok {
+			return nil, fmt.Errorf("line %d: unknown field %q", t.line, t.text)
+		}
+		return fieldExpr{t.text}, nil
+	}
+	p.back(t)
+	return nil, p.errorf("expected a value")
+}
+
+func (p *parser) call(name token) (expr, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("line %d: unknown function %q", name.line, name.text)
+	}
+	p.next()
+
+	var args []expr
+	for !p.is(tokOp, ")") {
+		if len(args) > 0 {
+			if err := p.expect(tokOp, ","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("line %d: %s takes %d arguments, got %d", name.line, name.text, fn.arity, len(args))
+	}
+	c := callExpr{line: name.line, name: name.text, args: args}
+	// Patterns are compiled once, so they have to be known up front
+	if fn.pattern >= 0 {
+		lit, ok := args[fn.pattern].(literal)
+		pattern, isString := lit.value.(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("line %d: the pattern of %s must be a string literal", name.line, name.text)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern: %w", name.line, err)
+		}
+		c.re = re
+	}
+	return c, nil
+}
@@ -0,0 +1,298 @@
+// Package script runs the ingest transforms operators write to fix up titles
+// as they come from upstream, without recompiling the server.
+//
+// A script is a list of statements, one per line:
+//
+//	# Upstream names some titles in all caps
+//	if name == upper(name) and has_system("XBOX")
+//	    name = trim(regex_replace(name, "\\s+", " "))
+//	end
+//	if starts_with(name, "Test ")
+//	    drop
+//	end
+//
+// Statements assign a field, test a condition with if, else and end, or drop
+// the title from the ingest. Values are strings and booleans; + joins strings
+// and ==, !=, and, or and not compare and combine them.
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/birabittoh/xtitles/internal/store"
+)
+
+// Script is a parsed transform.
+type Script struct {
+	name string
+	body []stmt
+}
+
+// Parse parses the source of a script, reporting syntax errors, unknown
+// fields and functions, and invalid patterns with their line.
+func Parse(name, src string) (*Script, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	p := &parser{tokens: tokens}
+	body, err := p.block()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &Script{name: name, body: body}, nil
+}
+
+// Name is the name the script was parsed with, usually its file name.
+func (s *Script) Name() string {
+	return s.name
+}
+
+// Run applies the script to t, returning false when the script dropped it.
+// t is left untouched when the script fails.
+func (s *Script) Run(t *store.Title) (bool, error) {
+	result := *t
+	keep, err := run(s.body, &result)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", s.name, err)
+	}
+	if keep {
+		*t = result
+	}
+	return keep, nil
+}
+
+// field is a title field scripts can read, and sometimes change.
+type field struct {
+	writable bool
+	get      func(t *store.Title) string
+	set      func(t *store.Title, value string)
+}
+
+// optional reads and writes a nullable column, where "" means NULL.
+func optional(column func(t *store.Title) **string) field {
+	return field{
+		writable: true,
+		get: func(t *store.Title) string {
+			if v := *column(t); v != nil {
+				return *v
+			}
+			return ""
+		},
+		set: func(t *store.Title, value string) {
+			if value == "" {
+				*column(t) = nil
+				return
+			}
+			*column(t) = &value
+		},
+	}
+}
+
+var fields = map[string]field{
+	"title_id": {get: func(t *store.Title) string { return t.TitleID }},
+	"name": {
+		writable: true,
+		get:      func(t *store.Title) string { return t.Name },
+		set:      func(t *store.Title, value string) { t.Name = value },
+	},
+	"bing_id": {
+		writable: true,
+		get:      func(t *store.Title) string { return t.BingID },
+		set:      func(t *store.Title, value string) { t.BingID = value },
+	},
+	"pfn":               optional(func(t *store.Title) **string { return &t.PFN }),
+	"service_config_id": optional(func(t *store.Title) **string { return &t.ServiceConfigID }),
+}
+
+// function is a builtin. pattern is the index of the argument that is a
+// regular expression, or -1.
+type function struct {
+	arity   int
+	pattern int
+	call    func(t *store.Title, re *regexp.Regexp, args []string) any
+}
+
+var functions = map[string]function{
+	"trim":  {1, -1, func(_ *store.Title, _ *regexp.Regexp, a []string) any { return strings.TrimSpace(a[0]) }},
+	"upper": {1, -1, func(_ *store.Title, _ *regexp.Regexp, a []string) any { return strings.ToUpper(a[0]) }},
+	"lower": {1, -1, func(_ *store.Title, _ *regexp.Regexp, a []string) any { return strings.ToLower(a[0]) }},
+	"replace": {3, -1, func(_ *store.Title, _ *regexp.Regexp, a []string) any {
+		return strings.ReplaceAll(a[0], a[1], a[2])
+	}},
+	"regex_replace": {3, 1, func(_ *store.Title, re *regexp.Regexp, a []string) any {
+		return re.ReplaceAllString(a[0], a[2])
+	}},
+	"contains":    {2, -1, func(_ *store.Title, _ *regexp.Regexp, a []string) any { return strings.Contains(a[0], a[1]) }},
+	"starts_with": {2, -1, func(_ *store.Title, _ *regexp.Regexp, a []string) any { return strings.HasPrefix(a[0], a[1]) }},
+	"ends_with":   {2, -1, func(_ *store.Title, _ *regexp.Regexp, a []string) any { return strings.HasSuffix(a[0], a[1]) }},
+	"matches":     {2, 1, func(_ *store.Title, re *regexp.Regexp, a []string) any { return re.MatchString(a[0]) }},
+	"has_system": {1, -1, func(t *store.Title, _ *regexp.Regexp, a []string) any {
+		return slices.Contains(t.Systems, strings.ToUpper(a[0]))
+	}},
+}
+
+type stmt interface{}
+
+type assignStmt struct {
+	line  int
+	field string
+	value expr
+}
+
+type ifStmt struct {
+	line            int
+	cond            expr
+	then, otherwise []stmt
+}
+
+type dropStmt struct{}
+
+type expr interface{}
+
+type literal struct{ value any }
+
+type fieldExpr struct{ name string }
+
+type notExpr struct {
+	line    int
+	operand expr
+}
+
+type binaryExpr struct {
+	line        int
+	op          string
+	left, right expr
+}
+
+type callExpr struct {
+	line int
+	name string
+	args []expr
+	re   *regexp.Regexp
+}
+
+// run runs statements, returning false once one of them drops the title.
+func run(body []stmt, t *store.Title) (bool, error) {
+	for _, s := range body {
+		switch s := s.(type) {
+		case dropStmt:
+			return false, nil
+
+		case assignStmt:
+			value, err := evalString(s.value, t, s.line)
+			if err != nil {
+				return false, err
+			}
+			fields[s.field].set(t, value)
+
+		case ifStmt:
+			cond, err := evalBool(s.cond, t, s.line)
+			if err != nil {
+				return false, err
+			}
+			branch := s.otherwise
+			if cond {
+				branch = s.then
+			}
+			if keep, err := run(branch, t); !keep || err != nil {
+				return keep, err
+			}
+		}
+	}
+	return true, nil
+}
+
+func eval(e expr, t *store.Title) (any, error) {
+	switch e := e.(type) {
+	case literal:
+		return e.value, nil
+
+	case fieldExpr:
+		return fields[e.name].get(t), nil
+
+	case notExpr:
+		v, err := evalBool(e.operand, t, e.line)
+		return !v, err
+
+	case binaryExpr:
+		switch e.op {
+		case "and", "or":
+			left, err := evalBool(e.left, t, e.line)
+			if err != nil {
+				return nil, err
+			}
+			// Short-circuit like any other language
+			if left == (e.op == "or") {
+				return left, nil
+			}
+			return evalBool(e.right, t, e.line)
+		case "+":
+			left, err := evalString(e.left, t, e.line)
+			if err != nil {
+				return nil, err
+			}
+			right, err := evalString(e.right, t, e.line)
+			return left + right, err
+		}
+
+		left, err := eval(e.left, t)
+		if err != nil {
+			return nil, err
+		}
+		right, err := eval(e.right, t)
+		if err != nil {
+			return nil, err
+		}
+		if typeName(left) != typeName(right) {
+			return nil, fmt.Errorf("line %d: cannot compare a %s with a %s", e.line, typeName(left), typeName(right))
+		}
+		return (left == right) == (e.op == "=="), nil
+
+	case callExpr:
+		args := make([]string, len(e.args))
+		for i, arg := range e.args {
+			v, err := evalString(arg, t, e.line)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		return functions[e.name].call(t, e.re, args), nil
+	}
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func evalString(e expr, t *store.Title, line int) (string, error) {
+	v, err := eval(e, t)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("line %d: expected a string, got a %s", line, typeName(v))
+	}
+	return s, nil
+}
+
+func evalBool(e expr, t *store.Title, line int) (bool, error) {
+	v, err := eval(e, t)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("line %d: expected a boolean, got a %s", line, typeName(v))
+	}
+	return b, nil
+}
+
+func typeName(v any) string {
+	if _, ok := v.(bool); ok {
+		return "boolean"
+	}
+	return "string"
+}
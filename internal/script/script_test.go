@@ -0,0 +1,133 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/birabittoh/xtitles/internal/store"
+)
+
+func TestRun(t *testing.T) {
+	pfn := "Microsoft.Halo3_8wekyb3d8bbwe"
+
+	tests := []struct {
+		name   string
+		src    string
+		title  store.Title
+		keep   bool
+		wanted func(t store.Title) bool
+	}{
+		{
+			"assign", `name = trim(name) + " (Classic)"`,
+			store.Title{Name: " Halo 3 "}, true,
+			func(t store.Title) bool { return t.Name == "Halo 3 (Classic)" },
+		},
+		{
+			"regex replace", `name = regex_replace(name, "\\s+", " ")`,
+			store.Title{Name: "Halo   3\tODST"}, true,
+			func(t store.Title) bool { return t.Name == "Halo 3 ODST" },
+		},
+		{
+			"if else", "if has_system(\"pc\") and not contains(name, \"PC\")\n  name = name + \" PC\"\nelse\n  name = upper(name)\nend",
+			store.Title{Name: "Minecraft", Systems: store.SystemList{"XBOX360"}}, true,
+			func(t store.Title) bool { return t.Name == "MINECRAFT" },
+		},
+		{
+			"nested", "# comment\nif starts_with(title_id, \"4D53\")\n  if pfn == \"\" # none yet\n    pfn = \"Microsoft.\" + replace(name, \" \", \"\")\n  end\nend\n",
+			store.Title{TitleID: "4D5307E6", Name: "Halo 3"}, true,
+			func(t store.Title) bool { return t.PFN != nil && *t.PFN == "Microsoft.Halo3" },
+		},
+		{
+			"clear optional", `pfn = ""`,
+			store.Title{PFN: &pfn}, true,
+			func(t store.Title) bool { return t.PFN == nil },
+		},
+		{
+			"drop", "if matches(name, \"(?i)^test\") or name == \"\"\n  drop\nend\nname = \"kept\"",
+			store.Title{Name: "TEST title"}, false,
+			func(t store.Title) bool { return t.Name == "TEST title" },
+		},
+		{
+			"keep", "if matches(name, \"(?i)^test\") or name == \"\"\n  drop\nend\nname = \"kept\"",
+			store.Title{Name: "Halo"}, true,
+			func(t store.Title) bool { return t.Name == "kept" },
+		},
+		{
+			"booleans", "if (true != false) == true\n  bing_id = \"66acd000-77fe-1000-9115-d8024d5307e6\"\nend",
+			store.Title{}, true,
+			func(t store.Title) bool { return t.BingID == "66acd000-77fe-1000-9115-d8024d5307e6" },
+		},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.name, tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		title := tt.title
+		keep, err := s.Run(&title)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if keep != tt.keep || !tt.wanted(title) {
+			t.Errorf("%s: keep = %v, title = %+v", tt.name, keep, title)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`name = "unterminated`, "line 1: unterminated string"},
+		{"\n\nname = nope", `line 3: unknown field "nope"`},
+		{`title_id = "00000000"`, `field "title_id" cannot be changed`},
+		{`name = shout(name)`, `unknown function "shout"`},
+		{`name = trim(name, name)`, "trim takes 1 arguments, got 2"},
+		{`name = regex_replace(name, name, "")`, "must be a string literal"},
+		{`if matches(name, "(")` + "\nend", "invalid pattern"},
+		{"if true\nname = name", `expected "end", found end of script`},
+		{`name = name +`, "expected a value, found end of script"},
+		{`name = name name`, "expected the end of the line"},
+		{`name = "a" ; drop`, "unexpected ';'"},
+		{"else", "expected a statement"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse("test.transform", tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "test.transform: ") {
+			t.Errorf("Parse(%q) = %v, want an error containing %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"if name\nend", "line 1: expected a boolean, got a string"},
+		{"\nname = true", "line 2: expected a string, got a boolean"},
+		{`name = name + (name == "")`, "expected a string, got a boolean"},
+		{"if name == true\nend", "cannot compare a string with a boolean"},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse("test.transform", tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.src, err)
+			continue
+		}
+		title := store.Title{Name: "Halo 3"}
+		_, err = s.Run(&title)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Run(%q) = %v, want an error containing %q", tt.src, err, tt.want)
+		}
+		if title.Name != "Halo 3" {
+			t.Errorf("Run(%q) changed the title on error: %+v", tt.src, title)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type Title struct {
+	TitleID         string          `json:"title_id" gorm:"primaryKey"`
+	Name            string          `json:"name"`
+	Systems         SystemList      `json:"systems"`
+	BingID          string          `json:"bing_id"`
+	ServiceConfigID *string         `json:"service_config_id"`
+	PFN             *string         `json:"pfn"`
+	Pictures        []Picture       `json:"pictures" gorm:"foreignKey:TitleID;references:TitleID"`
+	ExternalIDs     []ExternalID    `json:"external_ids,omitempty" gorm:"foreignKey:TitleID;references:TitleID"`
+	Achievements    *AchievementSet `json:"achievements,omitempty" gorm:"foreignKey:TitleID;references:TitleID"`
+	MarketValue     *MarketValue    `json:"market_value,omitempty" gorm:"foreignKey:TitleID;references:TitleID"`
+	ReviewScore     *ReviewScore    `json:"review_score,omitempty" gorm:"foreignKey:TitleID;references:TitleID"`
+	Media           []MediaLink     `json:"media,omitempty" gorm:"foreignKey:TitleID;references:TitleID"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	Curated         bool            `json:"curated"`
+	// When a sync first found the title upstream, and last found it there.
+	// Both stay unset for titles upstream never listed.
+	FirstSeenAt *time.Time `json:"first_seen_at,omitempty" gorm:"index"`
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty"`
+	// When a sync last added or changed the title, unlike UpdatedAt which
+	// edits and enrichers bump too. Unset for titles synced before it was
+	// recorded, whose first sighting stands in for it.
+	SyncedAt *time.Time `json:"-" gorm:"index"`
+}
+
+// LastSynced returns when a sync last added or changed the title, if one did.
+func (t Title) LastSynced() *time.Time {
+	if t.SyncedAt != nil {
+		return t.SyncedAt
+	}
+	return t.FirstSeenAt
+}
+
+type Picture struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	TitleID string `json:"title_id" gorm:"index;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Name    string `json:"name"`
+	Alt     string `json:"alt" gorm:"-"`
+
+	// Captured when the file is indexed, left out until a rescan for
+	// pictures indexed before they were
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty" gorm:"column:sha256;index"`
+	// The picture with the same content this one copies, in title details
+	DuplicateOf string `json:"duplicate_of,omitempty" gorm:"-"`
+}
+
+// AfterFind fills in the alt text of preloaded pictures, which depends on the title name.
+func (t *Title) AfterFind(tx *gorm.DB) error {
+	for i := range t.Pictures {
+		t.Pictures[i].Alt = PictureAlt(t.Name, t.Pictures[i].Name)
+	}
+	return nil
+}
+
+func PictureAlt(titleName, pictureName string) string {
+	return fmt.Sprintf("%s gamerpic %s", titleName, pictureName)
+}
+
+// SystemList is stored as a JSON array: a text column on SQLite, jsonb on
+// Postgres so that it can be queried there too.
+type SystemList []string
+
+func (SystemList) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == DriverPostgres {
+		return "jsonb"
+	}
+	return "text"
+}
+
+// Value always writes an array, older rows may still hold null.
+func (l SystemList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func (l *SystemList) Scan(value any) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported systems value %T", value)
+	}
+	var systems []string
+	if err := json.Unmarshal(b, &systems); err != nil {
+		return err
+	}
+	if len(systems) == 0 {
+		systems = nil
+	}
+	*l = systems
+	return nil
+}
+
+// ExternalID maps a title to its id in another database. A title has at most
+// one id per source and an id belongs to at most one title.
+type ExternalID struct {
+	ID         uint      `json:"-" gorm:"primaryKey"`
+	TitleID    string    `json:"-" gorm:"uniqueIndex:idx_external_ids_title_source;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Source     string    `json:"source" gorm:"uniqueIndex:idx_external_ids_title_source;uniqueIndex:idx_external_ids_source_id"`
+	ExternalID string    `json:"id" gorm:"uniqueIndex:idx_external_ids_source_id"`
+	Origin     string    `json:"origin"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+const (
+	retroAchievementsGameURL = "https://retroachievements.org/game/"
+	archiveItemURL           = "https://archive.org/details/"
+)
+
+// AchievementSet is what RetroAchievements has for a title mapped to one of
+// its games. Games without achievements are kept too, with zero counts, so
+// that their absence is known rather than unknown.
+type AchievementSet struct {
+	TitleID      string    `json:"-" gorm:"primaryKey"`
+	GameID       int       `json:"game_id"`
+	Achievements int       `json:"achievements"`
+	Points       int       `json:"points"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Available tells whether the game has an achievement set.
+func (a AchievementSet) Available() bool {
+	return a.Achievements > 0
+}
+
+func (a AchievementSet) URL() string {
+	return retroAchievementsGameURL + strconv.Itoa(a.GameID)
+}
+
+func (a AchievementSet) MarshalJSON() ([]byte, error) {
+	type set AchievementSet
+	return json.Marshal(struct {
+		set
+		Available bool   `json:"available"`
+		URL       string `json:"url"`
+	}{set(a), a.Available(), a.URL()})
+}
+
+// MarketValue is what a retail title sells for according to PriceCharting,
+// in cents of US dollars. A zero price is unknown.
+type MarketValue struct {
+	TitleID   string    `json:"-" gorm:"primaryKey"`
+	Loose     int       `json:"loose_price"`
+	CIB       int       `json:"cib_price"`
+	Currency  string    `json:"currency"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// ArchiveItem is an Internet Archive item about a title, such as a scanned
+// manual or disc.
+type ArchiveItem struct {
+	ID         uint      `json:"-" gorm:"primaryKey"`
+	TitleID    string    `json:"-" gorm:"index;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Identifier string    `json:"identifier"`
+	Name       string    `json:"title"`
+	Kind       string    `json:"kind"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (a ArchiveItem) URL() string {
+	return archiveItemURL + a.Identifier
+}
+
+func (a ArchiveItem) MarshalJSON() ([]byte, error) {
+	type item ArchiveItem
+	return json.Marshal(struct {
+		item
+		URL string `json:"url"`
+	}{item(a), a.URL()})
+}
+
+// MediaSoundtrack is the media category of soundtracks.
+const MediaSoundtrack = "soundtrack"
+
+// MediaLink points to media about a title hosted elsewhere, such as its
+// soundtrack on Spotify. Links are added by maintainers or by enrichers,
+// which only ever replace their own links.
+type MediaLink struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	TitleID   string    `json:"-" gorm:"index;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Category  string    `json:"category"`
+	Provider  string    `json:"provider"`
+	URL       string    `json:"url"`
+	Label     string    `json:"label,omitempty"`
+	Origin    string    `json:"origin"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReviewScore is the aggregate critic score of a title, out of 100, according
+// to the review score provider named by Source.
+type ReviewScore struct {
+	TitleID   string    `json:"-" gorm:"primaryKey"`
+	Source    string    `json:"source"`
+	Score     int       `json:"score" gorm:"index"`
+	Reviews   int       `json:"reviews"`
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
@@ -0,0 +1,267 @@
+// Package store holds the catalog models and the TitleStore that reads them,
+// independently of the HTTP layer.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// ErrNotFound is returned for titles that don't exist.
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// TitleQuery selects a page of the catalog, in title id order or, with
+// SortByScore, best reviewed first. Titles without a score come last.
+// SortByFirstSeen puts the titles upstream added last first, SortByName
+// sorts by name regardless of case and SortByUpdated puts the titles changed
+// last first. SortBySynced puts the titles syncs added or changed last first,
+// leaving out those no sync ever listed.
+type TitleQuery struct {
+	System           string
+	OnlyWithPictures bool
+	MinScore         int
+	// Only titles first seen upstream at or after this time
+	AddedSince      time.Time
+	SortByScore     bool
+	SortByFirstSeen bool
+	SortByName      bool
+	SortByUpdated   bool
+	SortBySynced    bool
+	Reverse         bool
+	// Only titles in this bucket of Letters
+	Letter string
+	// Only titles with this Package Family Name, regardless of case
+	PFN string
+	// SkipPictures leaves out the pictures of the titles
+	SkipPictures bool
+	// Only titles after this title id, in title id order, for keyset
+	// pagination. The total still counts those before it. Other orders
+	// aren't keyed on the title id alone, so it's only meaningful in this one.
+	After  string
+	Offset int
+	Limit  int
+}
+
+// lastSynced is Title.LastSynced in SQL.
+const lastSynced = "COALESCE(titles.synced_at, titles.first_seen_at)"
+
+// TitleStore reads the catalog.
+type TitleStore interface {
+	// Title returns a title with its pictures and enrichments, by its
+	// case-insensitive id.
+	Title(ctx context.Context, id string) (Title, error)
+	// Titles returns a page of titles with their pictures and the total
+	// number of titles matching q.
+	Titles(ctx context.Context, q TitleQuery) ([]Title, int64, error)
+	// Count returns the number of titles in the catalog.
+	Count(ctx context.Context) (int64, error)
+}
+
+// GormStore is the TitleStore of a GORM database.
+type GormStore struct {
+	db *gorm.DB
+
+	// MarketValues embeds market values in single titles.
+	MarketValues bool
+	// ReviewScores embeds review scores in titles.
+	ReviewScores bool
+}
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func (s *GormStore) Title(ctx context.Context, id string) (Title, error) {
+	var title Title
+	query := s.db.WithContext(ctx).Preload("Pictures").Preload("ExternalIDs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("source ASC")
+	}).Preload("Achievements").Preload("Media", func(db *gorm.DB) *gorm.DB {
+		return db.Order("category ASC, id ASC")
+	})
+	if s.MarketValues {
+		query = query.Preload("MarketValue")
+	}
+	if s.ReviewScores {
+		query = query.Preload("ReviewScore")
+	}
+	err := query.First(&title, "title_id = ?", strings.ToUpper(strings.TrimSpace(id))).Error
+	return title, err
+}
+
+func (s *GormStore) Titles(ctx context.Context, q TitleQuery) ([]Title, int64, error) {
+	var titles []Title
+	var total int64
+	err := ReadSnapshot(ctx, s.db, func(tx *gorm.DB) error {
+		query := tx.Model(&Title{})
+		if q.OnlyWithPictures {
+			query = query.Joins("JOIN pictures ON titles.title_id = pictures.title_id").Group("titles.title_id")
+		}
+		query = FilterBySystem(query, q.System)
+		query = FilterByLetter(query, q.Letter)
+		if q.PFN != "" {
+			query = query.Where("LOWER(titles.pfn) = ?", strings.ToLower(q.PFN))
+		}
+		if q.MinScore > 0 {
+			query = query.Where("EXISTS (SELECT 1 FROM review_scores WHERE review_scores.title_id = titles.title_id AND review_scores.score >= ?)", q.MinScore)
+		}
+		if !q.AddedSince.IsZero() {
+			query = query.Where("titles.first_seen_at >= ?", q.AddedSince)
+		}
+		if q.SortBySynced {
+			query = query.Where(lastSynced + " IS NOT NULL")
+		}
+
+		if err := query.Count(&total).Error; err != nil {
+			return err
+		}
+		if q.After != "" {
+			if q.Reverse {
+				query = query.Where("titles.title_id < ?", q.After)
+			} else {
+				query = query.Where("titles.title_id > ?", q.After)
+			}
+		}
+
+		// A subquery rather than a join keeps the grouping of OnlyWithPictures valid on Postgres
+		if q.SortByScore {
+			score := "COALESCE((SELECT review_scores.score FROM review_scores WHERE review_scores.title_id = titles.title_id), -1)"
+			if q.Reverse {
+				query = query.Order("CASE WHEN " + score + " < 0 THEN 1 ELSE 0 END, " + score + " ASC")
+			} else {
+				query = query.Order(score + " DESC")
+			}
+		}
+		// Newest first, titles never seen upstream last either way
+		if q.SortByFirstSeen {
+			if q.Reverse {
+				query = query.Order("CASE WHEN titles.first_seen_at IS NULL THEN 1 ELSE 0 END, titles.first_seen_at ASC")
+			} else {
+				query = query.Order("CASE WHEN titles.first_seen_at IS NULL THEN 1 ELSE 0 END, titles.first_seen_at DESC")
+			}
+		}
+		if q.SortByName {
+			if q.Reverse {
+				query = query.Order("LOWER(titles.name) DESC")
+			} else {
+				query = query.Order("LOWER(titles.name) ASC")
+			}
+		}
+		if q.SortByUpdated {
+			if q.Reverse {
+				query = query.Order("titles.updated_at ASC")
+			} else {
+				query = query.Order("titles.updated_at DESC")
+			}
+		}
+		if q.SortBySynced {
+			if q.Reverse {
+				query = query.Order(lastSynced + " ASC")
+			} else {
+				query = query.Order(lastSynced + " DESC")
+			}
+		}
+		if q.Reverse {
+			query = query.Order("titles.title_id DESC")
+		} else {
+			query = query.Order("titles.title_id ASC")
+		}
+		if !q.SkipPictures {
+			query = query.Preload("Pictures")
+		}
+		if s.ReviewScores {
+			query = query.Preload("ReviewScore")
+		}
+		return query.Offset(q.Offset).Limit(q.Limit).Find(&titles).Error
+	})
+	return titles, total, err
+}
+
+func (s *GormStore) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&Title{}).Count(&count).Error
+	return count, err
+}
+
+// ReadSnapshot runs fn in a read-only transaction, so that its queries all see
+// the database as of the first one, even if a sync commits in between. SQLite
+// transactions always read a snapshot, Postgres needs repeatable read.
+func ReadSnapshot(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return db.WithContext(ctx).Transaction(fn, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+}
+
+// SystemsTable is a FROM item listing the systems of each title as the value
+// column of a table named json_each, on every supported database.
+func SystemsTable(db *gorm.DB) string {
+	if db.Dialector.Name() == DriverPostgres {
+		return "jsonb_array_elements_text(titles.systems) AS json_each(value)"
+	}
+	return "json_each(titles.systems)"
+}
+
+// The buckets of titles by the first character of their name, after the
+// letters of the alphabet.
+const (
+	LetterDigits = "0-9"
+	LetterOther  = "other"
+)
+
+var (
+	alphabet = strings.Split("ABCDEFGHIJKLMNOPQRSTUVWXYZ", "")
+	digits   = strings.Split("0123456789", "")
+
+	// Letters are the buckets of the alphabet index, in order.
+	Letters = append(slices.Clone(alphabet), LetterDigits, LetterOther)
+)
+
+// NameInitial is the first character of the name of a title, in upper case
+// for ASCII letters.
+const NameInitial = "UPPER(SUBSTR(titles.name, 1, 1))"
+
+// LetterOf returns the bucket of Letters of a NameInitial.
+func LetterOf(initial string) string {
+	switch {
+	case slices.Contains(alphabet, initial):
+		return initial
+	case slices.Contains(digits, initial):
+		return LetterDigits
+	}
+	return LetterOther
+}
+
+// FilterByLetter restricts query to titles in a bucket of Letters.
+func FilterByLetter(query *gorm.DB, letter string) *gorm.DB {
+	switch letter {
+	case "":
+		return query
+	case LetterDigits:
+		return query.Where(NameInitial+" IN ?", digits)
+	case LetterOther:
+		return query.Where(NameInitial+" NOT IN ?", append(slices.Clone(alphabet), digits...))
+	}
+	return query.Where(NameInitial+" = ?", letter)
+}
+
+// FilterBySystem restricts query to titles available on system, or on any of
+// a comma separated list of systems.
+func FilterBySystem(query *gorm.DB, system string) *gorm.DB {
+	var systems []string
+	for _, s := range strings.Split(system, ",") {
+		if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+			systems = append(systems, s)
+		}
+	}
+	if len(systems) == 0 {
+		return query
+	}
+	return query.Where("EXISTS (SELECT 1 FROM "+SystemsTable(query)+" WHERE json_each.value IN ?)", systems)
+}
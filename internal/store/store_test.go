@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+var minecraftPFN = "Microsoft.MinecraftUWP_8wekyb3d8bbwe"
+
+func newTestStore(t *testing.T) *GormStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "titles.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.AutoMigrate(&Title{}, &Picture{}, &ExternalID{}, &AchievementSet{}, &MarketValue{}, &ReviewScore{}, &MediaLink{}); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := []Title{
+		{TitleID: "4D5307E6", Name: "Halo 3", Systems: SystemList{"XBOX360"}, Pictures: []Picture{{Name: "20400"}}},
+		{TitleID: "4D530802", Name: "Halo 3: ODST", Systems: SystemList{"XBOX360"}},
+		{TitleID: "584109EB", Name: "Minecraft", Systems: SystemList{"XBOX360", "PC"}, PFN: &minecraftPFN},
+	}
+	if err := db.Create(&titles).Error; err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Model(&Title{}).Where("title_id = ?", "4D530802").UpdateColumn("updated_at", time.Now().Add(time.Hour))
+	db.Model(&Title{}).Where("title_id = ?", "584109EB").UpdateColumn("updated_at", time.Now().Add(time.Minute))
+	db.Model(&Title{}).Where("title_id = ?", "4D5307E6").UpdateColumn("first_seen_at", seen)
+	db.Model(&Title{}).Where("title_id = ?", "4D530802").UpdateColumn("first_seen_at", seen.AddDate(0, 1, 0))
+	db.Model(&Title{}).Where("title_id = ?", "4D5307E6").UpdateColumn("synced_at", seen.AddDate(0, 2, 0))
+	db.Create(&MarketValue{TitleID: "4D5307E6", Loose: 350, Currency: "USD"})
+	db.Create(&ReviewScore{TitleID: "4D5307E6", Source: "opencritic", Score: 94})
+	db.Create(&ReviewScore{TitleID: "584109EB", Source: "opencritic", Score: 96})
+	return NewGormStore(db)
+}
+
+func TestGormStoreTitle(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	title, err := s.Title(ctx, " 4d5307e6 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(title.Pictures) != 1 || title.Pictures[0].Alt != "Halo 3 gamerpic 20400" {
+		t.Errorf("pictures = %+v", title.Pictures)
+	}
+	if title.MarketValue != nil {
+		t.Error("market values should only be loaded when enabled")
+	}
+
+	s.MarketValues = true
+	if title, _ := s.Title(ctx, "4D5307E6"); title.MarketValue == nil || title.MarketValue.Loose != 350 {
+		t.Errorf("market value = %+v", title.MarketValue)
+	}
+
+	if _, err := s.Title(ctx, "FFFFFFFF"); err != ErrNotFound {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGormStoreTitles(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		query TitleQuery
+		total int64
+		first string
+	}{
+		{TitleQuery{Limit: 2}, 3, "4D5307E6"},
+		{TitleQuery{Limit: 2, Reverse: true}, 3, "584109EB"},
+		{TitleQuery{Limit: 2, System: "pc"}, 1, "584109EB"},
+		{TitleQuery{Limit: 2, System: "PC, xbox360"}, 3, "4D5307E6"},
+		{TitleQuery{Limit: 2, System: "XBOXONE"}, 0, ""},
+		{TitleQuery{Limit: 2, System: " , "}, 3, "4D5307E6"},
+		{TitleQuery{Limit: 2, OnlyWithPictures: true}, 1, "4D5307E6"},
+		{TitleQuery{Limit: 2, Offset: 2}, 3, "584109EB"},
+		{TitleQuery{Limit: 2, SortByScore: true}, 3, "584109EB"},
+		{TitleQuery{Limit: 2, SortByScore: true, Reverse: true}, 3, "4D5307E6"},
+		{TitleQuery{Limit: 2, SortByScore: true, Offset: 2}, 3, "4D530802"},
+		{TitleQuery{Limit: 2, SortByScore: true, OnlyWithPictures: true}, 1, "4D5307E6"},
+		{TitleQuery{Limit: 2, MinScore: 95}, 1, "584109EB"},
+		{TitleQuery{Limit: 2, AddedSince: time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)}, 1, "4D530802"},
+		{TitleQuery{Limit: 2, SortByFirstSeen: true}, 3, "4D530802"},
+		{TitleQuery{Limit: 2, SortByFirstSeen: true, Reverse: true}, 3, "4D5307E6"},
+		{TitleQuery{Limit: 1, SortByFirstSeen: true, Offset: 2}, 3, "584109EB"},
+		{TitleQuery{Limit: 2, SortByName: true}, 3, "4D5307E6"},
+		{TitleQuery{Limit: 2, Letter: "H"}, 2, "4D5307E6"},
+		{TitleQuery{Limit: 2, Letter: "M", System: "PC"}, 1, "584109EB"},
+		{TitleQuery{Limit: 2, Letter: "Z"}, 0, ""},
+		{TitleQuery{Limit: 2, Letter: LetterDigits}, 0, ""},
+		{TitleQuery{Limit: 2, Letter: LetterOther}, 0, ""},
+		{TitleQuery{Limit: 2, PFN: "microsoft.minecraftuwp_8wekyb3d8bbwe"}, 1, "584109EB"},
+		{TitleQuery{Limit: 2, PFN: "Microsoft.Halo3_8wekyb3d8bbwe"}, 0, ""},
+		{TitleQuery{Limit: 2, SortByName: true, Reverse: true}, 3, "584109EB"},
+		{TitleQuery{Limit: 1, SortByName: true, Offset: 1}, 3, "4D530802"},
+		{TitleQuery{Limit: 2, SortByUpdated: true}, 3, "4D530802"},
+		{TitleQuery{Limit: 2, SortByUpdated: true, Reverse: true}, 3, "4D5307E6"},
+		{TitleQuery{Limit: 2, SortBySynced: true}, 2, "4D5307E6"},
+		{TitleQuery{Limit: 2, SortBySynced: true, Reverse: true}, 2, "4D530802"},
+		{TitleQuery{Limit: 2, After: "4D5307E6"}, 3, "4D530802"},
+		{TitleQuery{Limit: 2, After: "584109EB", Reverse: true}, 3, "4D530802"},
+		{TitleQuery{Limit: 2, After: "4D5307E6", OnlyWithPictures: true}, 1, ""},
+	}
+	for _, tt := range tests {
+		titles, total, err := s.Titles(ctx, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var first string
+		if len(titles) > 0 {
+			first = titles[0].TitleID
+		}
+		if total != tt.total || first != tt.first {
+			t.Errorf("%+v: total = %d, titles = %v", tt.query, total, titles)
+		}
+	}
+
+	if titles, _, err := s.Titles(ctx, TitleQuery{Limit: 1, SkipPictures: true}); err != nil || len(titles[0].Pictures) != 0 {
+		t.Errorf("SkipPictures: %+v, %v", titles, err)
+	}
+
+	if count, err := s.Count(ctx); err != nil || count != 3 {
+		t.Errorf("Count = %d, %v", count, err)
+	}
+}
+
+func TestLetterOf(t *testing.T) {
+	for initial, want := range map[string]string{"A": "A", "Z": "Z", "7": LetterDigits, "É": LetterOther, "[": LetterOther, "": LetterOther} {
+		if got := LetterOf(initial); got != want {
+			t.Errorf("LetterOf(%q) = %q, want %q", initial, got, want)
+		}
+	}
+}
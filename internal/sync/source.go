@@ -0,0 +1,307 @@
+// Package sync fetches the title catalog from upstream.
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+)
+
+// Response is a page of the upstream title_ids API.
+type Response struct {
+	Items []store.Title `json:"items"`
+	Count int           `json:"count"`
+}
+
+// Source fetches pages of titles from the upstream catalog.
+type Source interface {
+	FetchPage(ctx context.Context, offset, limit int) ([]store.Title, error)
+}
+
+// HTTPSource is the upstream title_ids API, for one system.
+type HTTPSource struct {
+	client  *http.Client
+	baseURL string
+	system  string
+
+	// OnCount, when set, is called with the number of titles upstream lists
+	// for the system, as reported by each page. Pages fetched at once call it
+	// concurrently.
+	OnCount func(count int)
+}
+
+func NewHTTPSource(client *http.Client, baseURL, system string) *HTTPSource {
+	return &HTTPSource{client: client, baseURL: baseURL, system: system}
+}
+
+func (s *HTTPSource) FetchPage(ctx context.Context, offset, limit int) ([]store.Title, error) {
+	url := fmt.Sprintf("%s?system=%s&limit=%d&offset=%d", s.baseURL, s.system, limit, offset)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	var r Response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("decode failed: %w", err)
+	}
+	if s.OnCount != nil {
+		s.OnCount(r.Count)
+	}
+
+	return r.Items, nil
+}
+
+// StatusError is an upstream response other than 200 OK.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.Code, e.Body)
+}
+
+// Retry is how a RetryingSource retries failed pages: up to Attempts times in
+// all, waiting Backoff before the first retry and twice as long before each
+// next one, up to MaxBackoff when set.
+type Retry struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// RetryingSource retries the pages of a source that fail with errors that may
+// go away, so that a sync resumes from the page that failed instead of
+// starting over.
+type RetryingSource struct {
+	Source Source
+	Retry  Retry
+
+	// sleep waits between attempts, replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func NewRetryingSource(source Source, retry Retry) *RetryingSource {
+	return &RetryingSource{Source: source, Retry: retry, sleep: sleep}
+}
+
+func (s *RetryingSource) FetchPage(ctx context.Context, offset, limit int) ([]store.Title, error) {
+	backoff := s.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		items, err := s.Source.FetchPage(ctx, offset, limit)
+		if err == nil || attempt >= s.Retry.Attempts || !retryable(err) || ctx.Err() != nil {
+			return items, err
+		}
+
+		// Jitter keeps instances that failed together from retrying together
+		wait := backoff/2 + rand.N(backoff/2+1)
+		log.Printf("Fetching offset %d failed (attempt %d of %d), retrying in %s: %v\n",
+			offset, attempt, s.Retry.Attempts, wait.Round(time.Millisecond), err)
+		if err := s.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+		if s.Retry.MaxBackoff > 0 {
+			backoff = min(backoff, s.Retry.MaxBackoff)
+		}
+	}
+}
+
+// retryable tells whether a failed page is worth asking for again: transport
+// errors, timeouts, truncated bodies, rate limits and server errors are, other
+// client errors are not.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code == http.StatusTooManyRequests || status.Code == http.StatusRequestTimeout || status.Code >= 500
+	}
+	return true
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FetchAll fetches every page of source, limit titles at a time.
+func FetchAll(ctx context.Context, source Source, limit int) ([]store.Title, error) {
+	return Fetcher{Limit: limit}.FetchAll(ctx, source)
+}
+
+// FetchAllPages is FetchAll calling onPage, when set, with each page fetched.
+func FetchAllPages(ctx context.Context, source Source, limit int, onPage func(page []store.Title)) ([]store.Title, error) {
+	return Fetcher{Limit: limit, OnPage: onPage}.FetchAll(ctx, source)
+}
+
+// Fetcher fetches every page of a source, several at a time when Workers is
+// more than one. The catalog has no page count, so pages are requested ahead
+// until one comes back short: up to Workers-1 requests past the end are
+// wasted, which is the price of not fetching one page after another.
+type Fetcher struct {
+	Limit int
+
+	// Workers is how many pages are fetched at once, one when unset
+	Workers int
+	// Interval is the least time between the starts of two requests
+	Interval time.Duration
+	// OnPage, when set, is called with each page in catalog order
+	OnPage func(page []store.Title)
+}
+
+// pageResult is a fetched page, or why it couldn't be fetched.
+type pageResult struct {
+	items []store.Title
+	err   error
+}
+
+// FetchAll fetches every page of source, stopping at the first error.
+func (f Fetcher) FetchAll(ctx context.Context, source Source) ([]store.Title, error) {
+	workers := max(f.Workers, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Pages are requested in order and their results queued in the same
+	// order, so that they are assembled as they would be one at a time. A
+	// slot is held until its page is assembled, so that no more than Workers
+	// pages are in flight or waiting, and one worker fetches them one by one.
+	queue := make(chan chan pageResult, workers)
+	slots := make(chan struct{}, workers)
+	// Waits for the producer and the requests it started alike
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		defer close(queue)
+		var last time.Time
+		for offset := 0; ; offset += f.Limit {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if wait := time.Until(last.Add(f.Interval)); wait > 0 {
+				if sleep(ctx, wait) != nil {
+					return
+				}
+			}
+			last = time.Now()
+
+			result := make(chan pageResult, 1)
+			wg.Go(func() {
+				items, err := source.FetchPage(ctx, offset, f.Limit)
+				result <- pageResult{items, err}
+			})
+			select {
+			case queue <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	// Cancel the pages fetched ahead and wait for them to return
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	var allTitles []store.Title
+	for result := range queue {
+		page := <-result
+		if page.err != nil {
+			return nil, page.err
+		}
+
+		allTitles = append(allTitles, page.items...)
+
+		log.Printf("Fetched %d titles (total: %d)\n", len(page.items), len(allTitles))
+		if f.OnPage != nil {
+			f.OnPage(page.items)
+		}
+
+		if len(page.items) < f.Limit {
+			return allTitles, nil
+		}
+		<-slots
+	}
+	return nil, ctx.Err()
+}
+
+// ChaosTransport injects upstream faults so that the sync error handling can
+// be exercised in integration tests and staging. Never enable it in production.
+type ChaosTransport struct {
+	Next          http.RoundTripper
+	ErrorRate     float64
+	MalformedRate float64
+	Latency       time.Duration
+}
+
+var ErrInjected = errors.New("injected upstream failure")
+
+func (t *ChaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Latency > 0 {
+		select {
+		case <-time.After(rand.N(t.Latency) + t.Latency/2):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if rand.Float64() < t.ErrorRate {
+		// Alternate between transport errors and upstream 5xx responses
+		if rand.IntN(2) == 0 {
+			return nil, ErrInjected
+		}
+		return &http.Response{
+			StatusCode: http.StatusBadGateway,
+			Status:     "502 Bad Gateway",
+			Header:     make(http.Header),
+			Body:       io.NopCloser(bytes.NewBufferString(ErrInjected.Error())),
+			Request:    req,
+		}, nil
+	}
+
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil || rand.Float64() >= t.MalformedRate {
+		return resp, err
+	}
+
+	// Truncate the body so it is no longer valid JSON
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body[:len(body)/2]))
+	resp.ContentLength = int64(len(body) / 2)
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
@@ -0,0 +1,283 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/birabittoh/xtitles/internal/store"
+)
+
+type fakeSource struct {
+	titles []store.Title
+	err    error
+}
+
+func (f fakeSource) FetchPage(ctx context.Context, offset, limit int) ([]store.Title, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.titles[min(offset, len(f.titles)):min(offset+limit, len(f.titles))], nil
+}
+
+func TestFetchAll(t *testing.T) {
+	var source fakeSource
+	for i := range 5 {
+		source.titles = append(source.titles, store.Title{TitleID: fmt.Sprintf("%08X", i)})
+	}
+
+	titles, err := FetchAll(context.Background(), source, 2)
+	if err != nil || len(titles) != 5 {
+		t.Fatalf("FetchAll = %d titles, %v", len(titles), err)
+	}
+
+	var pages []int
+	FetchAllPages(context.Background(), source, 2, func(page []store.Title) { pages = append(pages, len(page)) })
+	if fmt.Sprint(pages) != "[2 2 1]" {
+		t.Errorf("pages = %v, want [2 2 1]", pages)
+	}
+
+	source.err = errors.New("upstream down")
+	if _, err := FetchAll(context.Background(), source, 2); !errors.Is(err, source.err) {
+		t.Errorf("err = %v, want the source error", err)
+	}
+}
+
+// slowSource answers later pages first, counting the requests in flight.
+type slowSource struct {
+	fakeSource
+	inFlight, peak atomic.Int32
+	started        chan time.Time
+}
+
+func (s *slowSource) FetchPage(ctx context.Context, offset, limit int) ([]store.Title, error) {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for peak := s.peak.Load(); n > peak && !s.peak.CompareAndSwap(peak, n); peak = s.peak.Load() {
+	}
+	if s.started != nil {
+		s.started <- time.Now()
+	}
+	time.Sleep(time.Duration(10-offset%10) * time.Millisecond)
+	return s.fakeSource.FetchPage(ctx, offset, limit)
+}
+
+func TestFetcher(t *testing.T) {
+	source := &slowSource{}
+	for i := range 9 {
+		source.titles = append(source.titles, store.Title{TitleID: fmt.Sprintf("%08X", i)})
+	}
+
+	var order []string
+	titles, err := Fetcher{Limit: 2, Workers: 3, OnPage: func(page []store.Title) {
+		for _, title := range page {
+			order = append(order, title.TitleID)
+		}
+	}}.FetchAll(context.Background(), source)
+	if err != nil || len(titles) != 9 {
+		t.Fatalf("FetchAll = %d titles, %v", len(titles), err)
+	}
+	for i, title := range titles {
+		if want := fmt.Sprintf("%08X", i); title.TitleID != want || order[i] != want {
+			t.Fatalf("title %d = %s, page order %v, want catalog order", i, title.TitleID, order)
+		}
+	}
+	if peak := source.peak.Load(); peak < 2 || peak > 3 {
+		t.Errorf("peak requests in flight = %d, want 2 to 3", peak)
+	}
+
+	// Requests are spaced out, even with workers to spare
+	source.started = make(chan time.Time, 16)
+	if _, err := (Fetcher{Limit: 2, Workers: 4, Interval: 20 * time.Millisecond}).FetchAll(context.Background(), source); err != nil {
+		t.Fatal(err)
+	}
+	close(source.started)
+	var last time.Time
+	for start := range source.started {
+		if !last.IsZero() && start.Sub(last) < 15*time.Millisecond {
+			t.Errorf("requests %v apart, want at least 20ms", start.Sub(last))
+		}
+		last = start
+	}
+}
+
+func TestFetcherError(t *testing.T) {
+	var source fakeSource
+	for i := range 5 {
+		source.titles = append(source.titles, store.Title{TitleID: fmt.Sprintf("%08X", i)})
+	}
+	// Failing past the end of the catalog doesn't matter, before it does
+	flaky := &flakySource{fakeSource: source, failures: map[int]int{6: 1, 8: 1}, err: errors.New("upstream down")}
+	if titles, err := (Fetcher{Limit: 2, Workers: 5}).FetchAll(context.Background(), &lockedSource{source: flaky}); err != nil || len(titles) != 5 {
+		t.Errorf("failures past the end: %d titles, %v", len(titles), err)
+	}
+
+	flaky = &flakySource{fakeSource: source, failures: map[int]int{2: 1}, err: errors.New("upstream down")}
+	if _, err := (Fetcher{Limit: 2, Workers: 5}).FetchAll(context.Background(), &lockedSource{source: flaky}); !errors.Is(err, flaky.err) {
+		t.Errorf("err = %v, want the source error", err)
+	}
+}
+
+// lockedSource serializes a source that isn't safe for concurrent use.
+type lockedSource struct {
+	mu     sync.Mutex
+	source Source
+}
+
+func (l *lockedSource) FetchPage(ctx context.Context, offset, limit int) ([]store.Title, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.source.FetchPage(ctx, offset, limit)
+}
+
+// flakySource fails the pages in failures as many times as set there.
+type flakySource struct {
+	fakeSource
+	failures map[int]int
+	err      error
+	offsets  []int
+}
+
+func (f *flakySource) FetchPage(ctx context.Context, offset, limit int) ([]store.Title, error) {
+	f.offsets = append(f.offsets, offset)
+	if f.failures[offset] > 0 {
+		f.failures[offset]--
+		return nil, f.err
+	}
+	return f.fakeSource.FetchPage(ctx, offset, limit)
+}
+
+func TestRetryingSource(t *testing.T) {
+	var titles []store.Title
+	for i := range 5 {
+		titles = append(titles, store.Title{TitleID: fmt.Sprintf("%08X", i)})
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		failures int
+		offsets  string
+		waits    string
+		ok       bool
+	}{
+		{"resumes from the failed page", &StatusError{Code: http.StatusBadGateway}, 2, "[0 2 2 2 4]", "[1s 2s]", true},
+		{"gives up", &StatusError{Code: http.StatusServiceUnavailable}, 5, "[0 2 2 2 2]", "[1s 2s 3s]", false},
+		{"rate limited", &StatusError{Code: http.StatusTooManyRequests}, 1, "[0 2 2 4]", "[1s]", true},
+		{"transport error", errors.New("connection reset"), 1, "[0 2 2 4]", "[1s]", true},
+		{"client error", &StatusError{Code: http.StatusNotFound}, 1, "[0 2]", "[]", false},
+		{"cancelled", context.Canceled, 1, "[0 2]", "[]", false},
+	}
+
+	for _, tt := range tests {
+		flaky := &flakySource{fakeSource: fakeSource{titles: titles}, failures: map[int]int{2: tt.failures}, err: tt.err}
+		source := NewRetryingSource(flaky, Retry{Attempts: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second})
+		// Check the jittered waits against the backoff instead of sleeping
+		waits := []time.Duration{}
+		backoff := time.Second
+		source.sleep = func(ctx context.Context, d time.Duration) error {
+			if d > backoff || d < backoff/2 {
+				t.Errorf("%s: waited %s, want about %s", tt.name, d, backoff)
+			}
+			waits = append(waits, backoff)
+			backoff = min(backoff*2, 3*time.Second)
+			return nil
+		}
+
+		got, err := FetchAll(context.Background(), source, 2)
+		if (err == nil) != tt.ok || (tt.ok && len(got) != 5) {
+			t.Errorf("%s: FetchAll = %d titles, %v", tt.name, len(got), err)
+		}
+		if fmt.Sprint(flaky.offsets) != tt.offsets {
+			t.Errorf("%s: offsets = %v, want %s", tt.name, flaky.offsets, tt.offsets)
+		}
+		if fmt.Sprint(waits) != tt.waits {
+			t.Errorf("%s: waits = %v, want %s", tt.name, waits, tt.waits)
+		}
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("system") != "XBOX360" {
+			http.Error(w, "unknown system", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"items":[{"title_id":"4D5307E6","name":"Halo 3","systems":["XBOX360"]}],"count":1}`)
+	}))
+	defer srv.Close()
+
+	source := NewHTTPSource(srv.Client(), srv.URL+"/", "XBOX360")
+	count := -1
+	source.OnCount = func(n int) { count = n }
+	titles, err := source.FetchPage(context.Background(), 0, 10)
+	if err != nil || len(titles) != 1 || titles[0].Name != "Halo 3" {
+		t.Fatalf("FetchPage = %+v, %v", titles, err)
+	}
+	if count != 1 {
+		t.Errorf("OnCount got %d, want 1", count)
+	}
+
+	_, err = NewHTTPSource(srv.Client(), srv.URL+"/", "PS3").FetchPage(context.Background(), 0, 10)
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != http.StatusBadRequest {
+		t.Errorf("err = %v, want a 400 StatusError", err)
+	}
+}
+
+func TestChaosTransport(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprint(w, `{"items":[{"title_id":"4D5307E6","name":"Halo 3","systems":["XBOX360"]}],"count":1}`)
+	}))
+	defer srv.Close()
+
+	injected := func(err error) bool {
+		var status *StatusError
+		return errors.Is(err, ErrInjected) || (errors.As(err, &status) && status.Code == http.StatusBadGateway)
+	}
+	tests := []struct {
+		name      string
+		transport ChaosTransport
+		upstream  bool
+		check     func(err error) bool
+	}{
+		{"no faults", ChaosTransport{}, true, func(err error) bool { return err == nil }},
+		{"errors", ChaosTransport{ErrorRate: 1}, false, injected},
+		{"malformed", ChaosTransport{MalformedRate: 1}, true, func(err error) bool { return err != nil && !injected(err) }},
+		{"latency", ChaosTransport{Latency: 20 * time.Millisecond}, true, func(err error) bool { return err == nil }},
+	}
+	for _, tt := range tests {
+		calls.Store(0)
+		tt.transport.Next = http.DefaultTransport
+		source := NewHTTPSource(&http.Client{Transport: &tt.transport}, srv.URL+"/", "XBOX360")
+		for range 10 {
+			start := time.Now()
+			_, err := source.FetchPage(context.Background(), 0, 10)
+			if !tt.check(err) {
+				t.Errorf("%s: err = %v", tt.name, err)
+			}
+			if elapsed := time.Since(start); elapsed < tt.transport.Latency/2 {
+				t.Errorf("%s: took %s, want at least %s", tt.name, elapsed, tt.transport.Latency/2)
+			}
+		}
+		if reached := calls.Load() > 0; reached != tt.upstream {
+			t.Errorf("%s: upstream reached = %t, want %t", tt.name, reached, tt.upstream)
+		}
+	}
+
+	// Injected latency gives way to cancellation
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	slow := NewHTTPSource(&http.Client{Transport: &ChaosTransport{Next: http.DefaultTransport, Latency: time.Hour}}, srv.URL+"/", "XBOX360")
+	if _, err := slow.FetchPage(ctx, 0, 10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled: err = %v", err)
+	}
+}
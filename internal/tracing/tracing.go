@@ -0,0 +1,498 @@
+// Package tracing records OpenTelemetry spans and exports them in batches to
+// an OTLP collector, over HTTP with the JSON encoding. Trace context is
+// propagated with W3C traceparent headers.
+//
+// A nil *Tracer is valid and records nothing, so that callers don't have to
+// check whether tracing is enabled.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const scopeName = "github.com/birabittoh/xtitles"
+
+// SpanKind tells what a span stands for, with the values of OTLP.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// statusError is the OTLP status code of failed spans.
+const statusError = 2
+
+// Config configures a Tracer.
+type Config struct {
+	// Endpoint is the base URL of the collector, like http://collector:4318.
+	// Spans are posted to /v1/traces under it.
+	Endpoint string
+	// Headers are sent with every export, for authentication.
+	Headers map[string]string
+	// ServiceName is the service.name of the spans.
+	ServiceName string
+	// SampleRatio is the share of traces started here that are recorded.
+	// Traces started upstream follow the decision in their traceparent.
+	SampleRatio float64
+	// BatchSize and BatchTimeout bound how many spans wait to be exported
+	// and for how long.
+	BatchSize    int
+	BatchTimeout time.Duration
+	// Client posts the spans, a client with a 10s timeout by default.
+	Client *http.Client
+}
+
+// Tracer starts spans and exports those of sampled traces.
+type Tracer struct {
+	config Config
+	url    string
+
+	mu       sync.Mutex
+	pending  []*Span
+	flush    chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// New returns a Tracer exporting to cfg.Endpoint, exporting in the background
+// until Shutdown.
+func New(cfg Config) *Tracer {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "xtitles"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.BatchTimeout <= 0 {
+		cfg.BatchTimeout = 5 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	t := &Tracer{
+		config: cfg,
+		url:    strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		flush:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// SpanContext identifies a span within its trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent formats sc as a W3C traceparent header.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceParent parses a W3C traceparent header.
+func ParseTraceParent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// Later versions may add fields, version 00 may not
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+// SpanFromContext returns the span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// Extract returns ctx with the remote parent of a traceparent header in
+// header, if any.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if sc, ok := ParseTraceParent(header.Get("traceparent")); ok {
+		return context.WithValue(ctx, remoteKey, sc)
+	}
+	return ctx
+}
+
+// Inject sets the traceparent header of the span in ctx in header.
+func Inject(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set("traceparent", span.Context().TraceParent())
+	}
+}
+
+// Attr is an attribute of a span.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attr    { return Attr{key, value} }
+func Int(key string, value int64) Attr { return Attr{key, value} }
+func Bool(key string, value bool) Attr { return Attr{key, value} }
+
+// Span is an operation of a trace. Its methods are safe on a nil Span.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent [8]byte
+	name   string
+	kind   SpanKind
+	start  time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes []Attr
+	status     int
+	message    string
+}
+
+// Start starts a span, child of the span in ctx or of its remote parent, and
+// returns ctx with it. The span must be ended with End.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attributes: attrs}
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.sc
+	} else if remote, ok := ctx.Value(remoteKey).(SpanContext); ok {
+		parent = remote
+	}
+	if parent.IsValid() {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.sc.TraceID[:])
+		span.sc.Sampled = t.sample(span.sc.TraceID)
+	}
+	rand.Read(span.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// sample decides whether a new trace is recorded from its ID, so that the
+// decision is the same wherever it is made.
+func (t *Tracer) sample(traceID [16]byte) bool {
+	switch {
+	case t.config.SampleRatio >= 1:
+		return true
+	case t.config.SampleRatio <= 0:
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/(1<<53) < t.config.SampleRatio
+}
+
+// Context returns the identity of the span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attributes = append(s.attributes, attrs...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed with err.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status, s.message = statusError, err.Error()
+	s.mu.Unlock()
+}
+
+// End ends the span, queueing it for export if its trace is sampled.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	t.pending = append(t.pending, span)
+	full := len(t.pending) >= t.config.BatchSize
+	// Past a few batches the collector isn't keeping up, drop the oldest
+	if over := len(t.pending) - 4*t.config.BatchSize; over > 0 {
+		t.pending = t.pending[over:]
+	}
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.config.BatchTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		case <-t.stop:
+			return
+		}
+		if err := t.Flush(context.Background()); err != nil {
+			log.Printf("Warning: exporting traces failed: %v\n", err)
+		}
+	}
+}
+
+// Flush exports the spans ended so far.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	for len(spans) > 0 {
+		batch := spans[:min(len(spans), t.config.BatchSize)]
+		spans = spans[len(batch):]
+		if err := t.export(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown stops exporting in the background and exports the spans left.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+	return t.Flush(ctx)
+}
+
+func (t *Tracer) export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(t.payload(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP JSON encoding, with IDs in hex and 64-bit integers as strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func otlpAttributes(attrs []Attr) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]any
+		switch v := a.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, otlpAttribute{Key: a.Key, Value: value})
+	}
+	return out
+}
+
+func (t *Tracer) payload(spans []*Span) otlpRequest {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	scope.Scope.Name = scopeName
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attributes),
+		}
+		if s.status != 0 {
+			span.Status = &otlpStatus{Code: s.status, Message: s.message}
+		}
+		s.mu.Unlock()
+		if s.parent != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attr{String("service.name", t.config.ServiceName)})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
+
+// Transport traces the requests of an HTTP client as client spans, passing
+// the trace on to the server in a traceparent header.
+type Transport struct {
+	Tracer *Tracer
+	Next   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	ctx, span := t.Tracer.Start(req.Context(), req.Method, KindClient,
+		String("http.request.method", req.Method),
+		String("url.full", req.URL.Redacted()),
+		String("server.address", req.URL.Hostname()))
+	if span == nil {
+		return next.RoundTrip(req)
+	}
+	defer span.End()
+
+	// RoundTrippers must not change the request they are given
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", int64(resp.StatusCode)))
+	if resp.StatusCode >= 400 {
+		span.SetError(fmt.Errorf("status %s", resp.Status))
+	}
+	return resp, nil
+}
@@ -0,0 +1,172 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		// Later versions may carry more fields
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceParent(tt.header)
+		if ok != tt.ok || sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceParent(%q) = %+v, %v; want ok %v, sampled %v", tt.header, sc, ok, tt.ok, tt.sampled)
+		}
+		if ok && tt.header[:2] == "00" && sc.TraceParent() != tt.header {
+			t.Errorf("TraceParent() = %q, want %q", sc.TraceParent(), tt.header)
+		}
+	}
+}
+
+type collector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+	headers  []http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req otlpRequest
+	body, _ := io.ReadAll(r.Body)
+	if r.URL.Path != "/v1/traces" || json.Unmarshal(body, &req) != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header)
+	c.mu.Unlock()
+}
+
+func (c *collector) spans() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var spans []otlpSpan
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func TestTracerExport(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	tracer := New(Config{Endpoint: server.URL + "/", Headers: map[string]string{"Authorization": "Bearer s3cr3t"}, ServiceName: "test"})
+	defer tracer.Shutdown(context.Background())
+
+	header := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	ctx, parent := tracer.Start(Extract(context.Background(), header), "GET /titles", KindServer, String("http.route", "/titles"))
+
+	// A downstream server sees the client span as parent
+	var downstream string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = r.Header.Get("traceparent")
+	}))
+	defer api.Close()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, api.URL, nil)
+	resp, err := (&http.Client{Transport: &Transport{Tracer: tracer}}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	_, child := tracer.Start(ctx, "query titles", KindClient)
+	child.SetError(io.ErrUnexpectedEOF)
+	child.End()
+	parent.SetAttributes(Int("http.response.status_code", 200))
+	parent.End()
+	parent.End()
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	spans := c.spans()
+	if len(spans) != 3 {
+		t.Fatalf("exported %d spans, want 3: %+v", len(spans), spans)
+	}
+	byName := map[string]otlpSpan{}
+	for _, s := range spans {
+		byName[s.Name] = s
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s: trace ID = %s", s.Name, s.TraceID)
+		}
+	}
+
+	serverSpan := byName["GET /titles"]
+	if serverSpan.ParentSpanID != "00f067aa0ba902b7" || serverSpan.Kind != KindServer || len(serverSpan.Attributes) != 2 {
+		t.Errorf("server span = %+v", serverSpan)
+	}
+	if got := byName["GET"]; got.ParentSpanID != serverSpan.SpanID || downstream != "00-"+got.TraceID+"-"+got.SpanID+"-01" {
+		t.Errorf("client span = %+v, downstream traceparent = %q", got, downstream)
+	}
+	if got := byName["query titles"]; got.ParentSpanID != serverSpan.SpanID || got.Status == nil || got.Status.Code != statusError {
+		t.Errorf("query span = %+v", got)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.headers[0].Get("Authorization") != "Bearer s3cr3t" {
+		t.Errorf("export headers = %v", c.headers[0])
+	}
+	if attrs := c.requests[0].ResourceSpans[0].Resource.Attributes; len(attrs) != 1 || attrs[0].Value["stringValue"] != "test" {
+		t.Errorf("resource attributes = %+v", attrs)
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	tracer := New(Config{Endpoint: server.URL, SampleRatio: 0})
+	defer tracer.Shutdown(context.Background())
+
+	ctx, root := tracer.Start(context.Background(), "dropped", KindServer)
+	_, child := tracer.Start(ctx, "dropped child", KindInternal)
+	child.End()
+	root.End()
+
+	// The caller decided to record this one
+	header := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	_, kept := tracer.Start(Extract(context.Background(), header), "kept", KindServer)
+	kept.End()
+
+	var none *Tracer
+	_, span := none.Start(context.Background(), "disabled", KindInternal)
+	span.SetAttributes(String("a", "b"))
+	span.End()
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	spans := c.spans()
+	if len(spans) != 1 || spans[0].Name != "kept" {
+		t.Errorf("exported %+v, want only the kept span", spans)
+	}
+}
@@ -0,0 +1,51 @@
+// Package plugin extends xtitles from another module, without forking it. A
+// package registers its plugin from init, and the module's main runs the
+// server and its commands with the registered plugins:
+//
+//	func init() {
+//		if err := plugin.Register(myPlugin{}); err != nil {
+//			panic(err)
+//		}
+//	}
+//
+//	func main() {
+//		plugin.Main()
+//	}
+package plugin
+
+import (
+	"log"
+	"os"
+
+	"github.com/birabittoh/xtitles/internal/api"
+)
+
+// The plugin interfaces and the types their hooks are given, see the
+// documentation of each.
+type (
+	Plugin    = api.Plugin
+	Routes    = api.PluginRoutes
+	Enricher  = api.PluginEnricher
+	Lifecycle = api.PluginLifecycle
+	SyncHook  = api.PluginSyncHook
+
+	Server  = api.Server
+	Job     = api.Job
+	SyncRun = api.SyncRun
+	Title   = api.Title
+)
+
+// Register makes a plugin part of every server started afterwards. Names must
+// be lowercase letters, digits and dashes, and unique.
+func Register(p Plugin) error {
+	return api.RegisterPlugin(p)
+}
+
+// Main runs the xtitles command line, serving without a command, and exits
+// when it fails.
+func Main() {
+	if err := api.Run(os.Args[1:]); err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
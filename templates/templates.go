@@ -0,0 +1,8 @@
+// Package templates embeds the HTML templates of the server, so that any
+// binary built with it runs from any working directory.
+package templates
+
+import "embed"
+
+//go:embed *.html
+var FS embed.FS